@@ -0,0 +1,64 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/arn"
+)
+
+// isAccessPoint checks if the bucket is an access point arn, i.e.
+// arn:aws:s3:eu-west-1:123456789012:accesspoint/secrets
+func isAccessPoint(bucket string) bool {
+	parsed, err := arn.Parse(bucket)
+	if err != nil {
+		return false
+	}
+
+	return parsed.Service == "s3" && strings.HasPrefix(parsed.Resource, "accesspoint")
+}
+
+// accessPointRegion returns the region of the access point arn
+func accessPointRegion(bucket string) string {
+	parsed, err := arn.Parse(bucket)
+	if err != nil {
+		return ""
+	}
+
+	return parsed.Region
+}
+
+// splitS3Location splits a s3://bucket/key location, the bucket being a name or access point arn, i.e.
+// s3://arn:aws:s3:eu-west-1:123456789012:accesspoint/secrets/db.yml
+func splitS3Location(location string) (string, string) {
+	location = strings.TrimPrefix(location, "s3://")
+	items := strings.SplitN(location, "/", 2)
+	if strings.HasPrefix(location, "arn:") && len(items) > 1 {
+		name := strings.SplitN(items[1], "/", 2)
+		if isAccessPoint(items[0] + "/" + name[0]) {
+			if len(name) > 1 {
+				return items[0] + "/" + name[0], name[1]
+			}
+			return items[0] + "/" + name[0], ""
+		}
+	}
+	if len(items) > 1 {
+		return items[0], items[1]
+	}
+
+	return items[0], ""
+}
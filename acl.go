@@ -0,0 +1,383 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+)
+
+// the groups which make a grant public
+var publicGroups = map[string]string{
+	"http://acs.amazonaws.com/groups/global/AllUsers":           "everyone",
+	"http://acs.amazonaws.com/groups/global/AuthenticatedUsers": "any-aws-account",
+}
+
+//
+// newBucketACLCommand creates the bucket acl commands
+//
+func newBucketACLCommand(cmd *cliCommand) cli.Command {
+	bucketFlag := cli.StringFlag{
+		Name:   "b, bucket",
+		Usage:  "the name of the bucket `NAME`",
+		EnvVar: "AWS_S3_BUCKET",
+	}
+
+	return cli.Command{
+		Name:  "acl",
+		Usage: "inspect or set the acl and public access block of the bucket",
+		Subcommands: []cli.Command{
+			{
+				Name:  "get",
+				Usage: "display the grants and public access block of the bucket, highlighting any public access",
+				Flags: []cli.Flag{bucketFlag},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:bucket:s"}, cmd, getBucketACL)
+				},
+			},
+			{
+				Name:  "set",
+				Usage: "apply a canned acl to the bucket and or block all public access",
+				Flags: []cli.Flag{
+					bucketFlag,
+					cli.StringFlag{
+						Name:  "canned",
+						Usage: "the canned acl, i.e. private, log-delivery-write, public-read `ACL`",
+					},
+					cli.BoolFlag{
+						Name:  "block-public-access",
+						Usage: "block all public access to the bucket and the files within",
+					},
+					cli.BoolFlag{
+						Name:  "y, yes",
+						Usage: "do not prompt for confirmation before making the bucket public",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:bucket:s"}, cmd, setBucketACL)
+				},
+			},
+		},
+	}
+}
+
+//
+// newStatCommand creates a new stat command
+//
+func newStatCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:      "stat",
+		Usage:     "display the metadata of one or more files in the bucket",
+		ArgsUsage: "PATH...",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files `NAME`",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.BoolFlag{
+				Name:  "acl",
+				Usage: "include the grants of the files, highlighting any public access",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s"}, cmd, statFiles)
+		},
+	}
+}
+
+//
+// statFiles displays the metadata, and optionally the grants, of the files
+//
+func statFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	if len(cx.Args()) <= 0 {
+		return newExitError(exitUsage, "you have not specified any files")
+	}
+
+	for _, x := range cx.Args() {
+		key := strings.TrimPrefix(x, "/")
+		head, err := cmd.getFileMetadata(key, bucket)
+		if err != nil {
+			return err
+		}
+		fields := map[string]interface{}{
+			"bucket":        bucket,
+			"key":           key,
+			"size":          aws.Int64Value(head.ContentLength),
+			"last-modified": aws.TimeValue(head.LastModified),
+			"etag":          aws.StringValue(head.ETag),
+			"encryption":    aws.StringValue(head.ServerSideEncryption),
+			"kms-key":       aws.StringValue(head.SSEKMSKeyId),
+			"class":         defaultValue(aws.StringValue(head.StorageClass), s3.StorageClassStandard),
+			"version":       aws.StringValue(head.VersionId),
+			"metadata":      aws.StringValueMap(head.Metadata),
+		}
+		message := fmt.Sprintf("%s\n  size:       %d\n  modified:   %s\n  etag:       %s\n  encryption: %s\n  kms key:    %s\n  class:      %s\n  version:    %s\n",
+			colorKey(key), aws.Int64Value(head.ContentLength), aws.TimeValue(head.LastModified).Format(time.RFC822),
+			aws.StringValue(head.ETag), encryptionColumn(aws.StringValue(head.ServerSideEncryption)),
+			defaultValue(aws.StringValue(head.SSEKMSKeyId), "-"), fields["class"], defaultValue(aws.StringValue(head.VersionId), "-"))
+
+		if cx.Bool("acl") {
+			resp, err := cmd.s3Client.GetObjectAclWithContext(cmd.ctx, &s3.GetObjectAclInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(cmd.objectKey(key)),
+			})
+			if err != nil {
+				return fmt.Errorf("unable to retrieve the acl of the file: %s, error: %s", key, err)
+			}
+			grants, public := describeGrants(resp.Grants)
+			fields["grants"] = grants
+			fields["public"] = public
+			message += "  grants:\n"
+			for _, grant := range grants {
+				message += "    " + grant + "\n"
+			}
+			if public {
+				message += "  " + colorize(colorRed, "the file is public") + "\n"
+			}
+		}
+		o.fields(fields).log("%s", message)
+	}
+
+	return nil
+}
+
+//
+// getBucketACL displays the grants and public access block of the bucket
+//
+func getBucketACL(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	resp, err := cmd.s3Client.GetBucketAclWithContext(cmd.ctx, &s3.GetBucketAclInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to retrieve the acl of the bucket: %s, error: %s", bucket, err)
+	}
+	grants, _ := describeGrants(resp.Grants)
+	issues, err := cmd.publicExposure(bucket)
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("%s\n  owner: %s\n  grants:\n", bucket, ownerName(resp.Owner))
+	for _, grant := range grants {
+		message += "    " + grant + "\n"
+	}
+	for _, issue := range issues {
+		message += "  " + colorize(colorRed, issue) + "\n"
+	}
+	if len(issues) <= 0 {
+		message += "  public access is blocked\n"
+	}
+	o.fields(map[string]interface{}{
+		"bucket": bucket,
+		"owner":  ownerName(resp.Owner),
+		"grants": grants,
+		"issues": issues,
+		"public": len(issues) > 0,
+	}).log("%s", message)
+
+	return nil
+}
+
+//
+// setBucketACL applies the canned acl to the bucket and or blocks all public access
+//
+func setBucketACL(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	canned := cx.String("canned")
+	if canned == "" && !cx.Bool("block-public-access") {
+		return newExitError(exitUsage, "you have not specified a --canned acl or --block-public-access")
+	}
+	if canned != "" && !isValidOption(canned, s3.BucketCannedACL_Values()) {
+		return newExitError(exitUsage, "invalid canned acl: %s, expected one of: %s", canned, strings.Join(s3.BucketCannedACL_Values(), ", "))
+	}
+	if strings.HasPrefix(canned, "public-") || canned == s3.BucketCannedACLAuthenticatedRead {
+		if cx.Bool("block-public-access") {
+			return newExitError(exitUsage, "invalid option, the canned acl: %s is public, it cannot be used with --block-public-access", canned)
+		}
+		if err := confirm(cx, "the canned acl: %s makes the files of the bucket: %s readable by others", canned, bucket); err != nil {
+			return err
+		}
+	}
+
+	if canned != "" {
+		if _, err := cmd.s3Client.PutBucketAclWithContext(cmd.ctx, &s3.PutBucketAclInput{
+			Bucket: aws.String(bucket),
+			ACL:    aws.String(canned),
+		}); err != nil {
+			return fmt.Errorf("unable to set the acl of the bucket: %s, error: %s", bucket, err)
+		}
+		o.fields(map[string]interface{}{
+			"operation": "acl",
+			"bucket":    bucket,
+			"acl":       canned,
+		}).log("successfully set the acl of bucket: %s to: %s\n", bucket, canned)
+	}
+	if cx.Bool("block-public-access") {
+		if _, err := cmd.s3Client.PutPublicAccessBlockWithContext(cmd.ctx, &s3.PutPublicAccessBlockInput{
+			Bucket: aws.String(bucket),
+			PublicAccessBlockConfiguration: &s3.PublicAccessBlockConfiguration{
+				BlockPublicAcls:       aws.Bool(true),
+				BlockPublicPolicy:     aws.Bool(true),
+				IgnorePublicAcls:      aws.Bool(true),
+				RestrictPublicBuckets: aws.Bool(true),
+			},
+		}); err != nil {
+			return fmt.Errorf("unable to block public access to the bucket: %s, error: %s", bucket, err)
+		}
+		o.fields(map[string]interface{}{
+			"operation": "block-public-access",
+			"bucket":    bucket,
+		}).log("successfully blocked public access to bucket: %s\n", bucket)
+	}
+
+	return nil
+}
+
+//
+// publicExposure checks the bucket blocks public access and neither its acl nor its policy grant public
+// access, returning the issues found; the account wide public access block is not checked
+//
+func (r *cliCommand) publicExposure(bucket string) ([]string, error) {
+	var issues []string
+	for _, fn := range []func(string) ([]string, error){
+		r.publicAccessBlockIssues,
+		r.bucketACLIssues,
+		r.bucketPolicyIssues,
+	} {
+		found, err := fn(bucket)
+		if err != nil {
+			return nil, err
+		}
+		issues = append(issues, found...)
+	}
+
+	return issues, nil
+}
+
+//
+// publicAccessBlockIssues returns the settings of the public access block the bucket does not enable
+//
+func (r *cliCommand) publicAccessBlockIssues(bucket string) ([]string, error) {
+	resp, err := r.s3Client.GetPublicAccessBlockWithContext(r.ctx, &s3.GetPublicAccessBlockInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		if e, ok := err.(awserr.Error); ok && e.Code() == "NoSuchPublicAccessBlockConfiguration" {
+			return []string{"the bucket has no public access block"}, nil
+		}
+		return nil, fmt.Errorf("unable to retrieve the public access block of the bucket: %s, error: %s", bucket, err)
+	}
+	config := resp.PublicAccessBlockConfiguration
+	var disabled []string
+	for name, enabled := range map[string]*bool{
+		"BlockPublicAcls":       config.BlockPublicAcls,
+		"IgnorePublicAcls":      config.IgnorePublicAcls,
+		"BlockPublicPolicy":     config.BlockPublicPolicy,
+		"RestrictPublicBuckets": config.RestrictPublicBuckets,
+	} {
+		if !aws.BoolValue(enabled) {
+			disabled = append(disabled, name)
+		}
+	}
+	if len(disabled) <= 0 {
+		return nil, nil
+	}
+	sort.Strings(disabled)
+
+	return []string{fmt.Sprintf("the public access block of the bucket does not enable: %s", strings.Join(disabled, ", "))}, nil
+}
+
+//
+// bucketACLIssues returns the grants of the bucket acl made to everyone or any aws account
+//
+func (r *cliCommand) bucketACLIssues(bucket string) ([]string, error) {
+	resp, err := r.s3Client.GetBucketAclWithContext(r.ctx, &s3.GetBucketAclInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve the acl of the bucket: %s, error: %s", bucket, err)
+	}
+	var issues []string
+	for _, x := range resp.Grants {
+		if x.Grantee == nil {
+			continue
+		}
+		if group, found := publicGroups[aws.StringValue(x.Grantee.URI)]; found {
+			issues = append(issues, fmt.Sprintf("the acl of the bucket grants %s to %s", aws.StringValue(x.Permission), group))
+		}
+	}
+
+	return issues, nil
+}
+
+// describeGrants returns the grants as GRANTEE PERMISSION, and whether any are public
+func describeGrants(grants []*s3.Grant) ([]string, bool) {
+	var list []string
+	var public bool
+	for _, x := range grants {
+		grantee := "-"
+		if x.Grantee != nil {
+			switch {
+			case x.Grantee.URI != nil:
+				grantee = aws.StringValue(x.Grantee.URI)
+				if group, found := publicGroups[grantee]; found {
+					grantee = colorize(colorRed, group)
+					public = true
+				}
+			case x.Grantee.DisplayName != nil:
+				grantee = aws.StringValue(x.Grantee.DisplayName)
+			case x.Grantee.EmailAddress != nil:
+				grantee = aws.StringValue(x.Grantee.EmailAddress)
+			default:
+				grantee = aws.StringValue(x.Grantee.ID)
+			}
+		}
+		list = append(list, fmt.Sprintf("%s %s", grantee, aws.StringValue(x.Permission)))
+	}
+
+	return list, public
+}
+
+//
+// bucketPolicyIssues checks if the policy of the bucket grants public access, a bucket without a policy
+// has no issues
+//
+func (r *cliCommand) bucketPolicyIssues(bucket string) ([]string, error) {
+	resp, err := r.s3Client.GetBucketPolicyStatusWithContext(r.ctx, &s3.GetBucketPolicyStatusInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		if e, ok := err.(awserr.Error); ok && e.Code() == "NoSuchBucketPolicy" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unable to retrieve the policy status of the bucket: %s, error: %s", bucket, err)
+	}
+	if aws.BoolValue(resp.PolicyStatus.IsPublic) {
+		return []string{"the policy of the bucket grants public access"}, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,279 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+)
+
+//
+// newAgentCommand creates a new agent command
+//
+func newAgentCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "agent",
+		Usage: "serve the get, put, list and delete requests of the --use-agent clients on a unix socket, holding the aws session",
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{}, cmd, runAgent)
+		},
+	}
+}
+
+//
+// runAgent listens on the socket, serving the json-rpc requests of the clients with the session of the
+// agent until interrupted
+//
+func runAgent(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	socket := cx.GlobalString("agent-socket")
+	if cmd.agent != nil {
+		return newExitError(exitUsage, "the agent cannot itself use an agent, remove --use-agent")
+	}
+	// note: the clients apply the prefix of their environment to the keys
+	if cmd.prefix != "" {
+		return newExitError(exitUsage, "the agent serves all environments, the clients select them with --env")
+	}
+
+	// step: warm the credentials, assuming any role up front
+	if _, err := cmd.session.Config.Credentials.Get(); err != nil {
+		return fmt.Errorf("unable to retrieve the credentials, error: %s", err)
+	}
+
+	// step: the socket and its directory are only accessible to the user
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return err
+	}
+	if err := checkAgentDirectory(filepath.Dir(socket)); err != nil {
+		return newExitError(exitAccessDenied, "%s", err)
+	}
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+		return fmt.Errorf("an agent is already listening on: %s", socket)
+	}
+	os.Remove(socket)
+	listener, err := listenAgentSocket(socket)
+	if err != nil {
+		return fmt.Errorf("unable to listen on the socket: %s, error: %s", socket, err)
+	}
+	defer os.Remove(socket)
+	if err := os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+	go func() {
+		<-cmd.ctx.Done()
+		listener.Close()
+	}()
+
+	o.fields(map[string]interface{}{
+		"action": "agent",
+		"socket": socket,
+	}).log("the agent is listening on: %s\n", socket)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if cmd.ctx.Err() != nil {
+				logger.infof("exiting the agent")
+				return nil
+			}
+			return err
+		}
+		// note: only processes running as the user may use the credentials of the agent
+		if err := checkAgentPeer(conn); err != nil {
+			logger.warningf("rejecting the agent client, error: %s", err)
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer conn.Close()
+			if err := serveAPIStream(cx, cmd, conn, conn); err != nil {
+				logger.debugf("the agent client disconnected, error: %s", err)
+			}
+		}()
+	}
+}
+
+//
+// agentClient forwards the requests to the agent over the socket, one request at a time
+//
+type agentClient struct {
+	sync.Mutex
+	// the path of the socket
+	socket string
+	// the connection to the agent, dialed on the first request
+	conn net.Conn
+	// the reader of the responses
+	reader *bufio.Reader
+	// the id of the last request
+	id int
+}
+
+// newAgentClient creates a client of the agent listening on the socket
+func newAgentClient(socket string) *agentClient {
+	return &agentClient{socket: socket}
+}
+
+//
+// call sends the request to the agent and decodes the result, the errors of the agent being returned with
+// the exit code the agent would have returned
+//
+func (r *agentClient) call(method string, params *apiParams, result interface{}) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.conn == nil {
+		conn, err := net.DialTimeout("unix", r.socket, 5*time.Second)
+		if err != nil {
+			return fmt.Errorf("unable to connect to the agent on: %s, is kmsctl agent running? error: %s", r.socket, err)
+		}
+		r.conn, r.reader = conn, bufio.NewReader(conn)
+	}
+	r.id++
+	request, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      r.id,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := r.conn.Write(append(request, '\n')); err != nil {
+		r.close()
+		return fmt.Errorf("unable to send the request to the agent, error: %s", err)
+	}
+	line, err := r.reader.ReadBytes('\n')
+	if err != nil {
+		r.close()
+		return fmt.Errorf("unable to read the response of the agent, error: %s", err)
+	}
+
+	response := &struct {
+		Result json.RawMessage `json:"result"`
+		Error  *apiError       `json:"error"`
+	}{}
+	if err := json.Unmarshal(line, response); err != nil {
+		return fmt.Errorf("invalid response from the agent, error: %s", err)
+	}
+	if response.Error != nil {
+		code := exitFailure
+		if x, ok := response.Error.Data["exit-code"].(float64); ok {
+			code = int(x)
+		}
+		return newExitError(code, "%s", response.Error.Message)
+	}
+
+	return json.Unmarshal(response.Result, result)
+}
+
+// close drops the connection, the next request dialing the agent again
+func (r *agentClient) close() {
+	r.conn.Close()
+	r.conn, r.reader = nil, nil
+}
+
+// getFile retrieves the content of the file through the agent
+func (r *agentClient) getFile(bucket, key string) ([]byte, error) {
+	result := &struct {
+		Content  string `json:"content"`
+		Encoding string `json:"encoding"`
+	}{}
+	if err := r.call("get", &apiParams{Bucket: bucket, Key: key}, result); err != nil {
+		return nil, err
+	}
+
+	return decodeContent(&apiParams{Content: result.Content, Encoding: result.Encoding})
+}
+
+// upload places the content into the bucket through the agent
+func (r *agentClient) upload(bucket, key string, body io.Reader, kmsID string, options *uploadOptions, unlocked bool) error {
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	params := &apiParams{
+		Bucket:        bucket,
+		Key:           key,
+		Content:       base64.StdEncoding.EncodeToString(content),
+		Encoding:      apiEncodingBase64,
+		KMS:           kmsID,
+		IfMatch:       options.ifMatch,
+		IfNotExists:   options.ifNotExists,
+		SkipUnchanged: options.skipUnchanged,
+		Compress:      options.compress,
+		Tags:          options.tags,
+		Metadata:      options.metadata,
+		Unlock:        unlocked,
+	}
+	result := &struct {
+		Unchanged bool `json:"unchanged"`
+	}{}
+	if err := r.call("put", params, result); err != nil {
+		return err
+	}
+	if result.Unchanged {
+		return errUnchanged
+	}
+
+	return nil
+}
+
+// listObjects lists the objects under the prefix through the agent
+func (r *agentClient) listObjects(bucket, prefix string) ([]*s3.Object, error) {
+	result := &struct {
+		Files []struct {
+			Key      string    `json:"key"`
+			Size     int64     `json:"size"`
+			Modified time.Time `json:"modified"`
+			ETag     string    `json:"etag"`
+			Class    string    `json:"class"`
+		} `json:"files"`
+	}{}
+	if err := r.call("list", &apiParams{Bucket: bucket, Prefix: prefix}, result); err != nil {
+		return nil, err
+	}
+	var list []*s3.Object
+	for _, x := range result.Files {
+		list = append(list, &s3.Object{
+			Key:          aws.String(x.Key),
+			Size:         aws.Int64(x.Size),
+			LastModified: aws.Time(x.Modified),
+			ETag:         aws.String(x.ETag),
+			StorageClass: aws.String(x.Class),
+		})
+	}
+
+	return list, nil
+}
+
+// removeFile deletes the file through the agent
+func (r *agentClient) removeFile(bucket, key string, unlocked bool) error {
+	return r.call("delete", &apiParams{Bucket: bucket, Key: key, Unlock: unlocked}, &struct{}{})
+}
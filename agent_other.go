@@ -1,5 +1,8 @@
-Copyright 2015 James Saryerwinnie
+//go:build !linux
+// +build !linux
 
+/*
+Copyright 2015 All rights reserved.
 Licensed under the Apache License, Version 2.0 (the "License");
 you may not use this file except in compliance with the License.
 You may obtain a copy of the License at
@@ -11,3 +14,15 @@ distributed under the License is distributed on an "AS IS" BASIS,
 WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
 See the License for the specific language governing permissions and
 limitations under the License.
+*/
+
+package main
+
+import (
+	"net"
+)
+
+// checkAgentPeer is a no-op, the socket and its directory being restricted to the user
+func checkAgentPeer(conn net.Conn) error {
+	return nil
+}
@@ -0,0 +1,56 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+//
+// listenAgentSocket creates the socket readable only by the user, the umask ensuring there is no window
+// in which other users could connect before the permissions are applied
+//
+func listenAgentSocket(socket string) (net.Listener, error) {
+	previous := syscall.Umask(0177)
+	defer syscall.Umask(previous)
+
+	return net.Listen("unix", socket)
+}
+
+//
+// checkAgentDirectory ensures the directory of the socket is owned by and only accessible to the user
+//
+func checkAgentDirectory(directory string) error {
+	info, err := os.Stat(directory)
+	if err != nil {
+		return err
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("the directory: %s of the agent socket is not owned by the user", directory)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("the directory: %s of the agent socket is accessible to other users, "+
+			"restrict it with chmod 0700 or use another --agent-socket", directory)
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+//go:build windows
+// +build windows
+
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net"
+	"os"
+)
+
+// listenAgentSocket creates the socket, which windows restricts by the acl of the directory
+func listenAgentSocket(socket string) (net.Listener, error) {
+	return net.Listen("unix", socket)
+}
+
+// checkAgentDirectory ensures the directory of the socket exists, its acl being inherited from the profile
+func checkAgentDirectory(directory string) error {
+	_, err := os.Stat(directory)
+
+	return err
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/urfave/cli"
+)
+
+//
+// newKMSAliasCommand creates the kms alias management commands
+//
+func newKMSAliasCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "alias",
+		Usage: "provides the ability to create, update and delete aliases independently of the keys",
+		Subcommands: []cli.Command{
+			{
+				Name:  "ls, list",
+				Usage: "retrieve a listing of the aliases, optionally only those pointing at a key",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "k, key",
+						Usage: "only list the aliases pointing to this key `KEY`",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{}, cmd, listAliases)
+				},
+			},
+			{
+				Name:  "create",
+				Usage: "create one or more aliases pointing to an existing key",
+				Flags: []cli.Flag{
+					cli.StringSliceFlag{
+						Name:  "n, name",
+						Usage: "the name of the alias to create, can be specified multiple times `NAME`",
+					},
+					cli.StringFlag{
+						Name:  "k, key",
+						Usage: "the alias, arn or id of the key the aliases should point to `KEY`",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:name:a", "l:key:s"}, cmd, createAliases)
+				},
+			},
+			{
+				Name:  "update",
+				Usage: "point an existing alias at a different key, i.e. for key rotation",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "n, name",
+						Usage: "the name of the alias to update `NAME`",
+					},
+					cli.StringFlag{
+						Name:  "k, key",
+						Usage: "the alias, arn or id of the key the alias should now point to `KEY`",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:name:s", "l:key:s"}, cmd, updateAlias)
+				},
+			},
+			{
+				Name:    "delete",
+				Aliases: []string{"rm"},
+				Usage:   "delete one or more aliases, the keys themselves are not affected",
+				Flags: []cli.Flag{
+					cli.StringSliceFlag{
+						Name:  "n, name",
+						Usage: "the name of the alias to delete, can be specified multiple times `NAME`",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:name:a"}, cmd, deleteAliases)
+				},
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{}, cmd, listAliases)
+		},
+	}
+}
+
+//
+// listAliases provides a listing of the aliases and the keys they point to
+//
+func listAliases(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	var keyID string
+	if cx.String("key") != "" {
+		id, err := cmd.describeKeyID(cx.String("key"))
+		if err != nil {
+			return err
+		}
+		keyID = id
+	}
+
+	aliases, err := cmd.kmsKeys()
+	if err != nil {
+		return err
+	}
+	for _, x := range aliases {
+		target := aws.StringValue(x.TargetKeyId)
+		if keyID != "" && target != keyID {
+			continue
+		}
+		o.fields(map[string]interface{}{
+			"alias": *x.AliasName,
+			"id":    target,
+		}).log("%-40s %s\n", *x.AliasName, target)
+	}
+
+	return nil
+}
+
+//
+// createAliases creates one or more aliases for a key
+//
+func createAliases(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	key := cx.String("key")
+
+	keyID, err := cmd.describeKeyID(key)
+	if err != nil {
+		return err
+	}
+	for _, name := range cx.StringSlice("name") {
+		if _, err := cmd.kmsClient.CreateAliasWithContext(cmd.ctx, &kms.CreateAliasInput{
+			AliasName:   aws.String(aliasName(name)),
+			TargetKeyId: aws.String(keyID),
+		}); err != nil {
+			return fmt.Errorf("unable to create alias: %s, error: %s", name, err)
+		}
+
+		o.fields(map[string]interface{}{
+			"action": "create",
+			"alias":  aliasName(name),
+			"id":     keyID,
+		}).log("successfully created the alias: %s pointing to: %s\n", aliasName(name), keyID)
+	}
+
+	return nil
+}
+
+//
+// updateAlias points an existing alias to another key
+//
+func updateAlias(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	name := aliasName(cx.String("name"))
+
+	// step: retrieve the current target of the alias
+	alias, err := cmd.getKmsAlias(cx.String("name"))
+	if err != nil {
+		return err
+	}
+	keyID, err := cmd.describeKeyID(cx.String("key"))
+	if err != nil {
+		return err
+	}
+
+	if _, err := cmd.kmsClient.UpdateAliasWithContext(cmd.ctx, &kms.UpdateAliasInput{
+		AliasName:   aws.String(name),
+		TargetKeyId: aws.String(keyID),
+	}); err != nil {
+		return err
+	}
+
+	o.fields(map[string]interface{}{
+		"action":   "update",
+		"alias":    name,
+		"id":       keyID,
+		"previous": aws.StringValue(alias.TargetKeyId),
+	}).log("successfully updated the alias: %s from: %s to: %s\n", name, aws.StringValue(alias.TargetKeyId), keyID)
+
+	return nil
+}
+
+//
+// deleteAliases removes one or more aliases
+//
+func deleteAliases(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	for _, name := range cx.StringSlice("name") {
+		if _, err := cmd.kmsClient.DeleteAliasWithContext(cmd.ctx, &kms.DeleteAliasInput{
+			AliasName: aws.String(aliasName(name)),
+		}); err != nil {
+			return fmt.Errorf("unable to delete alias: %s, error: %s", name, err)
+		}
+
+		o.fields(map[string]interface{}{
+			"action": "delete",
+			"alias":  aliasName(name),
+		}).log("successfully deleted the alias: %s\n", aliasName(name))
+	}
+
+	return nil
+}
+
+//
+// describeKeyID resolves an alias, arn or id to the key id
+//
+func (r *cliCommand) describeKeyID(name string) (string, error) {
+	metadata, err := r.describeKey(name)
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(metadata.KeyId), nil
+}
+
+//
+// describeKey retrieves the metadata for a key from an alias, arn or id
+//
+func (r *cliCommand) describeKey(name string) (*kms.KeyMetadata, error) {
+	resp, err := r.kmsClient.DescribeKeyWithContext(r.ctx, &kms.DescribeKeyInput{
+		KeyId: aws.String(kmsKeyID(name)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.KeyMetadata, nil
+}
+
+//
+// aliasName ensures the alias name carries the alias/ prefix
+//
+func aliasName(name string) string {
+	if strings.HasPrefix(name, "alias/") {
+		return name
+	}
+
+	return "alias/" + name
+}
@@ -0,0 +1,458 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/urfave/cli"
+)
+
+const (
+	// the request is not valid json
+	apiParseError = -32700
+	// the request is not a valid request
+	apiInvalidRequest = -32600
+	// the method does not exist
+	apiMethodNotFound = -32601
+	// the parameters of the method are invalid
+	apiInvalidParams = -32602
+	// the method failed, the exit code is given in the data
+	apiMethodFailed = -32000
+)
+
+// the encodings of the content in the requests and responses
+const (
+	apiEncodingText   = "utf-8"
+	apiEncodingBase64 = "base64"
+)
+
+//
+// apiRequest is a json-rpc request read from stdin
+//
+type apiRequest struct {
+	// the version of the protocol, 2.0
+	Version string `json:"jsonrpc"`
+	// the id of the request, returned in the response
+	ID json.RawMessage `json:"id,omitempty"`
+	// the method to call
+	Method string `json:"method"`
+	// the parameters of the method
+	Params *apiParams `json:"params"`
+}
+
+//
+// apiParams are the parameters of the methods, each using those it needs
+//
+type apiParams struct {
+	// the bucket, defaults to --bucket
+	Bucket string `json:"bucket"`
+	// the key of the file
+	Key string `json:"key"`
+	// the prefix of the files listed
+	Prefix string `json:"prefix"`
+	// the content of the file or plaintext
+	Content string `json:"content"`
+	// the encoding of the content, utf-8 or base64
+	Encoding string `json:"encoding"`
+	// the kms key, defaults to --kms
+	KMS string `json:"kms"`
+	// the base64 encoded ciphertext to decrypt
+	Ciphertext string `json:"ciphertext"`
+	// only upload if the etag of the file matches
+	IfMatch string `json:"if-match"`
+	// only upload if the file does not exist
+	IfNotExists bool `json:"if-not-exists"`
+	// skip the upload if the file is unchanged
+	SkipUnchanged bool `json:"skip-unchanged"`
+	// the compression applied to the content on upload
+	Compress string `json:"compress"`
+	// the tags placed on the file
+	Tags map[string]string `json:"tags"`
+	// the additional metadata of the file
+	Metadata map[string]string `json:"metadata"`
+	// permit the locked files to be modified
+	Unlock bool `json:"unlock"`
+}
+
+//
+// apiResponse is a json-rpc response written to stdout
+//
+type apiResponse struct {
+	// the version of the protocol, 2.0
+	Version string `json:"jsonrpc"`
+	// the id of the request
+	ID json.RawMessage `json:"id"`
+	// the result of the method
+	Result interface{} `json:"result,omitempty"`
+	// the error of the method
+	Error *apiError `json:"error,omitempty"`
+}
+
+//
+// apiError is the error of a failed request
+//
+type apiError struct {
+	// the json-rpc error code
+	Code int `json:"code"`
+	// the error message
+	Message string `json:"message"`
+	// the exit code the command would have returned, for a failed method
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// apiMethod handles a method of the api
+type apiMethod func(cx *cli.Context, cmd *cliCommand, params *apiParams) (interface{}, error)
+
+// the methods of the api
+var apiMethods = map[string]apiMethod{
+	"get":          apiGet,
+	"put":          apiPut,
+	"list":         apiList,
+	"delete":       apiDelete,
+	"kms.list":     apiKMSList,
+	"kms.describe": apiKMSDescribe,
+	"kms.encrypt":  apiKMSEncrypt,
+	"kms.decrypt":  apiKMSDecrypt,
+}
+
+//
+// newAPICommand creates a new api command
+//
+func newAPICommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "api",
+		Usage: "serve newline delimited json-rpc requests, i.e. get, put, list, delete and kms.*, for embedding in editors and daemons",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "stdio",
+				Usage: "read the requests from stdin and write the responses to stdout, one per line",
+			},
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the default bucket of the requests `NAME`",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:   "k, kms",
+				Usage:  "the default kms key of the requests `KEY`",
+				EnvVar: "AWS_KMS_ID",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{}, cmd, serveAPI)
+		},
+	}
+}
+
+//
+// serveAPI handles the requests read from stdin in order until the input is closed, each request
+// producing a single line response
+//
+func serveAPI(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	if !cx.Bool("stdio") {
+		return newExitError(exitUsage, "you must specify the transport of the api, i.e. --stdio")
+	}
+
+	return serveAPIStream(cx, cmd, os.Stdin, os.Stdout)
+}
+
+//
+// serveAPIStream handles the newline delimited requests from the reader until it is closed, writing the
+// responses to the writer
+//
+func serveAPIStream(cx *cli.Context, cmd *cliCommand, in io.Reader, out io.Writer) error {
+	reader := bufio.NewReader(in)
+	encoder := json.NewEncoder(out)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if err := encoder.Encode(handleAPIRequest(cx, cmd, line)); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if cmd.ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+//
+// handleAPIRequest parses and dispatches the request, returning the response
+//
+func handleAPIRequest(cx *cli.Context, cmd *cliCommand, line []byte) *apiResponse {
+	response := &apiResponse{Version: "2.0", ID: json.RawMessage("null")}
+
+	request := new(apiRequest)
+	if err := json.Unmarshal(line, request); err != nil {
+		response.Error = &apiError{Code: apiParseError, Message: fmt.Sprintf("invalid request, error: %s", err)}
+		return response
+	}
+	if len(request.ID) > 0 {
+		response.ID = request.ID
+	}
+	if request.Version != "2.0" || request.Method == "" {
+		response.Error = &apiError{Code: apiInvalidRequest, Message: "the request must have a jsonrpc of 2.0 and a method"}
+		return response
+	}
+	method, found := apiMethods[request.Method]
+	if !found {
+		response.Error = &apiError{Code: apiMethodNotFound, Message: fmt.Sprintf("unknown method: %s", request.Method)}
+		return response
+	}
+	params := request.Params
+	if params == nil {
+		params = new(apiParams)
+	}
+	params.Bucket = defaultValue(params.Bucket, cx.String("bucket"))
+	params.KMS = defaultValue(params.KMS, cx.String("kms"))
+	logger.debugf("handling the api request: %s, method: %s", string(response.ID), request.Method)
+	// note: the requests are served concurrently, so the unlock applies to a copy
+	if params.Unlock {
+		unlocked := *cmd
+		unlocked.unlocked = true
+		cmd = &unlocked
+	}
+
+	result, err := method(cx, cmd, params)
+	if err != nil {
+		code := exitCode(err)
+		response.Error = &apiError{Code: apiMethodFailed, Message: err.Error(), Data: map[string]interface{}{"exit-code": code}}
+		if code == exitUsage {
+			response.Error.Code = apiInvalidParams
+		}
+		return response
+	}
+	response.Result = result
+
+	return response
+}
+
+// requireParams checks the parameters are given
+func requireParams(values map[string]string) error {
+	var names []string
+	for name, value := range values {
+		if value == "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		return newExitError(exitUsage, "missing the parameters: %s", strings.Join(names, ", "))
+	}
+
+	return nil
+}
+
+// decodeContent returns the content of the parameters in the encoding given
+func decodeContent(params *apiParams) ([]byte, error) {
+	switch params.Encoding {
+	case "", apiEncodingText:
+		return []byte(params.Content), nil
+	case apiEncodingBase64:
+		content, err := base64.StdEncoding.DecodeString(params.Content)
+		if err != nil {
+			return nil, newExitError(exitUsage, "invalid base64 content, error: %s", err)
+		}
+		return content, nil
+	}
+
+	return nil, newExitError(exitUsage, "invalid encoding: %s, must be %s or %s", params.Encoding, apiEncodingText, apiEncodingBase64)
+}
+
+// encodeContent returns the content as text if valid utf-8, else base64
+func encodeContent(result map[string]interface{}, content []byte) map[string]interface{} {
+	if utf8.Valid(content) {
+		result["content"], result["encoding"] = string(content), apiEncodingText
+	} else {
+		result["content"], result["encoding"] = base64.StdEncoding.EncodeToString(content), apiEncodingBase64
+	}
+
+	return result
+}
+
+// apiGet returns the content of the file
+func apiGet(cx *cli.Context, cmd *cliCommand, params *apiParams) (interface{}, error) {
+	if err := requireParams(map[string]string{"bucket": params.Bucket, "key": params.Key}); err != nil {
+		return nil, err
+	}
+	content, err := cmd.getFile(params.Bucket, params.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeContent(map[string]interface{}{"bucket": params.Bucket, "key": params.Key}, content), nil
+}
+
+// apiPut uploads the content to the file, encrypted with the kms key or the default encryption of the bucket
+func apiPut(cx *cli.Context, cmd *cliCommand, params *apiParams) (interface{}, error) {
+	if err := requireParams(map[string]string{"bucket": params.Bucket, "key": params.Key}); err != nil {
+		return nil, err
+	}
+	content, err := decodeContent(params)
+	if err != nil {
+		return nil, err
+	}
+	if params.IfNotExists && params.IfMatch != "" {
+		return nil, newExitError(exitUsage, "you cannot use if-not-exists and if-match together")
+	}
+	// note: without a kms key the default encryption of the bucket must be kms
+	if params.KMS == "" {
+		if err := cmd.hasDefaultKmsEncryption(params.Bucket); err != nil {
+			return nil, err
+		}
+	}
+	if err := validCompression(params.Compress); err != nil {
+		return nil, err
+	}
+	options := &uploadOptions{
+		tags:          params.Tags,
+		metadata:      params.Metadata,
+		compress:      params.Compress,
+		skipUnchanged: params.SkipUnchanged,
+		ifMatch:       params.IfMatch,
+		ifNotExists:   params.IfNotExists,
+	}
+	err = cmd.putContent(params.Bucket, params.Key, content, params.KMS, options)
+	if err != nil && err != errUnchanged {
+		return nil, err
+	}
+
+	return map[string]interface{}{"bucket": params.Bucket, "key": params.Key, "size": len(content), "unchanged": err == errUnchanged}, nil
+}
+
+// apiList returns the files under the prefix
+func apiList(cx *cli.Context, cmd *cliCommand, params *apiParams) (interface{}, error) {
+	if err := requireParams(map[string]string{"bucket": params.Bucket}); err != nil {
+		return nil, err
+	}
+	files, err := cmd.listBucketKeys(params.Bucket, params.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]map[string]interface{}, 0)
+	for _, x := range files {
+		list = append(list, map[string]interface{}{
+			"key":      aws.StringValue(x.Key),
+			"size":     aws.Int64Value(x.Size),
+			"modified": aws.TimeValue(x.LastModified),
+			"etag":     aws.StringValue(x.ETag),
+			"class":    aws.StringValue(x.StorageClass),
+		})
+	}
+
+	return map[string]interface{}{"bucket": params.Bucket, "files": list}, nil
+}
+
+// apiDelete removes the file
+func apiDelete(cx *cli.Context, cmd *cliCommand, params *apiParams) (interface{}, error) {
+	if err := requireParams(map[string]string{"bucket": params.Bucket, "key": params.Key}); err != nil {
+		return nil, err
+	}
+	if err := cmd.removeFile(params.Bucket, params.Key); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"bucket": params.Bucket, "key": params.Key}, nil
+}
+
+// apiKMSList returns the aliases of the kms keys
+func apiKMSList(cx *cli.Context, cmd *cliCommand, params *apiParams) (interface{}, error) {
+	aliases, err := cmd.kmsKeys()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]map[string]interface{}, 0)
+	for _, x := range aliases {
+		list = append(list, map[string]interface{}{
+			"alias":  aws.StringValue(x.AliasName),
+			"arn":    aws.StringValue(x.AliasArn),
+			"key-id": aws.StringValue(x.TargetKeyId),
+		})
+	}
+
+	return map[string]interface{}{"keys": list}, nil
+}
+
+// apiKMSDescribe returns the metadata of the kms key
+func apiKMSDescribe(cx *cli.Context, cmd *cliCommand, params *apiParams) (interface{}, error) {
+	if err := requireParams(map[string]string{"kms": params.KMS}); err != nil {
+		return nil, err
+	}
+	metadata, err := cmd.describeKey(params.KMS)
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// apiKMSEncrypt encrypts the content with the kms key, returning the base64 ciphertext
+func apiKMSEncrypt(cx *cli.Context, cmd *cliCommand, params *apiParams) (interface{}, error) {
+	if err := requireParams(map[string]string{"kms": params.KMS}); err != nil {
+		return nil, err
+	}
+	content, err := decodeContent(params)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := cmd.kmsClient.EncryptWithContext(cmd.ctx, &kms.EncryptInput{
+		KeyId:     aws.String(kmsKeyID(params.KMS)),
+		Plaintext: content,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"key-id":     aws.StringValue(resp.KeyId),
+		"ciphertext": base64.StdEncoding.EncodeToString(resp.CiphertextBlob),
+	}, nil
+}
+
+// apiKMSDecrypt decrypts the base64 ciphertext
+func apiKMSDecrypt(cx *cli.Context, cmd *cliCommand, params *apiParams) (interface{}, error) {
+	if err := requireParams(map[string]string{"ciphertext": params.Ciphertext}); err != nil {
+		return nil, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(params.Ciphertext)
+	if err != nil {
+		return nil, newExitError(exitUsage, "invalid base64 ciphertext, error: %s", err)
+	}
+	resp, err := cmd.kmsClient.DecryptWithContext(cmd.ctx, &kms.DecryptInput{CiphertextBlob: ciphertext})
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeContent(map[string]interface{}{"key-id": aws.StringValue(resp.KeyId)}, resp.Plaintext), nil
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+)
+
+// the storage classes which must be restored before they can be retrieved
+var archiveStorageClasses = []string{
+	s3.StorageClassGlacier,
+	s3.StorageClassDeepArchive,
+}
+
+// the retrieval tiers of a restore
+var archiveTiers = []string{
+	s3.TierStandard,
+	s3.TierExpedited,
+	s3.TierBulk,
+}
+
+//
+// newRestoreArchiveCommand creates a new restore-archive command
+//
+func newRestoreArchiveCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:      "restore-archive",
+		Usage:     "restore one or more files which have transitioned to an archive storage class, i.e. glacier",
+		ArgsUsage: "PATH...",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files `NAME`",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.IntFlag{
+				Name:  "days",
+				Usage: "the number of days the restored copy is available for",
+				Value: 1,
+			},
+			cli.StringFlag{
+				Name:  "tier",
+				Usage: "the retrieval tier, Standard, Expedited or Bulk `TIER`",
+				Value: s3.TierStandard,
+			},
+			cli.BoolFlag{
+				Name:  "wait",
+				Usage: "wait for the restorations to complete",
+			},
+			cli.DurationFlag{
+				Name:  "poll-interval",
+				Usage: "the interval between checking if the restorations have completed `DURATION`",
+				Value: time.Minute,
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s"}, cmd, restoreArchives)
+		},
+	}
+}
+
+//
+// restoreArchives requests the restoration of the archived files, optionally waiting for them
+//
+func restoreArchives(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	days := cx.Int("days")
+	tier := cx.String("tier")
+
+	if len(cx.Args()) <= 0 {
+		return newExitError(exitUsage, "you have not specified any files to restore")
+	}
+	if !isValidOption(tier, archiveTiers) {
+		return newExitError(exitUsage, "invalid tier: %s, must be one of %s", tier, strings.Join(archiveTiers, ", "))
+	}
+	if days <= 0 {
+		return newExitError(exitUsage, "the days must be positive")
+	}
+
+	// step: request the restoration of the files
+	var pending []string
+	for _, x := range cx.Args() {
+		key := strings.TrimPrefix(x, "/")
+		head, err := cmd.getFileMetadata(key, bucket)
+		if err != nil {
+			return err
+		}
+		if !isArchived(head) {
+			o.fields(map[string]interface{}{
+				"action": "restore-archive",
+				"bucket": bucket,
+				"key":    key,
+				"state":  "available",
+			}).log("the file: %s is not archived\n", key)
+			continue
+		}
+		request := &s3.RestoreRequest{
+			GlacierJobParameters: &s3.GlacierJobParameters{Tier: aws.String(tier)},
+		}
+		// note: the intelligent tiering archives do not accept an expiry
+		if head.ArchiveStatus == nil {
+			request.Days = aws.Int64(int64(days))
+		}
+		_, err = cmd.s3Client.RestoreObjectWithContext(cmd.ctx, &s3.RestoreObjectInput{
+			Bucket:         aws.String(bucket),
+			Key:            aws.String(cmd.objectKey(key)),
+			RestoreRequest: request,
+		})
+		if err != nil {
+			if e, ok := err.(awserr.Error); !ok || e.Code() != "RestoreAlreadyInProgress" {
+				return fmt.Errorf("failed to restore the file: %s, error: %s", key, err)
+			}
+		}
+		pending = append(pending, key)
+
+		o.fields(map[string]interface{}{
+			"action": "restore-archive",
+			"bucket": bucket,
+			"key":    key,
+			"tier":   tier,
+			"state":  "restoring",
+		}).log("requested the restoration of the file: %s (%s)\n", key, tier)
+	}
+	if !cx.Bool("wait") {
+		return nil
+	}
+
+	// step: poll until the files have been restored
+	for len(pending) > 0 {
+		select {
+		case <-cmd.ctx.Done():
+			return cmd.ctx.Err()
+		case <-time.After(cx.Duration("poll-interval")):
+		}
+		var remaining []string
+		for _, key := range pending {
+			head, err := cmd.getFileMetadata(key, bucket)
+			if err != nil {
+				return err
+			}
+			if isArchived(head) {
+				remaining = append(remaining, key)
+				continue
+			}
+			o.fields(map[string]interface{}{
+				"action": "restore-archive",
+				"bucket": bucket,
+				"key":    key,
+				"state":  "restored",
+			}).log("the file: %s has been restored\n", key)
+		}
+		pending = remaining
+	}
+
+	return nil
+}
+
+// isArchived checks if the file must be restored before it can be retrieved
+func isArchived(head *s3.HeadObjectOutput) bool {
+	if head.ArchiveStatus != nil {
+		return true
+	}
+	if !isValidOption(aws.StringValue(head.StorageClass), archiveStorageClasses) {
+		return false
+	}
+
+	// note: the restore header is absent until requested and carries an expiry once complete
+	return !strings.Contains(aws.StringValue(head.Restore), `ongoing-request="false"`)
+}
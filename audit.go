@@ -0,0 +1,184 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/urfave/cli"
+)
+
+//
+// newAuditCommand creates a new audit command
+//
+func newAuditCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "audit",
+		Usage: "search cloudtrail for who accessed (decrypted) the files in the bucket and when",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:  "k, key",
+				Usage: "the key of the file in the bucket, a trailing slash matches all the files under the path `KEY`",
+			},
+			cli.StringFlag{
+				Name:  "since",
+				Usage: "search for access within this duration, i.e. 12h or 7d `DURATION`",
+				Value: "7d",
+			},
+			cli.StringFlag{
+				Name:  "until",
+				Usage: "search for access up until this duration ago `DURATION`",
+			},
+			cli.IntFlag{
+				Name:  "max-events",
+				Usage: "the maximum number of cloudtrail events to search through, zero for no limit",
+				Value: 10000,
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s", "l:key:s"}, cmd, auditAccess)
+		},
+	}
+}
+
+//
+// trailEvent is the subset of the cloudtrail event we are interested in
+//
+type trailEvent struct {
+	EventTime    time.Time `json:"eventTime"`
+	EventName    string    `json:"eventName"`
+	ErrorCode    string    `json:"errorCode"`
+	SourceIP     string    `json:"sourceIPAddress"`
+	UserAgent    string    `json:"userAgent"`
+	UserIdentity struct {
+		Type        string `json:"type"`
+		ARN         string `json:"arn"`
+		PrincipalID string `json:"principalId"`
+		InvokedBy   string `json:"invokedBy"`
+	} `json:"userIdentity"`
+	RequestParameters struct {
+		EncryptionContext map[string]string `json:"encryptionContext"`
+	} `json:"requestParameters"`
+}
+
+//
+// auditAccess searches the kms decrypt events performed by s3 on behalf of the callers retrieving the file
+//
+func auditAccess(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	key := strings.TrimPrefix(cx.String("key"), "/")
+	maxEvents := cx.Int("max-events")
+
+	// step: parse the time window
+	since, err := parseDuration(cx.String("since"))
+	if err != nil {
+		return newExitError(exitUsage, "invalid since: %s, error: %s", cx.String("since"), err)
+	}
+	endTime := time.Now()
+	if until := cx.String("until"); until != "" {
+		duration, err := parseDuration(until)
+		if err != nil {
+			return newExitError(exitUsage, "invalid until: %s, error: %s", until, err)
+		}
+		endTime = endTime.Add(-duration)
+	}
+	startTime := time.Now().Add(-since)
+
+	// step: the events are recorded in the region of the bucket
+	region, err := cmd.getBucketRegion(bucket)
+	if err != nil {
+		return err
+	}
+	client := cloudtrail.New(cmd.sessionForRegion(region))
+
+	// note: s3 retrieves the data key with the object arn as the encryption context
+	objectARN := s3ARN(region, bucket+"/"+cmd.objectKey(key))
+	bucketARN := s3ARN(region, bucket)
+
+	var found, scanned, bucketKeyed int
+	err = client.LookupEventsPagesWithContext(cmd.ctx, &cloudtrail.LookupEventsInput{
+		LookupAttributes: []*cloudtrail.LookupAttribute{
+			{
+				AttributeKey:   aws.String(cloudtrail.LookupAttributeKeyEventName),
+				AttributeValue: aws.String("Decrypt"),
+			},
+		},
+		StartTime: aws.Time(startTime),
+		EndTime:   aws.Time(endTime),
+	}, func(page *cloudtrail.LookupEventsOutput, lastPage bool) bool {
+		for _, x := range page.Events {
+			scanned++
+			event := new(trailEvent)
+			if err := json.Unmarshal([]byte(aws.StringValue(x.CloudTrailEvent)), event); err != nil {
+				continue
+			}
+			resource := event.RequestParameters.EncryptionContext["aws:s3:arn"]
+			switch {
+			case resource == objectARN, strings.HasSuffix(objectARN, "/") && strings.HasPrefix(resource, objectARN):
+			case resource == bucketARN:
+				bucketKeyed++
+				continue
+			default:
+				continue
+			}
+			found++
+
+			identity := defaultValue(event.UserIdentity.ARN, event.UserIdentity.PrincipalID)
+			status := defaultValue(event.ErrorCode, "success")
+			o.fields(map[string]interface{}{
+				"action":     "audit",
+				"time":       event.EventTime.Format(time.RFC3339),
+				"identity":   identity,
+				"type":       event.UserIdentity.Type,
+				"source-ip":  event.SourceIP,
+				"user-agent": event.UserAgent,
+				"key":        strings.TrimPrefix(resource, bucketARN+"/"),
+				"status":     status,
+			}).log("%-25s %-70s %-16s %-40s %s\n", event.EventTime.Format(time.RFC3339), identity, event.SourceIP,
+				strings.TrimPrefix(resource, bucketARN+"/"), status)
+		}
+
+		return maxEvents <= 0 || scanned < maxEvents
+	})
+	if err != nil {
+		return err
+	}
+
+	if maxEvents > 0 && scanned >= maxEvents {
+		logger.warningf("stopped after searching %d events, use --max-events or narrow the window for more", scanned)
+	}
+	if bucketKeyed > 0 {
+		logger.warningf("%d events used an s3 bucket key and cannot be attributed to a file", bucketKeyed)
+	}
+	if found <= 0 {
+		o.fields(map[string]interface{}{
+			"action":  "audit",
+			"scanned": scanned,
+			"found":   0,
+		}).log("no access to s3://%s/%s found within the %d events searched\n", bucket, key, scanned)
+	}
+
+	return nil
+}
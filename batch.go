@@ -0,0 +1,336 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v2"
+)
+
+// the operations permitted in a batch manifest
+var batchOperations = []string{"get", "put", "rm", "copy"}
+
+//
+// batchManifest is the list of operations to perform
+//
+type batchManifest struct {
+	// the bucket the operations are performed against, defaults to --bucket
+	Bucket string `yaml:"bucket"`
+	// the default kms key of the put and copy operations
+	KMS string `yaml:"kms"`
+	// the operations performed in order
+	Operations []*batchOperation `yaml:"operations"`
+}
+
+//
+// batchOperation is a single operation of the manifest
+//
+type batchOperation struct {
+	// the operation, get, put, rm or copy
+	Op string `yaml:"op"`
+	// the key in the bucket, or the local file for a put
+	Source string `yaml:"source"`
+	// the key in the bucket, or the local file for a get
+	Destination string `yaml:"destination"`
+	// the kms key the files are encrypted with on put or copy
+	KMS string `yaml:"kms"`
+	// the file permissions of the file on get
+	Mode string `yaml:"mode"`
+}
+
+// batchUndo reverts an applied operation
+type batchUndo func() error
+
+//
+// newBatchCommand creates a new batch command
+//
+func newBatchCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "batch",
+		Usage: "perform the get, put, rm and copy operations listed in a manifest, rolling back the changes on a failure",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket, unless specified by the manifest `NAME`",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:  "f, file",
+				Usage: "the path to the manifest listing the operations `PATH`",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "validate the manifest and display the operations without performing them",
+			},
+			cli.BoolFlag{
+				Name:  "no-rollback",
+				Usage: "do not revert the operations already performed when one fails",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:file:s"}, cmd, runBatch)
+		},
+	}
+}
+
+//
+// runBatch performs the operations of the manifest in order, stopping at the first failure and reverting
+// the operations already performed
+//
+func runBatch(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	manifest, err := loadBatchManifest(cx.String("file"))
+	if err != nil {
+		return err
+	}
+	bucket := defaultValue(manifest.Bucket, cx.String("bucket"))
+	if bucket == "" {
+		return newExitError(exitUsage, "no bucket specified by the manifest or the --bucket option")
+	}
+
+	if cx.Bool("dry-run") {
+		for i, x := range manifest.Operations {
+			o.fields(map[string]interface{}{
+				"index":       i + 1,
+				"op":          x.Op,
+				"source":      x.Source,
+				"destination": x.Destination,
+				"dry-run":     true,
+			}).log("[dry-run] %d: %s\n", i+1, x.describe())
+		}
+		return nil
+	}
+
+	// step: the bucket must encrypt by default if any upload has no kms key
+	for _, x := range manifest.Operations {
+		if (x.Op == "put" || x.Op == "copy") && x.KMS == "" && manifest.KMS == "" {
+			if err := cmd.hasDefaultKmsEncryption(bucket); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	summary := newTransferSummary("applied")
+	var undos []batchUndo
+	for i, x := range manifest.Operations {
+		kmsKey := defaultValue(x.KMS, manifest.KMS)
+
+		undo, err := cmd.applyBatchOperation(bucket, kmsKey, x)
+		if err != nil {
+			summary.fail(fmt.Sprintf("%d: %s", i+1, x.describe()), err)
+			if !cx.Bool("no-rollback") {
+				// step: revert the operations in reverse
+				for j := len(undos) - 1; j >= 0; j-- {
+					if rerr := undos[j](); rerr != nil {
+						summary.fail(fmt.Sprintf("%d: rollback of %s", j+1, manifest.Operations[j].describe()), rerr)
+					}
+				}
+				o.log("rolled back %d operations\n", len(undos))
+			}
+			break
+		}
+		undos = append(undos, undo)
+		summary.success()
+
+		o.fields(map[string]interface{}{
+			"index":       i + 1,
+			"op":          x.Op,
+			"bucket":      bucket,
+			"source":      x.Source,
+			"destination": x.Destination,
+		}).log("%d: %s\n", i+1, x.describe())
+	}
+	summary.print(o)
+
+	return summary.err()
+}
+
+//
+// applyBatchOperation performs the operation, returning the means to revert it
+//
+func (r *cliCommand) applyBatchOperation(bucket, kmsKey string, x *batchOperation) (batchUndo, error) {
+	switch x.Op {
+	case "get":
+		mode, _ := strconv.ParseUint(defaultValue(x.Mode, "0600"), 0, 32)
+		undo, err := snapshotLocalFile(x.Destination)
+		if err != nil {
+			return nil, err
+		}
+		content, err := r.getFile(bucket, x.Source)
+		if err != nil {
+			return nil, err
+		}
+		return undo, writeFileAtomic(x.Destination, content, os.FileMode(mode))
+	case "put":
+		undo, err := r.snapshotFile(bucket, x.Destination)
+		if err != nil {
+			return nil, err
+		}
+		return undo, r.putFile(bucket, x.Destination, x.Source, kmsKey, nil)
+	case "rm":
+		undo, err := r.snapshotFile(bucket, x.Source)
+		if err != nil {
+			return nil, err
+		}
+		return undo, r.removeFile(bucket, x.Source)
+	case "copy":
+		undo, err := r.snapshotFile(bucket, x.Destination)
+		if err != nil {
+			return nil, err
+		}
+		if kmsKey == "" {
+			return undo, r.copyFile(bucket, x.Source, x.Destination)
+		}
+		// note: the file is re-encrypted with the kms key
+		content, err := r.getFile(bucket, x.Source)
+		if err != nil {
+			return nil, err
+		}
+		return undo, r.putContent(bucket, x.Destination, content, kmsKey, nil)
+	}
+
+	return nil, fmt.Errorf("unknown operation: %s", x.Op)
+}
+
+//
+// snapshotFile records the current state of the file in the bucket, returning the means to restore it
+//
+func (r *cliCommand) snapshotFile(bucket, key string) (batchUndo, error) {
+	head, err := r.getFileMetadata(key, bucket)
+	if err != nil {
+		if exitCode(err) != exitNotFound {
+			return nil, err
+		}
+		return func() error { return r.removeFile(bucket, key) }, nil
+	}
+	content, err := r.getFile(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := r.getObjectTags(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	var kmsKey string
+	if aws.StringValue(head.ServerSideEncryption) == s3.ServerSideEncryptionAwsKms {
+		kmsKey = aws.StringValue(head.SSEKMSKeyId)
+	}
+
+	return func() error { return r.putContent(bucket, key, content, kmsKey, &uploadOptions{tags: tags}) }, nil
+}
+
+// snapshotLocalFile records the current state of the local file, returning the means to restore it
+func snapshotLocalFile(path string) (batchUndo, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return func() error { return os.Remove(path) }, nil
+	} else if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() error { return writeFileAtomic(path, content, info.Mode().Perm()) }, nil
+}
+
+//
+// loadBatchManifest reads and validates the manifest
+//
+func loadBatchManifest(path string) (*batchManifest, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the manifest: %s, error: %s", path, err)
+	}
+	manifest := new(batchManifest)
+	if err := yaml.Unmarshal(content, manifest); err != nil {
+		return nil, fmt.Errorf("unable to parse the manifest: %s, error: %s", path, err)
+	}
+	if len(manifest.Operations) <= 0 {
+		return nil, fmt.Errorf("the manifest: %s has no operations", path)
+	}
+
+	// step: validate all the operations before performing any
+	for i, x := range manifest.Operations {
+		if err := x.validate(); err != nil {
+			return nil, newExitError(exitUsage, "invalid operation %d in the manifest: %s, error: %s", i+1, path, err)
+		}
+	}
+
+	return manifest, nil
+}
+
+//
+// validate checks the operation has the fields it requires
+//
+func (x *batchOperation) validate() error {
+	if !isValidOption(x.Op, batchOperations) {
+		return fmt.Errorf("unknown op: %s, must be one of %s", x.Op, strings.Join(batchOperations, ", "))
+	}
+	// note: the keys in the bucket are relative, the local files of get and put are not
+	if x.Op != "put" {
+		x.Source = strings.TrimPrefix(x.Source, "/")
+	}
+	if x.Op != "get" {
+		x.Destination = strings.TrimPrefix(x.Destination, "/")
+	}
+	if x.Source == "" {
+		return fmt.Errorf("no source specified")
+	}
+	switch x.Op {
+	case "rm":
+		if x.Destination != "" {
+			return fmt.Errorf("the rm operation does not take a destination")
+		}
+	default:
+		if x.Destination == "" {
+			return fmt.Errorf("no destination specified")
+		}
+	}
+	if x.Op == "put" {
+		if found, err := isFile(x.Source); err != nil || !found {
+			return fmt.Errorf("the file: %s does not exist", x.Source)
+		}
+	}
+	if x.Mode != "" {
+		if x.Op != "get" {
+			return fmt.Errorf("the mode only applies to the get operation")
+		}
+		if _, err := strconv.ParseUint(x.Mode, 0, 32); err != nil {
+			return fmt.Errorf("invalid mode: %s", x.Mode)
+		}
+	}
+
+	return nil
+}
+
+// describe returns a summary of the operation
+func (x *batchOperation) describe() string {
+	if x.Op == "rm" {
+		return fmt.Sprintf("rm %s", x.Source)
+	}
+
+	return fmt.Sprintf("%s %s -> %s", x.Op, x.Source, x.Destination)
+}
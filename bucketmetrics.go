@@ -0,0 +1,241 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/urfave/cli"
+)
+
+// the characters of the sparklines, from the lowest to the highest value
+var sparkCharacters = []rune("▁▂▃▄▅▆▇█")
+
+//
+// bucketMetric is a metric of the bucket published to cloudwatch
+//
+type bucketMetric struct {
+	// the name of the metric
+	name string
+	// the statistic retrieved
+	statistic string
+	// the dimensions of the metric besides the bucket name
+	dimensions map[string]string
+	// the values are sizes in bytes
+	bytes bool
+}
+
+// the storage metrics published daily, and the request metrics published if enabled on the bucket
+var bucketMetrics = []*bucketMetric{
+	{name: "BucketSizeBytes", statistic: cloudwatch.StatisticAverage, dimensions: map[string]string{"StorageType": "StandardStorage"}, bytes: true},
+	{name: "NumberOfObjects", statistic: cloudwatch.StatisticAverage, dimensions: map[string]string{"StorageType": "AllStorageTypes"}},
+	{name: "AllRequests", statistic: cloudwatch.StatisticSum, dimensions: map[string]string{"FilterId": "EntireBucket"}},
+	{name: "GetRequests", statistic: cloudwatch.StatisticSum, dimensions: map[string]string{"FilterId": "EntireBucket"}},
+	{name: "PutRequests", statistic: cloudwatch.StatisticSum, dimensions: map[string]string{"FilterId": "EntireBucket"}},
+	{name: "4xxErrors", statistic: cloudwatch.StatisticSum, dimensions: map[string]string{"FilterId": "EntireBucket"}},
+	{name: "5xxErrors", statistic: cloudwatch.StatisticSum, dimensions: map[string]string{"FilterId": "EntireBucket"}},
+}
+
+//
+// newBucketMetricsCommand creates a new buckets metrics command
+//
+func newBucketMetricsCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:      "metrics",
+		Usage:     "display the size, number of objects and requests of the bucket from cloudwatch, without scanning it",
+		ArgsUsage: "NAME",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the bucket, if not given as the argument `NAME`",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:  "since",
+				Usage: "the window of the metrics displayed, i.e. 14d `DURATION`",
+				Value: "14d",
+			},
+			cli.StringFlag{
+				Name:  "period",
+				Usage: "the period of each datapoint, the storage metrics are only published daily `DURATION`",
+				Value: "1d",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{}, cmd, showBucketMetrics)
+		},
+	}
+}
+
+//
+// showBucketMetrics displays the latest value, range and a sparkline of each of the metrics of the bucket
+//
+func showBucketMetrics(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := defaultValue(cx.Args().First(), cx.String("bucket"))
+	if bucket == "" {
+		return newExitError(exitUsage, "you must specify the name of the bucket")
+	}
+	since, err := parseDuration(cx.String("since"))
+	if err != nil || since <= 0 {
+		return newExitError(exitUsage, "invalid since: %s", cx.String("since"))
+	}
+	period, err := parseDuration(cx.String("period"))
+	if err != nil || period < time.Minute {
+		return newExitError(exitUsage, "invalid period: %s, must be at least a minute", cx.String("period"))
+	}
+	region, err := cmd.getBucketRegion(bucket)
+	if err != nil {
+		return err
+	}
+
+	end := time.Now()
+	for _, metric := range bucketMetrics {
+		datapoints, err := cmd.bucketMetricValues(region, bucket, metric, end.Add(-since), end, period)
+		if err != nil {
+			return err
+		}
+		if len(datapoints) <= 0 {
+			o.fields(map[string]interface{}{
+				"action": "metrics",
+				"bucket": bucket,
+				"metric": metric.name,
+			}).log("%-16s %s\n", metric.name, "no datapoints")
+			continue
+		}
+		var values []float64
+		var points []map[string]interface{}
+		low, high := datapoints[0].value, datapoints[0].value
+		for _, x := range datapoints {
+			values = append(values, x.value)
+			points = append(points, map[string]interface{}{"time": x.time, "value": x.value})
+			if x.value < low {
+				low = x.value
+			}
+			if x.value > high {
+				high = x.value
+			}
+		}
+		latest := values[len(values)-1]
+		o.fields(map[string]interface{}{
+			"action":     "metrics",
+			"bucket":     bucket,
+			"metric":     metric.name,
+			"statistic":  metric.statistic,
+			"latest":     latest,
+			"min":        low,
+			"max":        high,
+			"datapoints": points,
+		}).log("%-16s %12s  min %-12s max %-12s %s\n", metric.name, metric.format(latest), metric.format(low),
+			metric.format(high), colorize(colorCyan, sparkline(values)))
+	}
+
+	return nil
+}
+
+//
+// metricValue is a datapoint of a metric
+//
+type metricValue struct {
+	// the time of the datapoint
+	time time.Time
+	// the value of the statistic
+	value float64
+}
+
+//
+// bucketMetricValues retrieves the datapoints of the metric of the bucket, oldest first
+//
+func (r *cliCommand) bucketMetricValues(region, bucket string, metric *bucketMetric, start, end time.Time, period time.Duration) ([]*metricValue, error) {
+	dimensions := []*cloudwatch.Dimension{{Name: aws.String("BucketName"), Value: aws.String(bucket)}}
+	for k, v := range metric.dimensions {
+		dimensions = append(dimensions, &cloudwatch.Dimension{Name: aws.String(k), Value: aws.String(v)})
+	}
+	resp, err := cloudwatch.New(r.sessionForRegion(region)).GetMetricStatisticsWithContext(r.ctx, &cloudwatch.GetMetricStatisticsInput{
+		Namespace:  aws.String("AWS/S3"),
+		MetricName: aws.String(metric.name),
+		Dimensions: dimensions,
+		StartTime:  aws.Time(start),
+		EndTime:    aws.Time(end),
+		Period:     aws.Int64(int64(period / time.Second)),
+		Statistics: []*string{aws.String(metric.statistic)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var list []*metricValue
+	for _, x := range resp.Datapoints {
+		value := aws.Float64Value(x.Sum)
+		if metric.statistic == cloudwatch.StatisticAverage {
+			value = aws.Float64Value(x.Average)
+		}
+		list = append(list, &metricValue{time: aws.TimeValue(x.Timestamp), value: value})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].time.Before(list[j].time)
+	})
+
+	return list, nil
+}
+
+// format returns the value of the metric, the sizes in human readable units
+func (m *bucketMetric) format(value float64) string {
+	if !m.bytes {
+		return fmt.Sprintf("%.0f", value)
+	}
+
+	return formatBytes(value)
+}
+
+// formatBytes returns the size in the largest binary unit
+func formatBytes(size float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	i := 0
+	for ; size >= 1024 && i < len(units)-1; i++ {
+		size /= 1024
+	}
+
+	return fmt.Sprintf("%.1f%s", size, units[i])
+}
+
+// sparkline renders the values as a line of block characters scaled between the lowest and highest
+func sparkline(values []float64) string {
+	if len(values) <= 0 {
+		return ""
+	}
+	low, high := values[0], values[0]
+	for _, x := range values {
+		if x < low {
+			low = x
+		}
+		if x > high {
+			high = x
+		}
+	}
+	line := make([]rune, len(values))
+	for i, x := range values {
+		index := 0
+		if high > low {
+			index = int((x - low) / (high - low) * float64(len(sparkCharacters)-1))
+		}
+		line[i] = sparkCharacters[index]
+	}
+
+	return string(line)
+}
@@ -17,6 +17,7 @@ package main
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -32,10 +33,16 @@ func newBucketsCommand(cmd *cliCommand) cli.Command {
 		Name:      "buckets",
 		ShortName: "s3",
 		Usage:     "provides a list of the buckets available to you",
-		Subcommands: []cli.Command{
+		Subcommands: append([]cli.Command{
 			{
 				Name:  "ls, list",
 				Usage: "retrieve a listing of all the buckets within the specified region",
+				Flags: append([]cli.Flag{
+					cli.BoolFlag{
+						Name:  "l, long",
+						Usage: "provide a detailed listing including the region, encryption, versioning and tags",
+					},
+				}, regionFlags()...),
 				Action: func(cx *cli.Context) error {
 					return handleCommand(cx, []string{}, cmd, listBuckets)
 				},
@@ -48,6 +55,30 @@ func newBucketsCommand(cmd *cliCommand) cli.Command {
 						Name:  "b, bucket",
 						Usage: "the name of the bucket you wish to create",
 					},
+					cli.BoolFlag{
+						Name:  "versioning",
+						Usage: "enable versioning on the bucket once created",
+					},
+					cli.StringFlag{
+						Name:  "region",
+						Usage: "the region to create the bucket in, defaults to the global region `REGION`",
+					},
+					cli.StringSliceFlag{
+						Name:  "t, tag",
+						Usage: "a tag to apply to the bucket, can be specified multiple times `KEY=VALUE`",
+					},
+					cli.BoolTFlag{
+						Name:  "block-public-access",
+						Usage: "block all public access to the bucket and the files within (default true)",
+					},
+					cli.StringFlag{
+						Name:  "logging-bucket",
+						Usage: "enable access logging on the bucket, delivering the logs to this bucket `NAME`",
+					},
+					cli.StringFlag{
+						Name:  "logging-prefix",
+						Usage: "the prefix in the logging bucket the access logs are placed, defaults to the bucket name `PREFIX`",
+					},
 				},
 				Action: func(cx *cli.Context) error {
 					return handleCommand(cx, []string{"l:bucket:s"}, cmd, createBucket)
@@ -64,14 +95,68 @@ func newBucketsCommand(cmd *cliCommand) cli.Command {
 					},
 					cli.BoolFlag{
 						Name:  "force",
-						Usage: "delete the bucket regardless if empty or not",
+						Usage: "delete the bucket regardless if empty or not, removing all versions and delete markers",
+					},
+					cli.BoolFlag{
+						Name:  "y, yes",
+						Usage: "do not prompt for confirmation before deleting",
 					},
 				},
 				Action: func(cx *cli.Context) error {
 					return handleCommand(cx, []string{"l:bucket:s"}, cmd, deleteBucket)
 				},
 			},
-		},
+			{
+				Name:  "encryption",
+				Usage: "manage the default encryption applied to files placed in the bucket",
+				Subcommands: []cli.Command{
+					{
+						Name:  "get",
+						Usage: "retrieve the default encryption of the bucket",
+						Flags: []cli.Flag{
+							cli.StringFlag{
+								Name:   "b, bucket",
+								Usage:  "the name of the bucket `NAME`",
+								EnvVar: "AWS_S3_BUCKET",
+							},
+						},
+						Action: func(cx *cli.Context) error {
+							return handleCommand(cx, []string{"l:bucket:s"}, cmd, getBucketEncryption)
+						},
+					},
+					{
+						Name:  "set",
+						Usage: "set the default encryption of the bucket to the kms key",
+						Flags: []cli.Flag{
+							cli.StringFlag{
+								Name:   "b, bucket",
+								Usage:  "the name of the bucket `NAME`",
+								EnvVar: "AWS_S3_BUCKET",
+							},
+							cli.StringFlag{
+								Name:   "k, kms",
+								Usage:  "the alias, arn or id of the kms key to encrypt files with by default `KEY`",
+								EnvVar: "AWS_KMS_ID",
+							},
+							cli.BoolTFlag{
+								Name:  "bucket-key",
+								Usage: "use a s3 bucket key to reduce the kms requests made (default true)",
+							},
+						},
+						Action: func(cx *cli.Context) error {
+							return handleCommand(cx, []string{"l:bucket:s", "l:kms:s"}, cmd, setBucketEncryption)
+						},
+					},
+				},
+			},
+			newBucketACLCommand(cmd),
+			newBucketPolicyCommand(cmd),
+			newBucketVersioningCommand(cmd),
+			newBucketLifecycleCommand(cmd),
+			newBucketReplicationCommand(cmd),
+			newBucketNotifyCommand(cmd),
+			newBucketMetricsCommand(cmd),
+		}, newBucketTaggingCommands(cmd)...),
 		Action: func(cx *cli.Context) error {
 			return handleCommand(cx, []string{}, cmd, listBuckets)
 		},
@@ -79,6 +164,28 @@ func newBucketsCommand(cmd *cliCommand) cli.Command {
 }
 
 func listBuckets(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	regions, err := cmd.selectedRegions(cx, "s3")
+	if err != nil {
+		return err
+	}
+
+	// step: a short listing in specific regions only requires the location of the buckets
+	if len(regions) > 0 && !cx.Bool("long") {
+		list, locations, err := cmd.bucketsInRegions(regions)
+		if err != nil {
+			return err
+		}
+		for _, x := range list {
+			o.fields(map[string]interface{}{
+				"created": (*x.CreationDate).Format(time.RFC822Z),
+				"bucket":  *x.Name,
+				"region":  locations[*x.Name],
+			}).log("%-42s %-14s %20s\n", *x.Name, locations[*x.Name], (*x.CreationDate).Format(time.RFC822))
+		}
+
+		return nil
+	}
+
 	// step: get a list of buckets
 	buckets, err := cmd.listS3Buckets()
 	if err != nil {
@@ -86,18 +193,111 @@ func listBuckets(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 	}
 
 	// step: produce the entries
-	for _, x := range buckets {
+	if !cx.Bool("long") {
+		for _, x := range buckets {
+			o.fields(map[string]interface{}{
+				"created": (*x.CreationDate).Format(time.RFC822Z),
+				"bucket":  *x.Name,
+			}).log("%-42s %20s\n", *x.Name, (*x.CreationDate).Format(time.RFC822))
+		}
+
+		return nil
+	}
+
+	// step: retrieve the details of the buckets concurrently
+	details := make([]*bucketDetails, len(buckets))
+	errs := make([]error, len(buckets))
+	var wg sync.WaitGroup
+	limiter := make(chan struct{}, headConcurrency)
+	for i, x := range buckets {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			limiter <- struct{}{}
+			defer func() { <-limiter }()
+			details[i], errs[i] = cmd.describeBucket(name)
+		}(i, *x.Name)
+	}
+	wg.Wait()
+
+	for i, x := range buckets {
+		if errs[i] != nil {
+			return fmt.Errorf("unable to retrieve the details of bucket: %s, error: %s", *x.Name, errs[i])
+		}
+		d := details[i]
+		if len(regions) > 0 && !isValidOption(d.region, regions) {
+			continue
+		}
 		o.fields(map[string]interface{}{
-			"created": (*x.CreationDate).Format(time.RFC822Z),
-			"bucket":  *x.Name,
-		}).log("%-42s %20s\n", *x.Name, (*x.CreationDate).Format(time.RFC822))
+			"created":    (*x.CreationDate).Format(time.RFC822Z),
+			"bucket":     *x.Name,
+			"region":     d.region,
+			"encryption": d.encryption,
+			"kms-key":    d.kmsKey,
+			"versioning": d.versioning,
+			"tags":       d.tags,
+		}).log("%-42s %-14s %20s %-8s %-10s %s\n", *x.Name, d.region, (*x.CreationDate).Format(time.RFC822),
+			defaultValue(d.encryption, "none"), d.versioning, formatTags(d.tags))
 	}
 
 	return nil
 }
 
+//
+// bucketDetails is the detailed information on a bucket
+//
+type bucketDetails struct {
+	// the region the bucket resides
+	region string
+	// the default encryption algorithm of the bucket
+	encryption string
+	// the default kms key of the bucket
+	kmsKey string
+	// the versioning status of the bucket
+	versioning string
+	// the tags on the bucket
+	tags map[string]string
+}
+
+//
+// describeBucket retrieves the details of the bucket
+//
+func (r *cliCommand) describeBucket(name string) (*bucketDetails, error) {
+	region, err := r.getBucketRegion(name)
+	if err != nil {
+		return nil, err
+	}
+	details := &bucketDetails{region: region}
+
+	// step: the remaining calls must be made in the region of the bucket
+	regional := r.forRegion(details.region)
+
+	rule, err := regional.getBucketEncryption(name)
+	if err != nil {
+		return nil, err
+	}
+	if rule != nil {
+		details.encryption = aws.StringValue(rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm)
+		details.kmsKey = aws.StringValue(rule.ApplyServerSideEncryptionByDefault.KMSMasterKeyID)
+	}
+	if details.versioning, err = regional.getBucketVersioning(name); err != nil {
+		return nil, err
+	}
+	if details.tags, err = regional.getBucketTags(name); err != nil {
+		return nil, err
+	}
+
+	return details, nil
+}
+
 func createBucket(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 	name := cx.String("bucket")
+	region := defaultValue(cx.String("region"), cx.GlobalString("region"))
+
+	tags, err := parseKeyValues(cx.StringSlice("tag"))
+	if err != nil {
+		return err
+	}
 
 	if found, err := cmd.hasBucket(name); err != nil {
 		return err
@@ -105,17 +305,140 @@ func createBucket(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 		return fmt.Errorf("the bucket already exists")
 	}
 
-	if _, err := cmd.s3Client.CreateBucket(&s3.CreateBucketInput{
+	// step: the bucket is created and configured using a client in the region
+	regional := cmd.forRegion(region)
+	client := regional.s3Client
+
+	input := &s3.CreateBucketInput{
 		Bucket: aws.String(name),
+	}
+	// note: us-east-1 is the default location and cannot be specified as a constraint
+	if region != "us-east-1" {
+		input.CreateBucketConfiguration = &s3.CreateBucketConfiguration{
+			LocationConstraint: aws.String(region),
+		}
+	}
+	if _, err := client.CreateBucketWithContext(cmd.ctx, input); err != nil {
+		return err
+	}
+
+	// step: block any public access to the bucket
+	if cx.Bool("block-public-access") {
+		if _, err := client.PutPublicAccessBlockWithContext(cmd.ctx, &s3.PutPublicAccessBlockInput{
+			Bucket: aws.String(name),
+			PublicAccessBlockConfiguration: &s3.PublicAccessBlockConfiguration{
+				BlockPublicAcls:       aws.Bool(true),
+				BlockPublicPolicy:     aws.Bool(true),
+				IgnorePublicAcls:      aws.Bool(true),
+				RestrictPublicBuckets: aws.Bool(true),
+			},
+		}); err != nil {
+			return fmt.Errorf("the bucket was created but blocking public access failed, error: %s", err)
+		}
+	}
+
+	// step: apply any tags to the bucket
+	if len(tags) > 0 {
+		if err := regional.putBucketTags(name, tags); err != nil {
+			return fmt.Errorf("the bucket was created but tagging failed, error: %s", err)
+		}
+	}
+
+	// step: enable access logging if required
+	if logging := cx.String("logging-bucket"); logging != "" {
+		if _, err := client.PutBucketLoggingWithContext(cmd.ctx, &s3.PutBucketLoggingInput{
+			Bucket: aws.String(name),
+			BucketLoggingStatus: &s3.BucketLoggingStatus{
+				LoggingEnabled: &s3.LoggingEnabled{
+					TargetBucket: aws.String(logging),
+					TargetPrefix: aws.String(defaultValue(cx.String("logging-prefix"), name+"/")),
+				},
+			},
+		}); err != nil {
+			return fmt.Errorf("the bucket was created but enabling access logging failed, error: %s", err)
+		}
+	}
+
+	// step: enable versioning if required
+	if cx.Bool("versioning") {
+		if err := regional.setBucketVersioning(name, s3.BucketVersioningStatusEnabled); err != nil {
+			return fmt.Errorf("the bucket was created but enabling versioning failed, error: %s", err)
+		}
+	}
+
+	o.fields(map[string]interface{}{
+		"operation":           "created",
+		"bucket":              name,
+		"region":              region,
+		"created":             time.Now().Format(time.RFC822Z),
+		"versioning":          cx.Bool("versioning"),
+		"block-public-access": cx.Bool("block-public-access"),
+		"logging-bucket":      cx.String("logging-bucket"),
+		"tags":                tags,
+	}).log("successfully created the bucket: %s in region: %s\n", name, region)
+
+	return nil
+}
+
+func getBucketEncryption(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	name := cx.String("bucket")
+
+	rule, err := cmd.getBucketEncryption(name)
+	if err != nil {
+		return err
+	}
+	if rule == nil {
+		o.fields(map[string]interface{}{
+			"bucket":    name,
+			"algorithm": "none",
+		}).log("the bucket: %s has no default encryption\n", name)
+
+		return nil
+	}
+	algorithm := aws.StringValue(rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm)
+	kmsKey := aws.StringValue(rule.ApplyServerSideEncryptionByDefault.KMSMasterKeyID)
+
+	o.fields(map[string]interface{}{
+		"bucket":     name,
+		"algorithm":  algorithm,
+		"kms-key":    kmsKey,
+		"bucket-key": aws.BoolValue(rule.BucketKeyEnabled),
+	}).log("%-42s %-10s %s\n", name, algorithm, defaultValue(kmsKey, "-"))
+
+	return nil
+}
+
+func setBucketEncryption(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	name := cx.String("bucket")
+
+	// step: resolve the kms key to the arn
+	metadata, err := cmd.describeKey(cx.String("kms"))
+	if err != nil {
+		return fmt.Errorf("unable to resolve the kms key: %s, error: %s", cx.String("kms"), err)
+	}
+
+	if _, err := cmd.s3Client.PutBucketEncryptionWithContext(cmd.ctx, &s3.PutBucketEncryptionInput{
+		Bucket: aws.String(name),
+		ServerSideEncryptionConfiguration: &s3.ServerSideEncryptionConfiguration{
+			Rules: []*s3.ServerSideEncryptionRule{
+				{
+					ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{
+						SSEAlgorithm:   aws.String(s3.ServerSideEncryptionAwsKms),
+						KMSMasterKeyID: metadata.Arn,
+					},
+					BucketKeyEnabled: aws.Bool(cx.Bool("bucket-key")),
+				},
+			},
+		},
 	}); err != nil {
 		return err
 	}
 
 	o.fields(map[string]interface{}{
-		"operation": "created",
+		"operation": "encryption",
 		"bucket":    name,
-		"created":   time.Now().Format(time.RFC822Z),
-	}).log("successfully created the bucket: %s\n", name)
+		"kms-key":   aws.StringValue(metadata.Arn),
+	}).log("successfully set the default encryption of bucket: %s to: %s\n", name, aws.StringValue(metadata.Arn))
 
 	return nil
 }
@@ -129,35 +452,36 @@ func deleteBucket(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 	if err != nil {
 		return err
 	} else if !found {
-		return fmt.Errorf("the bucket does not exist")
+		return newNotFoundError("the bucket does not exist")
 	}
 
-	// step: check if the bucket is empty
-	count, err := cmd.sizeOfBucket(name)
+	// step: check if the bucket is empty, including any noncurrent versions and delete markers
+	versions, err := cmd.listBucketVersions(name)
 	if err != nil {
 		return err
-	} else if count > 0 && !force {
+	} else if len(versions) > 0 && !force {
 		return fmt.Errorf("the bucket is not empty, either force (--force) deletion or empty the bucket")
 	}
+	if err := confirm(cx, "this will delete the bucket: %s and %d object versions within", name, len(versions)); err != nil {
+		return err
+	}
 
-	// step: delete all the keys in the bucket first
-	// @TODO find of there is a force deletion api call
-	if count > 0 {
-		files, err := cmd.listBucketKeys(name, "")
-		if err != nil {
+	// step: delete all the versions in the bucket first, in batches
+	for i := 0; i < len(versions); i += deleteBatchSize {
+		batch := versions[i:min(i+deleteBatchSize, len(versions))]
+		if err := cmd.deleteObjectVersions(name, batch); err != nil {
 			return err
 		}
-		for _, x := range files {
-			if _, err := cmd.s3Client.DeleteObject(&s3.DeleteObjectInput{
-				Bucket: aws.String(name),
-				Key:    x.Key,
-			}); err != nil {
-				return fmt.Errorf("failed to remove the file: %s from bucket, error: %s", *x.Key, err)
-			}
-		}
+		o.fields(map[string]interface{}{
+			"operation": "empty",
+			"bucket":    name,
+			"deleted":   i + len(batch),
+			"total":     len(versions),
+		}).log("deleted %d of %d versions from bucket: %s\n", i+len(batch), len(versions), name)
 	}
+
 	// step: delete the bucket
-	if _, err := cmd.s3Client.DeleteBucket(&s3.DeleteBucketInput{
+	if _, err := cmd.s3Client.DeleteBucketWithContext(cmd.ctx, &s3.DeleteBucketInput{
 		Bucket: aws.String(name),
 	}); err != nil {
 		return err
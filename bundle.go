@@ -0,0 +1,160 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isBundle checks if the key is a tar.gz bundle
+func isBundle(key string) bool {
+	return strings.HasSuffix(key, ".tar.gz") || strings.HasSuffix(key, ".tgz")
+}
+
+//
+// createBundle produces a tar.gz of the files under the paths, the names in the bundle are relative
+// to the directories given, or the name of a file given
+//
+func createBundle(paths []string, symlinks string) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+	compressor := gzip.NewWriter(buffer)
+	writer := tar.NewWriter(compressor)
+
+	added := make(map[string]string, 0)
+	for _, path := range paths {
+		files, err := expandFiles(path, symlinks)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process path: %s, error: %s", path, err)
+		}
+		base := path
+		if len(files) == 1 && files[0] == path {
+			base = filepath.Dir(path)
+		}
+		for _, filename := range files {
+			name, err := filepath.Rel(base, filename)
+			if err != nil {
+				return nil, err
+			}
+			name = filepath.ToSlash(name)
+			if previous, found := added[name]; found {
+				return nil, fmt.Errorf("the files: %s and %s would both be bundled as: %s", previous, filename, name)
+			}
+			added[name] = filename
+
+			if err := addBundleFile(writer, name, filename); err != nil {
+				return nil, fmt.Errorf("failed to bundle the file: %s, error: %s", filename, err)
+			}
+		}
+	}
+	if len(added) <= 0 {
+		return nil, fmt.Errorf("there are no files to bundle")
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// addBundleFile writes the file into the bundle
+func addBundleFile(writer *tar.Writer, name, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	err = writer.WriteHeader(&tar.Header{
+		Name:     name,
+		Mode:     int64(info.Mode().Perm()),
+		Size:     info.Size(),
+		ModTime:  info.ModTime(),
+		Typeflag: tar.TypeReg,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, file)
+
+	return err
+}
+
+//
+// extractBundle unpacks the tar.gz into the directory, returning the files written; only regular files
+// and directories are extracted and none may escape the directory
+//
+func extractBundle(content []byte, directory string) ([]string, error) {
+	decompressor, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer decompressor.Close()
+
+	var list []string
+	reader := tar.NewReader(decompressor)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Clean(filepath.FromSlash(header.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("the bundle contains a path outside of the directory: %s", header.Name)
+		}
+		path := filepath.Join(directory, name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return nil, err
+			}
+		case tar.TypeReg:
+			data, err := ioutil.ReadAll(reader)
+			if err != nil {
+				return nil, err
+			}
+			if err := writeFileAtomic(path, data, os.FileMode(header.Mode).Perm()); err != nil {
+				return nil, err
+			}
+			if err := os.Chtimes(path, header.ModTime, header.ModTime); err != nil {
+				return nil, err
+			}
+			list = append(list, path)
+		default:
+			return nil, fmt.Errorf("the bundle contains an unsupported entry: %s", header.Name)
+		}
+	}
+
+	return list, nil
+}
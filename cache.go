@@ -0,0 +1,405 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+)
+
+//
+// cacheEntry is a file in the cache, encrypted with a kms data key
+//
+type cacheEntry struct {
+	// the bucket the file was retrieved from
+	Bucket string `json:"bucket"`
+	// the key of the file
+	Key string `json:"key"`
+	// the time the file was retrieved
+	Stored time.Time `json:"stored"`
+	// the data key encrypted by kms
+	DataKey []byte `json:"data_key"`
+	// the data key encrypted by the local key of the cache, permitting the file to be decrypted offline
+	LocalKey []byte `json:"local_key,omitempty"`
+	// the nonce of the encryption of the local key
+	LocalNonce []byte `json:"local_nonce,omitempty"`
+	// the nonce of the encryption
+	Nonce []byte `json:"nonce"`
+	// the content encrypted with the data key
+	Content []byte `json:"content"`
+}
+
+//
+// objectCache is a local cache of the files retrieved, each encrypted with a kms data key, serving the
+// files while fresh and when s3 is unreachable; with a local key the data keys are also sealed with it,
+// so the files can be served when kms is unreachable too
+//
+type objectCache struct {
+	// the directory of the cache
+	directory string
+	// the time the files are served from the cache without retrieving them
+	ttl time.Duration
+	// the kms key the data keys are generated from, else the default encryption of the bucket
+	kmsKey string
+	// the key sealing the data keys, if the files may be served without kms
+	localKey []byte
+}
+
+// the file in the cache directory the local key was held in by previous versions
+const legacyCacheKeyFile = "cache.key"
+
+//
+// cacheFlags returns the options of the local cache
+//
+func cacheFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:   "cache-dir",
+			Usage:  "cache the files retrieved in the directory, encrypted, serving them when fresh or s3 is unreachable `DIR`",
+			EnvVar: "KMSCTL_CACHE_DIR",
+		},
+		cli.DurationFlag{
+			Name:   "cache-ttl",
+			Usage:  "the time the cached files are served without retrieving them, zero always retrieves them `DURATION`",
+			EnvVar: "KMSCTL_CACHE_TTL",
+			Value:  time.Hour,
+		},
+		cli.StringFlag{
+			Name:   "cache-kms",
+			Usage:  "the kms key the cache is encrypted with, defaults to the default encryption of the bucket `KEY`",
+			EnvVar: "KMSCTL_CACHE_KMS",
+		},
+		cli.StringFlag{
+			Name:   "cache-key-file",
+			Usage:  "serve the cached files when kms is unreachable, sealing the data keys with the key in this file, outside the cache directory `PATH`",
+			EnvVar: "KMSCTL_CACHE_KEY_FILE",
+		},
+	}
+}
+
+//
+// applyCacheOptions enables the local cache if a directory is given
+//
+func (r *cliCommand) applyCacheOptions(cx *cli.Context) error {
+	directory := cx.String("cache-dir")
+	if directory == "" {
+		return nil
+	}
+	if cx.Duration("cache-ttl") < 0 {
+		return newExitError(exitUsage, "the cache-ttl cannot be negative")
+	}
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return fmt.Errorf("unable to create the cache directory: %s, error: %s", directory, err)
+	}
+	// note: a key left in the cache directory would decrypt every file cached beside it
+	legacy := filepath.Join(directory, legacyCacheKeyFile)
+	if _, err := os.Stat(legacy); err == nil {
+		logger.warningf("removing the key: %s from the cache directory, use --cache-key-file to serve the files without kms", legacy)
+		if err := os.Remove(legacy); err != nil {
+			return fmt.Errorf("unable to remove the key: %s from the cache directory, error: %s", legacy, err)
+		}
+	}
+	r.cache = &objectCache{
+		directory: directory,
+		ttl:       cx.Duration("cache-ttl"),
+		kmsKey:    cx.String("cache-kms"),
+	}
+
+	// step: the files are only served without kms when a key held outside the cache is given
+	if path := cx.String("cache-key-file"); path != "" {
+		if isWithinDirectory(directory, path) {
+			return newExitError(exitUsage, "the cache-key-file must be held outside the cache directory: %s", directory)
+		}
+		localKey, err := cacheLocalKey(path)
+		if err != nil {
+			return fmt.Errorf("unable to read the key of the cache, error: %s", err)
+		}
+		r.cache.localKey = localKey
+	}
+
+	return nil
+}
+
+// isWithinDirectory checks if the path resides in the directory
+func isWithinDirectory(directory, path string) bool {
+	absDirectory, err := filepath.Abs(directory)
+	if err != nil {
+		return false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	relative, err := filepath.Rel(absDirectory, absPath)
+
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+//
+// cacheLocalKey reads the key of the cache, generating it on first use readable only by the user
+//
+func cacheLocalKey(path string) ([]byte, error) {
+	key, err := ioutil.ReadFile(path)
+	if err == nil {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("the key: %s is invalid, remove it to reset the cache", path)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	// note: another process may have created the key in the meantime
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return cacheLocalKey(path)
+		}
+		return nil, err
+	}
+	if _, err := file.Write(key); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	return key, file.Close()
+}
+
+//
+// getFile returns the file from the cache while fresh, else retrieves and caches it; should s3 be
+// unreachable the cached copy is served regardless of age
+//
+func (c *objectCache) getFile(cmd *cliCommand, bucket, key string) ([]byte, error) {
+	path := c.path(bucket, cmd.objectKey(key))
+
+	entry, err := c.load(path)
+	if err != nil && !os.IsNotExist(err) {
+		logger.warningf("ignoring the cached copy of the file: %s, error: %s", key, err)
+	}
+	if entry != nil && time.Since(entry.Stored) < c.ttl {
+		if content, err := c.decrypt(cmd, entry); err == nil {
+			logger.debugf("serving the file: %s from the cache, retrieved at %s", key, entry.Stored.Format(time.RFC3339))
+			return content, nil
+		}
+	}
+
+	content, err := cmd.fetchFile(bucket, key)
+	if err != nil {
+		if entry == nil || !isUnreachable(err) {
+			return nil, err
+		}
+		cached, e := c.decrypt(cmd, entry)
+		if e != nil {
+			return nil, fmt.Errorf("%s, and the cached copy cannot be decrypted, error: %s", err, e)
+		}
+		logger.warningf("unable to retrieve the file: %s, serving the cached copy from %s, error: %s", key, entry.Stored.Format(time.RFC3339), err)
+
+		return cached, nil
+	}
+	if err := c.store(cmd, path, bucket, key, content); err != nil {
+		logger.warningf("unable to cache the file: %s, error: %s", key, err)
+	}
+
+	return content, nil
+}
+
+//
+// listKeys returns the files of the bucket under the prefix held in the cache, used in place of the
+// listing when s3 is unreachable
+//
+func (c *objectCache) listKeys(cmd *cliCommand, bucket, prefix string) ([]*s3.Object, error) {
+	files, err := ioutil.ReadDir(c.directory)
+	if err != nil {
+		return nil, err
+	}
+	var list []*s3.Object
+	for _, x := range files {
+		path := filepath.Join(c.directory, x.Name())
+		if x.IsDir() || filepath.Ext(path) != ".json" {
+			continue
+		}
+		entry, err := c.load(path)
+		if err != nil {
+			continue
+		}
+		// note: the path ensures the entry was cached under the same environment prefix
+		if entry.Bucket != bucket || !strings.HasPrefix(entry.Key, prefix) || c.path(bucket, cmd.objectKey(entry.Key)) != path {
+			continue
+		}
+		list = append(list, &s3.Object{Key: aws.String(entry.Key), ETag: aws.String("")})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return aws.StringValue(list[i].Key) < aws.StringValue(list[j].Key)
+	})
+
+	return list, nil
+}
+
+// path returns the path of the file in the cache
+func (c *objectCache) path(bucket, key string) string {
+	hash := sha256.Sum256([]byte(bucket + "/" + key))
+
+	return filepath.Join(c.directory, hex.EncodeToString(hash[:])+".json")
+}
+
+// load reads the entry from the cache
+func (c *objectCache) load(path string) (*cacheEntry, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entry := new(cacheEntry)
+	if err := json.Unmarshal(content, entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+//
+// store encrypts the content with a new data key and writes it to the cache, the bucket and key being
+// authenticated along with the content
+//
+func (c *objectCache) store(cmd *cliCommand, path, bucket, key string, content []byte) error {
+	kmsKey := c.kmsKey
+	if kmsKey == "" {
+		rule, err := cmd.getBucketEncryption(bucket)
+		if err != nil {
+			return err
+		}
+		if rule == nil || rule.ApplyServerSideEncryptionByDefault.KMSMasterKeyID == nil {
+			return fmt.Errorf("the bucket: %s has no default kms key, use --cache-kms", bucket)
+		}
+		kmsKey = aws.StringValue(rule.ApplyServerSideEncryptionByDefault.KMSMasterKeyID)
+	}
+	resp, err := cmd.kmsClient.GenerateDataKeyWithContext(cmd.ctx, &kms.GenerateDataKeyInput{
+		KeyId:   aws.String(kmsKeyID(kmsKey)),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(resp.Plaintext)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	entry := &cacheEntry{
+		Bucket:  bucket,
+		Key:     key,
+		Stored:  time.Now().UTC(),
+		DataKey: resp.CiphertextBlob,
+		Nonce:   nonce,
+		Content: gcm.Seal(nil, nonce, content, []byte(bucket+"/"+key)),
+	}
+	// step: seal the data key with the local key, if given, permitting the file to be served without kms
+	if c.localKey != nil {
+		local, err := newGCM(c.localKey)
+		if err != nil {
+			return err
+		}
+		entry.LocalNonce = make([]byte, local.NonceSize())
+		if _, err := rand.Read(entry.LocalNonce); err != nil {
+			return err
+		}
+		entry.LocalKey = local.Seal(nil, entry.LocalNonce, resp.Plaintext, []byte(bucket+"/"+key))
+	}
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, encoded, 0600)
+}
+
+//
+// decrypt decrypts the data key of the entry with kms, and the content with the data key; only when kms
+// is unreachable is the data key decrypted with the local key, so revoking the access to kms, or to the
+// key, still revokes the access to the cached files
+//
+func (c *objectCache) decrypt(cmd *cliCommand, entry *cacheEntry) ([]byte, error) {
+	var dataKey []byte
+	resp, err := cmd.kmsClient.DecryptWithContext(cmd.ctx, &kms.DecryptInput{CiphertextBlob: entry.DataKey})
+	switch {
+	case err == nil:
+		dataKey = resp.Plaintext
+	case c.localKey != nil && len(entry.LocalKey) > 0 && isUnreachable(err):
+		local, e := newGCM(c.localKey)
+		if e != nil {
+			return nil, e
+		}
+		if dataKey, e = local.Open(nil, entry.LocalNonce, entry.LocalKey, []byte(entry.Bucket+"/"+entry.Key)); e != nil {
+			return nil, fmt.Errorf("unable to decrypt the data key with the key of the cache, error: %s", e)
+		}
+		logger.warningf("kms is unreachable, decrypting the cached copy of the file: %s with the key of the cache, error: %s", entry.Key, err)
+	default:
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Open(nil, entry.Nonce, entry.Content, []byte(entry.Bucket+"/"+entry.Key))
+}
+
+// newGCM creates the aes-gcm cipher from the key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// isUnreachable checks if the error indicates s3 could not be reached or is unavailable
+func isUnreachable(err error) bool {
+	if e, ok := err.(awserr.RequestFailure); ok {
+		return e.StatusCode() >= 500
+	}
+	if e, ok := err.(awserr.Error); ok {
+		return e.Code() == "RequestError" || e.Code() == request.ErrCodeResponseTimeout
+	}
+
+	return false
+}
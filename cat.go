@@ -16,12 +16,18 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/urfave/cli"
 )
 
+// separatorEscapes expands the escapes permitted in the separator
+var separatorEscapes = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\0`, "\x00")
+
 //
 // newCatCommand creates a new cat command
 //
@@ -29,13 +35,35 @@ func newCatCommand(cmd *cliCommand) cli.Command {
 	return cli.Command{
 		Name:  "cat",
 		Usage: "retrieves and displays the contents of one or more files to the stdout",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			cli.StringFlag{
 				Name:   "b, bucket",
 				Usage:  "the name of the s3 bucket containing the encrypted files",
 				EnvVar: "AWS_S3_BUCKET",
 			},
-		},
+			cli.BoolFlag{
+				Name:  "header",
+				Usage: "print a ==> key <== header before each of the files, as tail does",
+			},
+			cli.StringFlag{
+				Name:  "separator",
+				Usage: "print the separator between the files, the escapes \\n, \\t and \\0 are expanded `TEXT`",
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "output the files as a json object of the keys to their content",
+			},
+			cli.StringFlag{
+				Name:  "jsonpath",
+				Usage: "parse the files as json and only print the field at the path, i.e. $.db.password `PATH`",
+			},
+			cli.StringFlag{
+				Name:  "yamlpath",
+				Usage: "parse the files as yaml and only print the field at the path, i.e. .db.password `PATH`",
+			},
+			keysFromFlag,
+			interactiveFlag,
+		}, cacheFlags()...),
 		Action: func(cx *cli.Context) error {
 			return handleCommand(cx, []string{"l:bucket:s"}, cmd, catFiles)
 		},
@@ -47,14 +75,74 @@ func newCatCommand(cmd *cliCommand) cli.Command {
 //
 func catFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 	bucket := cx.String("bucket")
+	if err := cmd.applyCacheOptions(cx); err != nil {
+		return err
+	}
+
+	header := cx.Bool("header")
+	separator := separatorEscapes.Replace(cx.String("separator"))
+	if cx.Bool("json") && (header || separator != "") {
+		return newExitError(exitUsage, "invalid option, --json cannot be used with --header or --separator")
+	}
+	jsonPath, yamlPath := cx.String("jsonpath"), cx.String("yamlpath")
+	if jsonPath != "" && yamlPath != "" {
+		return newExitError(exitUsage, "invalid option, --jsonpath and --yamlpath are mutually exclusive")
+	}
 
-	for _, filename := range cx.Args() {
+	keys, err := getKeys(cx)
+	if cx.Bool("interactive") {
+		keys, err = cmd.selectKeys(cx, bucket)
+	}
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string]string, 0)
+	for i, filename := range keys {
 		content, err := cmd.getFile(bucket, filename)
 		if err != nil {
 			return err
 		}
+		// step: extract the field from the file if required
+		switch {
+		case jsonPath != "":
+			content, err = extractJSONPath(filename, content, jsonPath)
+		case yamlPath != "":
+			content, err = extractYAMLPath(filename, content, yamlPath)
+		}
+		if err != nil {
+			return err
+		}
+		if cx.Bool("json") {
+			if !utf8.Valid(content) {
+				return fmt.Errorf("the file: %s is not utf-8 text, it cannot be output as json", filename)
+			}
+			files[filename] = string(content)
+			// note: the scalars extracted are printed with a newline, which is not part of the value
+			if jsonPath != "" || yamlPath != "" {
+				files[filename] = strings.TrimSuffix(files[filename], "\n")
+			}
+			continue
+		}
+		if i > 0 {
+			fmt.Fprint(os.Stdout, separator)
+		}
+		if header {
+			// note: as with tail, the headers of the following files are set apart by a blank line
+			if i > 0 && separator == "" {
+				fmt.Fprintln(os.Stdout)
+			}
+			fmt.Fprintf(os.Stdout, "==> %s <==\n", filename)
+		}
 		fmt.Fprintf(os.Stdout, "%s", content)
 	}
+	if cx.Bool("json") {
+		encoded, err := json.MarshalIndent(files, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "%s\n", encoded)
+	}
 
 	return nil
 }
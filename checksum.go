@@ -0,0 +1,74 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// the metadata holding the sha256 checksum of the content
+const checksumMetadata = "kmsctl-sha256"
+
+//
+// checksumBody computes the sha256 checksum and size of the content, returning a reader positioned at
+// the start of the content
+//
+func checksumBody(body io.Reader) (io.Reader, []byte, int64, error) {
+	hash := sha256.New()
+
+	// note: files and buffers can be rewound, anything else is read into memory
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		size, err := io.Copy(hash, seeker)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, 0, err
+		}
+		return seeker, hash.Sum(nil), size, nil
+	}
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	hash.Write(content)
+
+	return bytes.NewReader(content), hash.Sum(nil), int64(len(content)), nil
+}
+
+//
+// verifyChecksum checks the content against the checksum recorded when the file was uploaded, files
+// uploaded without a checksum are not verified
+//
+func verifyChecksum(key string, metadata map[string]*string, content []byte) error {
+	expected, found := metadataValue(metadata, checksumMetadata)
+	if !found {
+		return nil
+	}
+	sum := sha256.Sum256(content)
+	if actual := hex.EncodeToString(sum[:]); !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("the checksum of the file: %s does not match, expected: %s, got: %s, the content is corrupt",
+			key, expected, actual)
+	}
+
+	return nil
+}
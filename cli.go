@@ -16,12 +16,17 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/defaults"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -30,12 +35,30 @@ import (
 )
 
 type cliCommand struct {
+	// the prefix of the environment prepended to all the keys
+	prefix string
+	// the context used by all the aws calls
+	ctx context.Context
+	// cancels the context
+	cancel context.CancelFunc
+	// the aws session the clients are created from
+	session *session.Session
 	// the kms client for aws
 	kmsClient *kms.KMS
 	// the s3 client
 	s3Client *s3.S3
 	// the s3 uploader
 	uploader *s3manager.Uploader
+	// the bandwidth limit of the transfers, if any
+	limiter *rateLimiter
+	// the local cache of the files retrieved, if enabled
+	cache *objectCache
+	// the agent the requests are forwarded to, if enabled
+	agent *agentClient
+	// the directory the state of the transfers is kept in when resuming them, if enabled
+	resumeDir string
+	// permits the locked files to be modified
+	unlocked bool
 }
 
 func newCliApplication() *cli.App {
@@ -50,14 +73,14 @@ func newCliApplication() *cli.App {
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
 			Name:   "p, profile",
-			Usage:  "the aws profile to use for static credentials `NAME`",
+			Usage:  "the aws profile to use from the shared credentials and config files `NAME`",
 			EnvVar: "AWS_DEFAULT_PROFILE",
 		},
 		cli.StringFlag{
 			Name:   "c, credentials",
 			Usage:  "the path to the credentials file container the aws profiles `PATH`",
 			EnvVar: "AWS_SHARED_CREDENTIALS_FILE",
-			Value:  os.Getenv("HOME") + "/.aws/credentials",
+			Value:  homePath(".aws", "credentials"),
 		},
 		cli.StringFlag{
 			Name:   "access-key",
@@ -74,10 +97,42 @@ func newCliApplication() *cli.App {
 			Usage:  "the aws session token to use when accessing the resources `KEY`",
 			EnvVar: "AWS_SESSION_TOKEN",
 		},
+		cli.StringFlag{
+			Name:  "role-arn",
+			Usage: "the arn of the iam role to assume using the resolved credentials or the web identity token `ARN`",
+		},
+		cli.StringFlag{
+			Name:  "role-session-name",
+			Usage: "the session name used when assuming the role `NAME`",
+			Value: progName,
+		},
+		cli.StringFlag{
+			Name:  "web-identity-token-file",
+			Usage: "the path to a web identity token (i.e. a service account token) exchanged for the role credentials `PATH`",
+		},
 		cli.StringFlag{
 			Name:  "environment-file",
 			Usage: "a file containing a list of environment variables `PATH`",
 		},
+		cli.BoolTFlag{
+			Name:  "credentials-cache",
+			Usage: "cache the temporary credentials of assumed roles on disk until they expire (default true)",
+		},
+		cli.StringFlag{
+			Name:   "ca-bundle",
+			Usage:  "the path to a pem bundle of additional certificate authorities to trust, i.e. for a tls intercepting proxy `PATH`",
+			EnvVar: "AWS_CA_BUNDLE",
+		},
+		cli.BoolFlag{
+			Name:   "accelerate",
+			Usage:  "use the s3 transfer acceleration endpoints, the bucket must have acceleration enabled",
+			EnvVar: "KMSCTL_S3_ACCELERATE",
+		},
+		cli.BoolFlag{
+			Name:   "dualstack",
+			Usage:  "use the s3 dualstack endpoints, permitting access over ipv6",
+			EnvVar: "KMSCTL_S3_DUALSTACK",
+		},
 		cli.StringFlag{
 			Name:   "r, region",
 			Usage:  "the aws region where the resources are located `NAME`",
@@ -89,21 +144,123 @@ func newCliApplication() *cli.App {
 			Usage: "the format of the output to generate (accepts json, yaml or default text) `FORMAT`",
 			Value: "text",
 		},
+		cli.BoolFlag{
+			Name:   "debug",
+			Usage:  "print the resolved credentials, region and endpoints, and log the aws requests made",
+			EnvVar: "KMSCTL_DEBUG",
+		},
+		cli.BoolFlag{
+			Name:   "q, quiet",
+			Usage:  "print nothing on success, only the errors, i.e. for cron and ci",
+			EnvVar: "KMSCTL_QUIET",
+		},
+		cli.BoolFlag{
+			Name:   "verbose",
+			Usage:  "also print the files skipped, the filter decisions and the timings",
+			EnvVar: "KMSCTL_VERBOSE",
+		},
+		cli.BoolFlag{
+			Name:   "no-color",
+			Usage:  "do not colorize the output, which is otherwise colorized on a terminal unless NO_COLOR is set",
+			EnvVar: "KMSCTL_NO_COLOR",
+		},
+		cli.StringFlag{
+			Name:   "log-level",
+			Usage:  "the minimum level of the logs written to the stderr, debug, info, warning or error `LEVEL`",
+			EnvVar: "KMSCTL_LOG_LEVEL",
+			Value:  "info",
+		},
+		cli.StringFlag{
+			Name:   "log-format",
+			Usage:  "the format of the logs written to the stderr, text or json `FORMAT`",
+			EnvVar: "KMSCTL_LOG_FORMAT",
+			Value:  "text",
+		},
+		cli.DurationFlag{
+			Name:   "timeout",
+			Usage:  "the maximum time the command is permitted to run, zero for no limit `DURATION`",
+			EnvVar: "KMSCTL_TIMEOUT",
+		},
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "the path to the configuration file defining the environments `PATH`",
+			EnvVar: "KMSCTL_CONFIG",
+			Value:  homePath(".kmsctl", "config.yaml"),
+		},
+		cli.BoolFlag{
+			Name:   "use-agent",
+			Usage:  "forward the get, put, list and delete requests to the kmsctl agent, avoiding the set up of the session",
+			EnvVar: "KMSCTL_USE_AGENT",
+		},
+		cli.StringFlag{
+			Name:   "agent-socket",
+			Usage:  "the path to the unix socket of the kmsctl agent `PATH`",
+			EnvVar: "KMSCTL_AGENT_SOCKET",
+			Value:  homePath(".kmsctl", "agent.sock"),
+		},
+		cli.StringFlag{
+			Name:   "env",
+			Usage:  "use the bucket, prefix, kms key, region and profile of the environment from the configuration file `NAME`",
+			EnvVar: "KMSCTL_ENV",
+		},
 	}
 
-	// step: add the method for retrieving the credentials and bootstrapping
-	app.Before = cmd.getCredentials()
+	// step: add the method for retrieving the credentials and bootstrapping, any error without an exit
+	// code being a failure, rather than the usage error of the options not parsing
+	before := cmd.getCredentials()
+	app.Before = func(cx *cli.Context) error {
+		if err := before(cx); err != nil {
+			var e *exitError
+			if !errors.As(err, &e) {
+				return newExitError(exitCode(err), "%s", err)
+			}
+			return err
+		}
 
-	app.Commands = []cli.Command{
+		return nil
+	}
+	// step: the unknown commands are run as plugins found on the path
+	app.CommandNotFound = cmd.runPlugin()
+
+	app.Commands = append([]cli.Command{
 		newKMSCommand(cmd),
 		newBucketsCommand(cmd),
 		newListCommand(cmd),
 		newDeleteCommand(cmd),
 		newCatCommand(cmd),
 		newGetCommand(cmd),
+		newStatusCommand(cmd),
 		newPutCommand(cmd),
 		newEditCommand(cmd),
-	}
+		newVerifyEncryptionCommand(cmd),
+		newShellCommand(cmd),
+		newExportCommand(cmd),
+		newImportCommand(cmd),
+		newServeCommand(cmd),
+		newAuditCommand(cmd),
+		newHistoryCommand(cmd),
+		newIAMPolicyCommand(cmd),
+		newDoctorCommand(cmd),
+		newCheckExposureCommand(cmd),
+		newPromoteCommand(cmd),
+		newExpiringCommand(cmd),
+		newTrashCommand(cmd),
+		newRestoreCommand(cmd),
+		newRestoreArchiveCommand(cmd),
+		newMultipartCommand(cmd),
+		newStatCommand(cmd),
+		newBatchCommand(cmd),
+		newTailCommand(cmd),
+		newNewCommand(cmd),
+		newRotateSecretCommand(cmd),
+		newCopyCommand(cmd),
+		newCostCommand(cmd),
+		newPluginsCommand(cmd),
+		newAPICommand(cmd),
+		newAgentCommand(cmd),
+		newVersionCommand(cmd),
+		newSelfUpdateCommand(cmd),
+	}, append(newObjectTaggingCommands(cmd), newLockCommands(cmd)...)...)
 
 	return app
 }
@@ -115,7 +272,8 @@ func handleCommand(cx *cli.Context, options []string, cmd *cliCommand, method fu
 	// step: handle any panics in the command
 	defer func() {
 		if r := recover(); r != nil {
-			fmt.Fprintf(os.Stderr, "[error] internal error occurred, message: %s", r)
+			logger.errorf("internal error occurred, message: %s", r)
+			cleanup.run()
 			os.Exit(1)
 		}
 	}()
@@ -144,7 +302,7 @@ func handleCommand(cx *cli.Context, options []string, cmd *cliCommand, method fu
 				invalid = !cx.GlobalIsSet(name) && len(cx.GlobalStringSlice(name)) == 0
 			}
 			if invalid {
-				printError("the global option: '%s' is required", name)
+				exitWithError(exitUsage, "the global option: '%s' is required", name)
 			}
 		default:
 			switch t := items[2]; t {
@@ -153,22 +311,43 @@ func handleCommand(cx *cli.Context, options []string, cmd *cliCommand, method fu
 			case "a":
 				invalid = !cx.IsSet(name) && len(cx.StringSlice(name)) == 0
 			}
+			if invalid && !canPrompt() {
+				exitWithError(exitUsage, "the command option: '%s' is required", name)
+			}
+			// step: we are attached to a terminal, so ask for the option
 			if invalid {
-				printError("the command option: '%s' is required", name)
+				if err := promptOption(cx, cmd, name); err != nil {
+					exitWithError(exitCode(err), "%s", err)
+				}
 			}
 		}
 	}
 
 	// step: create a cli output
-	writer, err := newFormatter(cx.GlobalString("format"), os.Stdout)
+	verbosity := outputNormal
+	switch {
+	case cx.GlobalBool("quiet") && cx.GlobalBool("verbose"):
+		exitWithError(exitUsage, "invalid option, you cannot use --quiet and --verbose together")
+	case cx.GlobalBool("quiet"):
+		verbosity = outputQuiet
+	case cx.GlobalBool("verbose"):
+		verbosity = outputVerbose
+	}
+	writer, err := newFormatter(cx.GlobalString("format"), os.Stdout, verbosity)
 	if err != nil {
-		printError("error: %s", err)
+		exitWithError(exitUsage, "error: %s", err)
 	}
 
 	// step: call the command and handle any errors
+	started := time.Now()
 	if err := method(writer, cx, cmd); err != nil {
-		printError("operation failed, error: %s", err)
+		exitWithError(exitCode(err), "operation failed, error: %s", err)
 	}
+	writer.verbose(map[string]interface{}{
+		"action":   "timing",
+		"command":  cx.Command.FullName(),
+		"duration": time.Since(started).String(),
+	}).log("completed %s in %s\n", cx.Command.FullName(), time.Since(started))
 
 	return nil
 }
@@ -178,43 +357,209 @@ func handleCommand(cx *cli.Context, options []string, cmd *cliCommand, method fu
 //
 func (r *cliCommand) getCredentials() func(cx *cli.Context) error {
 	return func(cx *cli.Context) error {
+		// step: configure the logs, kept on the stderr apart from the output
+		if err := logger.configure(cx.GlobalString("log-level"), cx.GlobalString("log-format")); err != nil {
+			exitWithError(exitUsage, "%s", err)
+		}
+		switch {
+		case cx.GlobalBool("debug"):
+			logger.configure("debug", cx.GlobalString("log-format"))
+		case cx.GlobalBool("quiet") && !cx.GlobalIsSet("log-level"):
+			logger.configure("error", cx.GlobalString("log-format"))
+		}
+		setupColor(cx.GlobalBool("no-color"))
+		// step: apply the environment if one was selected
+		if name := cx.GlobalString("env"); name != "" {
+			if err := r.useEnvironment(cx, name); err != nil {
+				return err
+			}
+		}
 		// step: ensure we have a region
 		if cx.GlobalString("region") == "" {
-			fmt.Fprintf(os.Stderr, "[error] you have not specified the aws region the resources reside\n")
-			os.Exit(1)
+			exitWithError(exitUsage, "you have not specified the aws region the resources reside")
 		}
+		r.setupContext(cx.GlobalDuration("timeout"))
+		if cx.GlobalBool("use-agent") {
+			r.agent = newAgentClient(cx.GlobalString("agent-socket"))
+		}
+
 		config := &aws.Config{
-			Region: aws.String(cx.GlobalString("region")),
+			Region:          aws.String(cx.GlobalString("region")),
+			S3UseAccelerate: aws.Bool(cx.GlobalBool("accelerate")),
+			UseDualStack:    aws.Bool(cx.GlobalBool("dualstack")),
+			// note: permits access point arns in another region to be used as the bucket
+			S3UseARNRegion: aws.Bool(true),
 		}
 
+		// step: create the http client, the proxy is taken from the environment (HTTPS_PROXY, NO_PROXY)
+		client, err := newHTTPClient(cx.GlobalString("ca-bundle"))
+		if err != nil {
+			return err
+		}
+		config.HTTPClient = client
+
 		// step: are we using static credentials
-		if cx.GlobalString("access-key") != "" || cx.GlobalString("secret-ket") != "" {
+		if cx.GlobalString("access-key") != "" || cx.GlobalString("secret-key") != "" {
 			if cx.GlobalString("secret-key") == "" {
 				return fmt.Errorf("you have specified a access key with a secret key")
 			}
 			if cx.GlobalString("access-key") == "" {
 				return fmt.Errorf("you have specified a secret key with a access key")
 			}
+			logger.redact(cx.GlobalString("secret-key"), cx.GlobalString("session-token"))
 			config.Credentials = credentials.NewStaticCredentials(cx.GlobalString("access-key"),
 				cx.GlobalString("secret-key"),
 				cx.GlobalString("session-token"))
-		} else if cx.GlobalString("profile") != "" {
-			config.Credentials = credentials.NewSharedCredentials(
-				cx.GlobalString("credentials"),
-				cx.GlobalString("profile"))
+		}
 
+		// step: create the session, the default chain covers the environment, shared config profiles
+		// including roles, web identity tokens (i.e. IRSA), ecs task roles and the instance profile
+		sess, err := newProfileSession(config, cx.GlobalString("profile"), cx.GlobalString("credentials"))
+		if err != nil {
+			return fmt.Errorf("unable to create the aws session, error: %s", err)
+		}
+		r.session = sess
+
+		// step: if the profile uses sso, ensure we have a valid token when the credentials are first
+		// retrieved, logging in if required, so the commands not calling aws never prompt; the agent
+		// holds the session of the clients
+		if config.Credentials == nil && r.agent == nil {
+			profile := profileName(cx.GlobalString("profile"))
+			r.session = r.session.Copy(&aws.Config{
+				Credentials: newSSOLoginCredentials(r.session.Config.Credentials, func() error {
+					return r.ensureSSOLogin(profile, sharedConfigFile(), client)
+				}),
+			})
+		}
+
+		// step: are we assuming a role?
+		profile := profileName(cx.GlobalString("profile"))
+		cache := cx.GlobalBool("credentials-cache")
+		if roleARN := cx.GlobalString("role-arn"); roleARN != "" {
+			sessionName := cx.GlobalString("role-session-name")
+			tokenFile := cx.GlobalString("web-identity-token-file")
+			creds := roleCredentials(r.session, roleARN, sessionName, tokenFile)
+			if cache {
+				creds = newCachedRoleCredentials(creds, profile, roleARN, sessionName, tokenFile)
+			}
+			r.session = r.session.Copy(&aws.Config{Credentials: creds})
+		} else if cx.GlobalString("web-identity-token-file") != "" {
+			return fmt.Errorf("the web identity token file requires a role arn (--role-arn)")
+		} else if cache && config.Credentials == nil {
+			// step: cache the credentials of role based profiles
+			if keys := roleProfileKeys(profile, cx.GlobalString("credentials"), sharedConfigFile()); keys != nil {
+				r.session = r.session.Copy(&aws.Config{
+					Credentials: newCachedRoleCredentials(r.session.Config.Credentials, keys...),
+				})
+			}
 		}
 
 		// step: create the clients
-		r.s3Client = s3.New(session.New(config))
-		r.kmsClient = kms.New(session.New(config))
-		r.uploader = s3manager.NewUploader(session.New(config))
+		// step: are we debugging the requests?
+		if cx.GlobalBool("debug") {
+			enableDebug(r.session)
+		}
+		instrumentSession(r.session)
+		r.s3Client = s3.New(r.session)
+		r.kmsClient = kms.New(r.session)
+		r.uploader = s3manager.NewUploader(r.session)
+
+		if cx.GlobalBool("debug") {
+			logger.debugf("endpoints: s3: %s, kms: %s", r.s3Client.Endpoint, r.kmsClient.Endpoint)
+		}
 
 		return nil
 	}
 }
 
+//
+// roleCredentials returns the credentials for the role, either via a web identity token or by
+// assuming the role with the credentials of the session
+//
+func roleCredentials(sess *session.Session, roleARN, sessionName, tokenFile string) *credentials.Credentials {
+	if tokenFile != "" {
+		return stscreds.NewWebIdentityCredentials(sess, roleARN, sessionName, tokenFile)
+	}
+
+	return stscreds.NewCredentials(sess, roleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = sessionName
+	})
+}
+
+//
+// profileName returns the name of the profile in use
+//
+func profileName(profile string) string {
+	for _, x := range []string{profile, os.Getenv("AWS_PROFILE"), os.Getenv("AWS_DEFAULT_PROFILE")} {
+		if x != "" {
+			return x
+		}
+	}
+
+	return "default"
+}
+
+//
+// sharedConfigFile returns the path to the aws shared config file
+//
+func sharedConfigFile() string {
+	if path := os.Getenv("AWS_CONFIG_FILE"); path != "" {
+		return path
+	}
+
+	return defaults.SharedConfigFilename()
+}
+
+//
+// forRegion returns a copy of the command with the clients for another region
+//
+func (r *cliCommand) forRegion(region string) *cliCommand {
+	sess := r.sessionForRegion(region)
+
+	return &cliCommand{
+		prefix:    r.prefix,
+		ctx:       r.ctx,
+		cancel:    r.cancel,
+		session:   sess,
+		s3Client:  s3.New(sess),
+		kmsClient: kms.New(sess),
+		uploader:  s3manager.NewUploader(sess),
+		limiter:   r.limiter,
+		cache:     r.cache,
+		agent:     r.agent,
+		resumeDir: r.resumeDir,
+		unlocked:  r.unlocked,
+	}
+}
+
+//
+// newProfileSession creates a session from the profile in the shared credentials and config files
+//
+func newProfileSession(config *aws.Config, profile, credentialsFile string) (*session.Session, error) {
+	return session.NewSessionWithOptions(session.Options{
+		Config:            *config,
+		Profile:           profile,
+		SharedConfigState: session.SharedConfigEnable,
+		SharedConfigFiles: []string{credentialsFile, sharedConfigFile()},
+		// note: prompt for the mfa token if a role profile has a mfa_serial
+		AssumeRoleTokenProvider: stscreds.StdinTokenProvider,
+	})
+}
+
+//
+// sessionForRegion returns a copy of the session for another region
+//
+func (r *cliCommand) sessionForRegion(region string) *session.Session {
+	return r.session.Copy(&aws.Config{Region: aws.String(region)})
+}
+
 func printError(message string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, "[error] "+message+"\n", args...)
-	os.Exit(1)
+	exitWithError(exitFailure, message, args...)
+}
+
+// exitWithError prints the error and exits with the code, removing any temporary files
+func exitWithError(code int, message string, args ...interface{}) {
+	logger.errorf(message, args...)
+	cleanup.run()
+	os.Exit(code)
 }
@@ -16,12 +16,22 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
@@ -30,6 +40,17 @@ import (
 // hasBucket checks if the bucket exists
 //
 func (r cliCommand) hasBucket(bucket string) (bool, error) {
+	// note: the access points are not listed, and may belong to another account
+	if isAccessPoint(bucket) {
+		_, err := r.s3Client.HeadBucketWithContext(r.ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+		if err != nil {
+			if exitCode(err) == exitNotFound {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
 	list, err := r.listS3Buckets()
 	if err != nil {
 		return false, err
@@ -47,7 +68,7 @@ func (r cliCommand) hasBucket(bucket string) (bool, error) {
 // listS3Buckets gets a list of buckets
 //
 func (r cliCommand) listS3Buckets() ([]*s3.Bucket, error) {
-	list, err := r.s3Client.ListBuckets(&s3.ListBucketsInput{})
+	list, err := r.s3Client.ListBucketsWithContext(r.ctx, &s3.ListBucketsInput{})
 	if err != nil {
 		return nil, err
 	}
@@ -55,33 +76,196 @@ func (r cliCommand) listS3Buckets() ([]*s3.Bucket, error) {
 	return list.Buckets, nil
 }
 
+//
+// getBucketEncryption returns the default encryption of the bucket, or nil if none is configured
+//
+func (r cliCommand) getBucketEncryption(bucket string) (*s3.ServerSideEncryptionRule, error) {
+	if isAccessPoint(bucket) {
+		return nil, newExitError(exitUsage, "the default encryption of the bucket cannot be retrieved via the access point: %s, specify the kms key", bucket)
+	}
+	resp, err := r.s3Client.GetBucketEncryptionWithContext(r.ctx, &s3.GetBucketEncryptionInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		if e, ok := err.(awserr.Error); ok && e.Code() == "ServerSideEncryptionConfigurationNotFoundError" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	for _, x := range resp.ServerSideEncryptionConfiguration.Rules {
+		if x.ApplyServerSideEncryptionByDefault != nil {
+			return x, nil
+		}
+	}
+
+	return nil, nil
+}
+
+//
+// hasDefaultKmsEncryption checks the bucket encrypts files with a kms key by default
+//
+func (r cliCommand) hasDefaultKmsEncryption(bucket string) error {
+	rule, err := r.getBucketEncryption(bucket)
+	if err != nil {
+		return err
+	}
+	if rule == nil || aws.StringValue(rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm) != s3.ServerSideEncryptionAwsKms {
+		return fmt.Errorf("no kms key specified and the bucket: %s has no default kms encryption", bucket)
+	}
+
+	return nil
+}
+
 //
 // getFileMetadata returns the head data for the specific key
 //
 func (r cliCommand) getFileMetadata(key, bucket string) (*s3.HeadObjectOutput, error) {
-	return r.s3Client.HeadObject(&s3.HeadObjectInput{
+	return r.s3Client.HeadObjectWithContext(r.ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
+		Key:    aws.String(r.objectKey(key)),
 	})
 }
 
 //
-// getFile retrieves the content from a file in the bucket
+// headObjects retrieves the head data for a collection of keys in parallel
+//
+func (r cliCommand) headObjects(bucket string, keys []string) (map[string]*s3.HeadObjectOutput, error) {
+	type result struct {
+		key  string
+		head *s3.HeadObjectOutput
+		err  error
+	}
+	keysCh := make(chan string)
+	resultCh := make(chan result, len(keys))
+
+	// step: start the workers
+	var wg sync.WaitGroup
+	for i := 0; i < headConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keysCh {
+				head, err := r.getFileMetadata(key, bucket)
+				resultCh <- result{key: key, head: head, err: err}
+			}
+		}()
+	}
+	for _, key := range keys {
+		keysCh <- key
+	}
+	close(keysCh)
+	wg.Wait()
+	close(resultCh)
+
+	list := make(map[string]*s3.HeadObjectOutput, len(keys))
+	for x := range resultCh {
+		if x.err != nil {
+			return nil, fmt.Errorf("unable to retrieve metadata for: %s, error: %s", x.key, x.err)
+		}
+		list[x.key] = x.head
+	}
+
+	return list, nil
+}
+
+//
+// getFile retrieves the content from a file in the bucket, or the local cache if enabled
 //
 func (r *cliCommand) getFile(bucket, key string) ([]byte, error) {
+	if r.cache != nil {
+		return r.cache.getFile(r, bucket, key)
+	}
+
+	return r.fetchFile(bucket, key)
+}
+
+//
+// fetchFile retrieves the content from a file in the bucket
+//
+func (r *cliCommand) fetchFile(bucket, key string) ([]byte, error) {
+	if r.agent != nil {
+		return r.agent.getFile(bucket, r.objectKey(key))
+	}
+	if r.resumeDir != "" {
+		content, _, err := r.resumeDownload(bucket, key, "")
+		return content, err
+	}
 	// step: retrieve the object from the bucket
-	resp, err := r.s3Client.GetObject(&s3.GetObjectInput{
+	resp, err := r.s3Client.GetObjectWithContext(r.ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(r.objectKey(key)),
+	})
+	if err != nil {
+		if e, ok := err.(awserr.Error); ok && e.Code() == "InvalidObjectState" {
+			return nil, fmt.Errorf("the file: %s has been archived (i.e. glacier), use the restore-archive command to retrieve it", key)
+		}
+		return nil, err
+	}
+
+	return r.readObject(key, resp)
+}
+
+//
+// getFileIfChanged retrieves the file unless its etag matches, no content being returned if unchanged
+//
+func (r *cliCommand) getFileIfChanged(bucket, key, etag string) ([]byte, *s3.GetObjectOutput, error) {
+	if r.resumeDir != "" {
+		return r.resumeDownload(bucket, key, etag)
+	}
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
+		Key:    aws.String(r.objectKey(key)),
+	}
+	if etag != "" {
+		input.IfNoneMatch = aws.String(etag)
+	}
+	resp, err := r.s3Client.GetObjectWithContext(r.ctx, input)
+	if err != nil {
+		if e, ok := err.(awserr.RequestFailure); ok && e.StatusCode() == http.StatusNotModified {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	content, err := r.readObject(key, resp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return content, resp, nil
+}
+
+//
+// getFileVersion retrieves a specific version of the file from the bucket
+//
+func (r *cliCommand) getFileVersion(bucket, key, version string) ([]byte, error) {
+	resp, err := r.s3Client.GetObjectWithContext(r.ctx, &s3.GetObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(r.objectKey(key)),
+		VersionId: aws.String(version),
 	})
 	if err != nil {
 		return nil, err
 	}
-	// step: read the content
-	content, err := ioutil.ReadAll(resp.Body)
+
+	return r.readObject(key, resp)
+}
+
+//
+// readObject reads the content of the object, verifying the checksum and reversing any compression
+//
+func (r *cliCommand) readObject(key string, resp *s3.GetObjectOutput) ([]byte, error) {
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(r.limitReader(resp.Body))
 	if err != nil {
 		return nil, err
 	}
+	if err := verifyChecksum(key, resp.Metadata, content); err != nil {
+		return nil, err
+	}
+	if content, err = decompressContent(resp.Metadata, content); err != nil {
+		return nil, fmt.Errorf("unable to decompress the file: %s, error: %s", key, err)
+	}
 
 	return content, nil
 }
@@ -90,38 +274,245 @@ func (r *cliCommand) getFile(bucket, key string) ([]byte, error) {
 // removeFile removes a file from a bucket
 //
 func (r *cliCommand) removeFile(bucket, key string) error {
-	_, err := r.s3Client.DeleteObject(&s3.DeleteObjectInput{
+	// note: the agent checks the lock with its own session
+	if r.agent != nil {
+		return r.agent.removeFile(bucket, r.objectKey(key), r.unlocked)
+	}
+	if err := r.checkUnlocked(bucket, key); err != nil {
+		return err
+	}
+	_, err := r.s3Client.DeleteObjectWithContext(r.ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
+		Key:    aws.String(r.objectKey(key)),
 	})
 
 	return err
 }
 
+//
+// uploadOptions are the optional settings of an upload
+//
+type uploadOptions struct {
+	// the tags placed on the file
+	tags map[string]string
+	// the additional metadata of the file
+	metadata map[string]string
+	// the compression applied to the content, if any
+	compress string
+	// skip the upload if the file in the bucket has the same content and kms key
+	skipUnchanged bool
+	// only upload if the file does not exist
+	ifNotExists bool
+	// only upload if the etag of the file matches
+	ifMatch string
+}
+
 //
 // putFile uploads a file to the bucket
 //
-func (r *cliCommand) putFile(bucket, key, path, kmsID string) error {
+func (r *cliCommand) putFile(bucket, key, path, kmsID string, options *uploadOptions) error {
 	// step: open the file
 	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
-	// step: check if the file has changed
+	defer file.Close()
+
+	return r.upload(bucket, key, file, kmsID, options)
+}
+
+//
+// putContent uploads the content to the bucket
+//
+func (r *cliCommand) putContent(bucket, key string, content []byte, kmsID string, options *uploadOptions) error {
+	return r.upload(bucket, key, bytes.NewReader(content), kmsID, options)
+}
 
-	// step: create the input
+//
+// upload places the content into the bucket, encrypted with the kms key and with the options
+//
+func (r *cliCommand) upload(bucket, key string, body io.Reader, kmsID string, options *uploadOptions) error {
+	if options == nil {
+		options = &uploadOptions{}
+	}
+	if r.agent != nil {
+		return r.agent.upload(bucket, r.objectKey(key), r.limitReader(body), kmsID, options, r.unlocked)
+	}
+	// step: carry the tags of the file forward, the upload replacing them, i.e. the lock or owner
+	tags, err := r.existingTags(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := r.checkLockTag(key, tags); err != nil {
+		return err
+	}
+	for k, v := range options.tags {
+		tags[k] = v
+	}
+	// step: compress the content if required
+	if options.compress != "" {
+		content, err := ioutil.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		if content, err = compressContent(options.compress, content); err != nil {
+			return err
+		}
+		body = bytes.NewReader(content)
+	}
+	// step: compute the checksum of the content, recorded in the metadata and verified on retrieval
+	body, sum, size, err := checksumBody(body)
+	if err != nil {
+		return err
+	}
 	input := &s3manager.UploadInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-		Body:   file,
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(r.objectKey(key)),
+		Body:     r.limitReader(body),
+		Metadata: map[string]*string{checksumMetadata: aws.String(hex.EncodeToString(sum))},
+	}
+	for k, v := range options.metadata {
+		input.Metadata[k] = aws.String(v)
+	}
+	if options.compress != "" {
+		input.Metadata[compressionMetadata] = aws.String(options.compress)
+	}
+	if options.skipUnchanged {
+		if unchanged, err := r.isUnchanged(bucket, key, hex.EncodeToString(sum), kmsID); err != nil {
+			return err
+		} else if unchanged {
+			return errUnchanged
+		}
+	}
+	// note: s3 validates the checksum of a single part upload, multipart uploads use composite checksums
+	if size < r.uploader.PartSize {
+		input.ChecksumSHA256 = aws.String(base64.StdEncoding.EncodeToString(sum))
 	}
 	if kmsID != "" {
 		input.ServerSideEncryption = aws.String("aws:kms")
 		input.SSEKMSKeyId = aws.String(kmsID)
 	}
+	if len(tags) > 0 {
+		input.Tagging = aws.String(encodeTags(tags))
+	}
+	if options.ifNotExists || options.ifMatch != "" {
+		return r.conditionalUpload(input, options)
+	}
+	// note: the multipart uploads are resumed when enabled, the small files being uploaded in one request
+	if seeker, ok := body.(io.ReadSeeker); ok && r.resumeDir != "" && size >= r.uploader.PartSize {
+		return r.resumeUpload(input, seeker, size, hex.EncodeToString(sum))
+	}
+	_, err = r.uploader.UploadWithContext(r.ctx, input)
+
+	return err
+}
+
+//
+// isUnchanged checks if the file in the bucket has the checksum and is encrypted with the kms key
+//
+func (r *cliCommand) isUnchanged(bucket, key, checksum, kmsID string) (bool, error) {
+	head, err := r.getFileMetadata(key, bucket)
+	if err != nil {
+		if exitCode(err) == exitNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	if value, found := metadataValue(head.Metadata, checksumMetadata); !found || value != checksum {
+		return false, nil
+	}
+	if kmsID == "" {
+		return true, nil
+	}
+	current := aws.StringValue(head.SSEKMSKeyId)
+	if current == kmsID || strings.HasSuffix(current, ":key/"+kmsID) {
+		return true, nil
+	}
+	// note: the key may be an alias, requiring the arn to be resolved
+	metadata, err := r.describeKey(kmsID)
+	if err != nil {
+		return false, err
+	}
+
+	return aws.StringValue(metadata.Arn) == current, nil
+}
+
+//
+// conditionalUpload uploads the file in a single request, which fails if the precondition is not met
+//
+func (r *cliCommand) conditionalUpload(input *s3manager.UploadInput, options *uploadOptions) error {
+	// note: the conditions are only honoured on a put or the completion of a multipart upload, so
+	// rather than applying them to every request of the uploader we use a single put
+	content, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return err
+	}
+	headers := make(map[string]string, 0)
+	if options.ifNotExists {
+		headers["If-None-Match"] = "*"
+	}
+	if options.ifMatch != "" {
+		headers["If-Match"] = `"` + strings.Trim(options.ifMatch, `"`) + `"`
+	}
+	_, err = r.s3Client.PutObjectWithContext(r.ctx, &s3.PutObjectInput{
+		Bucket:               input.Bucket,
+		Key:                  input.Key,
+		Body:                 bytes.NewReader(content),
+		ServerSideEncryption: input.ServerSideEncryption,
+		SSEKMSKeyId:          input.SSEKMSKeyId,
+		Tagging:              input.Tagging,
+		Metadata:             input.Metadata,
+		ChecksumSHA256:       input.ChecksumSHA256,
+	}, request.WithSetRequestHeaders(headers))
+	if e, ok := err.(awserr.Error); ok {
+		switch e.Code() {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			if options.ifNotExists {
+				return newExitError(exitFailure, "the file: %s already exists", aws.StringValue(input.Key))
+			}
+			return newExitError(exitFailure, "the etag of the file: %s does not match: %s", aws.StringValue(input.Key), options.ifMatch)
+		case "NoSuchKey":
+			return newNotFoundError("the file: %s does not exist", aws.StringValue(input.Key))
+		}
+	}
 
-	// step: upload the file
-	_, err = r.uploader.Upload(input)
+	return err
+}
+
+//
+// copyFile copies a file within the bucket, keeping the kms key it is encrypted with
+//
+func (r *cliCommand) copyFile(bucket, source, target string) error {
+	return r.copyObject(bucket, source, bucket, target, "")
+}
+
+//
+// copyObject copies a file between the buckets, re-encrypting it with the kms key, or keeping the kms key
+// of the source if none is given; the metadata and tags are copied with the file
+//
+func (r *cliCommand) copyObject(sourceBucket, source, bucket, target, kmsID string) error {
+	if err := r.checkUnlocked(bucket, target); err != nil {
+		return err
+	}
+	head, err := r.getFileMetadata(source, sourceBucket)
+	if err != nil {
+		return err
+	}
+	// note: a copy into an access point is sent to the region of its arn, the sessions using the arn region
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(bucket),
+		Key:        aws.String(r.objectKey(target)),
+		CopySource: aws.String(copySource(sourceBucket, r.objectKey(source))),
+	}
+	switch {
+	case kmsID != "":
+		input.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAwsKms)
+		input.SSEKMSKeyId = aws.String(kmsID)
+	case aws.StringValue(head.ServerSideEncryption) == s3.ServerSideEncryptionAwsKms:
+		input.ServerSideEncryption = head.ServerSideEncryption
+		input.SSEKMSKeyId = head.SSEKMSKeyId
+	}
+	_, err = r.s3Client.CopyObjectWithContext(r.ctx, input)
 
 	return err
 }
@@ -130,53 +521,241 @@ func (r *cliCommand) putFile(bucket, key, path, kmsID string) error {
 // listBucketKeys get all the keys from the bucket
 //
 func (r *cliCommand) listBucketKeys(bucket, prefix string) ([]*s3.Object, error) {
-	var list []*s3.Object
+	var objects []*s3.Object
+	if r.agent != nil {
+		var err error
+		if objects, err = r.agent.listObjects(bucket, r.objectKey(prefix)); err != nil {
+			return nil, err
+		}
+	} else {
+		err := r.s3Client.ListObjectsPagesWithContext(r.ctx, &s3.ListObjectsInput{
+			Bucket: aws.String(bucket),
+			Prefix: aws.String(r.objectKey(prefix)),
+		}, func(page *s3.ListObjectsOutput, last bool) bool {
+			objects = append(objects, page.Contents...)
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	resp, err := r.s3Client.ListObjects(&s3.ListObjectsInput{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(prefix),
-	})
-	if err != nil {
-		return nil, err
+	return r.bucketFiles(objects, prefix), nil
+}
+
+//
+// listBucketPage lists up to the limit of the keys under the prefix following the start after key, returning
+// the key to continue the listing from, or an empty marker once the listing is complete
+//
+func (r *cliCommand) listBucketPage(bucket, prefix, startAfter string, limit int) ([]*s3.Object, string, error) {
+	var objects []*s3.Object
+	var more bool
+	if r.agent != nil {
+		list, err := r.agent.listObjects(bucket, r.objectKey(prefix))
+		if err != nil {
+			return nil, "", err
+		}
+		for _, x := range list {
+			if startAfter != "" && *x.Key <= r.objectKey(startAfter) {
+				continue
+			}
+			if limit > 0 && len(objects) >= limit {
+				more = true
+				break
+			}
+			objects = append(objects, x)
+		}
+	} else {
+		input := &s3.ListObjectsV2Input{
+			Bucket: aws.String(bucket),
+			Prefix: aws.String(r.objectKey(prefix)),
+		}
+		if startAfter != "" {
+			input.StartAfter = aws.String(r.objectKey(startAfter))
+		}
+		if limit > 0 && limit < 1000 {
+			input.MaxKeys = aws.Int64(int64(limit))
+		}
+		err := r.s3Client.ListObjectsV2PagesWithContext(r.ctx, input, func(page *s3.ListObjectsV2Output, last bool) bool {
+			for _, x := range page.Contents {
+				if limit > 0 && len(objects) >= limit {
+					more = true
+					return false
+				}
+				objects = append(objects, x)
+			}
+			more = aws.BoolValue(page.IsTruncated)
+			return limit <= 0 || len(objects) < limit
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// note: the marker is the last key scanned, whether or not it is listed
+	var marker string
+	if more && len(objects) > 0 {
+		marker = strings.TrimPrefix(*objects[len(objects)-1].Key, r.prefix)
 	}
 
-	// step: filter out any keys which are directories
-	for _, x := range resp.Contents {
+	return r.bucketFiles(objects, prefix), marker, nil
+}
+
+//
+// listBucketDirs lists the files directly under the prefix and the directories beneath it, i.e. the
+// common prefixes of the keys delimited by a /
+//
+func (r *cliCommand) listBucketDirs(bucket, prefix string) ([]*s3.Object, []string, error) {
+	var objects []*s3.Object
+	var dirs []string
+	if r.agent != nil {
+		list, err := r.agent.listObjects(bucket, r.objectKey(prefix))
+		if err != nil {
+			return nil, nil, err
+		}
+		seen := make(map[string]bool, 0)
+		for _, x := range list {
+			name := strings.TrimPrefix(*x.Key, r.objectKey(prefix))
+			if i := strings.Index(name, "/"); i >= 0 {
+				if dir := r.objectKey(prefix) + name[:i+1]; !seen[dir] {
+					dirs = append(dirs, dir)
+					seen[dir] = true
+				}
+				continue
+			}
+			objects = append(objects, x)
+		}
+	} else {
+		err := r.s3Client.ListObjectsV2PagesWithContext(r.ctx, &s3.ListObjectsV2Input{
+			Bucket:    aws.String(bucket),
+			Prefix:    aws.String(r.objectKey(prefix)),
+			Delimiter: aws.String("/"),
+		}, func(page *s3.ListObjectsV2Output, last bool) bool {
+			objects = append(objects, page.Contents...)
+			for _, x := range page.CommonPrefixes {
+				dirs = append(dirs, aws.StringValue(x.Prefix))
+			}
+			return true
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var list []string
+	for _, x := range dirs {
+		// note: the directories are relative to the prefix of the environment, the trash being hidden
+		x = strings.TrimPrefix(x, r.prefix)
+		if isTrashKey(x) && !isTrashKey(prefix) {
+			continue
+		}
+		list = append(list, x)
+	}
+	sort.Strings(list)
+
+	return r.bucketFiles(objects, prefix), list, nil
+}
+
+//
+// bucketFiles filters the directories and trash from the objects, making the keys relative to the prefix
+// of the environment
+//
+func (r *cliCommand) bucketFiles(objects []*s3.Object, prefix string) []*s3.Object {
+	var list []*s3.Object
+	for _, x := range objects {
+		// step: filter out any keys which are directories
 		if strings.HasSuffix(*x.Key, "/") {
 			continue
 		}
+		// note: the keys are relative to the prefix of the environment
+		x.Key = aws.String(strings.TrimPrefix(*x.Key, r.prefix))
+		// note: the deleted files are hidden unless listing the trash itself
+		if isTrashKey(*x.Key) && !isTrashKey(prefix) {
+			continue
+		}
 		list = append(list, x)
 	}
 
-	return list, nil
+	return list
 }
 
 //
 // hasKey checks if the key exist in the bucket
 //
 func (r cliCommand) hasKey(key, bucket string) (bool, error) {
-	keys, err := r.listBucketKeys(bucket, filepath.Dir(key))
-	if err != nil {
+	if _, err := r.getFileMetadata(key, bucket); err != nil {
+		if exitCode(err) == exitNotFound {
+			return false, nil
+		}
 		return false, err
 	}
 
-	for _, k := range keys {
-		if key == *k.Key {
-			return true, nil
+	return true, nil
+}
+
+//
+// listBucketVersions retrieves all the versions and delete markers in the bucket
+//
+func (r cliCommand) listBucketVersions(bucket string) ([]*s3.ObjectIdentifier, error) {
+	var list []*s3.ObjectIdentifier
+
+	err := r.s3Client.ListObjectVersionsPagesWithContext(r.ctx, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+	}, func(page *s3.ListObjectVersionsOutput, last bool) bool {
+		for _, x := range page.Versions {
+			list = append(list, &s3.ObjectIdentifier{Key: x.Key, VersionId: x.VersionId})
 		}
+		for _, x := range page.DeleteMarkers {
+			list = append(list, &s3.ObjectIdentifier{Key: x.Key, VersionId: x.VersionId})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return false, nil
+	return list, nil
 }
 
 //
-// sizeOfBucket gets the number of objects in the bucket
+// deleteObjectVersions removes a batch of versions from the bucket
 //
-func (r cliCommand) sizeOfBucket(name string) (int, error) {
-	files, err := r.listBucketKeys(name, "")
+func (r cliCommand) deleteObjectVersions(bucket string, versions []*s3.ObjectIdentifier) error {
+	resp, err := r.s3Client.DeleteObjectsWithContext(r.ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: &s3.Delete{
+			Objects: versions,
+			Quiet:   aws.Bool(true),
+		},
+	})
 	if err != nil {
-		return 0, err
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		x := resp.Errors[0]
+		return fmt.Errorf("failed to remove %d versions, the first: %s (%s), error: %s", len(resp.Errors),
+			aws.StringValue(x.Key), aws.StringValue(x.VersionId), aws.StringValue(x.Message))
+	}
+
+	return nil
+}
+
+// copySource returns the url encoded source of a copy, the key of an access point being under object/
+func copySource(bucket, key string) string {
+	var list []string
+	for _, x := range strings.Split(key, "/") {
+		list = append(list, url.PathEscape(x))
+	}
+	if isAccessPoint(bucket) {
+		return bucket + "/object/" + strings.Join(list, "/")
 	}
 
-	return len(files), nil
+	return bucket + "/" + strings.Join(list, "/")
+}
+
+//
+// objectKey returns the key of the file in the bucket, prefixed by the environment
+//
+func (r *cliCommand) objectKey(key string) string {
+	return r.prefix + key
 }
@@ -0,0 +1,76 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorBlue   = "\033[34m"
+	colorCyan   = "\033[36m"
+	colorGray   = "\033[90m"
+)
+
+// colorOutput indicates the output on the stdout is colorized
+var colorOutput bool
+
+//
+// setupColor enables the colors on the stdout and stderr when they are terminals, unless disabled by
+// the option or the NO_COLOR convention (https://no-color.org)
+//
+func setupColor(disabled bool) {
+	enabled := !disabled && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+
+	colorOutput = enabled && isTerminal(os.Stdout)
+	logger.Lock()
+	logger.color = enabled && isTerminal(os.Stderr)
+	logger.Unlock()
+}
+
+// isTerminal checks if the file is a terminal
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps the text in the color when the output is colorized
+func colorize(color, text string) string {
+	if !colorOutput || text == "" {
+		return text
+	}
+
+	return color + text + colorReset
+}
+
+// colorKey colorizes the directory of the key, making the prefixes of long listings easier to scan
+func colorKey(key string) string {
+	index := strings.LastIndex(key, "/")
+	if index < 0 {
+		return key
+	}
+
+	return colorize(colorBlue, key[:index+1]) + key[index+1:]
+}
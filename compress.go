@@ -0,0 +1,85 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// the metadata holding the compression applied to the content
+const compressionMetadata = "kmsctl-compression"
+
+// the compression algorithms supported; zstd is not, there being no implementation in the vendored dependencies
+var compressionAlgorithms = []string{"gzip"}
+
+// validCompression checks the compression algorithm is supported
+func validCompression(algorithm string) error {
+	if algorithm == "zstd" {
+		return newExitError(exitUsage, "zstd compression is not supported, use gzip")
+	}
+	if algorithm != "" && !isValidOption(algorithm, compressionAlgorithms) {
+		return newExitError(exitUsage, "unsupported compression: %s, must be one of %s", algorithm, strings.Join(compressionAlgorithms, ", "))
+	}
+
+	return nil
+}
+
+// compressContent compresses the content with the algorithm
+func compressContent(algorithm string, content []byte) ([]byte, error) {
+	switch algorithm {
+	case "gzip":
+		buffer := new(bytes.Buffer)
+		writer, err := gzip.NewWriterLevel(buffer, gzip.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := writer.Write(content); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buffer.Bytes(), nil
+	}
+
+	return nil, fmt.Errorf("unsupported compression: %s", algorithm)
+}
+
+//
+// decompressContent reverses the compression recorded in the object metadata, if any
+//
+func decompressContent(metadata map[string]*string, content []byte) ([]byte, error) {
+	algorithm, found := metadataValue(metadata, compressionMetadata)
+	if !found {
+		return content, nil
+	}
+	switch algorithm {
+	case "gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+
+		return ioutil.ReadAll(reader)
+	}
+
+	return nil, fmt.Errorf("the file is compressed with an unsupported algorithm: %s, only gzip is supported", algorithm)
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//
+// cleanupRegistry is a list of temporary files and directories which must be removed on exit, the files
+// are overwritten before being removed
+//
+type cleanupRegistry struct {
+	sync.Mutex
+	// the paths to remove
+	paths map[string]bool
+}
+
+// cleanup is the registry of temporary files
+var cleanup = &cleanupRegistry{paths: make(map[string]bool, 0)}
+
+// add registers the path for removal
+func (r *cleanupRegistry) add(path string) {
+	r.Lock()
+	defer r.Unlock()
+	r.paths[path] = true
+}
+
+// remove deletes the path and removes it from the registry
+func (r *cleanupRegistry) remove(path string) error {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.paths, path)
+
+	return shred(path)
+}
+
+// run removes all the registered paths
+func (r *cleanupRegistry) run() {
+	r.Lock()
+	defer r.Unlock()
+	for x := range r.paths {
+		shred(x)
+	}
+	r.paths = make(map[string]bool, 0)
+}
+
+//
+// privateTempDir creates a temporary directory accessible only by the user, registered for removal
+//
+func privateTempDir(tmpdir string) (string, error) {
+	directory, err := ioutil.TempDir(tmpdir, "kmsctl.")
+	if err != nil {
+		return "", fmt.Errorf("unable to create the temporary directory, error: %s", err)
+	}
+	cleanup.add(directory)
+
+	if err := os.Chmod(directory, 0700); err != nil {
+		cleanup.remove(directory)
+		return "", err
+	}
+
+	return directory, nil
+}
+
+//
+// shred overwrites the files under the path with zeros before removing them, so the content is not left
+// in the free blocks of the filesystem; this is best effort on copy on write and journaling filesystems
+//
+func shred(path string) error {
+	filepath.Walk(path, func(x string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			overwriteFile(x, info.Size())
+		}
+		return nil
+	})
+
+	return os.RemoveAll(path)
+}
+
+// overwriteFile overwrites the content of the file with zeros
+func overwriteFile(path string, size int64) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	zeros := make([]byte, 32*1024)
+	for written := int64(0); written < size; {
+		n := int64(len(zeros))
+		if size-written < n {
+			n = size - written
+		}
+		if _, err := file.Write(zeros[:n]); err != nil {
+			return err
+		}
+		written += n
+	}
+
+	return file.Sync()
+}
+
+//
+// interruptHandler dispatches the signals received by the process, permitting the shell, or a command
+// running a child process attached to the terminal, to replace the default of cancelling the context
+//
+type interruptHandler struct {
+	sync.Mutex
+	// the method handling the signals
+	handler func(os.Signal)
+}
+
+// interrupts is the handler of the signals
+var interrupts = &interruptHandler{}
+
+// set replaces the method handling the signals, returning a method restoring the previous
+func (r *interruptHandler) set(handler func(os.Signal)) func() {
+	r.Lock()
+	defer r.Unlock()
+	previous := r.handler
+	r.handler = handler
+
+	return func() {
+		r.Lock()
+		defer r.Unlock()
+		r.handler = previous
+	}
+}
+
+// ignore discards the signals while a child process, i.e. the editor, handles them itself
+func (r *interruptHandler) ignore() func() {
+	return r.set(func(os.Signal) {})
+}
+
+// handle passes the signal to the current handler
+func (r *interruptHandler) handle(sig os.Signal) {
+	r.Lock()
+	handler := r.handler
+	r.Unlock()
+	if handler != nil {
+		handler(sig)
+	}
+}
+
+//
+// setupContext creates the context used by all the aws calls, applying the timeout if any, and cancels
+// it when the process is interrupted; the command returns as normal, the temporary files being removed
+// on exit. A second signal exits immediately, for commands blocked outside of the context
+//
+func (r *cliCommand) setupContext(timeout time.Duration) {
+	if timeout > 0 {
+		r.ctx, r.cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		r.ctx, r.cancel = context.WithCancel(context.Background())
+	}
+
+	interrupts.set(func(sig os.Signal) {
+		if r.ctx.Err() != nil {
+			exitWithError(exitInterrupted, "received the signal: %s again, exiting", sig)
+		}
+		logger.errorf("received the signal: %s, cancelling the operation", sig)
+		r.cancel()
+	})
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	go func() {
+		for sig := range signalCh {
+			interrupts.handle(sig)
+		}
+	}()
+}
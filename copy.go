@@ -0,0 +1,211 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v2"
+)
+
+//
+// keyMap assigns the kms keys of the copied files by the prefix of the destination
+//
+type keyMap struct {
+	// the kms key of the files not matching any prefix
+	Default string `yaml:"default"`
+	// the kms key of the files by prefix, the longest prefix matching
+	Prefixes map[string]string `yaml:"prefixes"`
+}
+
+//
+// newCopyCommand creates a new cp command
+//
+func newCopyCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:      "cp",
+		Aliases:   []string{"copy"},
+		Usage:     "copy a file, or the files under a prefix ending in /, within or between buckets, optionally re-encrypting them",
+		ArgsUsage: "SOURCE DESTINATION",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the files to copy",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:  "to-bucket",
+				Usage: "the name of the s3 bucket to copy the files to, defaults to the source bucket `NAME`",
+			},
+			cli.StringFlag{
+				Name:  "k, kms",
+				Usage: "the kms key the copied files are encrypted with, by default the key of each source file `KEY`",
+			},
+			cli.StringFlag{
+				Name:  "key-map",
+				Usage: "the yaml file assigning the kms keys of the copied files by the prefix of the destination `PATH`",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "display the files which would be copied and the kms keys, without copying them",
+			},
+			cli.BoolFlag{
+				Name:  "continue-on-error",
+				Usage: "continue copying the remaining files on a failure, exiting non-zero once complete",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s"}, cmd, copyFiles)
+		},
+	}
+}
+
+//
+// copyFiles copies the file, or the files under the prefix, to the destination, encrypting each with the
+// kms key assigned by the key map, the --kms key, or the key of the source file
+//
+func copyFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	target := defaultValue(cx.String("to-bucket"), bucket)
+	continueOnError := cx.Bool("continue-on-error")
+
+	if len(cx.Args()) != 2 {
+		return newExitError(exitUsage, "you must specify the source and destination, i.e. SOURCE DESTINATION")
+	}
+	source := strings.TrimPrefix(cx.Args()[0], "/")
+	destination := strings.TrimPrefix(cx.Args()[1], "/")
+
+	mapping := &keyMap{Default: cx.String("kms")}
+	if path := cx.String("key-map"); path != "" {
+		var err error
+		if mapping, err = loadKeyMap(path); err != nil {
+			return err
+		}
+		// note: the --kms option overrides the default of the map
+		mapping.Default = defaultValue(cx.String("kms"), mapping.Default)
+	}
+
+	// step: expand the source into the files and their destinations
+	copies := make(map[string]string, 0)
+	var keys []string
+	if source == "" || strings.HasSuffix(source, "/") {
+		if destination != "" && !strings.HasSuffix(destination, "/") {
+			return newExitError(exitUsage, "the destination: %s must be a prefix ending in / when copying a prefix", destination)
+		}
+		files, err := cmd.listBucketKeys(bucket, source)
+		if err != nil {
+			return err
+		}
+		for _, x := range files {
+			key := aws.StringValue(x.Key)
+			keys = append(keys, key)
+			copies[key] = destination + strings.TrimPrefix(key, source)
+		}
+	} else {
+		keys = append(keys, source)
+		copies[source] = destination
+		if destination == "" || strings.HasSuffix(destination, "/") {
+			copies[source] = destination + source[strings.LastIndex(source, "/")+1:]
+		}
+	}
+	if len(keys) <= 0 {
+		return newNotFoundError("no files found under: s3://%s/%s", bucket, source)
+	}
+	if bucket == target {
+		for _, key := range keys {
+			if copies[key] == key {
+				return newExitError(exitUsage, "the file: %s cannot be copied onto itself", key)
+			}
+		}
+	}
+
+	if cx.Bool("dry-run") {
+		for _, key := range keys {
+			kmsKey := defaultValue(mapping.lookup(copies[key]), "(source key)")
+			o.fields(map[string]interface{}{
+				"action":      "copy",
+				"source":      fmt.Sprintf("s3://%s/%s", bucket, key),
+				"destination": fmt.Sprintf("s3://%s/%s", target, copies[key]),
+				"kms":         kmsKey,
+				"dry-run":     true,
+			}).log("[dry-run] s3://%s/%s -> s3://%s/%s (%s)\n", bucket, key, target, copies[key], kmsKey)
+		}
+		return nil
+	}
+
+	summary := newTransferSummary("copied")
+	for _, key := range keys {
+		kmsKey := mapping.lookup(copies[key])
+		if err := cmd.copyObject(bucket, key, target, copies[key], kmsKey); err != nil {
+			if continueOnError {
+				summary.fail(key, err)
+				continue
+			}
+			return fmt.Errorf("failed to copy the file: %s, error: %s", key, err)
+		}
+		summary.success()
+
+		o.fields(map[string]interface{}{
+			"action":      "copy",
+			"source":      fmt.Sprintf("s3://%s/%s", bucket, key),
+			"destination": fmt.Sprintf("s3://%s/%s", target, copies[key]),
+			"kms":         kmsKey,
+		}).log("copied s3://%s/%s to s3://%s/%s\n", bucket, key, target, copies[key])
+	}
+	summary.print(o)
+
+	return summary.err()
+}
+
+//
+// loadKeyMap reads the key map from the file
+//
+func loadKeyMap(path string) (*keyMap, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the key map: %s, error: %s", path, err)
+	}
+	mapping := new(keyMap)
+	if err := yaml.Unmarshal(content, mapping); err != nil {
+		return nil, fmt.Errorf("unable to parse the key map: %s, error: %s", path, err)
+	}
+	if len(mapping.Prefixes) <= 0 && mapping.Default == "" {
+		return nil, newExitError(exitUsage, "the key map: %s has no prefixes or default", path)
+	}
+	for prefix, kmsKey := range mapping.Prefixes {
+		if kmsKey == "" {
+			return nil, newExitError(exitUsage, "the prefix: %s in the key map: %s has no kms key", prefix, path)
+		}
+	}
+
+	return mapping, nil
+}
+
+// lookup returns the kms key of the longest prefix matching the key, else the default
+func (m *keyMap) lookup(key string) string {
+	var matched, kmsKey string
+	for prefix, x := range m.Prefixes {
+		if strings.HasPrefix(key, strings.TrimPrefix(prefix, "/")) && len(prefix) >= len(matched) {
+			matched, kmsKey = prefix, x
+		}
+	}
+
+	return defaultValue(kmsKey, m.Default)
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/urfave/cli"
+)
+
+const (
+	// the days in the month the estimates are normalized to
+	costMonthDays = 30
+	// the price of the tier one requests (put, copy, post and list) per thousand
+	priceTier1Requests = 0.005
+	// the price of the tier two requests (get, head and others) per thousand
+	priceTier2Requests = 0.0004
+	// the price of a customer managed kms key per month
+	priceKMSKey = 1.0
+	// the price of the kms requests per ten thousand
+	priceKMSRequests = 0.03
+)
+
+// the price of the storage per gigabyte month by storage class, the list prices of us-east-1
+var priceStorage = map[string]float64{
+	"STANDARD":            0.023,
+	"REDUCED_REDUNDANCY":  0.024,
+	"INTELLIGENT_TIERING": 0.023,
+	"STANDARD_IA":         0.0125,
+	"ONEZONE_IA":          0.01,
+	"GLACIER_IR":          0.004,
+	"GLACIER":             0.0036,
+	"DEEP_ARCHIVE":        0.00099,
+}
+
+//
+// newCostCommand creates a new cost command
+//
+func newCostCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "cost",
+		Usage: "estimate the monthly cost of the storage, requests and kms usage of the files in the bucket",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:   "k, kms",
+				Usage:  "the kms key the files are encrypted with, including the key and its requests in the estimate `KEY`",
+				EnvVar: "AWS_KMS_ID",
+			},
+			cli.StringFlag{
+				Name:  "since",
+				Usage: "the window the request rates are measured over, normalized to a month, at most 90d `DURATION`",
+				Value: "30d",
+			},
+			cli.IntFlag{
+				Name:  "max-events",
+				Usage: "the maximum number of cloudtrail events to search through for the kms requests, zero for no limit",
+				Value: 100000,
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s"}, cmd, estimateCost)
+		},
+	}
+}
+
+//
+// estimateCost estimates the monthly cost of the bucket from the sizes of the files, the request metrics of
+// the bucket in cloudwatch and the kms requests recorded by cloudtrail; the list prices of us-east-1 are
+// used, so the figures are only an indication
+//
+func estimateCost(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	since, err := parseDuration(cx.String("since"))
+	if err != nil || since <= 0 {
+		return newExitError(exitUsage, "invalid since: %s", cx.String("since"))
+	}
+	// note: the request counts over the window are scaled to a month
+	scale := float64(costMonthDays*24*time.Hour) / float64(since)
+
+	region, err := cmd.getBucketRegion(bucket)
+	if err != nil {
+		return err
+	}
+	var total float64
+	costLine := func(component string, quantity float64, unit string, cost float64) {
+		total += cost
+		o.fields(map[string]interface{}{
+			"action":    "cost",
+			"bucket":    bucket,
+			"component": component,
+			"quantity":  quantity,
+			"unit":      unit,
+			"monthly":   cost,
+		}).log("%-28s %16.0f %-14s $%10.4f\n", component, quantity, unit, cost)
+	}
+
+	// step: the storage by storage class, from the listing of the files
+	files, err := cmd.forRegion(region).listBucketKeys(bucket, "")
+	if err != nil {
+		return err
+	}
+	sizes := make(map[string]int64, 0)
+	for _, x := range files {
+		class := defaultValue(aws.StringValue(x.StorageClass), "STANDARD")
+		sizes[class] += aws.Int64Value(x.Size)
+	}
+	var classes []string
+	for x := range sizes {
+		classes = append(classes, x)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		price, found := priceStorage[class]
+		if !found {
+			price = priceStorage["STANDARD"]
+		}
+		gigabytes := float64(sizes[class]) / (1 << 30)
+		costLine("storage "+class, float64(sizes[class]), "bytes", gigabytes*price)
+	}
+
+	// step: the requests from the request metrics of the bucket
+	requests, err := cmd.bucketRequests(region, bucket, since)
+	if err != nil {
+		return err
+	}
+	if len(requests) <= 0 {
+		logger.warningf("no request metrics found for the bucket: %s, enable the EntireBucket request metrics to estimate the requests", bucket)
+	}
+	tier1 := (requests["PutRequests"] + requests["PostRequests"] + requests["ListRequests"]) * scale
+	tier2 := (requests["GetRequests"] + requests["HeadRequests"]) * scale
+	costLine("requests put/copy/post/list", tier1, "requests", tier1/1000*priceTier1Requests)
+	costLine("requests get/head", tier2, "requests", tier2/1000*priceTier2Requests)
+
+	// step: the kms key and the requests made with it
+	if name := cx.String("kms"); name != "" {
+		metadata, err := cmd.describeKey(name)
+		if err != nil {
+			return err
+		}
+		if aws.StringValue(metadata.KeyManager) == kms.KeyManagerTypeCustomer {
+			costLine("kms key", 1, "keys", priceKMSKey)
+		}
+		calls, err := cmd.keyCalls(aws.StringValue(metadata.Arn), time.Now().Add(-since), cx.Int("max-events"))
+		if err != nil {
+			return err
+		}
+		if maxEvents := cx.Int("max-events"); maxEvents > 0 && calls.scanned >= maxEvents {
+			logger.warningf("stopped after searching %d events, the kms requests are underestimated", calls.scanned)
+		}
+		kmsRequests := float64(calls.scanned) * scale
+		costLine("kms requests", kmsRequests, "requests", kmsRequests/10000*priceKMSRequests)
+	}
+
+	o.fields(map[string]interface{}{
+		"action":  "cost",
+		"bucket":  bucket,
+		"monthly": total,
+	}).log("%-28s %31s $%10.4f\n", "estimated monthly total", "", total)
+
+	return nil
+}
+
+//
+// bucketRequests retrieves the sum of the requests to the bucket by metric over the window, from the
+// request metrics of the entire bucket; no requests are returned if the metrics are not enabled
+//
+func (r *cliCommand) bucketRequests(region, bucket string, window time.Duration) (map[string]float64, error) {
+	requests := make(map[string]float64, 0)
+
+	end := time.Now()
+	for _, name := range []string{"GetRequests", "HeadRequests", "PutRequests", "PostRequests", "ListRequests"} {
+		metric := &bucketMetric{
+			name:       name,
+			statistic:  cloudwatch.StatisticSum,
+			dimensions: map[string]string{"FilterId": "EntireBucket"},
+		}
+		datapoints, err := r.bucketMetricValues(region, bucket, metric, end.Add(-window), end, 24*time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		for _, x := range datapoints {
+			requests[name] += x.value
+		}
+	}
+
+	return requests, nil
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// the name of the credentials provider when served from the cache
+const cachedProviderName = "CachedRoleProvider"
+
+//
+// cachedCredentials is the format of the credentials in the cache file
+//
+type cachedCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	SessionToken    string    `json:"SessionToken"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+//
+// cachedRoleProvider wraps the role credentials, caching them on disk until they expire so
+// successive invocations do not each have to assume the role
+//
+type cachedRoleProvider struct {
+	credentials.Expiry
+	// the credentials being cached
+	creds *credentials.Credentials
+	// the path to the cache file
+	path string
+}
+
+//
+// newCachedRoleCredentials wraps the credentials with a disk cache, keyed by the values which identity the role
+//
+func newCachedRoleCredentials(creds *credentials.Credentials, keys ...string) *credentials.Credentials {
+	hash := sha1.Sum([]byte(strings.Join(keys, "|")))
+
+	return credentials.NewCredentials(&cachedRoleProvider{
+		creds: creds,
+		path:  filepath.Join(credentialsCacheDir(), hex.EncodeToString(hash[:])+".json"),
+	})
+}
+
+//
+// Retrieve returns the cached credentials if still valid, else retrieves and caches them
+//
+func (r *cachedRoleProvider) Retrieve() (credentials.Value, error) {
+	// step: check the cache for valid credentials
+	if content, err := os.ReadFile(r.path); err == nil {
+		cached := new(cachedCredentials)
+		if err := json.Unmarshal(content, cached); err == nil && time.Now().Add(5*time.Minute).Before(cached.Expiration) {
+			r.SetExpiration(cached.Expiration, 5*time.Minute)
+
+			return credentials.Value{
+				AccessKeyID:     cached.AccessKeyID,
+				SecretAccessKey: cached.SecretAccessKey,
+				SessionToken:    cached.SessionToken,
+				ProviderName:    cachedProviderName,
+			}, nil
+		}
+	}
+
+	// step: retrieve the credentials and cache them
+	r.creds.Expire()
+	value, err := r.creds.Get()
+	if err != nil {
+		return value, err
+	}
+	expiration, err := r.creds.ExpiresAt()
+	if err != nil {
+		// note: the credentials do not expire, there is nothing to cache
+		return value, nil
+	}
+	r.SetExpiration(expiration, 5*time.Minute)
+
+	content, err := json.Marshal(&cachedCredentials{
+		AccessKeyID:     value.AccessKeyID,
+		SecretAccessKey: value.SecretAccessKey,
+		SessionToken:    value.SessionToken,
+		Expiration:      expiration,
+	})
+	if err == nil && os.MkdirAll(filepath.Dir(r.path), 0700) == nil {
+		os.WriteFile(r.path, content, 0600)
+	}
+
+	return value, nil
+}
+
+//
+// credentialsCacheDir returns the directory the credentials are cached in
+//
+func credentialsCacheDir() string {
+	return homePath(".kmsctl", "cache")
+}
+
+//
+// roleProfileKeys returns the values identifying the role of the profile, or nil if the profile does not assume a role
+//
+func roleProfileKeys(profile string, files ...string) []string {
+	for _, file := range files {
+		config, err := parseIniFile(file)
+		if err != nil {
+			continue
+		}
+		for _, name := range []string{"profile " + profile, profile} {
+			values, found := config[name]
+			if !found || values["role_arn"] == "" || values["web_identity_token_file"] != "" {
+				continue
+			}
+
+			return []string{
+				profile,
+				values["role_arn"],
+				values["role_session_name"],
+				values["source_profile"],
+				values["credential_source"],
+				values["external_id"],
+				values["mfa_serial"],
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// redactedHeaders is a list of headers whose values should never be printed
+var redactedHeaders = []string{
+	"Authorization",
+	"X-Amz-Security-Token",
+	"X-Amz-Server-Side-Encryption-Customer-Key",
+	"X-Amz-Server-Side-Encryption-Customer-Key-Md5",
+	"X-Amz-Copy-Source-Server-Side-Encryption-Customer-Key",
+	"X-Amz-Copy-Source-Server-Side-Encryption-Customer-Key-Md5",
+}
+
+//
+// enableDebug adds the request logging handlers to the session and prints the resolved settings, note
+// the clients copy the handlers on creation, so this must be called before they are created
+//
+func enableDebug(sess *session.Session) {
+	// step: print the resolved configuration
+	logger.debugf("region: %s", aws.StringValue(sess.Config.Region))
+
+	// step: the credentials are logged once the first request has resolved them, rather than resolving
+	// them here, which would prompt for sso or mfa and assume roles for commands never calling aws
+	var once sync.Once
+	sess.Handlers.Sign.PushBack(func(req *request.Request) {
+		once.Do(func() {
+			creds, err := req.Config.Credentials.Get()
+			if err != nil {
+				logger.debugf("credentials: unable to resolve, error: %s", err)
+				return
+			}
+			logger.redact(creds.SecretAccessKey, creds.SessionToken)
+			logger.debugf("credentials: provider: %s, access key: %s", creds.ProviderName, maskValue(creds.AccessKeyID))
+		})
+	})
+
+	// step: log the request once signed and the response once received
+	sess.Handlers.Send.PushFront(func(req *request.Request) {
+		logger.debugf("request: %s.%s %s %s\n%s", req.ClientInfo.ServiceName, req.Operation.Name,
+			req.HTTPRequest.Method, req.HTTPRequest.URL.String(), formatHeaders(req.HTTPRequest.Header))
+	})
+	sess.Handlers.Send.PushBack(func(req *request.Request) {
+		latency := time.Since(req.AttemptTime)
+		if req.HTTPResponse == nil || req.HTTPResponse.StatusCode == 0 {
+			logger.debugf("response: %s.%s failed after %s, error: %v", req.ClientInfo.ServiceName, req.Operation.Name, latency, req.Error)
+			return
+		}
+		logger.debugf("response: %s.%s status: %d, latency: %s, request-id: %s\n%s", req.ClientInfo.ServiceName, req.Operation.Name,
+			req.HTTPResponse.StatusCode, latency, req.RequestID, formatHeaders(req.HTTPResponse.Header))
+	})
+	sess.Handlers.Complete.PushBack(func(req *request.Request) {
+		if req.Error != nil {
+			logger.debugf("error: %s.%s retries: %d, error: %s", req.ClientInfo.ServiceName, req.Operation.Name, req.RetryCount, req.Error)
+		}
+	})
+}
+
+//
+// formatHeaders returns the sorted headers with any sensitive values redacted
+//
+func formatHeaders(headers http.Header) string {
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, k := range names {
+		value := strings.Join(headers[k], ", ")
+		for _, x := range redactedHeaders {
+			if strings.EqualFold(k, x) {
+				value = "[redacted]"
+			}
+		}
+		lines = append(lines, fmt.Sprintf("  %s: %s", k, value))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// maskValue hides all but the last four characters of the value
+func maskValue(value string) string {
+	if len(value) <= 4 {
+		return strings.Repeat("*", len(value))
+	}
+
+	return strings.Repeat("*", len(value)-4) + value[len(value)-4:]
+}
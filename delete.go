@@ -17,7 +17,7 @@ package main
 
 import (
 	"errors"
-	"fmt"
+	"strings"
 
 	"github.com/urfave/cli"
 )
@@ -36,6 +36,18 @@ func newDeleteCommand(cmd *cliCommand) cli.Command {
 				Usage:  "the name of the s3 bucket containing the encrypted files `NAME`",
 				EnvVar: "AWS_S3_BUCKET",
 			},
+			cli.BoolFlag{
+				Name:  "y, yes",
+				Usage: "do not prompt for confirmation before deleting",
+			},
+			cli.BoolFlag{
+				Name:   "trash",
+				Usage:  "move the files into the trash, from where they can be restored, rather than deleting them",
+				EnvVar: "KMSCTL_TRASH",
+			},
+			keysFromFlag,
+			interactiveFlag,
+			unlockFlag,
 		},
 		Action: func(cx *cli.Context) error {
 			return handleCommand(cx, []string{"l:bucket:s"}, cmd, deleteFile)
@@ -47,26 +59,76 @@ func newDeleteCommand(cmd *cliCommand) cli.Command {
 // deleteFile removes a file from the bucket
 //
 func deleteFile(o *formatter, cx *cli.Context, cmd *cliCommand) error {
-	if len(cx.Args()) <= 0 {
+	cmd.unlocked = cx.Bool("unlock")
+	paths, err := getKeys(cx)
+	if cx.Bool("interactive") {
+		paths, err = cmd.selectKeys(cx, cx.String("bucket"))
+	}
+	if err != nil {
+		return err
+	}
+	if len(paths) <= 0 {
 		return errors.New("you have not specified any files to delete")
 	}
+	// note: an empty path would remove the root of the bucket, or of the environment
+	for _, path := range paths {
+		if strings.Trim(strings.TrimSpace(path), "/") == "" {
+			return newExitError(exitUsage, "invalid path: %q, the files to delete cannot be empty", path)
+		}
+	}
 
 	bucket := cx.String("bucket")
 	// step: ensure the bucket exists
 	if found, err := cmd.hasBucket(bucket); err != nil {
 		return err
 	} else if !found {
-		return fmt.Errorf("the bucket: %s does not exist", bucket)
+		return newNotFoundError("the bucket: %s does not exist", bucket)
 	}
 
-	for _, path := range getPaths(cx) {
+	// step: confirm the deletion
+	action := "delete"
+	if cx.Bool("trash") {
+		action = "move to the trash"
+	}
+	if err := confirm(cx, "this will %s %d files from the bucket: %s\n  %s", action, len(paths), bucket,
+		strings.Join(paths, "\n  ")); err != nil {
+		return err
+	}
+
+	summary := newTransferSummary("deleted")
+	if cx.Bool("trash") {
+		summary = newTransferSummary("moved to the trash")
+	}
+	for _, path := range paths {
+		if cx.Bool("trash") {
+			trashed, err := cmd.trashFile(bucket, path)
+			if err != nil {
+				summary.fail(path, err)
+				o.fields(map[string]interface{}{
+					"action": "trash",
+					"bucket": bucket,
+					"path":   path,
+					"error":  err.Error(),
+				}).log("failed to move s3://%s/%s to the trash, error: %s\n", bucket, path, err)
+				continue
+			}
+			o.fields(map[string]interface{}{
+				"action": "trash",
+				"bucket": bucket,
+				"path":   path,
+				"trash":  trashed,
+			}).log("moved the file s3://%s/%s to the trash\n", bucket, path)
+			summary.success()
+			continue
+		}
 		if err := cmd.removeFile(bucket, path); err != nil {
+			summary.fail(path, err)
 			o.fields(map[string]interface{}{
 				"action": "delete",
 				"bucket": bucket,
 				"path":   path,
 				"error":  err.Error(),
-			}).log("failed to remove s3://%s/%s, error: %s", bucket, path, err)
+			}).log("failed to remove s3://%s/%s, error: %s\n", bucket, path, err)
 			continue
 		}
 		o.fields(map[string]interface{}{
@@ -74,7 +136,8 @@ func deleteFile(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 			"bucket": bucket,
 			"path":   path,
 		}).log("successfully deleted the file s3://%s/%s\n", bucket, path)
+		summary.success()
 	}
 
-	return nil
+	return summary.err()
 }
@@ -0,0 +1,331 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// the number of unchanged lines shown around the changes
+	diffContext = 3
+	// the maximum size of the comparison, lines of a multiplied by lines of b
+	diffMaxCells = 16 * 1024 * 1024
+)
+
+//
+// diffLine is a line of the edit script
+//
+type diffLine struct {
+	// the operation, ' ' unchanged, '-' removed or '+' added
+	op byte
+	// the content of the line
+	text string
+}
+
+//
+// unifiedDiff produces a unified diff of the content, empty if the content is the same
+//
+func unifiedDiff(a, b []byte, nameA, nameB string) (string, error) {
+	if bytes.Equal(a, b) {
+		return "", nil
+	}
+	linesA, linesB := splitLines(a), splitLines(b)
+	if len(linesA)*len(linesB) > diffMaxCells {
+		return "", fmt.Errorf("the files are too large to compare")
+	}
+	script := diffLines(linesA, linesB)
+
+	buffer := new(bytes.Buffer)
+	fmt.Fprintf(buffer, "--- %s\n+++ %s\n", nameA, nameB)
+
+	// step: group the changes into hunks, with the surrounding context
+	for i := 0; i < len(script); {
+		if script[i].op == ' ' {
+			i++
+			continue
+		}
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		// step: extend the hunk while the changes are within twice the context of each other
+		end := i
+		for j := i; j < len(script); j++ {
+			if script[j].op != ' ' {
+				end = j
+			} else if j-end > 2*diffContext {
+				break
+			}
+		}
+		end += diffContext + 1
+		if end > len(script) {
+			end = len(script)
+		}
+
+		// step: compute the line numbers of the hunk
+		startA, startB := 1, 1
+		for _, x := range script[:start] {
+			if x.op != '+' {
+				startA++
+			}
+			if x.op != '-' {
+				startB++
+			}
+		}
+		var countA, countB int
+		for _, x := range script[start:end] {
+			if x.op != '+' {
+				countA++
+			}
+			if x.op != '-' {
+				countB++
+			}
+		}
+		fmt.Fprintf(buffer, "@@ -%s +%s @@\n", hunkRange(startA, countA), hunkRange(startB, countB))
+		for _, x := range script[start:end] {
+			fmt.Fprintf(buffer, "%c%s\n", x.op, x.text)
+		}
+		i = end
+	}
+
+	return buffer.String(), nil
+}
+
+//
+// diffLines computes the edit script between the lines via the longest common subsequence
+//
+func diffLines(a, b []string) []diffLine {
+	// step: lcs[i][j] is the length of the common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var script []diffLine
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			script = append(script, diffLine{op: ' ', text: a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			script = append(script, diffLine{op: '-', text: a[i]})
+			i++
+		default:
+			script = append(script, diffLine{op: '+', text: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		script = append(script, diffLine{op: '-', text: a[i]})
+	}
+	for ; j < len(b); j++ {
+		script = append(script, diffLine{op: '+', text: b[j]})
+	}
+
+	return script
+}
+
+// splitLines splits the content into lines, ignoring the trailing newline
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+// hunkRange formats the start and length of a hunk
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// diffStats returns the number of lines added and removed between the content
+func diffStats(a, b []byte) (int, int, error) {
+	linesA, linesB := splitLines(a), splitLines(b)
+	if len(linesA)*len(linesB) > diffMaxCells {
+		return 0, 0, fmt.Errorf("the files are too large to compare")
+	}
+	var added, removed int
+	for _, x := range diffLines(linesA, linesB) {
+		switch x.op {
+		case '+':
+			added++
+		case '-':
+			removed++
+		}
+	}
+
+	return added, removed, nil
+}
+
+//
+// redactDiff masks the lines of the diff, keeping the names of any KEY=VALUE or key: value lines so
+// the changes can be reviewed without the secrets being shown
+//
+func redactDiff(diff string) string {
+	lines := strings.Split(diff, "\n")
+	for i, x := range lines {
+		// note: the first two lines are the names of the files
+		if i < 2 || x == "" || strings.HasPrefix(x, "@@ ") {
+			continue
+		}
+		lines[i] = x[:1] + redactLine(x[1:])
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// redactLine masks the value of the line, or the whole line if it has no name
+func redactLine(line string) string {
+	if strings.TrimSpace(line) == "" {
+		return line
+	}
+	index := strings.IndexAny(line, "=:")
+	switch {
+	case index <= 0:
+		return "[redacted]"
+	case line[index] == ':':
+		return line[:index+1] + " [redacted]"
+	}
+
+	return line[:index+1] + "[redacted]"
+}
+
+//
+// applyPatch applies the unified diff to the content, failing if the context or the lines removed by any
+// of the hunks do not match, i.e. the content has changed since the patch was produced
+//
+func applyPatch(content []byte, patch []byte) ([]byte, error) {
+	lines := splitLines(content)
+	trailing := len(content) == 0 || bytes.HasSuffix(content, []byte("\n"))
+
+	var result []string
+	var position, hunks int
+	var last byte
+	patchLines := splitLines(patch)
+	for i := 0; i < len(patchLines); i++ {
+		line := patchLines[i]
+		if !strings.HasPrefix(line, "@@ ") {
+			// note: the file headers and any lines outside of the hunks are ignored
+			continue
+		}
+		hunks++
+		start, count, err := parseHunkHeader(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hunk: %q, error: %s", line, err)
+		}
+		// note: a hunk removing no lines is inserted after the start line
+		if count > 0 {
+			start--
+		}
+		if start < position || start > len(lines) {
+			return nil, fmt.Errorf("the hunk: %q is out of order or beyond the end of the file", line)
+		}
+		result = append(result, lines[position:start]...)
+		position = start
+
+		// step: apply the lines of the hunk
+		for ; i+1 < len(patchLines); i++ {
+			text := patchLines[i+1]
+			if strings.HasPrefix(text, "@@ ") {
+				break
+			}
+			op := byte(' ')
+			if text != "" {
+				op, text = text[0], text[1:]
+			}
+			switch op {
+			case ' ', '-':
+				if position >= len(lines) || lines[position] != text {
+					return nil, fmt.Errorf("the patch does not apply, hunk %d expected %q at line %d", hunks, text, position+1)
+				}
+				if op == ' ' {
+					result = append(result, text)
+				}
+				position++
+			case '+':
+				result = append(result, text)
+			case '\\':
+				// note: the marker refers to the line before it, the new content ends without a newline
+				// unless the line was removed
+				trailing = last == '-'
+				continue
+			default:
+				return nil, fmt.Errorf("invalid line in hunk %d: %q", hunks, text)
+			}
+			last = op
+		}
+	}
+	if hunks <= 0 {
+		return nil, fmt.Errorf("the patch does not contain any hunks")
+	}
+	result = append(result, lines[position:]...)
+	if len(result) <= 0 {
+		return []byte{}, nil
+	}
+	patched := strings.Join(result, "\n")
+	if trailing {
+		patched += "\n"
+	}
+
+	return []byte(patched), nil
+}
+
+// parseHunkHeader returns the start and number of the original lines from the hunk header
+func parseHunkHeader(header string) (int, int, error) {
+	fields := strings.Fields(header)
+	if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") {
+		return 0, 0, fmt.Errorf("expected @@ -start,count +start,count @@")
+	}
+	values := strings.SplitN(strings.TrimPrefix(fields[1], "-"), ",", 2)
+	start, err := strconv.Atoi(values[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	count := 1
+	if len(values) > 1 {
+		if count, err = strconv.Atoi(values[1]); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return start, count, nil
+}
@@ -20,4 +20,21 @@ const (
 	version  = "v1.0.4"
 	author   = "Rohith"
 	email    = "gambol99@gmail.com"
+	// the github repository the releases are published to
+	repository = "gambol99/kmsctl"
+)
+
+// the build metadata, set by the linker i.e. -X main.gitCommit=abc123
+var (
+	// the git commit the binary was built from
+	gitCommit = "unknown"
+	// the date the binary was built
+	buildDate = "unknown"
+)
+
+const (
+	// the number of concurrent head requests made when inspecting objects
+	headConcurrency = 10
+	// the maximum number of keys which can be removed in a single request
+	deleteBatchSize = 1000
 )
@@ -0,0 +1,275 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/urfave/cli"
+)
+
+const (
+	// the result of a check which passed
+	checkPass = "pass"
+	// the result of a check which failed
+	checkFail = "fail"
+	// the result of a check which could not be performed
+	checkSkip = "skip"
+)
+
+//
+// newDoctorCommand creates a new doctor command
+//
+func newDoctorCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "doctor",
+		Usage: "diagnose the credentials, region, bucket and kms permissions, reporting the result of each check",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket to check the permissions against",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:   "k, kms",
+				Usage:  "the aws kms id to check the permissions against, defaults to the bucket default encryption",
+				EnvVar: "AWS_KMS_ID",
+			},
+			cli.BoolFlag{
+				Name:  "read-only",
+				Usage: "skip the checks which write and delete a probe file in the bucket",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{}, cmd, runDoctor)
+		},
+	}
+}
+
+//
+// doctorCheck is the outcome of a diagnostic check
+//
+type doctorCheck struct {
+	// the name of the check
+	name string
+	// the result, pass, fail or skip
+	result string
+	// the detail or error
+	detail string
+}
+
+//
+// runDoctor performs the checks, returning an error if any failed
+//
+func runDoctor(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	kmsKey := cx.String("kms")
+	readOnly := cx.Bool("read-only")
+
+	var checks []*doctorCheck
+	check := func(name string, fn func() (string, error)) bool {
+		detail, err := fn()
+		c := &doctorCheck{name: name, result: checkPass, detail: detail}
+		if err != nil {
+			c.result, c.detail = checkFail, strings.Join(strings.Fields(err.Error()), " ")
+		}
+		checks = append(checks, c)
+		return err == nil
+	}
+	skip := func(name, reason string) {
+		checks = append(checks, &doctorCheck{name: name, result: checkSkip, detail: reason})
+	}
+
+	// step: check the credentials and identity
+	credentials := check("credentials", func() (string, error) {
+		value, err := cmd.session.Config.Credentials.Get()
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("provider: %s, access key: %s", value.ProviderName, maskValue(value.AccessKeyID)), nil
+	})
+	check("region", func() (string, error) {
+		if cmd.region() == "" {
+			return "", fmt.Errorf("no region configured, use --region or AWS_DEFAULT_REGION")
+		}
+		return cmd.region(), nil
+	})
+	if credentials {
+		check("identity (sts:GetCallerIdentity)", func() (string, error) {
+			resp, err := sts.New(cmd.session).GetCallerIdentityWithContext(cmd.ctx, &sts.GetCallerIdentityInput{})
+			if err != nil {
+				return "", err
+			}
+			return aws.StringValue(resp.Arn), nil
+		})
+	} else {
+		skip("identity (sts:GetCallerIdentity)", "no credentials")
+	}
+
+	// step: check the bucket and the permissions to the files
+	bucketChecks := []string{"s3:HeadBucket", "s3:GetBucketLocation", "s3:GetEncryptionConfiguration", "s3:ListBucket",
+		"s3:PutObject", "s3:GetObject", "s3:DeleteObject"}
+	switch {
+	case bucket == "":
+		for _, x := range bucketChecks {
+			skip(x, "no bucket specified")
+		}
+	case !credentials:
+		for _, x := range bucketChecks {
+			skip(x, "no credentials")
+		}
+	default:
+		exists := check("s3:HeadBucket", func() (string, error) {
+			_, err := cmd.s3Client.HeadBucketWithContext(cmd.ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+			return bucket, err
+		})
+		check("s3:GetBucketLocation", func() (string, error) {
+			region, err := cmd.getBucketRegion(bucket)
+			if err != nil {
+				return "", err
+			}
+			if region != cmd.region() {
+				return fmt.Sprintf("%s (differs from the configured region: %s)", region, cmd.region()), nil
+			}
+			return region, nil
+		})
+		check("s3:GetEncryptionConfiguration", func() (string, error) {
+			rule, err := cmd.getBucketEncryption(bucket)
+			if err != nil {
+				return "", err
+			}
+			if rule == nil {
+				return "no default encryption", nil
+			}
+			defaults := rule.ApplyServerSideEncryptionByDefault
+			if kmsKey == "" && aws.StringValue(defaults.SSEAlgorithm) == s3.ServerSideEncryptionAwsKms {
+				kmsKey = aws.StringValue(defaults.KMSMasterKeyID)
+			}
+			return fmt.Sprintf("%s %s", aws.StringValue(defaults.SSEAlgorithm), aws.StringValue(defaults.KMSMasterKeyID)), nil
+		})
+		check("s3:ListBucket", func() (string, error) {
+			resp, err := cmd.s3Client.ListObjectsV2WithContext(cmd.ctx, &s3.ListObjectsV2Input{
+				Bucket:  aws.String(bucket),
+				MaxKeys: aws.Int64(1),
+			})
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d keys returned", aws.Int64Value(resp.KeyCount)), nil
+		})
+
+		// step: write, read and remove a probe file
+		probe := fmt.Sprintf(".kmsctl-doctor-%d", time.Now().UnixNano())
+		switch {
+		case readOnly:
+			skip("s3:PutObject", "read only")
+			skip("s3:GetObject", "read only")
+			skip("s3:DeleteObject", "read only")
+		case !exists:
+			skip("s3:PutObject", "the bucket is not accessible")
+			skip("s3:GetObject", "the bucket is not accessible")
+			skip("s3:DeleteObject", "the bucket is not accessible")
+		default:
+			written := check("s3:PutObject", func() (string, error) {
+				return probe, cmd.putContent(bucket, probe, []byte("1"), kmsKey, nil)
+			})
+			if written {
+				check("s3:GetObject", func() (string, error) {
+					content, err := cmd.getFile(bucket, probe)
+					if err == nil && string(content) != "1" {
+						err = fmt.Errorf("the content of the probe file does not match")
+					}
+					return probe, err
+				})
+				check("s3:DeleteObject", func() (string, error) {
+					return probe, cmd.removeFile(bucket, probe)
+				})
+			} else {
+				skip("s3:GetObject", "the probe file could not be written")
+				skip("s3:DeleteObject", "the probe file could not be written")
+			}
+		}
+	}
+
+	// step: check the permissions on the kms key
+	kmsChecks := []string{"kms:DescribeKey", "kms:Encrypt", "kms:Decrypt"}
+	switch {
+	case kmsKey == "":
+		for _, x := range kmsChecks {
+			skip(x, "no kms key specified")
+		}
+	case !credentials:
+		for _, x := range kmsChecks {
+			skip(x, "no credentials")
+		}
+	default:
+		check("kms:DescribeKey", func() (string, error) {
+			metadata, err := cmd.describeKey(kmsKey)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s (%s)", aws.StringValue(metadata.Arn), aws.StringValue(metadata.KeyState)), nil
+		})
+		var ciphertext []byte
+		encrypted := check("kms:Encrypt", func() (string, error) {
+			resp, err := cmd.kmsClient.EncryptWithContext(cmd.ctx, &kms.EncryptInput{
+				KeyId:     aws.String(kmsKeyID(kmsKey)),
+				Plaintext: []byte("1"),
+			})
+			if err != nil {
+				return "", err
+			}
+			ciphertext = resp.CiphertextBlob
+			return kmsKey, nil
+		})
+		if encrypted {
+			check("kms:Decrypt", func() (string, error) {
+				resp, err := cmd.kmsClient.DecryptWithContext(cmd.ctx, &kms.DecryptInput{CiphertextBlob: ciphertext})
+				if err == nil && !bytes.Equal(resp.Plaintext, []byte("1")) {
+					err = fmt.Errorf("the decrypted content does not match")
+				}
+				return kmsKey, err
+			})
+		} else {
+			skip("kms:Decrypt", "nothing was encrypted")
+		}
+	}
+
+	// step: print the matrix
+	var failed int
+	for _, x := range checks {
+		if x.result == checkFail {
+			failed++
+		}
+		o.fields(map[string]interface{}{
+			"check":  x.name,
+			"result": x.result,
+			"detail": x.detail,
+		}).log("%-34s %-5s %s\n", x.name, x.result, x.detail)
+	}
+	if failed > 0 {
+		return newExitError(exitFailure, "%d of %d checks failed", failed, len(checks))
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// dotenvLineRegex matches a KEY=VALUE line of a dotenv file
+	dotenvLineRegex = regexp.MustCompile(`^(?:export\s+)?([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(.*)$`)
+	// dotenvInvalidRegex matches the characters not permitted in an environment variable name
+	dotenvInvalidRegex = regexp.MustCompile(`[^A-Z0-9_]`)
+)
+
+//
+// dotenvFile is a collection of environment variables merged from multiple files
+//
+type dotenvFile struct {
+	// the names of the variables in the order they were added
+	names []string
+	// the values of the variables
+	values map[string]string
+	// the file which each variable came from
+	sources map[string]string
+}
+
+//
+// newDotenvFile creates an empty dotenv file
+//
+func newDotenvFile() *dotenvFile {
+	return &dotenvFile{
+		values:  make(map[string]string, 0),
+		sources: make(map[string]string, 0),
+	}
+}
+
+//
+// merge adds the content of the file, either KEY=VALUE lines or a single value named after the file,
+// replacing any variables previously merged from the same file
+//
+func (r *dotenvFile) merge(source string, content []byte) error {
+	names, values, ok := parseDotenv(content)
+	if !ok {
+		name := dotenvName(source)
+		names = []string{name}
+		values = map[string]string{name: strings.TrimRight(string(content), "\r\n")}
+	}
+
+	// step: check for conflicts with the other files
+	for _, name := range names {
+		if from, found := r.sources[name]; found && from != source && r.values[name] != values[name] {
+			return fmt.Errorf("the variable: %s in: %s conflicts with the value from: %s", name, source, from)
+		}
+	}
+
+	// step: remove any variables from a previous version of the file
+	var list []string
+	for _, name := range r.names {
+		if r.sources[name] == source {
+			delete(r.values, name)
+			delete(r.sources, name)
+			continue
+		}
+		list = append(list, name)
+	}
+	r.names = list
+
+	for _, name := range names {
+		if _, found := r.sources[name]; !found {
+			r.names = append(r.names, name)
+		}
+		r.values[name] = values[name]
+		r.sources[name] = source
+	}
+
+	return nil
+}
+
+//
+// write saves the variables to the path, replacing the file atomically
+//
+func (r *dotenvFile) write(path string, mode os.FileMode) error {
+	buffer := new(bytes.Buffer)
+	for _, name := range r.names {
+		fmt.Fprintf(buffer, "%s=%s\n", name, dotenvQuote(r.values[name]))
+	}
+
+	return writeFileAtomic(path, buffer.Bytes(), mode)
+}
+
+//
+// parseDotenv parses the content as KEY=VALUE lines, returning false if any line is not in the format
+//
+func parseDotenv(content []byte) ([]string, map[string]string, bool) {
+	var names []string
+	values := make(map[string]string, 0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		matches := dotenvLineRegex.FindStringSubmatch(line)
+		if matches == nil {
+			return nil, nil, false
+		}
+		if _, found := values[matches[1]]; !found {
+			names = append(names, matches[1])
+		}
+		values[matches[1]] = dotenvUnquote(matches[2])
+	}
+	if scanner.Err() != nil || len(names) <= 0 {
+		return nil, nil, false
+	}
+
+	return names, values, true
+}
+
+// dotenvName returns the variable name for a single value file, derived from the basename
+func dotenvName(key string) string {
+	name := dotenvInvalidRegex.ReplaceAllString(strings.ToUpper(filepath.Base(key)), "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+
+	return name
+}
+
+// dotenvUnquote removes the quotes from a value, the escapes of a double quoted value being expanded
+func dotenvUnquote(value string) string {
+	switch {
+	case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+		return dotenvUnescape(value[1 : len(value)-1])
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		return value[1 : len(value)-1]
+	}
+
+	return value
+}
+
+//
+// dotenvQuote quotes the value if it contains any whitespace, quotes or special characters; single quotes
+// are used so the shell and docker do not expand the value, falling back to double quotes with $, `, \
+// and " escaped when the value contains a single quote or newline
+//
+func dotenvQuote(value string) string {
+	switch {
+	case !strings.ContainsAny(value, " \t\r\n\"'#$\\`"):
+		return value
+	case !strings.ContainsAny(value, "'\r\n"):
+		return "'" + value + "'"
+	}
+
+	return `"` + dotenvEscaper.Replace(value) + `"`
+}
+
+// dotenvEscaper escapes the characters the shell expands within double quotes, and the newlines
+var dotenvEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`", "\n", `\n`, "\r", `\r`)
+
+// dotenvUnescape reverses the escapes of a double quoted value
+func dotenvUnescape(value string) string {
+	var unescaped strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i == len(value)-1 {
+			unescaped.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n':
+			unescaped.WriteByte('\n')
+		case 'r':
+			unescaped.WriteByte('\r')
+		case 't':
+			unescaped.WriteByte('\t')
+		default:
+			unescaped.WriteByte(value[i])
+		}
+	}
+
+	return unescaped.String()
+}
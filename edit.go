@@ -16,11 +16,15 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/urfave/cli"
 )
 
@@ -36,10 +40,46 @@ func newEditCommand(cmd *cliCommand) cli.Command {
 			},
 			cli.StringFlag{
 				Name:   "e, editor",
-				Usage:  "the editor to open the file with for editing",
-				Value:  "vim",
-				EnvVar: "EDITOR",
+				Usage:  "the editor to open the file with, including any arguments, i.e. \"code --wait\" `COMMAND`",
+				Value:  defaultEditor(),
+				EnvVar: "VISUAL,EDITOR",
 			},
+			cli.BoolFlag{
+				Name:  "c, create",
+				Usage: "create the files which do not exist, opening an empty file or the template",
+			},
+			cli.StringFlag{
+				Name:  "template-file",
+				Usage: "the file the content of the created files starts from `PATH`",
+			},
+			cli.BoolFlag{
+				Name:  "stdin",
+				Usage: "replace the content of the file with the stdin rather than opening the editor",
+			},
+			cli.StringFlag{
+				Name:  "patch",
+				Usage: "apply the unified diff to the file rather than opening the editor, - for stdin `PATH`",
+			},
+			cli.StringFlag{
+				Name:   "tmpdir",
+				Usage:  "the directory the decrypted files are written to for editing, i.e. a tmpfs such as /dev/shm `DIR`",
+				EnvVar: "KMSCTL_TMPDIR",
+			},
+			cli.BoolFlag{
+				Name:  "redact",
+				Usage: "mask the values in the diff of the changes shown before uploading them",
+			},
+			cli.BoolFlag{
+				Name:  "y, yes",
+				Usage: "do not prompt for confirmation before uploading the changes",
+			},
+			cli.StringFlag{
+				Name:   "k, kms",
+				Usage:  "the aws kms id to encrypt the created files with, defaults to the bucket default encryption",
+				EnvVar: "AWS_KMS_ID",
+			},
+			interactiveFlag,
+			unlockFlag,
 		},
 		Action: func(cx *cli.Context) error {
 			return handleCommand(cx, []string{"l:bucket:s"}, cmd, editFile)
@@ -47,76 +87,302 @@ func newEditCommand(cmd *cliCommand) cli.Command {
 	}
 }
 
+// editFunc returns the edited content of the file
+type editFunc func(key string, content []byte) ([]byte, error)
+
 //
-// editFile permits an inline edit of the file
+// editFile permits an inline edit of the file, or the non-interactive replacement or patching of it
 //
 func editFile(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 	bucket := cx.String("bucket")
-	editor := cx.String("editor")
+	cmd.unlocked = cx.Bool("unlock")
+	create := cx.Bool("create")
+
+	if cx.String("template-file") != "" && !create {
+		return newExitError(exitUsage, "invalid option, --template-file requires --create")
+	}
+	edit, err := newEditFunc(cx)
+	if err != nil {
+		return err
+	}
+	keys := []string(cx.Args())
+	if cx.Bool("interactive") {
+		if keys, err = cmd.selectKeys(cx, bucket); err != nil {
+			return err
+		}
+	}
 
-	for _, key := range cx.Args() {
+	for _, key := range keys {
 		// step: retrieve the head metadata
 		metadata, err := cmd.getFileMetadata(key, bucket)
+		if err != nil {
+			if exitCode(err) != exitNotFound {
+				return err
+			}
+			if !create {
+				return newNotFoundError("the file: %s does not exist, use --create to create it", key)
+			}
+			if err := createFile(o, cx, cmd, bucket, key, edit); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// step: retrieve the tags, the upload would otherwise remove them
+		tags, err := cmd.getObjectTags(bucket, key)
 		if err != nil {
 			return err
 		}
 
+		// note: the compression of the file is kept
+		compression, _ := metadataValue(metadata.Metadata, compressionMetadata)
+
 		// step: attempt to retrieve the data
 		content, err := cmd.getFile(bucket, key)
 		if err != nil {
 			return fmt.Errorf("unable to retrieve keythe file: %s, error: %s", key, err)
 		}
 
-		// step: write the file to the
-		path, err := inlineEdit(content, editor)
+		// step: edit the content of the file
+		edited, err := edit(key, content)
 		if err != nil {
 			return fmt.Errorf("unable to edit the file: %s, error: %s", key, err)
 		}
 
-		// step: upload the content to bucket
-		if err := cmd.putFile(bucket, key, path, *metadata.SSEKMSKeyId); err != nil {
-			os.Remove(path)
+		// step: review the changes before uploading them
+		added, removed, changed, err := reviewEdit(o, cx, fmt.Sprintf("s3://%s/%s", bucket, key), content, edited)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			continue
+		}
+
+		// step: upload the content to bucket, provided the file has not changed since it was retrieved
+		if err := cmd.putContent(bucket, key, edited, *metadata.SSEKMSKeyId, &uploadOptions{
+			tags:     tags,
+			compress: compression,
+			ifMatch:  aws.StringValue(metadata.ETag),
+		}); err != nil {
+			if exitCode(err) == exitFailure {
+				return fmt.Errorf("unable to upload the file: %s, it may have been changed since it was retrieved, error: %s", key, err)
+			}
 			return err
 		}
 
 		// step: add the log
 		o.fields(map[string]interface{}{
-			"action": "put",
-			"key":    key,
-			"bucket": bucket,
-		}).log("successfully edited and uploaded file: s3://%s/%s\n", bucket, key)
+			"action":  "put",
+			"key":     key,
+			"bucket":  bucket,
+			"added":   added,
+			"removed": removed,
+		}).log("successfully edited and uploaded file: s3://%s/%s (+%d -%d)\n", bucket, key, added, removed)
+	}
+
+	return nil
+}
+
+//
+// newEditFunc returns the edit of the files, replacing the content with the stdin, applying the patch or
+// opening the content with the editor
+//
+func newEditFunc(cx *cli.Context) (editFunc, error) {
+	patch := cx.String("patch")
+	switch {
+	case cx.Bool("stdin") && patch != "":
+		return nil, newExitError(exitUsage, "invalid option, --stdin and --patch are mutually exclusive")
+	case (cx.Bool("stdin") || patch != "") && cx.Bool("interactive"):
+		return nil, newExitError(exitUsage, "invalid option, --interactive only applies when opening the editor")
+	case (cx.Bool("stdin") || patch != "") && cx.String("template-file") != "":
+		return nil, newExitError(exitUsage, "invalid option, --template-file only applies when opening the editor")
+	case (cx.Bool("stdin") || patch != "") && len(cx.Args()) != 1:
+		return nil, newExitError(exitUsage, "invalid option, --stdin and --patch edit a single file")
+	}
+
+	switch {
+	case cx.Bool("stdin"):
+		content, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the stdin, error: %s", err)
+		}
+		return func(string, []byte) ([]byte, error) {
+			return content, nil
+		}, nil
+	case patch != "":
+		diff, err := readFileOrStdin(patch)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the patch: %s, error: %s", patch, err)
+		}
+		return func(_ string, content []byte) ([]byte, error) {
+			return applyPatch(content, diff)
+		}, nil
+	}
+	editor, tmpdir := cx.String("editor"), cx.String("tmpdir")
+
+	return func(key string, content []byte) ([]byte, error) {
+		path, err := inlineEdit(content, editor, tmpdir, key)
+		if err != nil {
+			return nil, err
+		}
+		defer removeEdit(path)
+
+		return ioutil.ReadFile(path)
+	}, nil
+}
 
-		os.Remove(path)
+// defaultEditor returns the editor used when none is specified, notepad on windows, else vim
+func defaultEditor() string {
+	if runtime.GOOS == "windows" {
+		return "notepad"
 	}
 
+	return "vim"
+}
+
+//
+// createFile edits an empty file, or the template, and uploads the result as a new file
+//
+func createFile(o *formatter, cx *cli.Context, cmd *cliCommand, bucket, key string, edit editFunc) error {
+	kms := cx.String("kms")
+	if kms == "" {
+		if err := cmd.hasDefaultKmsEncryption(bucket); err != nil {
+			return err
+		}
+	}
+	var content []byte
+	if template := cx.String("template-file"); template != "" {
+		var err error
+		if content, err = ioutil.ReadFile(template); err != nil {
+			return fmt.Errorf("unable to read the template file: %s, error: %s", template, err)
+		}
+	}
+
+	edited, err := edit(key, content)
+	if err != nil {
+		return fmt.Errorf("unable to edit the file: %s, error: %s", key, err)
+	}
+
+	// step: an empty file is taken as the creation being abandoned
+	if len(edited) <= 0 {
+		o.log("the file: %s is empty, it has not been created\n", key)
+		return nil
+	}
+	added, _, _, err := reviewEdit(o, cx, fmt.Sprintf("s3://%s/%s", bucket, key), nil, edited)
+	if err != nil {
+		return err
+	}
+
+	// note: the file must still not exist, so a file created in the meantime is not overwritten
+	if err := cmd.putContent(bucket, key, edited, kms, &uploadOptions{ifNotExists: true}); err != nil {
+		return err
+	}
+	o.fields(map[string]interface{}{
+		"action": "create",
+		"key":    key,
+		"bucket": bucket,
+		"added":  added,
+	}).log("successfully created and uploaded file: s3://%s/%s (+%d)\n", bucket, key, added)
+
 	return nil
 }
 
 //
-// inlineEdit performs an inline edit of the file
+// reviewEdit displays the changes made to the file, masked if requested, and asks for confirmation before
+// they are uploaded; it returns the lines added and removed and whether the file was changed
 //
-func inlineEdit(content []byte, editor string) (string, error) {
-	// step: create a temporary file and write the data
-	tmp, err := ioutil.TempFile("/tmp", "edit.XXXXXXXX")
+func reviewEdit(o *formatter, cx *cli.Context, name string, before, after []byte) (int, int, bool, error) {
+	if bytes.Equal(before, after) {
+		o.log("the file: %s is unchanged, nothing to upload\n", name)
+		return 0, 0, false, nil
+	}
+	added, removed, _ := diffStats(before, after)
+
+	diff, err := unifiedDiff(before, after, name, name+" (edited)")
+	if err != nil {
+		diff = fmt.Sprintf("the content of %s differs, %s\n", name, err)
+	}
+	// note: the diff is only recorded in the structured output once masked
+	fields := map[string]interface{}{
+		"action":  "review",
+		"key":     name,
+		"added":   added,
+		"removed": removed,
+	}
+	if cx.Bool("redact") {
+		diff = redactDiff(diff)
+		fields["diff"] = diff
+	}
+	o.fields(fields).log("%s", diff)
+
+	if err := confirm(cx, "upload the changes to %s (+%d -%d)", name, added, removed); err != nil {
+		return 0, 0, false, err
+	}
+
+	return added, removed, true, nil
+}
+
+//
+// editorArgs splits the editor into the command and arguments; an editor naming an existing file is used
+// as is, so the paths containing spaces, i.e. on windows, do not need quoting
+//
+func editorArgs(editor string) ([]string, error) {
+	if found, err := isFile(editor); err == nil && found {
+		return []string{editor}, nil
+	}
+	args, err := splitArgs(editor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid editor: %s, error: %s", editor, err)
+	}
+	if len(args) <= 0 {
+		return nil, newExitError(exitUsage, "no editor specified")
+	}
+
+	return args, nil
+}
+
+//
+// inlineEdit performs an inline edit of the content, the file being written with the name of the key
+// into a private directory, which is shredded on removal or on exit
+//
+func inlineEdit(content []byte, editor, tmpdir, key string) (string, error) {
+	// step: create a private directory for the file and any files created by the editor
+	directory, err := privateTempDir(tmpdir)
 	if err != nil {
 		return "", err
 	}
-	// step: write out the content of the file
-	if _, err := tmp.Write(content); err != nil {
+	// note: the name of the key is kept, permitting the editor to detect the format
+	path := filepath.Join(directory, filepath.Base(filepath.FromSlash(key)))
+
+	// step: write out the content of the file, readable only by the user
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		cleanup.remove(directory)
 		return "", err
 	}
-	tmp.Close()
 
 	// step: open the secret with the editor
-	cmd := exec.Command(editor, tmp.Name())
+	args, err := editorArgs(editor)
+	if err != nil {
+		cleanup.remove(directory)
+		return "", err
+	}
+	cmd := exec.Command(args[0], append(args[1:], path)...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
 
-	// step: execute the editor
+	// step: execute the editor, which handles the signals from the terminal itself
+	restore := interrupts.ignore()
+	defer restore()
 	if err := cmd.Run(); err != nil {
+		cleanup.remove(directory)
 		return "", err
 	}
 
-	return tmp.Name(), nil
+	return path, nil
+}
+
+// removeEdit shreds the file edited and the private directory containing it
+func removeEdit(path string) error {
+	return cleanup.remove(filepath.Dir(path))
 }
@@ -0,0 +1,194 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v2"
+)
+
+//
+// config is the kmsctl configuration file
+//
+type config struct {
+	// the environments keyed by name
+	Environments map[string]*environment `yaml:"environments"`
+	// the hooks run around the transfers of the files
+	Hooks []*transferHook `yaml:"hooks"`
+	// the shortcuts expanded to the commands, i.e. prodget: get --bucket prod-secrets
+	Shortcuts map[string]string `yaml:"shortcuts"`
+}
+
+//
+// environment is a namespace of secrets, i.e. a bucket, prefix and kms key
+//
+type environment struct {
+	// the name of the environment
+	Name string `yaml:"-"`
+	// the bucket containing the secrets
+	Bucket string `yaml:"bucket"`
+	// the prefix of the secrets within the bucket
+	Prefix string `yaml:"prefix"`
+	// the kms key used to encrypt the secrets
+	KMS string `yaml:"kms"`
+	// the region of the bucket and kms key
+	Region string `yaml:"region"`
+	// the aws profile used to access the environment
+	Profile string `yaml:"profile"`
+}
+
+//
+// loadConfig reads the configuration file
+//
+func loadConfig(path string) (*config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the configuration file: %s, error: %s", path, err)
+	}
+	c := new(config)
+	if err := yaml.Unmarshal(content, c); err != nil {
+		return nil, fmt.Errorf("unable to parse the configuration file: %s, error: %s", path, err)
+	}
+	for name, x := range c.Environments {
+		if x == nil {
+			return nil, fmt.Errorf("the environment: %s has no configuration", name)
+		}
+		x.Name = name
+		if x.Bucket == "" {
+			return nil, fmt.Errorf("the environment: %s does not specify a bucket", name)
+		}
+		// note: the prefix is a directory within the bucket
+		if x.Prefix = strings.Trim(x.Prefix, "/"); x.Prefix != "" {
+			x.Prefix += "/"
+		}
+	}
+	for i, x := range c.Hooks {
+		if x == nil {
+			return nil, fmt.Errorf("the hook: %d in the configuration file has no configuration", i)
+		}
+		x.Name = defaultValue(x.Name, fmt.Sprintf("hooks[%d]", i))
+		if err := x.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+//
+// getEnvironment retrieves the environment from the configuration file
+//
+func getEnvironment(cx *cli.Context, name string) (*environment, error) {
+	c, err := loadConfig(cx.GlobalString("config"))
+	if err != nil {
+		return nil, err
+	}
+	env, found := c.Environments[name]
+	if !found {
+		var names []string
+		for k := range c.Environments {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+
+		return nil, newExitError(exitUsage, "the environment: %s does not exist, the environments are: %s", name, strings.Join(names, ", "))
+	}
+
+	return env, nil
+}
+
+//
+// useEnvironment applies the environment, the bucket and kms key become the defaults of the commands
+// and all the keys are relative to the prefix
+//
+func (r *cliCommand) useEnvironment(cx *cli.Context, name string) error {
+	env, err := getEnvironment(cx, name)
+	if err != nil {
+		return err
+	}
+	r.prefix = env.Prefix
+
+	// note: the command options are parsed after this, so the environment variables act as their defaults
+	os.Setenv("AWS_S3_BUCKET", env.Bucket)
+	if env.KMS != "" {
+		os.Setenv("AWS_KMS_ID", env.KMS)
+	}
+	if env.Region != "" {
+		if err := cx.GlobalSet("region", env.Region); err != nil {
+			return err
+		}
+	}
+	if env.Profile != "" {
+		if err := cx.GlobalSet("profile", env.Profile); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//
+// forEnvironment returns a copy of the command with the clients for the environment
+//
+func (r *cliCommand) forEnvironment(cx *cli.Context, env *environment) (*cliCommand, error) {
+	sess := r.session
+	switch {
+	case env.Profile != "" && env.Profile != cx.GlobalString("profile"):
+		var err error
+		sess, err = newProfileSession(&aws.Config{
+			Region:          aws.String(defaultValue(env.Region, r.region())),
+			HTTPClient:      r.session.Config.HTTPClient,
+			S3UseAccelerate: r.session.Config.S3UseAccelerate,
+			UseDualStack:    r.session.Config.UseDualStack,
+			S3UseARNRegion:  r.session.Config.S3UseARNRegion,
+		}, env.Profile, cx.GlobalString("credentials"))
+		if err != nil {
+			return nil, fmt.Errorf("unable to create the session for the environment: %s, error: %s", env.Name, err)
+		}
+		// note: a sso profile logs in when the credentials of the environment are first retrieved
+		sess = sess.Copy(&aws.Config{
+			Credentials: newSSOLoginCredentials(sess.Config.Credentials, func() error {
+				return r.ensureSSOLogin(env.Profile, sharedConfigFile(), r.session.Config.HTTPClient)
+			}),
+		})
+		if cx.GlobalBool("debug") {
+			enableDebug(sess)
+		}
+		instrumentSession(sess)
+	case env.Region != "" && env.Region != r.region():
+		sess = r.sessionForRegion(env.Region)
+	}
+
+	return &cliCommand{
+		prefix:    env.Prefix,
+		ctx:       r.ctx,
+		cancel:    r.cancel,
+		session:   sess,
+		s3Client:  s3.New(sess),
+		kmsClient: kms.New(sess),
+		uploader:  s3manager.NewUploader(sess),
+	}, nil
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+const (
+	// a generic failure
+	exitFailure = 1
+	// the command was used incorrectly, i.e. missing options
+	exitUsage = 2
+	// the bucket, file or key does not exist
+	exitNotFound = 3
+	// access to the resource was denied
+	exitAccessDenied = 4
+	// some of the items in a bulk operation failed
+	exitPartialFailure = 5
+	// the operation was interrupted by a signal
+	exitInterrupted = 130
+)
+
+// errUnchanged indicates the upload was skipped as the file is unchanged
+var errUnchanged = errors.New("the file is unchanged")
+
+// the aws error codes which indicate the resource does not exist
+var notFoundCodes = []string{
+	"NoSuchBucket",
+	"NoSuchKey",
+	"NoSuchVersion",
+	"NotFound",
+	"NotFoundException",
+	"ResourceNotFoundException",
+}
+
+// the aws error codes which indicate access has been denied
+var accessDeniedCodes = []string{
+	"AccessDenied",
+	"AccessDeniedException",
+	"Forbidden",
+	"InvalidAccessKeyId",
+	"SignatureDoesNotMatch",
+	"ExpiredToken",
+	"UnrecognizedClientException",
+}
+
+//
+// exitError is an error carrying the exit code the program should return
+//
+type exitError struct {
+	// the exit code
+	code int
+	// the underlying error
+	err error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+//
+// newExitError creates an error with an exit code
+//
+func newExitError(code int, message string, args ...interface{}) error {
+	return &exitError{code: code, err: fmt.Errorf(message, args...)}
+}
+
+//
+// newNotFoundError creates an error indicating the resource does not exist
+//
+func newNotFoundError(message string, args ...interface{}) error {
+	return newExitError(exitNotFound, message, args...)
+}
+
+//
+// exitCode determines the exit code for the error
+//
+func exitCode(err error) int {
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	if err == errKmsNotFound {
+		return exitNotFound
+	}
+	if errors.Is(err, context.Canceled) {
+		return exitInterrupted
+	}
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		if aerr.Code() == request.CanceledErrorCode {
+			return exitInterrupted
+		}
+		if isValidOption(aerr.Code(), notFoundCodes) {
+			return exitNotFound
+		}
+		if isValidOption(aerr.Code(), accessDeniedCodes) {
+			return exitAccessDenied
+		}
+	}
+
+	return exitFailure
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// the tag recording when the file expires
+const expiresTag = "kmsctl:expires"
+
+//
+// newExpiringCommand creates a new expiring command
+//
+func newExpiringCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:      "expiring",
+		Usage:     "list the files which have expired or expire soon, exiting non-zero if there are any",
+		ArgsUsage: "[PATH...]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:  "w, within",
+				Usage: "include the files which expire within this duration, i.e. 30d `DURATION`",
+				Value: "30d",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s"}, cmd, listExpiring)
+		},
+	}
+}
+
+//
+// expiringFile is a file which has expired or is about to
+//
+type expiringFile struct {
+	// the key of the file
+	key string
+	// when the file expires
+	expires time.Time
+	// the state of the file, expired, expiring or invalid
+	state string
+}
+
+//
+// listExpiring lists the files past or nearing their expiry
+//
+func listExpiring(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	within, err := parseDuration(cx.String("within"))
+	if err != nil {
+		return newExitError(exitUsage, "invalid within: %s, error: %s", cx.String("within"), err)
+	}
+	now := time.Now()
+
+	var list []*expiringFile
+	for _, p := range getPaths(cx) {
+		files, err := cmd.listBucketKeys(bucket, p)
+		if err != nil {
+			return err
+		}
+		var keys []string
+		for _, x := range files {
+			keys = append(keys, *x.Key)
+		}
+		tags, err := cmd.objectTags(bucket, keys)
+		if err != nil {
+			return err
+		}
+
+		// step: check the expiry of the files
+		for _, key := range keys {
+			value, found := tags[key][expiresTag]
+			if !found {
+				continue
+			}
+			expires, err := time.Parse(time.RFC3339, value)
+			switch {
+			case err != nil:
+				list = append(list, &expiringFile{key: key, state: "invalid"})
+			case expires.Before(now):
+				list = append(list, &expiringFile{key: key, expires: expires, state: "expired"})
+			case expires.Before(now.Add(within)):
+				list = append(list, &expiringFile{key: key, expires: expires, state: "expiring"})
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].expires.Before(list[j].expires) })
+
+	for _, x := range list {
+		var expires string
+		if !x.expires.IsZero() {
+			expires = x.expires.Format(time.RFC3339)
+		}
+		o.fields(map[string]interface{}{
+			"bucket":  bucket,
+			"key":     x.key,
+			"expires": expires,
+			"state":   x.state,
+		}).log("%-8s %-25s %s\n", x.state, defaultValue(expires, "-"), x.key)
+	}
+	if len(list) > 0 {
+		return newExitError(exitFailure, "%d files have expired or expire within %s", len(list), cx.String("within"))
+	}
+
+	return nil
+}
+
+//
+// parseExpiry parses the expiry as either a duration from now, i.e. 90d, or a date
+//
+func parseExpiry(value string, now time.Time) (time.Time, error) {
+	if duration, err := parseDuration(value); err == nil {
+		if duration <= 0 {
+			return time.Time{}, fmt.Errorf("the duration must be positive")
+		}
+		return now.Add(duration), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if expires, err := time.Parse(layout, value); err == nil {
+			return expires, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("expected a duration (i.e. 90d) or a date (i.e. 2006-01-02)")
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+//
+// newCheckExposureCommand creates a new check-exposure command
+//
+func newCheckExposureCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "check-exposure",
+		Usage: "report whether the bucket is exposed publicly by its policy, acl or public access block, exiting non-zero if so",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket to check `NAME`",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s"}, cmd, checkExposure)
+		},
+	}
+}
+
+//
+// checkExposure checks the public access block, acl and policy of the bucket, returning an error if the
+// bucket is exposed or a check could not be performed
+//
+func checkExposure(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+
+	// step: the checks are performed against the region of the bucket
+	region, err := cmd.getBucketRegion(bucket)
+	if err != nil {
+		return err
+	}
+	regional := cmd.forRegion(region)
+
+	var checks []*doctorCheck
+	check := func(name string, fn func() ([]string, string, error)) {
+		issues, detail, err := fn()
+		c := &doctorCheck{name: name, result: checkPass, detail: detail}
+		switch {
+		case err != nil:
+			c.result, c.detail = checkSkip, strings.Join(strings.Fields(err.Error()), " ")
+		case len(issues) > 0:
+			c.result, c.detail = checkFail, strings.Join(issues, ", ")
+		}
+		checks = append(checks, c)
+	}
+
+	check("public access block", func() ([]string, string, error) {
+		issues, err := regional.publicAccessBlockIssues(bucket)
+		return issues, "all public access is blocked", err
+	})
+	check("bucket acl", func() ([]string, string, error) {
+		issues, err := regional.bucketACLIssues(bucket)
+		return issues, "no public grants", err
+	})
+	check("bucket policy", func() ([]string, string, error) {
+		issues, err := regional.bucketPolicyIssues(bucket)
+		return issues, "the policy does not grant public access", err
+	})
+
+	// step: print the report
+	var failed, skipped int
+	for _, x := range checks {
+		color := colorGreen
+		switch x.result {
+		case checkFail:
+			color = colorRed
+			failed++
+		case checkSkip:
+			color = colorYellow
+			skipped++
+		}
+		o.fields(map[string]interface{}{
+			"bucket": bucket,
+			"check":  x.name,
+			"result": x.result,
+			"detail": x.detail,
+		}).log("%-20s %s %s\n", x.name, colorize(color, fmt.Sprintf("%-5s", x.result)), x.detail)
+	}
+	switch {
+	case failed > 0:
+		return newExitError(exitFailure, "the bucket: %s is exposed publicly, %d of %d checks failed", bucket, failed, len(checks))
+	case skipped > 0:
+		return newExitError(exitPartialFailure, "unable to verify the bucket: %s is not exposed, %d of %d checks could not be performed",
+			bucket, skipped, len(checks))
+	}
+
+	return nil
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+//
+// pathStep is an element of a field path, either the name of a field or the index of an item
+//
+type pathStep struct {
+	// the name of the field
+	name string
+	// the index of the item, negative from the end
+	index int
+	// indicates the step is an index
+	isIndex bool
+}
+
+// String returns the step as written in the path
+func (r pathStep) String() string {
+	if r.isIndex {
+		return fmt.Sprintf("[%d]", r.index)
+	}
+
+	return "." + r.name
+}
+
+//
+// parseFieldPath parses a jsonpath or yq style path, i.e. $.db.password, .db.password, $.hosts[0] or
+// $['db']['password']
+//
+func parseFieldPath(path string) ([]pathStep, error) {
+	expr := strings.TrimSpace(path)
+	expr = strings.TrimPrefix(expr, "$")
+
+	var steps []pathStep
+	for len(expr) > 0 {
+		switch expr[0] {
+		case '.':
+			expr = expr[1:]
+			end := strings.IndexAny(expr, ".[")
+			if end < 0 {
+				end = len(expr)
+			}
+			if end == 0 {
+				// note: a lone . is the whole document, as with yq
+				if len(expr) == 0 && len(steps) == 0 {
+					return steps, nil
+				}
+				return nil, fmt.Errorf("invalid path: %s, expected a field name after the .", path)
+			}
+			steps = append(steps, pathStep{name: expr[:end]})
+			expr = expr[end:]
+		case '[':
+			end := strings.Index(expr, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path: %s, the [ is not closed", path)
+			}
+			value := strings.TrimSpace(expr[1:end])
+			expr = expr[end+1:]
+			if len(value) >= 2 && (value[0] == '\'' || value[0] == '"') && value[len(value)-1] == value[0] {
+				steps = append(steps, pathStep{name: value[1 : len(value)-1]})
+				continue
+			}
+			index, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path: %s, expected an index or quoted field in the []", path)
+			}
+			steps = append(steps, pathStep{index: index, isIndex: true})
+		default:
+			if len(steps) > 0 || strings.HasPrefix(strings.TrimSpace(path), "$") {
+				return nil, fmt.Errorf("invalid path: %s, unexpected: %s", path, expr)
+			}
+			// note: permit the leading . to be omitted, i.e. db.password
+			expr = "." + expr
+		}
+	}
+
+	return steps, nil
+}
+
+//
+// extractJSONPath parses the content as json and returns the field at the path, scalars as is and
+// objects or arrays as json
+//
+func extractJSONPath(name string, content []byte, path string) ([]byte, error) {
+	steps, err := parseFieldPath(path)
+	if err != nil {
+		return nil, newExitError(exitUsage, "%s", err)
+	}
+	var document interface{}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("unable to parse the file: %s as json, error: %s", name, err)
+	}
+
+	value, err := walkFieldPath(name, document, steps)
+	if err != nil {
+		return nil, err
+	}
+	if scalar, found := scalarValue(value); found {
+		return []byte(scalar + "\n"), nil
+	}
+	encoded, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(encoded, '\n'), nil
+}
+
+//
+// extractYAMLPath parses the content as yaml and returns the field at the path, scalars as is and
+// mappings or sequences as yaml
+//
+func extractYAMLPath(name string, content []byte, path string) ([]byte, error) {
+	steps, err := parseFieldPath(path)
+	if err != nil {
+		return nil, newExitError(exitUsage, "%s", err)
+	}
+	// note: the mappings are decoded in order, unless the document is not a mapping
+	var document interface{}
+	var tree yaml.MapSlice
+	if err := yaml.Unmarshal(content, &tree); err == nil {
+		document = tree
+	} else if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("unable to parse the file: %s as yaml, error: %s", name, err)
+	}
+
+	value, err := walkFieldPath(name, document, steps)
+	if err != nil {
+		return nil, err
+	}
+	if scalar, found := scalarValue(value); found {
+		return []byte(scalar + "\n"), nil
+	}
+
+	return yaml.Marshal(value)
+}
+
+//
+// walkFieldPath follows the steps of the path through the document of the file
+//
+func walkFieldPath(name string, document interface{}, steps []pathStep) (interface{}, error) {
+	value := document
+	var walked string
+	for _, step := range steps {
+		walked += step.String()
+		found := false
+		switch x := value.(type) {
+		case map[string]interface{}:
+			if !step.isIndex {
+				value, found = x[step.name]
+			}
+		case map[interface{}]interface{}:
+			for k, v := range x {
+				if !step.isIndex && fmt.Sprint(k) == step.name {
+					value, found = v, true
+					break
+				}
+			}
+		case yaml.MapSlice:
+			for _, item := range x {
+				if !step.isIndex && fmt.Sprint(item.Key) == step.name {
+					value, found = item.Value, true
+					break
+				}
+			}
+		case []interface{}:
+			index := step.index
+			if index < 0 {
+				index += len(x)
+			}
+			if step.isIndex && index >= 0 && index < len(x) {
+				value, found = x[index], true
+			}
+		}
+		if !found {
+			return nil, newNotFoundError("the path: $%s was not found in the file: %s", walked, name)
+		}
+	}
+
+	return value, nil
+}
+
+// scalarValue returns the value as text if it is a scalar, i.e. a string, number or boolean
+func scalarValue(value interface{}) (string, bool) {
+	switch x := value.(type) {
+	case nil:
+		return "null", true
+	case string:
+		return x, true
+	case json.Number, bool, int, int64, uint64, float64:
+		return fmt.Sprint(x), true
+	}
+
+	return "", false
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	// the metadata holding the permissions of the local file
+	modeMetadata = "kmsctl-mode"
+	// the metadata holding the modification time of the local file
+	mtimeMetadata = "kmsctl-mtime"
+	// follow all the symlinks
+	symlinksFollow = "follow"
+	// skip all the symlinks
+	symlinksSkip = "skip"
+)
+
+// localFileMetadata returns the permissions and modification time of the file as object metadata
+func localFileMetadata(info os.FileInfo) map[string]string {
+	return map[string]string{
+		modeMetadata:  fmt.Sprintf("%#o", info.Mode().Perm()),
+		mtimeMetadata: info.ModTime().UTC().Format(time.RFC3339Nano),
+	}
+}
+
+//
+// applyFileMetadata restores the permissions and modification time recorded in the object metadata
+// to the file, files uploaded without them are left as is
+//
+func applyFileMetadata(path string, metadata map[string]*string) error {
+	if value, found := metadataValue(metadata, modeMetadata); found {
+		mode, err := strconv.ParseUint(value, 0, 32)
+		if err != nil {
+			return fmt.Errorf("invalid mode: %s in the metadata, error: %s", value, err)
+		}
+		if err := os.Chmod(path, os.FileMode(mode).Perm()); err != nil {
+			return err
+		}
+	}
+	if value, found := metadataValue(metadata, mtimeMetadata); found {
+		mtime, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return fmt.Errorf("invalid mtime: %s in the metadata, error: %s", value, err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// metadataValue retrieves the value from the object metadata, the names being case insensitive
+func metadataValue(metadata map[string]*string, name string) (string, bool) {
+	for k, v := range metadata {
+		if strings.EqualFold(k, name) {
+			return aws.StringValue(v), true
+		}
+	}
+
+	return "", false
+}
+
+//
+// expandFiles returns the files under the path; the symlinks are followed, skipped or, by default,
+// only followed when they are the path itself
+//
+func expandFiles(path, symlinks string) ([]string, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if symlinks == symlinksSkip {
+			return nil, nil
+		}
+		if info, err = os.Stat(path); err != nil {
+			return nil, err
+		}
+	}
+	if !info.IsDir() {
+		if !info.Mode().IsRegular() {
+			return nil, fmt.Errorf("the path: %s is not a regular file", path)
+		}
+		return []string{path}, nil
+	}
+
+	// step: walk the directories, the visited directories guard against symlink loops
+	var list []string
+	visited := make(map[string]bool, 0)
+	var walk func(string) error
+	walk = func(directory string) error {
+		resolved, err := filepath.EvalSymlinks(directory)
+		if err != nil {
+			return err
+		}
+		if visited[resolved] {
+			return nil
+		}
+		visited[resolved] = true
+
+		entries, err := ioutil.ReadDir(directory)
+		if err != nil {
+			return err
+		}
+		for _, x := range entries {
+			name := filepath.Join(directory, x.Name())
+			mode := x.Mode()
+			if mode&os.ModeSymlink != 0 {
+				switch symlinks {
+				case symlinksSkip:
+					continue
+				case symlinksFollow:
+					target, err := os.Stat(name)
+					if err != nil {
+						return fmt.Errorf("unable to follow the symlink: %s, error: %s", name, err)
+					}
+					mode = target.Mode()
+				default:
+					logger.warningf("skipping the symlink: %s, use --follow-symlinks to follow it", name)
+					continue
+				}
+			}
+			switch {
+			case mode.IsDir():
+				if err := walk(name); err != nil {
+					return err
+				}
+			case mode.IsRegular():
+				list = append(list, name)
+			}
+		}
+
+		return nil
+	}
+
+	return list, walk(path)
+}
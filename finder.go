@@ -0,0 +1,200 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// the number of matches displayed by the finder
+const finderMatches = 20
+
+// interactiveFlag is the option used to select the keys with the fuzzy finder
+var interactiveFlag = cli.BoolFlag{
+	Name:  "i, interactive",
+	Usage: "select the keys from a listing of the bucket with the fuzzy finder, the arguments being the initial query",
+}
+
+//
+// selectKeys lists the keys of the bucket and lets the user pick them with the fuzzy finder, the
+// arguments being used as the initial query
+//
+func (r *cliCommand) selectKeys(cx *cli.Context, bucket string) ([]string, error) {
+	if !canPrompt() {
+		return nil, newExitError(exitUsage, "invalid option, --interactive requires a terminal")
+	}
+	if cx.String("keys-from") != "" {
+		return nil, newExitError(exitUsage, "invalid option, --interactive and --keys-from are mutually exclusive")
+	}
+	list, err := r.listBucketKeys(bucket, "")
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, x := range list {
+		keys = append(keys, *x.Key)
+	}
+	if len(keys) <= 0 {
+		return nil, newNotFoundError("the bucket: %s has no files to select", bucket)
+	}
+
+	selected, err := fuzzyFind(keys, strings.Join(cx.Args(), " "), os.Stdin, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if len(selected) <= 0 {
+		return nil, newExitError(exitUsage, "no files were selected")
+	}
+
+	return selected, nil
+}
+
+//
+// fuzzyFind displays the items matching the query, best first, reading either a new query or the
+// numbers of the items to select, i.e. 1 3 5-7, or * for all the matches; an empty answer ends the
+// selection without any items
+//
+func fuzzyFind(items []string, query string, in io.Reader, out io.Writer) ([]string, error) {
+	reader := bufio.NewReader(in)
+	for {
+		matches := fuzzyMatches(items, query)
+		fmt.Fprintf(out, "\nquery: '%s', %d of %d match\n", query, len(matches), len(items))
+		for i, x := range matches {
+			if i >= finderMatches {
+				fmt.Fprintf(out, "  ... %d more, refine the query\n", len(matches)-finderMatches)
+				break
+			}
+			fmt.Fprintf(out, "  %3d) %s\n", i+1, x)
+		}
+		fmt.Fprintf(out, "enter a query to filter, the numbers to select (i.e. 1 3 5-7) or * for all the matches: ")
+
+		answer, err := reader.ReadString('\n')
+		if err != nil && (err != io.EOF || answer == "") {
+			return nil, err
+		}
+		answer = strings.TrimSpace(answer)
+		switch {
+		case answer == "":
+			return nil, nil
+		case answer == "*":
+			return matches, nil
+		}
+		if selected, found := finderSelection(answer, matches); found {
+			return selected, nil
+		}
+		query = answer
+	}
+}
+
+// finderSelection parses the numbers of the items selected, i.e. 1 3 5-7, returning false if the
+// answer is not a selection
+func finderSelection(answer string, matches []string) ([]string, bool) {
+	var list []string
+	seen := make(map[int]bool, 0)
+	for _, x := range strings.Fields(strings.Replace(answer, ",", " ", -1)) {
+		bounds := strings.SplitN(x, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, false
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, false
+			}
+		}
+		if first < 1 || last > len(matches) || last > finderMatches || first > last {
+			return nil, false
+		}
+		for i := first; i <= last; i++ {
+			if !seen[i] {
+				list = append(list, matches[i-1])
+				seen[i] = true
+			}
+		}
+	}
+
+	return list, len(list) > 0
+}
+
+// fuzzyMatches returns the items matching the query, ordered by the score of the match
+func fuzzyMatches(items []string, query string) []string {
+	type match struct {
+		item  string
+		score int
+	}
+	var list []match
+	for _, x := range items {
+		if score, found := fuzzyScore(x, query); found {
+			list = append(list, match{item: x, score: score})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].score != list[j].score {
+			return list[i].score > list[j].score
+		}
+		return list[i].item < list[j].item
+	})
+
+	var matches []string
+	for _, x := range list {
+		matches = append(matches, x.item)
+	}
+
+	return matches
+}
+
+//
+// fuzzyScore checks the characters of the query appear in order in the item, ignoring case and
+// spaces, as with fzf; consecutive characters and those at the start of a path element or word
+// score higher, and shorter items win a tie
+//
+func fuzzyScore(item, query string) (int, bool) {
+	needle := []rune(strings.ToLower(strings.Replace(query, " ", "", -1)))
+	haystack := []rune(strings.ToLower(item))
+	if len(needle) <= 0 {
+		return 0, true
+	}
+
+	score, next, previous := 0, 0, -2
+	for i := 0; i < len(haystack) && next < len(needle); i++ {
+		if haystack[i] != needle[next] {
+			continue
+		}
+		score++
+		if i == previous+1 {
+			score += 4
+		}
+		if i == 0 || strings.ContainsRune("/-_.", haystack[i-1]) {
+			score += 2
+		}
+		previous = i
+		next++
+	}
+	if next < len(needle) {
+		return 0, false
+	}
+
+	return score*100 - len(haystack), true
+}
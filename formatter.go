@@ -16,22 +16,44 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"sync"
+	"time"
 
 	"gopkg.in/yaml.v2"
-	"time"
 )
 
+const (
+	// print nothing on success
+	outputQuiet = iota - 1
+	// print the outcome of the operations
+	outputNormal
+	// also print the skipped files, filter decisions and timings
+	outputVerbose
+)
+
+//
+// formatter writes the output of the commands; the writes are serialized so the records of concurrent
+// operations are never interleaved, and buffered formatters hold the records of an item until flushed
+//
 type formatter struct {
 	// the format we should be using
 	format string
 	// the writer
 	writer io.Writer
+	// the level of output, quiet, normal or verbose
+	verbosity int
+	// serializes the writes to the writer
+	lock *sync.Mutex
+	// the formatter the buffered records are flushed into
+	parent *formatter
 }
 
-func newFormatter(format string, writer io.Writer) (*formatter, error) {
+func newFormatter(format string, writer io.Writer, verbosity int) (*formatter, error) {
 	switch format {
 	case "yml":
 		fallthrough
@@ -42,12 +64,72 @@ func newFormatter(format string, writer io.Writer) (*formatter, error) {
 		return nil, fmt.Errorf("unsupport output format")
 	}
 
+	// note: the quiet output is discarded, the errors are written to the stderr regardless
+	if verbosity <= outputQuiet {
+		writer = ioutil.Discard
+	}
+
 	return &formatter{
-		format: format,
-		writer: writer,
+		format:    format,
+		writer:    writer,
+		verbosity: verbosity,
+		lock:      new(sync.Mutex),
 	}, nil
 }
 
+// quiet checks if the output is being discarded
+func (r *formatter) quiet() bool {
+	return r.verbosity <= outputQuiet
+}
+
+//
+// verbose is the equivalent of fields for the details only printed in verbose mode, i.e. the files
+// skipped, filter decisions and timings
+//
+func (r *formatter) verbose(v map[string]interface{}) *formatter {
+	if r.verbosity < outputVerbose {
+		return &formatter{format: r.format, writer: ioutil.Discard, verbosity: r.verbosity, lock: new(sync.Mutex)}
+	}
+
+	return r.fields(v)
+}
+
+//
+// buffered returns a formatter holding the records until flushed into this one, keeping the records of
+// an item together; creating them in order and flushing them at the end keeps the output in order
+// regardless of the order the items complete
+//
+func (r *formatter) buffered() *formatter {
+	return &formatter{
+		format:    r.format,
+		writer:    new(bytes.Buffer),
+		verbosity: r.verbosity,
+		lock:      new(sync.Mutex),
+		parent:    r,
+	}
+}
+
+// flush writes the records held by a buffered formatter
+func (r *formatter) flush() {
+	buffer, ok := r.writer.(*bytes.Buffer)
+	if r.parent == nil || !ok {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.parent.write(buffer.Bytes())
+	buffer.Reset()
+}
+
+// write writes the record whole
+func (r *formatter) write(record []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.writer.Write(record)
+}
+
 func (r *formatter) fields(v map[string]interface{}) *formatter {
 	v["stamp"] = time.Now().Format(time.RFC3339)
 	switch r.format {
@@ -58,13 +140,13 @@ func (r *formatter) fields(v map[string]interface{}) *formatter {
 		if err != nil {
 			return r
 		}
-		fmt.Fprintf(r.writer, string(encode)+"\n")
+		r.write(append(encode, '\n'))
 	case "json":
 		encode, err := json.Marshal(v)
 		if err != nil {
 			return r
 		}
-		fmt.Fprintf(r.writer, string(encode)+"\n")
+		r.write(append(encode, '\n'))
 	default:
 	}
 
@@ -74,7 +156,7 @@ func (r *formatter) fields(v map[string]interface{}) *formatter {
 // add a message to the last log entry
 func (r *formatter) log(message string, args ...interface{}) *formatter {
 	if r.format == "text" {
-		fmt.Fprintf(r.writer, message, args...)
+		r.write([]byte(fmt.Sprintf(message, args...)))
 	}
 
 	return r
@@ -0,0 +1,238 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/urfave/cli"
+)
+
+const (
+	// the characters of the generated passwords
+	passwordCharacters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!#%+,-.:=@^_~"
+	// the maximum number of bytes retrieved from kms in a request
+	kmsRandomMaxBytes = 1024
+)
+
+// the secrets which can be generated and their default size
+var generateTypes = map[string]int{"password": 32, "hex": 64, "base64": 32, "rsa": 4096}
+
+//
+// generateFlags returns the options for generating the content of the files
+//
+func generateFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "generate",
+			Usage: "generate the content rather than reading it, one of password:LENGTH, hex:LENGTH, base64:BYTES or rsa:BITS `TYPE`",
+		},
+		cli.BoolFlag{
+			Name:  "kms-random",
+			Usage: "generate the random bytes with kms rather than locally, not supported for rsa keys",
+		},
+		cli.BoolFlag{
+			Name:  "print",
+			Usage: "display the generated content, by default it is never shown",
+		},
+	}
+}
+
+//
+// generatedSecret is the content generated for a file
+//
+type generatedSecret struct {
+	// the content of the file
+	content []byte
+	// the public key of a keypair, uploaded alongside the file
+	publicKey []byte
+}
+
+//
+// generateSecret generates the content from the specification, i.e. password:32
+//
+func (r *cliCommand) generateSecret(spec string, useKMS bool) (*generatedSecret, error) {
+	kind, size, err := parseGenerate(spec)
+	if err != nil {
+		return nil, err
+	}
+	random := rand.Reader
+	if useKMS {
+		if kind == "rsa" {
+			return nil, newExitError(exitUsage, "invalid option, the rsa keys are always generated locally")
+		}
+		random = &kmsRandom{cmd: r}
+	}
+
+	switch kind {
+	case "password":
+		password := make([]byte, size)
+		for i := range password {
+			index, err := randomIndex(random, len(passwordCharacters))
+			if err != nil {
+				return nil, err
+			}
+			password[i] = passwordCharacters[index]
+		}
+		return &generatedSecret{content: password}, nil
+	case "hex":
+		data, err := randomBytes(random, (size+1)/2)
+		if err != nil {
+			return nil, err
+		}
+		return &generatedSecret{content: []byte(hex.EncodeToString(data)[:size])}, nil
+	case "base64":
+		data, err := randomBytes(random, size)
+		if err != nil {
+			return nil, err
+		}
+		return &generatedSecret{content: []byte(base64.StdEncoding.EncodeToString(data))}, nil
+	}
+
+	// step: generate the keypair
+	key, err := rsa.GenerateKey(random, size)
+	if err != nil {
+		return nil, err
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &generatedSecret{
+		content:   pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		publicKey: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}),
+	}, nil
+}
+
+//
+// putGenerated generates the content of the file and uploads it, along with the public key of a keypair
+// as key.pub; the content is only displayed if requested
+//
+func putGenerated(o *formatter, cx *cli.Context, cmd *cliCommand, bucket, key, kmsID string, options *uploadOptions) error {
+	secret, err := cmd.generateSecret(cx.String("generate"), cx.Bool("kms-random"))
+	if err != nil {
+		return err
+	}
+	// note: the content must never appear in the logs, i.e. the debug logging of the requests
+	logger.redact(string(secret.content))
+
+	if err := cmd.putContent(bucket, key, secret.content, kmsID, options); err != nil {
+		return fmt.Errorf("failed to put the file: %s, error: %s", key, err)
+	}
+	fields := map[string]interface{}{
+		"action":   "generate",
+		"bucket":   bucket,
+		"key":      key,
+		"generate": cx.String("generate"),
+	}
+	if secret.publicKey != nil {
+		if err := cmd.putContent(bucket, key+".pub", secret.publicKey, kmsID, options); err != nil {
+			return fmt.Errorf("failed to put the public key: %s.pub, error: %s", key, err)
+		}
+		fields["public-key"] = key + ".pub"
+	}
+	if !cx.Bool("print") {
+		o.fields(fields).log("successfully generated the file: s3://%s/%s\n", bucket, key)
+		return nil
+	}
+	fields["content"] = string(secret.content)
+	o.fields(fields).log("%s\n", strings.TrimRight(string(secret.content), "\n"))
+
+	return nil
+}
+
+// parseGenerate parses the type and size of the content to generate, the size defaulting per type
+func parseGenerate(spec string) (string, int, error) {
+	items := strings.SplitN(spec, ":", 2)
+	size, found := generateTypes[items[0]]
+	if !found {
+		return "", 0, newExitError(exitUsage, "invalid generate: %s, must be one of password, hex, base64 or rsa", spec)
+	}
+	if len(items) > 1 {
+		value, err := strconv.Atoi(items[1])
+		if err != nil || value <= 0 {
+			return "", 0, newExitError(exitUsage, "invalid generate: %s, the size must be a positive integer", spec)
+		}
+		size = value
+	}
+	if items[0] == "rsa" && size < 2048 {
+		return "", 0, newExitError(exitUsage, "invalid generate: %s, the rsa keys must be at least 2048 bits", spec)
+	}
+
+	return items[0], size, nil
+}
+
+// randomBytes reads the number of random bytes
+func randomBytes(random io.Reader, size int) ([]byte, error) {
+	data := make([]byte, size)
+	if _, err := io.ReadFull(random, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// randomIndex returns a uniform random index below the limit, rejecting the bytes which would bias it
+func randomIndex(random io.Reader, limit int) (int, error) {
+	ceiling := 256 - 256%limit
+	for {
+		data, err := randomBytes(random, 1)
+		if err != nil {
+			return 0, err
+		}
+		if int(data[0]) < ceiling {
+			return int(data[0]) % limit, nil
+		}
+	}
+}
+
+//
+// kmsRandom is a source of random bytes from kms
+//
+type kmsRandom struct {
+	cmd *cliCommand
+	// the bytes retrieved and not yet read
+	buffer []byte
+}
+
+// Read fills the data with the random bytes from kms
+func (k *kmsRandom) Read(data []byte) (int, error) {
+	if len(k.buffer) <= 0 {
+		resp, err := k.cmd.kmsClient.GenerateRandomWithContext(k.cmd.ctx, &kms.GenerateRandomInput{
+			NumberOfBytes: aws.Int64(kmsRandomMaxBytes),
+		})
+		if err != nil {
+			return 0, fmt.Errorf("unable to generate the random bytes with kms, error: %s", err)
+		}
+		k.buffer = resp.Plaintext
+	}
+	n := copy(data, k.buffer)
+	k.buffer = k.buffer[n:]
+
+	return n, nil
+}
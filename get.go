@@ -19,14 +19,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/signal"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
-	"syscall"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/urfave/cli"
 )
 
@@ -37,7 +37,7 @@ func newGetCommand(cmd *cliCommand) cli.Command {
 	return cli.Command{
 		Name:  "get",
 		Usage: "retrieve one or more files from the s3 bucket",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			cli.StringFlag{
 				Name:   "b, bucket",
 				Usage:  "the name of the s3 bucket containing the encrypted files",
@@ -65,6 +65,16 @@ func newGetCommand(cmd *cliCommand) cli.Command {
 				Usage: "the time interval between successive pollings, i.e how long we should wait to recheck",
 				Value: time.Duration(30 * time.Second),
 			},
+			cli.StringFlag{
+				Name:   "events-queue",
+				Usage:  "synchronize on the events of the bucket received from the sqs queue, see buckets notify setup `URL`",
+				EnvVar: "KMSCTL_EVENTS_QUEUE",
+			},
+			cli.StringFlag{
+				Name:   "metrics-listen",
+				Usage:  "expose the prometheus /metrics and /healthz endpoints on this address when synchronizing `ADDRESS`",
+				EnvVar: "KMSCTL_METRICS_LISTEN",
+			},
 			cli.StringFlag{
 				Name:   "d, output-dir",
 				Usage:  "the path to the directory in which to save the files",
@@ -76,7 +86,58 @@ func newGetCommand(cmd *cliCommand) cli.Command {
 				Usage: "apply the following regex filter to the files before retrieving",
 				Value: ".*",
 			},
-		},
+			cli.BoolFlag{
+				Name:  "continue-on-error",
+				Usage: "continue processing the remaining files on a failure, exiting non-zero once complete",
+			},
+			cli.BoolFlag{
+				Name:  "sops",
+				Usage: "write the files in the sops format, preserving the metadata of any existing sops file at the destination",
+			},
+			cli.StringFlag{
+				Name:  "sops-kms",
+				Usage: "the aws kms id used to encrypt new sops files, defaults to the kms key of the object",
+			},
+			cli.StringFlag{
+				Name:  "docker-secrets",
+				Usage: "write the files flattened into the directory, i.e. /run/secrets, read only (0400) along with a manifest `DIR`",
+			},
+			cli.StringFlag{
+				Name:  "systemd-creds",
+				Usage: "write the files into the systemd credential store, i.e. /etc/credstore, for use by LoadCredential and ImportCredential `DIR`",
+			},
+			cli.StringFlag{
+				Name:  "merge-env",
+				Usage: "merge the files, either KEY=VALUE lines or a single value named by the file, into a single dotenv file `PATH`",
+			},
+			cli.BoolFlag{
+				Name:  "show-content",
+				Usage: "include the content of the files in the json and yaml output, by default it is never shown",
+			},
+			cli.BoolFlag{
+				Name:  "extract",
+				Usage: "unpack the tar.gz bundles (i.e. uploaded with put --archive) into the output directory",
+			},
+			cli.StringFlag{
+				Name:   "state-file",
+				Usage:  "the file recording the etags of the files retrieved, by default in the output directory `PATH`",
+				EnvVar: "KMSCTL_STATE_FILE",
+			},
+			cli.BoolFlag{
+				Name:  "no-state",
+				Usage: "do not record the files retrieved, retrieving all the files on each run",
+			},
+			cli.BoolFlag{
+				Name:  "prune",
+				Usage: "remove the files previously retrieved whose keys no longer exist in the bucket, see status",
+			},
+			cli.BoolFlag{
+				Name:  "preserve",
+				Usage: "restore the permissions and modification time the files had when uploaded, overriding --perms",
+			},
+			keysFromFlag,
+			interactiveFlag,
+		}, append(append(cacheFlags(), hookFlags()...), transferFlags(false)...)...),
 		Action: func(cx *cli.Context) error {
 			return handleCommand(cx, []string{"l:bucket:s", "l:output-dir:s"}, cmd, getFiles)
 		},
@@ -97,6 +158,71 @@ func getFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 	syncEnabled := cx.Bool("sync")
 	perms := cx.String("perms")
 	syncInterval := cx.Duration("sync-interval")
+	continueOnError := cx.Bool("continue-on-error")
+	sops := cx.Bool("sops")
+	sopsKms := cx.String("sops-kms")
+	mergeEnv := cx.String("merge-env")
+	dockerSecrets := cx.String("docker-secrets")
+	systemdCreds := cx.String("systemd-creds")
+	extract := cx.Bool("extract")
+	showContent := cx.Bool("show-content")
+
+	// check: the output modes are mutually exclusive
+	var modes []string
+	for _, x := range []string{"sops", "merge-env", "docker-secrets", "systemd-creds", "extract", "show-content"} {
+		if cx.IsSet(x) {
+			modes = append(modes, "--"+x)
+		}
+	}
+	if len(modes) > 1 {
+		return fmt.Errorf("invalid option, you cannot use %s together", strings.Join(modes, " and "))
+	}
+	preserve := cx.Bool("preserve")
+	if preserve && (mergeEnv != "" || dockerSecrets != "" || systemdCreds != "" || extract) {
+		return fmt.Errorf("invalid option, you cannot use --preserve with %s", modes[0])
+	}
+	mode, err := strconv.ParseUint(perms, 0, 32)
+	if err != nil {
+		return fmt.Errorf("perms: %s is invalid, message: %s", perms, err)
+	}
+	if err := cmd.applyTransferOptions(cx); err != nil {
+		return err
+	}
+	if err := cmd.applyCacheOptions(cx); err != nil {
+		return err
+	}
+	action := "get"
+	if syncEnabled {
+		action = "sync"
+	}
+	hooks, err := getTransferHooks(cx, action)
+	if err != nil {
+		return err
+	}
+	// step: the files are retrieved conditionally on the etags recorded by the previous runs; the
+	// merged and laid out modes always need the content of all the files
+	var state *syncState
+	if !cx.Bool("no-state") && (len(modes) <= 0 || showContent) {
+		if state, err = loadSyncState(defaultValue(cx.String("state-file"), filepath.Join(directory, syncStateName))); err != nil {
+			return fmt.Errorf("unable to read the state file, error: %s", err)
+		}
+	}
+	prune := cx.Bool("prune")
+	if prune && state == nil {
+		return fmt.Errorf("invalid option, --prune requires the state file, which is not used with --no-state or %s", strings.Join(modes, " "))
+	}
+	// note: the keys are read once, as stdin cannot be read on each synchronization
+	paths := getPaths(cx)
+	switch {
+	case cx.Bool("interactive"):
+		if paths, err = cmd.selectKeys(cx, bucket); err != nil {
+			return err
+		}
+	case cx.String("keys-from") != "" || isValidOption("-", cx.Args()):
+		if paths, err = getKeys(cx); err != nil {
+			return err
+		}
+	}
 
 	// step: validate the filter if any
 	var filter *regexp.Regexp
@@ -104,25 +230,53 @@ func getFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 		return fmt.Errorf("filter: %s is invalid, message: %s", cx.String("filter"), err)
 	}
 
+	// note: the events are in addition to the polling, which catches any events missed
+	var eventsCh <-chan []string
+	if queue := cx.String("events-queue"); queue != "" {
+		if !syncEnabled {
+			return fmt.Errorf("invalid option, --events-queue requires --sync")
+		}
+		eventsCh = cmd.watchEvents(queue, bucket, paths)
+	}
+
+	// step: expose the metrics and health of the synchronization
+	if listen := cx.String("metrics-listen"); listen != "" && syncEnabled {
+		serveMetrics(cmd, listen, 3*syncInterval)
+	}
+
 	// step: create the output directory if required
 	if err = os.MkdirAll(directory, 0755); err != nil {
 		return err
 	}
 
-	// step: create a signal to handle exits and a ticker for intervals
-	signalCh := make(chan os.Signal)
-	signal.Notify(signalCh, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	// step: create a ticker for intervals
 	tickerCh := time.NewTicker(1)
 	exitCh := make(chan error, 1)
 	firstTime := true
 
 	// step: create a map for etags - used to maintainer the etags of the files
 	fileTags := make(map[string]string, 0)
+	// step: the variables merged from the files when writing a dotenv file
+	env := newDotenvFile()
+	// step: the secrets written when laid out for containers
+	manifest := newSecretsManifest(dockerSecrets)
+	if systemdCreds != "" {
+		manifest = newSystemdCredstore(systemdCreds)
+	}
 
 	for {
 		select {
 		case err = <-exitCh:
 			return err
+		case keys := <-eventsCh:
+			o.fields(map[string]interface{}{
+				"bucket": bucket,
+				"keys":   keys,
+			}).log("received the events for the files: %s, synchronizing\n", strings.Join(keys, ", "))
+			// note: synchronize now and restart the interval
+			tickerCh.Stop()
+			tickerCh = time.NewTicker(1)
+			firstTime = true
 		case <-tickerCh.C:
 			if firstTime {
 				tickerCh.Stop()
@@ -130,11 +284,20 @@ func getFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 				firstTime = false
 			}
 			// step: iterate the paths specified on the command line
+			summary := newTransferSummary("retrieved")
+			// the keys merged into the dotenv file in this pass
+			var merged []string
 			err := func() error {
-				for _, bucketPath := range getPaths(cx) {
+				for _, bucketPath := range paths {
 					path := strings.TrimPrefix(bucketPath, "/")
 					// step: retrieve a list of files under this path
 					list, err := cmd.listBucketKeys(bucket, path)
+					cached := false
+					if err != nil && cmd.cache != nil && isUnreachable(err) {
+						logger.warningf("unable to retrieve a listing of the path: %s, using the cached files, error: %s", path, err)
+						list, err = cmd.cache.listKeys(cmd, bucket, path)
+						cached = true
+					}
 					if err != nil {
 						o.fields(map[string]interface{}{
 							"bucket": bucket,
@@ -142,14 +305,28 @@ func getFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 							"error":  err.Error(),
 						}).log("unable to retrieve a listing in bucket: %s, path: %s\n", bucket, path)
 
+						if continueOnError {
+							summary.fail(path, err)
+							continue
+						}
 						return err
 					}
 
+					// step: remove the files retrieved before which no longer exist in the bucket
+					if prune && !cached {
+						pruneFiles(o, state, bucket, path, list)
+					}
+
 					// step: iterate the files under the path
 					for _, file := range list {
 						keyName := strings.TrimPrefix(*file.Key, "/")
 						// step: apply the filter and ignore everything were not interested in
 						if !filter.MatchString(keyName) {
+							o.verbose(map[string]interface{}{
+								"action": "filter",
+								"bucket": bucket,
+								"key":    keyName,
+							}).log("skipping the file: %s, it does not match the filter\n", keyName)
 							continue
 						}
 						// step: are we recursive? i.e. if not, check the file ends with the filename
@@ -157,19 +334,153 @@ func getFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 							continue
 						}
 
-						// step: if we have download this file before, check the etag has changed
-						if etag, found := fileTags[keyName]; found && etag == *file.ETag {
-							continue // we can skip the file, nothing has changed
-						}
-
 						// step: are we flattening the files
-						filename := fmt.Sprintf("%s/%s", directory, keyName)
+						filename := filepath.Join(directory, filepath.FromSlash(keyName))
 						if flatten {
-							filename = fmt.Sprintf("%s/%s", directory, filepath.Base(keyName))
+							filename = filepath.Join(directory, filepath.Base(keyName))
+						}
+
+						// step: if we have download this file before, check the etag has changed
+						etag, found := fileTags[keyName]
+						if !found && state != nil {
+							etag = state.etag(keyName, filename)
+							found = etag != ""
+						}
+						if found && etag == *file.ETag {
+							summary.skip()
+							o.verbose(map[string]interface{}{
+								"action": "skip",
+								"bucket": bucket,
+								"key":    keyName,
+								"etag":   etag,
+							}).log("skipping the file: %s, it is unchanged\n", keyName)
+							continue // we can skip the file, nothing has changed
 						}
 
 						// step: retrieve file and write the content to disk
-						if err := processFile(filename, keyName, bucket, perms, cmd); err != nil {
+						var shown []byte
+						var unchanged bool
+						var hash string
+						// note: the etag and version of the object actually retrieved are recorded
+						etag, version := *file.ETag, ""
+						write := processFile
+						switch {
+						case sops:
+							write = func(path, key, bucket, perms string, cmd *cliCommand) error {
+								return processSopsFile(path, key, bucket, perms, sopsKms, cmd)
+							}
+						case mergeEnv != "":
+							filename = mergeEnv
+							write = func(path, key, bucket, perms string, cmd *cliCommand) error {
+								content, err := cmd.getFile(bucket, key)
+								if err != nil {
+									return err
+								}
+								return env.merge(key, content)
+							}
+						case extract && isBundle(keyName):
+							filename = directory
+							write = func(path, key, bucket, perms string, cmd *cliCommand) error {
+								content, err := cmd.getFile(bucket, key)
+								if err != nil {
+									return err
+								}
+								_, err = extractBundle(content, path)
+								return err
+							}
+						case dockerSecrets != "", systemdCreds != "":
+							filename = filepath.Join(manifest.directory, filepath.Base(keyName))
+							etag := aws.StringValue(file.ETag)
+							write = func(path, key, bucket, perms string, cmd *cliCommand) error {
+								if _, err := manifest.path(bucket, key); err != nil {
+									return err
+								}
+								content, err := cmd.getFile(bucket, key)
+								if err != nil {
+									return err
+								}
+								return manifest.add(bucket, key, etag, content)
+							}
+						case state != nil:
+							// note: the etag of the listing may be stale, so the retrieval is conditional as well
+							write = func(path, key, bucket, perms string, cmd *cliCommand) error {
+								previous := state.etag(key, path)
+								content, resp, err := cmd.getFileIfChanged(bucket, key, previous)
+								if err != nil {
+									if previous != "" && cmd.cache != nil && isUnreachable(err) {
+										logger.warningf("unable to retrieve the file: %s, keeping the existing copy, error: %s", key, err)
+										unchanged = true
+										return nil
+									}
+									return err
+								}
+								if content == nil {
+									unchanged = true
+									return nil
+								}
+								etag, version = aws.StringValue(resp.ETag), aws.StringValue(resp.VersionId)
+								if showContent {
+									shown = content
+								}
+								hash = contentHash(content)
+								return writeContent(path, content, perms)
+							}
+						case showContent:
+							write = func(path, key, bucket, perms string, cmd *cliCommand) error {
+								content, err := cmd.getFile(bucket, key)
+								if err != nil {
+									return err
+								}
+								shown = content
+								return writeContent(path, content, perms)
+							}
+						}
+						if preserve {
+							retrieve := write
+							write = func(path, key, bucket, perms string, cmd *cliCommand) error {
+								if err := retrieve(path, key, bucket, perms, cmd); err != nil {
+									return err
+								}
+								head, err := cmd.getFileMetadata(key, bucket)
+								if err != nil {
+									return err
+								}
+								return applyFileMetadata(path, head.Metadata)
+							}
+						}
+						switch {
+						case len(hooks) > 0 && mergeEnv != "":
+							// note: the after hooks run once the dotenv file has been written
+							retrieve := write
+							write = func(path, key, bucket, perms string, cmd *cliCommand) error {
+								if err := execHooks(hooks, hookBefore, action, bucket, key, path); err != nil {
+									return err
+								}
+								if err := retrieve(path, key, bucket, perms, cmd); err != nil {
+									return err
+								}
+								merged = append(merged, key)
+								return nil
+							}
+						case len(hooks) > 0:
+							retrieve := write
+							write = func(path, key, bucket, perms string, cmd *cliCommand) error {
+								err := runHooks(hooks, action, bucket, key, path, func() error {
+									if err := retrieve(path, key, bucket, perms, cmd); err != nil {
+										return err
+									}
+									if unchanged {
+										return errUnchanged
+									}
+									return nil
+								})
+								if err == errUnchanged {
+									return nil
+								}
+								return err
+							}
+						}
+						if err := write(filename, keyName, bucket, perms, cmd); err != nil {
 							o.fields(map[string]interface{}{
 								"action":      "get",
 								"bucket":      bucket,
@@ -177,34 +488,130 @@ func getFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 								"error":       err.Error(),
 							}).log("failed to retrieve file: %s, error: %s\n", keyName, err)
 
+							if continueOnError {
+								summary.fail(keyName, err)
+								continue
+							}
 							return err
 						}
 						// step: update the file tags
 						fileTags[keyName] = *file.ETag
+						if unchanged {
+							summary.skip()
+							o.verbose(map[string]interface{}{
+								"action": "skip",
+								"bucket": bucket,
+								"key":    keyName,
+								"etag":   *file.ETag,
+							}).log("skipping the file: %s, it is unchanged\n", keyName)
+							continue
+						}
+						summary.success()
+						if state != nil {
+							if info, err := os.Stat(filename); err == nil {
+								state.record(keyName, &syncStateFile{
+									Bucket:      bucket,
+									ETag:        etag,
+									Version:     version,
+									Destination: filename,
+									Hash:        hash,
+									Perms:       fileMode(info),
+								})
+							}
+						}
 
-						// step: add the log
-						o.fields(map[string]interface{}{
+						// step: add the log, the content is only included when asked for
+						fields := map[string]interface{}{
 							"action":      "get",
 							"bucket":      bucket,
 							"destination": filename,
 							"etag":        file.ETag,
-						}).log("retrieved the file: %s and wrote to: %s\n", keyName, filename)
+						}
+						if shown != nil {
+							fields["content"] = string(shown)
+						}
+						o.fields(fields).log("retrieved the file: %s and wrote to: %s\n", keyName, filename)
+					}
+				}
+
+				// step: record the files retrieved for the next run
+				if state != nil {
+					if err := state.write(); err != nil {
+						return err
+					}
+				}
+				// step: write the manifest of the secrets
+				if dockerSecrets != "" && summary.transferred > 0 {
+					if err := manifest.write(); err != nil {
+						return fmt.Errorf("unable to write the secrets manifest, error: %s", err)
+					}
+				}
+				// step: write the merged variables to the dotenv file
+				if mergeEnv != "" && summary.transferred > 0 {
+					if err := env.write(mergeEnv, os.FileMode(mode)); err != nil {
+						return fmt.Errorf("unable to write the dotenv file: %s, error: %s", mergeEnv, err)
+					}
+					for _, key := range merged {
+						if err := execHooks(hooks, hookAfter, action, bucket, key, mergeEnv); err != nil {
+							return err
+						}
 					}
 				}
 
 				return nil
 			}()
+			// step: record the outcome of the synchronization
+			metrics.add("kmsctl_sync_files_total", `result="retrieved"`, float64(summary.transferred))
+			metrics.add("kmsctl_sync_files_total", `result="skipped"`, float64(summary.skipped))
+			metrics.add("kmsctl_sync_files_total", `result="failed"`, float64(len(summary.errors)))
+			if err != nil {
+				metrics.syncCompleted(err)
+			} else {
+				metrics.syncCompleted(summary.err())
+			}
 			// step: if we are not in a sync loop we can exit
 			if !syncEnabled {
+				if err == nil {
+					summary.print(o)
+					err = summary.err()
+				}
 				exitCh <- err
 			}
-		case <-signalCh:
-			o.log("exitting the synchronzition service")
+		case <-cmd.ctx.Done():
+			logger.infof("exiting the synchronization service")
 			return nil
 		}
 	}
 }
 
+//
+// pruneFiles removes the files recorded in the state under the path which are absent from the listing
+//
+func pruneFiles(o *formatter, state *syncState, bucket, path string, list []*s3.Object) {
+	listed := make(map[string]bool, len(list))
+	for _, x := range list {
+		listed[strings.TrimPrefix(aws.StringValue(x.Key), "/")] = true
+	}
+	for _, key := range state.keys() {
+		file := state.Files[key]
+		if file.Bucket != bucket || !strings.HasPrefix(key, path) || listed[key] {
+			continue
+		}
+		if err := os.Remove(file.Destination); err != nil && !os.IsNotExist(err) {
+			logger.warningf("unable to prune the file: %s, error: %s", file.Destination, err)
+			continue
+		}
+		state.remove(key)
+
+		o.fields(map[string]interface{}{
+			"action":      "prune",
+			"bucket":      bucket,
+			"key":         key,
+			"destination": file.Destination,
+		}).log("pruned the file: %s, the key: %s no longer exists\n", file.Destination, key)
+	}
+}
+
 // processFile is responsible for retrieving the files
 func processFile(path, key, bucket, perms string, cmd *cliCommand) error {
 	// step: retrieve the file content
@@ -212,6 +619,12 @@ func processFile(path, key, bucket, perms string, cmd *cliCommand) error {
 	if err != nil {
 		return err
 	}
+
+	return writeContent(path, content, perms)
+}
+
+// writeContent writes the content of the file with the permissions
+func writeContent(path string, content []byte, perms string) error {
 	// step: ensure the directory structure
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return err
@@ -225,3 +638,41 @@ func processFile(path, key, bucket, perms string, cmd *cliCommand) error {
 	// step: create the file for writing
 	return ioutil.WriteFile(path, content, os.FileMode(mode))
 }
+
+// processSopsFile retrieves the file and writes it encrypted in the sops format
+func processSopsFile(path, key, bucket, perms, kmsKey string, cmd *cliCommand) error {
+	content, err := cmd.getFile(bucket, key)
+	if err != nil {
+		return err
+	}
+	// step: default to the kms key the object is encrypted with
+	if kmsKey == "" {
+		head, err := cmd.getFileMetadata(key, bucket)
+		if err != nil {
+			return err
+		}
+		if head.SSEKMSKeyId == nil {
+			return fmt.Errorf("the file is not encrypted with kms, you must specify a sops kms key")
+		}
+		kmsKey = *head.SSEKMSKeyId
+	}
+	// step: preserve the metadata of any existing sops file
+	existing, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	encrypted, err := cmd.encryptSops(content, existing, sopsFormat(path), kmsKey)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	mode, err := strconv.ParseUint(perms, 0, 32)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, encrypted, os.FileMode(mode))
+}
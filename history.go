@@ -0,0 +1,281 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+)
+
+// the maximum difference between the modified time of a version and the cloudtrail event
+const writerTolerance = 5 * time.Second
+
+//
+// newHistoryCommand creates a new history command
+//
+func newHistoryCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:      "history",
+		Usage:     "list the versions of a file in a versioned bucket, or compare two of them",
+		ArgsUsage: "KEY",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.BoolFlag{
+				Name:  "diff",
+				Usage: "produce a unified diff of the two versions specified after the key, i.e. KEY V1 V2",
+			},
+			cli.BoolFlag{
+				Name:  "no-writer",
+				Usage: "do not search cloudtrail for the identity which wrote each version",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s"}, cmd, fileHistory)
+		},
+	}
+}
+
+//
+// fileHistory lists the versions of the file
+//
+func fileHistory(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+
+	if cx.Bool("diff") {
+		if len(cx.Args()) != 3 {
+			return newExitError(exitUsage, "you must specify the key and two versions to compare, i.e. KEY V1 V2")
+		}
+		return diffVersions(cmd, bucket, strings.TrimPrefix(cx.Args()[0], "/"), cx.Args()[1], cx.Args()[2])
+	}
+	if len(cx.Args()) != 1 {
+		return newExitError(exitUsage, "you must specify the key of the file")
+	}
+	key := strings.TrimPrefix(cx.Args()[0], "/")
+
+	versions, err := cmd.listFileVersions(bucket, key)
+	if err != nil {
+		return err
+	}
+	if len(versions) <= 0 {
+		return newNotFoundError("the file: %s does not exist in the bucket: %s", key, bucket)
+	}
+
+	// step: attempt to resolve the writer of the versions from cloudtrail
+	writers := make(map[string]string, 0)
+	if !cx.Bool("no-writer") {
+		if writers, err = cmd.versionWriters(bucket, key, versions); err != nil {
+			logger.warningf("unable to retrieve the writers from cloudtrail, error: %s", err)
+		}
+	}
+
+	for _, x := range versions {
+		o.fields(map[string]interface{}{
+			"version":  x.version,
+			"size":     x.size,
+			"modified": x.modified.Format(time.RFC3339),
+			"latest":   x.latest,
+			"deleted":  x.deleted,
+			"writer":   writers[x.version],
+		}).log("%-34s %-8s %-25s %-7s %s\n", x.version, versionSize(x), x.modified.Format(time.RFC3339),
+			versionState(x), defaultValue(writers[x.version], "-"))
+	}
+
+	return nil
+}
+
+//
+// fileVersion is a version of a file, or a delete marker
+//
+type fileVersion struct {
+	// the version id
+	version string
+	// the size of the version
+	size int64
+	// the time the version was written
+	modified time.Time
+	// indicates this is the current version
+	latest bool
+	// indicates this is a delete marker
+	deleted bool
+}
+
+//
+// listFileVersions retrieves the versions of the file, newest first
+//
+func (r *cliCommand) listFileVersions(bucket, key string) ([]*fileVersion, error) {
+	var list []*fileVersion
+
+	err := r.s3Client.ListObjectVersionsPagesWithContext(r.ctx, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(r.objectKey(key)),
+	}, func(page *s3.ListObjectVersionsOutput, last bool) bool {
+		for _, x := range page.Versions {
+			if aws.StringValue(x.Key) == r.objectKey(key) {
+				list = append(list, &fileVersion{
+					version:  aws.StringValue(x.VersionId),
+					size:     aws.Int64Value(x.Size),
+					modified: aws.TimeValue(x.LastModified),
+					latest:   aws.BoolValue(x.IsLatest),
+				})
+			}
+		}
+		for _, x := range page.DeleteMarkers {
+			if aws.StringValue(x.Key) == r.objectKey(key) {
+				list = append(list, &fileVersion{
+					version:  aws.StringValue(x.VersionId),
+					modified: aws.TimeValue(x.LastModified),
+					latest:   aws.BoolValue(x.IsLatest),
+					deleted:  true,
+				})
+			}
+		}
+
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].modified.After(list[j].modified)
+	})
+
+	return list, nil
+}
+
+//
+// versionWriters searches cloudtrail for the identity which wrote each version, s3 generates a data
+// key on behalf of the caller, with the arn of the object as the encryption context
+//
+func (r *cliCommand) versionWriters(bucket, key string, versions []*fileVersion) (map[string]string, error) {
+	writers := make(map[string]string, 0)
+
+	// step: cloudtrail only retains the events for ninety days
+	oldest := versions[len(versions)-1].modified
+	if limit := time.Now().Add(-90 * 24 * time.Hour); oldest.Before(limit) {
+		oldest = limit
+	}
+	region, err := r.getBucketRegion(bucket)
+	if err != nil {
+		return writers, err
+	}
+	client := cloudtrail.New(r.sessionForRegion(region))
+	objectARN := s3ARN(region, bucket+"/"+r.objectKey(key))
+
+	var events []*trailEvent
+	err = client.LookupEventsPagesWithContext(r.ctx, &cloudtrail.LookupEventsInput{
+		LookupAttributes: []*cloudtrail.LookupAttribute{
+			{
+				AttributeKey:   aws.String(cloudtrail.LookupAttributeKeyEventName),
+				AttributeValue: aws.String("GenerateDataKey"),
+			},
+		},
+		StartTime: aws.Time(oldest.Add(-writerTolerance)),
+		EndTime:   aws.Time(versions[0].modified.Add(writerTolerance)),
+	}, func(page *cloudtrail.LookupEventsOutput, lastPage bool) bool {
+		for _, x := range page.Events {
+			event := new(trailEvent)
+			if err := json.Unmarshal([]byte(aws.StringValue(x.CloudTrailEvent)), event); err != nil {
+				continue
+			}
+			if event.RequestParameters.EncryptionContext["aws:s3:arn"] == objectARN && event.ErrorCode == "" {
+				events = append(events, event)
+			}
+		}
+
+		return true
+	})
+	if err != nil {
+		return writers, err
+	}
+
+	// step: match the versions to the closest event
+	for _, x := range versions {
+		if x.deleted {
+			continue
+		}
+		closest := writerTolerance + 1
+		for _, e := range events {
+			delta := e.EventTime.Sub(x.modified)
+			if delta < 0 {
+				delta = -delta
+			}
+			if delta <= writerTolerance && delta < closest {
+				closest = delta
+				writers[x.version] = defaultValue(e.UserIdentity.ARN, e.UserIdentity.PrincipalID)
+			}
+		}
+	}
+
+	return writers, nil
+}
+
+//
+// diffVersions prints a unified diff of the two versions of the file
+//
+func diffVersions(cmd *cliCommand, bucket, key, from, to string) error {
+	contentA, err := cmd.getFileVersion(bucket, key, from)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve the version: %s, error: %s", from, err)
+	}
+	contentB, err := cmd.getFileVersion(bucket, key, to)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve the version: %s, error: %s", to, err)
+	}
+
+	diff, err := unifiedDiff(contentA, contentB, fmt.Sprintf("%s@%s", key, from), fmt.Sprintf("%s@%s", key, to))
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(os.Stdout, diff)
+
+	return nil
+}
+
+// versionSize returns the size of the version for display
+func versionSize(x *fileVersion) string {
+	if x.deleted {
+		return "-"
+	}
+
+	return fmt.Sprintf("%d", x.size)
+}
+
+// versionState returns the state of the version for display
+func versionState(x *fileVersion) string {
+	switch {
+	case x.deleted && x.latest:
+		return "deleted"
+	case x.deleted:
+		return "marker"
+	case x.latest:
+		return "latest"
+	}
+
+	return ""
+}
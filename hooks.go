@@ -0,0 +1,164 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+
+	"github.com/urfave/cli"
+)
+
+const (
+	// the hook runs before the file is transferred
+	hookBefore = "before"
+	// the hook runs after the file has been transferred
+	hookAfter = "after"
+)
+
+//
+// transferHook is a command run before or after a file is transferred
+//
+type transferHook struct {
+	// the name of the hook, used in the errors
+	Name string `yaml:"name"`
+	// the action the hook applies to, get, put or sync, else all of them
+	Action string `yaml:"action"`
+	// when the hook is run, before or after
+	When string `yaml:"when"`
+	// a regex the key must match for the hook to run, else all the keys
+	Match string `yaml:"match"`
+	// the command to run
+	Command string `yaml:"command"`
+	// the parsed command
+	args []string
+	// the compiled match
+	filter *regexp.Regexp
+}
+
+//
+// hookFlags returns the options of the transfer hooks
+//
+func hookFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  "exec-before",
+			Usage: "run the command before each file is transferred, with KMSCTL_ACTION, KMSCTL_BUCKET, KMSCTL_KEY and KMSCTL_PATH set `COMMAND`",
+		},
+		cli.StringFlag{
+			Name:  "exec-after",
+			Usage: "run the command after each file has changed, i.e. to reload a service, with the same variables as --exec-before `COMMAND`",
+		},
+	}
+}
+
+// validate checks and parses the hook
+func (h *transferHook) validate() error {
+	if !isValidOption(h.When, []string{hookBefore, hookAfter}) {
+		return fmt.Errorf("the hook: %s must run before or after, not: %s", h.Name, h.When)
+	}
+	if !isValidOption(h.Action, []string{"", "get", "put", "sync"}) {
+		return fmt.Errorf("the hook: %s has an invalid action: %s, must be get, put or sync", h.Name, h.Action)
+	}
+	args, err := splitArgs(h.Command)
+	if err != nil || len(args) <= 0 {
+		return fmt.Errorf("the hook: %s has an invalid command: %s", h.Name, h.Command)
+	}
+	h.args = args
+	if h.Match != "" {
+		if h.filter, err = regexp.Compile(h.Match); err != nil {
+			return fmt.Errorf("the hook: %s has an invalid match: %s, error: %s", h.Name, h.Match, err)
+		}
+	}
+
+	return nil
+}
+
+//
+// getTransferHooks returns the hooks of the action from the options and the configuration file, if any
+//
+func getTransferHooks(cx *cli.Context, action string) ([]*transferHook, error) {
+	var hooks []*transferHook
+	for _, when := range []string{hookBefore, hookAfter} {
+		if command := cx.String("exec-" + when); command != "" {
+			hook := &transferHook{Name: "--exec-" + when, When: when, Command: command}
+			if err := hook.validate(); err != nil {
+				return nil, newExitError(exitUsage, "%s", err)
+			}
+			hooks = append(hooks, hook)
+		}
+	}
+
+	// note: the configuration file is optional, unlike when selecting an environment
+	path := cx.GlobalString("config")
+	if found, err := isFile(path); err != nil || !found {
+		return hooks, nil
+	}
+	c, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, x := range c.Hooks {
+		if x.Action == "" || x.Action == action {
+			hooks = append(hooks, x)
+		}
+	}
+
+	return hooks, nil
+}
+
+//
+// runHooks wraps the transfer of the file with the hooks, the after hooks only running if the file has
+// changed, i.e. the transfer did not return errUnchanged
+//
+func runHooks(hooks []*transferHook, action, bucket, key, path string, transfer func() error) error {
+	if err := execHooks(hooks, hookBefore, action, bucket, key, path); err != nil {
+		return err
+	}
+	if err := transfer(); err != nil {
+		return err
+	}
+
+	return execHooks(hooks, hookAfter, action, bucket, key, path)
+}
+
+// execHooks runs the hooks of the stage matching the key, in order, stopping on the first failure
+func execHooks(hooks []*transferHook, when, action, bucket, key, path string) error {
+	for _, x := range hooks {
+		if x.When != when || (x.filter != nil && !x.filter.MatchString(key)) {
+			continue
+		}
+		logger.debugf("running the %s hook: %s for the file: %s", when, x.Name, key)
+
+		command := exec.Command(x.args[0], x.args[1:]...)
+		command.Env = append(os.Environ(),
+			"KMSCTL_ACTION="+action,
+			"KMSCTL_HOOK="+when,
+			"KMSCTL_BUCKET="+bucket,
+			"KMSCTL_KEY="+key,
+			"KMSCTL_PATH="+path,
+		)
+		command.Stdout = os.Stderr
+		command.Stderr = os.Stderr
+		if err := command.Run(); err != nil {
+			return fmt.Errorf("the %s hook: %s failed, error: %s", when, x.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+)
+
+// the access levels the policy can grant
+var policyAccessLevels = []string{"read", "write"}
+
+//
+// newIAMPolicyCommand creates a new iam-policy command
+//
+func newIAMPolicyCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "iam-policy",
+		Usage: "generate the least privilege iam policy a consumer needs to read or write the files under a prefix",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:  "p, prefix",
+				Usage: "restrict the access to the files under this prefix, i.e. app/ `PREFIX`",
+			},
+			cli.StringFlag{
+				Name:   "k, kms",
+				Usage:  "the alias, arn or id of the kms key, defaults to the bucket default encryption `KEY`",
+				EnvVar: "AWS_KMS_ID",
+			},
+			cli.StringFlag{
+				Name:  "a, access",
+				Usage: "the access required to the files, read or write `ACCESS`",
+				Value: "read",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s", "l:access:s"}, cmd, generateIAMPolicy)
+		},
+	}
+}
+
+//
+// generateIAMPolicy prints the iam policy required to access the files under the prefix
+//
+func generateIAMPolicy(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	prefix := cmd.objectKey(strings.TrimPrefix(cx.String("prefix"), "/"))
+	access := cx.String("access")
+
+	if !isValidOption(access, policyAccessLevels) {
+		return newExitError(exitUsage, "invalid access: %s, must be one of %s", access, strings.Join(policyAccessLevels, ", "))
+	}
+
+	// step: resolve the kms key, falling back to the bucket default encryption
+	rule, err := cmd.getBucketEncryption(bucket)
+	if err != nil {
+		return err
+	}
+	kmsKey := cx.String("kms")
+	if kmsKey == "" {
+		if rule == nil || aws.StringValue(rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm) != s3.ServerSideEncryptionAwsKms {
+			return fmt.Errorf("no kms key specified and the bucket: %s has no default kms encryption", bucket)
+		}
+		kmsKey = aws.StringValue(rule.ApplyServerSideEncryptionByDefault.KMSMasterKeyID)
+	}
+	metadata, err := cmd.describeKey(kmsKey)
+	if err != nil {
+		return fmt.Errorf("unable to resolve the kms key: %s, error: %s", kmsKey, err)
+	}
+	region, err := cmd.getBucketRegion(bucket)
+	if err != nil {
+		return err
+	}
+	bucketKey := rule != nil && aws.BoolValue(rule.BucketKeyEnabled)
+
+	policy := iamPolicyDocument(bucket, prefix, aws.StringValue(metadata.Arn), region, access, bucketKey)
+	encoded, err := json.MarshalIndent(policy, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	o.fields(map[string]interface{}{
+		"bucket": bucket,
+		"prefix": prefix,
+		"access": access,
+		"policy": policy,
+	}).log("%s\n", encoded)
+
+	return nil
+}
+
+//
+// iamPolicyDocument generates the policy, the kms permissions are restricted to use via s3 and, unless
+// the bucket uses a bucket key, to the objects under the prefix
+//
+func iamPolicyDocument(bucket, prefix, keyArn, region, access string, bucketKey bool) map[string]interface{} {
+	objects := s3ARN(region, bucket+"/"+prefix+"*")
+
+	kmsCondition := map[string]interface{}{
+		"StringEquals": map[string]string{"kms:ViaService": fmt.Sprintf("s3.%s.%s", region, regionPartition(region).DNSSuffix())},
+	}
+	if !bucketKey {
+		kmsCondition["StringLike"] = map[string]string{"kms:EncryptionContext:aws:s3:arn": objects}
+	}
+
+	listing := map[string]interface{}{
+		"Sid":      "KmsctlListFiles",
+		"Effect":   "Allow",
+		"Action":   "s3:ListBucket",
+		"Resource": s3ARN(region, bucket),
+	}
+	if prefix != "" {
+		listing["Condition"] = map[string]interface{}{
+			"StringLike": map[string][]string{"s3:prefix": {prefix, prefix + "*"}},
+		}
+	}
+	statements := []interface{}{listing}
+
+	switch access {
+	case "read":
+		statements = append(statements,
+			map[string]interface{}{
+				"Sid":      "KmsctlReadFiles",
+				"Effect":   "Allow",
+				"Action":   "s3:GetObject",
+				"Resource": objects,
+			},
+			map[string]interface{}{
+				"Sid":       "KmsctlDecryptFiles",
+				"Effect":    "Allow",
+				"Action":    "kms:Decrypt",
+				"Resource":  keyArn,
+				"Condition": kmsCondition,
+			})
+	case "write":
+		// note: the put command verifies the bucket, its encryption and that it is not public, heads the files
+		// for --skip-unchanged and --if-match, carries their tags forward checking they are not locked, and
+		// multipart uploads require decrypt
+		statements = append(statements,
+			map[string]interface{}{
+				"Sid":      "KmsctlListBuckets",
+				"Effect":   "Allow",
+				"Action":   "s3:ListAllMyBuckets",
+				"Resource": "*",
+			},
+			map[string]interface{}{
+				"Sid":    "KmsctlCheckBucket",
+				"Effect": "Allow",
+				"Action": []string{
+					"s3:GetEncryptionConfiguration",
+					"s3:GetBucketPublicAccessBlock",
+					"s3:GetBucketAcl",
+					"s3:GetBucketPolicyStatus",
+				},
+				"Resource": s3ARN(region, bucket),
+			},
+			map[string]interface{}{
+				"Sid":      "KmsctlWriteFiles",
+				"Effect":   "Allow",
+				"Action":   []string{"s3:PutObject", "s3:GetObject", "s3:GetObjectTagging", "s3:PutObjectTagging"},
+				"Resource": objects,
+			},
+			map[string]interface{}{
+				"Sid":       "KmsctlEncryptFiles",
+				"Effect":    "Allow",
+				"Action":    []string{"kms:GenerateDataKey", "kms:Decrypt"},
+				"Resource":  keyArn,
+				"Condition": kmsCondition,
+			})
+	}
+
+	return map[string]interface{}{
+		"Version":   "2012-10-17",
+		"Statement": statements,
+	}
+}
@@ -0,0 +1,35 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/urfave/cli"
+)
+
+//
+// newImportCommand creates the command to import secrets from another store into the bucket
+//
+func newImportCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "import",
+		Usage: "import the secrets from another secrets store into the bucket",
+		Subcommands: []cli.Command{
+			newImportSSMCommand(cmd),
+			newImportSecretsManagerCommand(cmd),
+			newImportVaultCommand(cmd),
+		},
+	}
+}
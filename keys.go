@@ -16,28 +16,41 @@ limitations under the License.
 package main
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
 	"strings"
-	"errors"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/kms"
 	"github.com/urfave/cli"
-	"github.com/aws/aws-sdk-go/aws"
 )
 
 var errKmsNotFound = errors.New("kms alias does not exist")
 
+var keyIDRegex = regexp.MustCompile(`^([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}|mrk-[0-9a-f]{32})$`)
+
 //
 // newKMSCommand creates a new list kms key command
 //
 func newKMSCommand(cmd *cliCommand) cli.Command {
 	return cli.Command{
-		Name:      "kms",
-		Usage:     "provides the ability to create, list and delete kms keys",
+		Name:  "kms",
+		Usage: "provides the ability to create, list and delete kms keys",
 		Subcommands: []cli.Command{
 			{
 				Name:  "ls, list",
 				Usage: "retrieve a listing of all the kms within the specified region",
+				Flags: regionFlags(),
 				Action: func(cx *cli.Context) error {
 					return handleCommand(cx, []string{}, cmd, listKeys)
 				},
@@ -54,9 +67,27 @@ func newKMSCommand(cmd *cliCommand) cli.Command {
 						Name:  "d, description",
 						Usage: "the description of the kms key you wish to create `DESCRIPTION`",
 					},
+					cli.StringFlag{
+						Name:  "key-spec",
+						Usage: "the type of key to create, i.e. SYMMETRIC_DEFAULT, RSA_2048, ECC_NIST_P256, HMAC_256 `SPEC`",
+						Value: kms.KeySpecSymmetricDefault,
+					},
+					cli.StringFlag{
+						Name:  "key-usage",
+						Usage: "the operations the key is used for, ENCRYPT_DECRYPT, SIGN_VERIFY or GENERATE_VERIFY_MAC, defaults from the spec `USAGE`",
+					},
+					cli.StringFlag{
+						Name:  "origin",
+						Usage: "the source of the key material, AWS_KMS or EXTERNAL when importing your own material `ORIGIN`",
+						Value: kms.OriginTypeAwsKms,
+					},
+					cli.StringFlag{
+						Name:  "custom-key-store-id",
+						Usage: "create the key in the custom key store (cloudhsm) with this id `ID`",
+					},
 				},
 				Action: func(cx *cli.Context) error {
-					return handleCommand(cx, []string{"l:name:s","l:description:s"}, cmd, createKey)
+					return handleCommand(cx, []string{"l:name:s", "l:description:s"}, cmd, createKey)
 				},
 			},
 			{
@@ -72,11 +103,90 @@ func newKMSCommand(cmd *cliCommand) cli.Command {
 						Name:  "schedule-deletion",
 						Usage: "indicates if you wish to schedule the key for deletion `BOOL`",
 					},
+					cli.BoolFlag{
+						Name:  "y, yes",
+						Usage: "do not prompt for confirmation before deleting",
+					},
 				},
 				Action: func(cx *cli.Context) error {
 					return handleCommand(cx, []string{"l:name:s"}, cmd, deleteKey)
 				},
 			},
+			{
+				Name:  "generate-data-key",
+				Usage: "generate a data key for envelope encryption, returning the plaintext and encrypted copy",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "n, name",
+						Usage: "the name, alias or id of the kms key used to encrypt the data key `NAME`",
+					},
+					cli.StringFlag{
+						Name:  "key-spec",
+						Usage: "the length of the data key, either AES_256 or AES_128 `SPEC`",
+						Value: kms.DataKeySpecAes256,
+					},
+					cli.BoolFlag{
+						Name:  "without-plaintext",
+						Usage: "only return the encrypted copy of the data key",
+					},
+					cli.StringFlag{
+						Name:  "e, encoding",
+						Usage: "the encoding of the keys, base64 or raw; raw requires the keys be written to files `ENCODING`",
+						Value: "base64",
+					},
+					cli.StringFlag{
+						Name:  "plaintext-file",
+						Usage: "write the plaintext data key to this file rather than the output `PATH`",
+					},
+					cli.StringFlag{
+						Name:  "ciphertext-file",
+						Usage: "write the encrypted data key to this file rather than the output `PATH`",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:name:s"}, cmd, generateDataKey)
+				},
+			},
+			{
+				Name:  "random",
+				Usage: "generate cryptographically secure random bytes from kms",
+				Flags: []cli.Flag{
+					cli.IntFlag{
+						Name:  "b, bytes",
+						Usage: "the number of random bytes to generate, between 1 and 1024 `BYTES`",
+						Value: 32,
+					},
+					cli.StringFlag{
+						Name:  "e, encoding",
+						Usage: "the encoding of the random bytes, base64, hex or raw `ENCODING`",
+						Value: "base64",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{}, cmd, generateRandom)
+				},
+			},
+			{
+				Name:      "import",
+				Usage:     "import your own key material into a key created with an EXTERNAL origin",
+				ArgsUsage: "ALIAS",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "m, material",
+						Usage: "the path to the file containing the raw key material `PATH`",
+					},
+					cli.StringFlag{
+						Name:  "expires",
+						Usage: "when the key material expires, a RFC3339 timestamp or duration i.e. 90d, default never `EXPIRES`",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:material:s"}, cmd, importKeyMaterial)
+				},
+			},
+			newKMSAliasCommand(cmd),
+			newKMSKeyStoresCommand(cmd),
+			newKMSUsageCommand(cmd),
 		},
 		Action: func(cx *cli.Context) error {
 			return handleCommand(cx, []string{}, cmd, listKeys)
@@ -88,6 +198,28 @@ func newKMSCommand(cmd *cliCommand) cli.Command {
 // listKeys provides a listing of kms keys available
 //
 func listKeys(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	regions, err := cmd.selectedRegions(cx, "kms")
+	if err != nil {
+		return err
+	}
+	if len(regions) <= 0 {
+		return listRegionKeys(o, cmd, "")
+	}
+
+	// step: fan out across the regions, with the region prefixed to the listing
+	for _, region := range regions {
+		if err := listRegionKeys(o, cmd.forRegion(region), region); err != nil {
+			regionWarning(region, err)
+		}
+	}
+
+	return nil
+}
+
+//
+// listRegionKeys lists the kms keys in the region of the command, prefixing the region if set
+//
+func listRegionKeys(o *formatter, cmd *cliCommand, region string) error {
 	// step: retrieve the keys from kms
 	keys, err := cmd.kmsKeys()
 	if err != nil {
@@ -100,10 +232,37 @@ func listKeys(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 		if k.TargetKeyId == nil {
 			continue
 		}
+		// step: retrieve the key spec and usage, a key we cannot describe is still listed
+		spec, usage := "unknown", "unknown"
+		resp, err := cmd.kmsClient.DescribeKeyWithContext(cmd.ctx, &kms.DescribeKeyInput{
+			KeyId: k.TargetKeyId,
+		})
+		if err != nil {
+			if cmd.ctx.Err() != nil {
+				return cmd.ctx.Err()
+			}
+			logger.warningf("unable to describe the kms key: %s, error: %s", *k.AliasName, err)
+		} else {
+			spec = aws.StringValue(resp.KeyMetadata.KeySpec)
+			usage = aws.StringValue(resp.KeyMetadata.KeyUsage)
+		}
+
+		if region != "" {
+			o.fields(map[string]interface{}{
+				"region": region,
+				"id":     *k.TargetKeyId,
+				"alias":  *k.AliasName,
+				"spec":   spec,
+				"usage":  usage,
+			}).log("%-16s %-40s %-18s %-20s %s\n", region, *k.TargetKeyId, spec, usage, *k.AliasName)
+			continue
+		}
 		o.fields(map[string]interface{}{
 			"id":    *k.TargetKeyId,
 			"alias": *k.AliasName,
-		}).log("%-40s %-24s\n", *k.TargetKeyId, *k.AliasName)
+			"spec":  spec,
+			"usage": usage,
+		}).log("%-40s %-18s %-20s %s\n", *k.TargetKeyId, spec, usage, *k.AliasName)
 	}
 
 	return nil
@@ -115,8 +274,36 @@ func listKeys(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 func createKey(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 	name := cx.String("name")
 	description := cx.String("description")
+	keySpec := strings.ToUpper(cx.String("key-spec"))
+	keyUsage := strings.ToUpper(cx.String("key-usage"))
+	origin := strings.ToUpper(cx.String("origin"))
+	keyStoreID := cx.String("custom-key-store-id")
 	aliasName := fmt.Sprintf("alias/%s", name)
 
+	// step: validate the key spec and usage
+	if !isValidOption(keySpec, kms.KeySpec_Values()) {
+		return fmt.Errorf("invalid key spec: %s, must be one of %s", keySpec, strings.Join(kms.KeySpec_Values(), ", "))
+	}
+	if keyUsage == "" {
+		keyUsage = defaultKeyUsage(keySpec)
+	}
+	if !isValidOption(keyUsage, kms.KeyUsageType_Values()) {
+		return fmt.Errorf("invalid key usage: %s, must be one of %s", keyUsage, strings.Join(kms.KeyUsageType_Values(), ", "))
+	}
+	if keyStoreID != "" {
+		// step: keys in a custom key store must originate from the cloudhsm cluster
+		if cx.IsSet("origin") && origin != kms.OriginTypeAwsCloudhsm {
+			return fmt.Errorf("keys within a custom key store must have an origin of %s", kms.OriginTypeAwsCloudhsm)
+		}
+		if keySpec != kms.KeySpecSymmetricDefault {
+			return fmt.Errorf("custom key stores only support %s keys", kms.KeySpecSymmetricDefault)
+		}
+		origin = kms.OriginTypeAwsCloudhsm
+	}
+	if !isValidOption(origin, []string{kms.OriginTypeAwsKms, kms.OriginTypeExternal, kms.OriginTypeAwsCloudhsm}) {
+		return fmt.Errorf("invalid origin: %s, must be AWS_KMS, EXTERNAL or AWS_CLOUDHSM", origin)
+	}
+
 	// step: check if a key already exists
 	exists, err := cmd.hasKmsAlias(name)
 	if err != nil {
@@ -124,7 +311,7 @@ func createKey(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 	}
 	if exists {
 		o.fields(map[string]interface{}{
-			"alias":    name,
+			"alias": name,
 		}).log("a key alias already exists with this name: %s\n", name)
 
 		return nil
@@ -132,32 +319,57 @@ func createKey(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 
 	// step: create the key creation input
 	input := &kms.CreateKeyInput{
-		Description: 	aws.String(description),
-		Origin: 	aws.String("AWS_KMS"),
+		Description: aws.String(description),
+		KeySpec:     aws.String(keySpec),
+		KeyUsage:    aws.String(keyUsage),
+		Origin:      aws.String(origin),
+	}
+	if keyStoreID != "" {
+		input.CustomKeyStoreId = aws.String(keyStoreID)
 	}
-	resp, err := cmd.kmsClient.CreateKey(input)
+	resp, err := cmd.kmsClient.CreateKeyWithContext(cmd.ctx, input)
 	if err != nil {
 		return err
 	}
 
 	// step: create the alias for the key
-	_, err = cmd.kmsClient.CreateAlias(&kms.CreateAliasInput{
-		AliasName: 	aws.String(aliasName),
-		TargetKeyId: 	resp.KeyMetadata.Arn,
+	_, err = cmd.kmsClient.CreateAliasWithContext(cmd.ctx, &kms.CreateAliasInput{
+		AliasName:   aws.String(aliasName),
+		TargetKeyId: resp.KeyMetadata.Arn,
 	})
 	if err != nil {
 		return err
 	}
 
 	o.fields(map[string]interface{}{
-		"alias":    name,
-		"arn":	    *resp.KeyMetadata.Arn,
-		"account":  *resp.KeyMetadata.AWSAccountId,
+		"alias":   name,
+		"arn":     *resp.KeyMetadata.Arn,
+		"account": *resp.KeyMetadata.AWSAccountId,
+		"spec":    keySpec,
+		"usage":   keyUsage,
+		"origin":  origin,
 	}).log("successfully create the key: %s\n", name)
+	if origin == kms.OriginTypeExternal {
+		o.log("the key is pending the import of key material, see kms import\n")
+	}
 
 	return nil
 }
 
+//
+// defaultKeyUsage returns the key usage implied by the key spec
+//
+func defaultKeyUsage(spec string) string {
+	switch {
+	case strings.HasPrefix(spec, "HMAC_"):
+		return kms.KeyUsageTypeGenerateVerifyMac
+	case strings.HasPrefix(spec, "ECC_"):
+		return kms.KeyUsageTypeSignVerify
+	}
+
+	return kms.KeyUsageTypeEncryptDecrypt
+}
+
 //
 // deleteKey removes a kms key
 //
@@ -170,8 +382,16 @@ func deleteKey(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 	if err != nil {
 		return err
 	}
+	// step: confirm the deletion
+	summary := fmt.Sprintf("this will delete the alias: %s (key: %s)", *alias.AliasName, aws.StringValue(alias.TargetKeyId))
+	if deletion {
+		summary += " and schedule the key for deletion in 7 days"
+	}
+	if err := confirm(cx, "%s", summary); err != nil {
+		return err
+	}
 	// step: attempt to remove the alias
-	if _, err = cmd.kmsClient.DeleteAlias(&kms.DeleteAliasInput{
+	if _, err = cmd.kmsClient.DeleteAliasWithContext(cmd.ctx, &kms.DeleteAliasInput{
 		AliasName: alias.AliasName,
 	}); err != nil {
 		return err
@@ -179,23 +399,227 @@ func deleteKey(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 	// step: are we deleting the key?
 	if deletion {
 		// step: attempt to schedule to the removal of the key
-		if _, err = cmd.kmsClient.ScheduleKeyDeletion(&kms.ScheduleKeyDeletionInput{
-			KeyId: 			aws.String(*alias.TargetKeyId),
-			PendingWindowInDays: 	aws.Int64(7),
+		if _, err = cmd.kmsClient.ScheduleKeyDeletionWithContext(cmd.ctx, &kms.ScheduleKeyDeletionInput{
+			KeyId:               aws.String(*alias.TargetKeyId),
+			PendingWindowInDays: aws.Int64(7),
 		}); err != nil {
 			return err
 		}
 	}
 
 	o.fields(map[string]interface{}{
-		"alias":	*alias.AliasName,
-		"keyId":	*alias.TargetKeyId,
-		"deletion":	deletion,
+		"alias":    *alias.AliasName,
+		"keyId":    *alias.TargetKeyId,
+		"deletion": deletion,
 	}).log("successfully deleted the kms key: %s\n", name)
 
 	return nil
 }
 
+//
+// importKeyMaterial wraps and imports external key material into the key
+//
+func importKeyMaterial(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	if len(cx.Args()) != 1 {
+		return fmt.Errorf("you must specify the alias of the key to import the material into")
+	}
+	name := cx.Args().First()
+
+	// step: read in the key material
+	material, err := ioutil.ReadFile(cx.String("material"))
+	if err != nil {
+		return err
+	}
+
+	// step: determine the expiration of the material
+	input := &kms.ImportKeyMaterialInput{
+		KeyId:           aws.String(kmsKeyID(name)),
+		ExpirationModel: aws.String(kms.ExpirationModelTypeKeyMaterialDoesNotExpire),
+	}
+	if expires := cx.String("expires"); expires != "" {
+		validTo, err := time.Parse(time.RFC3339, expires)
+		if err != nil {
+			duration, err := parseDuration(expires)
+			if err != nil {
+				return fmt.Errorf("invalid expiry: %s, must be a RFC3339 timestamp or duration", expires)
+			}
+			validTo = time.Now().Add(duration)
+		}
+		input.ExpirationModel = aws.String(kms.ExpirationModelTypeKeyMaterialExpires)
+		input.ValidTo = aws.Time(validTo)
+	}
+
+	// step: retrieve the wrapping key and import token
+	params, err := cmd.kmsClient.GetParametersForImportWithContext(cmd.ctx, &kms.GetParametersForImportInput{
+		KeyId:             aws.String(kmsKeyID(name)),
+		WrappingAlgorithm: aws.String(kms.AlgorithmSpecRsaesOaepSha256),
+		WrappingKeySpec:   aws.String(kms.WrappingKeySpecRsa2048),
+	})
+	if err != nil {
+		return err
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(params.PublicKey)
+	if err != nil {
+		return fmt.Errorf("unable to parse the wrapping key, error: %s", err)
+	}
+	wrappingKey, ok := publicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("the wrapping key is not a rsa public key")
+	}
+
+	// step: wrap the key material and import
+	encrypted, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, wrappingKey, material, nil)
+	if err != nil {
+		return fmt.Errorf("unable to wrap the key material, error: %s", err)
+	}
+	input.EncryptedKeyMaterial = encrypted
+	input.ImportToken = params.ImportToken
+
+	if _, err := cmd.kmsClient.ImportKeyMaterialWithContext(cmd.ctx, input); err != nil {
+		return err
+	}
+
+	fields := map[string]interface{}{
+		"action": "import",
+		"id":     aws.StringValue(params.KeyId),
+		"model":  aws.StringValue(input.ExpirationModel),
+	}
+	if input.ValidTo != nil {
+		fields["expires"] = input.ValidTo.Format(time.RFC3339)
+	}
+	o.fields(fields).log("successfully imported the key material into: %s\n", name)
+
+	return nil
+}
+
+//
+// generateDataKey generates a data key under the kms key for envelope encryption
+//
+func generateDataKey(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	name := cx.String("name")
+	keySpec := strings.ToUpper(cx.String("key-spec"))
+	withoutPlaintext := cx.Bool("without-plaintext")
+	encoding := cx.String("encoding")
+	plaintextFile := cx.String("plaintext-file")
+	ciphertextFile := cx.String("ciphertext-file")
+
+	// step: validate the options
+	if !isValidOption(keySpec, kms.DataKeySpec_Values()) {
+		return fmt.Errorf("invalid key spec: %s, must be one of %s", keySpec, strings.Join(kms.DataKeySpec_Values(), ", "))
+	}
+	switch encoding {
+	case "base64":
+	case "raw":
+		if ciphertextFile == "" || (!withoutPlaintext && plaintextFile == "") {
+			return fmt.Errorf("raw encoding requires the keys are written to files, see --plaintext-file and --ciphertext-file")
+		}
+	default:
+		return fmt.Errorf("unsupported encoding: %s, must be base64 or raw", encoding)
+	}
+
+	// step: generate the data key
+	var plaintext, ciphertext []byte
+	var keyID string
+	if withoutPlaintext {
+		resp, err := cmd.kmsClient.GenerateDataKeyWithoutPlaintextWithContext(cmd.ctx, &kms.GenerateDataKeyWithoutPlaintextInput{
+			KeyId:   aws.String(kmsKeyID(name)),
+			KeySpec: aws.String(keySpec),
+		})
+		if err != nil {
+			return err
+		}
+		ciphertext, keyID = resp.CiphertextBlob, aws.StringValue(resp.KeyId)
+	} else {
+		resp, err := cmd.kmsClient.GenerateDataKeyWithContext(cmd.ctx, &kms.GenerateDataKeyInput{
+			KeyId:   aws.String(kmsKeyID(name)),
+			KeySpec: aws.String(keySpec),
+		})
+		if err != nil {
+			return err
+		}
+		plaintext, ciphertext, keyID = resp.Plaintext, resp.CiphertextBlob, aws.StringValue(resp.KeyId)
+	}
+
+	// step: write the keys to disk if requested
+	fields := map[string]interface{}{
+		"key-id": keyID,
+		"spec":   keySpec,
+	}
+	var lines []string
+	for _, x := range []struct {
+		name    string
+		path    string
+		content []byte
+	}{
+		{name: "plaintext", path: plaintextFile, content: plaintext},
+		{name: "ciphertext", path: ciphertextFile, content: ciphertext},
+	} {
+		if x.content == nil {
+			continue
+		}
+		if x.path == "" {
+			fields[x.name] = base64.StdEncoding.EncodeToString(x.content)
+			lines = append(lines, fmt.Sprintf("%-11s %s\n", x.name+":", fields[x.name]))
+			continue
+		}
+		content := x.content
+		if encoding == "base64" {
+			content = []byte(base64.StdEncoding.EncodeToString(x.content))
+		}
+		if err := ioutil.WriteFile(x.path, content, 0600); err != nil {
+			return err
+		}
+		fields[x.name+"-file"] = x.path
+		lines = append(lines, fmt.Sprintf("%-11s written to %s\n", x.name+":", x.path))
+	}
+
+	o.fields(fields).log("%s", strings.Join(lines, ""))
+
+	return nil
+}
+
+//
+// generateRandom produces random bytes via the kms service
+//
+func generateRandom(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	size := cx.Int("bytes")
+	encoding := cx.String("encoding")
+
+	if size < 1 || size > 1024 {
+		return fmt.Errorf("the number of bytes must be between 1 and 1024")
+	}
+	if !isValidOption(encoding, []string{"base64", "hex", "raw"}) {
+		return fmt.Errorf("unsupported encoding: %s, must be base64, hex or raw", encoding)
+	}
+
+	resp, err := cmd.kmsClient.GenerateRandomWithContext(cmd.ctx, &kms.GenerateRandomInput{
+		NumberOfBytes: aws.Int64(int64(size)),
+	})
+	if err != nil {
+		return err
+	}
+
+	// step: raw bytes are written as is, handy for piping
+	var encoded string
+	switch encoding {
+	case "raw":
+		_, err := os.Stdout.Write(resp.Plaintext)
+		return err
+	case "hex":
+		encoded = hex.EncodeToString(resp.Plaintext)
+	default:
+		encoded = base64.StdEncoding.EncodeToString(resp.Plaintext)
+	}
+
+	o.fields(map[string]interface{}{
+		"bytes":    size,
+		"encoding": encoding,
+		"random":   encoded,
+	}).log("%s\n", encoded)
+
+	return nil
+}
+
 //
 // hasKmsAlias checks to see if an alias already exists
 //
@@ -222,7 +646,7 @@ func (r *cliCommand) getKmsAlias(name string) (*kms.AliasListEntry, error) {
 
 	var alias *kms.AliasListEntry
 	for _, x := range aliases {
-		if strings.TrimLeft(*x.AliasName, "alias/") == name {
+		if *x.AliasName == aliasName(name) {
 			alias = x
 			break
 		}
@@ -234,14 +658,33 @@ func (r *cliCommand) getKmsAlias(name string) (*kms.AliasListEntry, error) {
 	return alias, err
 }
 
+//
+// kmsKeyID converts a key name into an identifier kms will accept, i.e. an alias, arn or key id
+//
+func kmsKeyID(name string) string {
+	switch {
+	case strings.HasPrefix(name, "alias/"):
+	case strings.HasPrefix(name, "arn:"):
+	case keyIDRegex.MatchString(name):
+	default:
+		return "alias/" + name
+	}
+
+	return name
+}
+
 //
 // kmsKeys retrieves the kms keys from aws
 //
 func (r *cliCommand) kmsKeys() ([]*kms.AliasListEntry, error) {
-	resp, err := r.kmsClient.ListAliases(&kms.ListAliasesInput{})
+	var list []*kms.AliasListEntry
+	err := r.kmsClient.ListAliasesPagesWithContext(r.ctx, &kms.ListAliasesInput{}, func(page *kms.ListAliasesOutput, last bool) bool {
+		list = append(list, page.Aliases...)
+		return true
+	})
 	if err != nil {
 		return []*kms.AliasListEntry{}, err
 	}
 
-	return resp.Aliases, nil
+	return list, nil
 }
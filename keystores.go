@@ -0,0 +1,73 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/urfave/cli"
+)
+
+//
+// newKMSKeyStoresCommand creates the custom key store commands
+//
+func newKMSKeyStoresCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "keystores",
+		Usage: "provides a listing of the custom key stores i.e. cloudhsm backed",
+		Subcommands: []cli.Command{
+			{
+				Name:  "ls, list",
+				Usage: "retrieve a listing of the custom key stores within the specified region",
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{}, cmd, listKeyStores)
+				},
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{}, cmd, listKeyStores)
+		},
+	}
+}
+
+//
+// listKeyStores provides a listing of the custom key stores
+//
+func listKeyStores(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	var list []*kms.CustomKeyStoresListEntry
+	err := cmd.kmsClient.DescribeCustomKeyStoresPagesWithContext(cmd.ctx, &kms.DescribeCustomKeyStoresInput{}, func(page *kms.DescribeCustomKeyStoresOutput, last bool) bool {
+		list = append(list, page.CustomKeyStores...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, x := range list {
+		o.fields(map[string]interface{}{
+			"id":      aws.StringValue(x.CustomKeyStoreId),
+			"name":    aws.StringValue(x.CustomKeyStoreName),
+			"type":    aws.StringValue(x.CustomKeyStoreType),
+			"cluster": aws.StringValue(x.CloudHsmClusterId),
+			"state":   aws.StringValue(x.ConnectionState),
+			"error":   aws.StringValue(x.ConnectionErrorCode),
+		}).log("%-24s %-30s %-20s %-24s %s\n",
+			aws.StringValue(x.CustomKeyStoreId), aws.StringValue(x.CustomKeyStoreName),
+			aws.StringValue(x.CustomKeyStoreType), aws.StringValue(x.CloudHsmClusterId), aws.StringValue(x.ConnectionState))
+	}
+
+	return nil
+}
@@ -0,0 +1,261 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/urfave/cli"
+)
+
+//
+// keyCalls are the calls made with the kms key recorded by cloudtrail
+//
+type keyCalls struct {
+	// the number of calls by the name of the event
+	events map[string]int
+	// the number of calls by the identity
+	identities map[string]int
+	// the time the key was last used
+	last time.Time
+	// the number of events searched
+	scanned int
+}
+
+//
+// newKMSUsageCommand creates a new kms usage command
+//
+func newKMSUsageCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:      "usage",
+		Usage:     "report the files in the buckets encrypted with the kms key and the calls made with it, i.e. before deleting it",
+		ArgsUsage: "ALIAS",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "buckets",
+				Usage: "a comma separated list of the s3 buckets to search for files encrypted with the key `NAMES`",
+			},
+			cli.StringFlag{
+				Name:  "since",
+				Usage: "search cloudtrail for the calls within this duration, at most 90d `DURATION`",
+				Value: "30d",
+			},
+			cli.IntFlag{
+				Name:  "max-events",
+				Usage: "the maximum number of cloudtrail events to search through, zero for no limit",
+				Value: 10000,
+			},
+			cli.BoolFlag{
+				Name:  "no-cloudtrail",
+				Usage: "do not search cloudtrail for the calls made with the key",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{}, cmd, keyUsage)
+		},
+	}
+}
+
+//
+// keyUsage reports the files in the buckets referencing the kms key, the buckets encrypting with it by
+// default and the calls made with it according to cloudtrail
+//
+func keyUsage(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	if len(cx.Args()) != 1 {
+		return newExitError(exitUsage, "you must specify the alias, id or arn of the kms key")
+	}
+	since, err := parseDuration(cx.String("since"))
+	if err != nil {
+		return newExitError(exitUsage, "invalid since: %s, error: %s", cx.String("since"), err)
+	}
+	metadata, err := cmd.describeKey(cx.Args()[0])
+	if err != nil {
+		return err
+	}
+	arn := aws.StringValue(metadata.Arn)
+
+	// step: count the files in the buckets encrypted with the key
+	var references int
+	for _, bucket := range strings.Split(cx.String("buckets"), ",") {
+		if bucket = strings.TrimSpace(bucket); bucket == "" {
+			continue
+		}
+		files, isDefault, err := cmd.bucketKeyUsage(bucket, arn)
+		if err != nil {
+			logger.warningf("skipping the bucket: %s, error: %s", bucket, err)
+			continue
+		}
+		references += files
+		o.fields(map[string]interface{}{
+			"action":  "usage",
+			"kms-key": arn,
+			"bucket":  bucket,
+			"files":   files,
+			"default": isDefault,
+		}).log("%-42s %d files, default encryption: %t\n", bucket, files, isDefault)
+		if isDefault {
+			references++
+		}
+	}
+
+	// step: count the calls made with the key
+	var calls int
+	if !cx.Bool("no-cloudtrail") {
+		usage, err := cmd.keyCalls(arn, time.Now().Add(-since), cx.Int("max-events"))
+		if err != nil {
+			return err
+		}
+		var names []string
+		for x := range usage.events {
+			names = append(names, x)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			calls += usage.events[name]
+			o.fields(map[string]interface{}{
+				"action":  "usage",
+				"kms-key": arn,
+				"event":   name,
+				"calls":   usage.events[name],
+			}).log("%-42s %d calls\n", name, usage.events[name])
+		}
+		var identities []string
+		for x := range usage.identities {
+			identities = append(identities, x)
+		}
+		sort.Strings(identities)
+		for _, identity := range identities {
+			o.verbose(map[string]interface{}{
+				"action":   "usage",
+				"kms-key":  arn,
+				"identity": identity,
+				"calls":    usage.identities[identity],
+			}).log("  %s: %d calls\n", identity, usage.identities[identity])
+		}
+		if !usage.last.IsZero() {
+			o.log("last used: %s\n", usage.last.Format(time.RFC3339))
+		}
+		if maxEvents := cx.Int("max-events"); maxEvents > 0 && usage.scanned >= maxEvents {
+			logger.warningf("stopped after searching %d events, the key may have been used more, use --max-events", usage.scanned)
+		}
+	}
+
+	if references <= 0 && calls <= 0 {
+		o.fields(map[string]interface{}{
+			"action":  "usage",
+			"kms-key": arn,
+			"unused":  true,
+		}).log("the key: %s is not referenced by the buckets searched and has no calls within %s\n", arn, cx.String("since"))
+	}
+
+	return nil
+}
+
+//
+// bucketKeyUsage counts the files in the bucket encrypted with the key and checks if the bucket encrypts
+// with it by default
+//
+func (r *cliCommand) bucketKeyUsage(bucket, arn string) (int, bool, error) {
+	region, err := r.getBucketRegion(bucket)
+	if err != nil {
+		return 0, false, err
+	}
+	cmd := r.forRegion(region)
+
+	files, err := cmd.listBucketKeys(bucket, "")
+	if err != nil {
+		return 0, false, err
+	}
+	var keys []string
+	for _, x := range files {
+		keys = append(keys, aws.StringValue(x.Key))
+	}
+	heads, err := cmd.headObjects(bucket, keys)
+	if err != nil {
+		return 0, false, err
+	}
+	var count int
+	for _, x := range heads {
+		if aws.StringValue(x.SSEKMSKeyId) == arn {
+			count++
+		}
+	}
+
+	// step: the default encryption may reference the key by alias, id or arn
+	var isDefault bool
+	rule, err := cmd.getBucketEncryption(bucket)
+	if err != nil {
+		return 0, false, err
+	}
+	if rule != nil && rule.ApplyServerSideEncryptionByDefault.KMSMasterKeyID != nil {
+		if metadata, err := cmd.describeKey(aws.StringValue(rule.ApplyServerSideEncryptionByDefault.KMSMasterKeyID)); err == nil {
+			isDefault = aws.StringValue(metadata.Arn) == arn
+		}
+	}
+
+	return count, isDefault, nil
+}
+
+//
+// keyCalls searches cloudtrail for the calls made with the key since the time
+//
+func (r *cliCommand) keyCalls(arn string, since time.Time, maxEvents int) (*keyCalls, error) {
+	usage := &keyCalls{
+		events:     make(map[string]int, 0),
+		identities: make(map[string]int, 0),
+	}
+	// note: cloudtrail only retains the events for ninety days
+	if limit := time.Now().Add(-90 * 24 * time.Hour); since.Before(limit) {
+		since = limit
+	}
+	client := cloudtrail.New(r.session)
+
+	err := client.LookupEventsPagesWithContext(r.ctx, &cloudtrail.LookupEventsInput{
+		LookupAttributes: []*cloudtrail.LookupAttribute{
+			{
+				AttributeKey:   aws.String(cloudtrail.LookupAttributeKeyResourceName),
+				AttributeValue: aws.String(arn),
+			},
+		},
+		StartTime: aws.Time(since),
+		EndTime:   aws.Time(time.Now()),
+	}, func(page *cloudtrail.LookupEventsOutput, lastPage bool) bool {
+		for _, x := range page.Events {
+			usage.scanned++
+			event := new(trailEvent)
+			if err := json.Unmarshal([]byte(aws.StringValue(x.CloudTrailEvent)), event); err != nil {
+				continue
+			}
+			usage.events[event.EventName]++
+			usage.identities[defaultValue(event.UserIdentity.ARN, defaultValue(event.UserIdentity.InvokedBy, event.UserIdentity.PrincipalID))]++
+			if event.EventTime.After(usage.last) {
+				usage.last = event.EventTime
+			}
+		}
+
+		return maxEvents <= 0 || usage.scanned < maxEvents
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,271 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v2"
+)
+
+//
+// lifecycleRule is a simplified lifecycle rule
+//
+type lifecycleRule struct {
+	// the id of the rule
+	ID string `yaml:"id" json:"id"`
+	// the prefix the rule applies to
+	Prefix string `yaml:"prefix" json:"prefix"`
+	// the number of days after which files are expired
+	ExpireDays int64 `yaml:"expire-days" json:"expire-days"`
+	// the number of days after which noncurrent versions are expired
+	ExpireNoncurrentDays int64 `yaml:"expire-noncurrent-days" json:"expire-noncurrent-days"`
+	// the number of noncurrent versions to retain regardless of age
+	RetainNoncurrentVersions int64 `yaml:"retain-noncurrent-versions" json:"retain-noncurrent-versions"`
+	// the number of days after which incomplete multipart uploads are aborted
+	AbortMultipartDays int64 `yaml:"abort-multipart-days" json:"abort-multipart-days"`
+	// indicates the rule is disabled
+	Disabled bool `yaml:"disabled" json:"disabled"`
+}
+
+//
+// lifecycleRules is the file format accepted by lifecycle set
+//
+type lifecycleRules struct {
+	Rules []*lifecycleRule `yaml:"rules" json:"rules"`
+}
+
+//
+// newBucketLifecycleCommand creates the bucket lifecycle commands
+//
+func newBucketLifecycleCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "lifecycle",
+		Usage: "manage the lifecycle rules of the bucket, i.e. expiring old versions of files",
+		Subcommands: []cli.Command{
+			{
+				Name:  "get",
+				Usage: "retrieve the lifecycle rules of the bucket",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "b, bucket",
+						Usage:  "the name of the bucket `NAME`",
+						EnvVar: "AWS_S3_BUCKET",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:bucket:s"}, cmd, getBucketLifecycle)
+				},
+			},
+			{
+				Name:  "set",
+				Usage: "replace the lifecycle rules of the bucket from a file (json or yaml) or a single rule from the options",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "b, bucket",
+						Usage:  "the name of the bucket `NAME`",
+						EnvVar: "AWS_S3_BUCKET",
+					},
+					cli.StringFlag{
+						Name:  "f, file",
+						Usage: "the path to a json or yaml file containing the rules `PATH`",
+					},
+					cli.StringFlag{
+						Name:  "id",
+						Usage: "the id of the rule `ID`",
+						Value: "kmsctl",
+					},
+					cli.StringFlag{
+						Name:  "prefix",
+						Usage: "the prefix within the bucket the rule applies to `PREFIX`",
+					},
+					cli.Int64Flag{
+						Name:  "expire-days",
+						Usage: "expire the current version of files after this many days `DAYS`",
+					},
+					cli.Int64Flag{
+						Name:  "expire-noncurrent-days",
+						Usage: "expire noncurrent versions of files after this many days `DAYS`",
+					},
+					cli.Int64Flag{
+						Name:  "retain-noncurrent-versions",
+						Usage: "the number of noncurrent versions to retain regardless of their age `COUNT`",
+					},
+					cli.Int64Flag{
+						Name:  "abort-multipart-days",
+						Usage: "abort incomplete multipart uploads after this many days `DAYS`",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:bucket:s"}, cmd, setBucketLifecycle)
+				},
+			},
+		},
+	}
+}
+
+func getBucketLifecycle(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	name := cx.String("bucket")
+
+	resp, err := cmd.s3Client.GetBucketLifecycleConfigurationWithContext(cmd.ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(name),
+	})
+	if err != nil {
+		if e, ok := err.(awserr.Error); ok && e.Code() == "NoSuchLifecycleConfiguration" {
+			return fmt.Errorf("the bucket: %s has no lifecycle rules", name)
+		}
+		return err
+	}
+
+	for _, x := range resp.Rules {
+		rule := fromLifecycleRule(x)
+		o.fields(map[string]interface{}{
+			"bucket": name,
+			"rule":   rule,
+		}).log("%-20s prefix: %-20s expire: %-4d noncurrent: %-4d retain: %-4d abort-multipart: %-4d enabled: %t\n",
+			rule.ID, defaultValue(rule.Prefix, "/"), rule.ExpireDays, rule.ExpireNoncurrentDays,
+			rule.RetainNoncurrentVersions, rule.AbortMultipartDays, !rule.Disabled)
+	}
+
+	return nil
+}
+
+func setBucketLifecycle(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	name := cx.String("bucket")
+
+	// step: read in the rules from the file or the options
+	var rules lifecycleRules
+	if path := cx.String("file"); path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		// note: yaml is a superset of json, so this handles both
+		if err := yaml.Unmarshal(content, &rules); err != nil {
+			return fmt.Errorf("unable to parse the rules file: %s, error: %s", path, err)
+		}
+	} else {
+		rules.Rules = []*lifecycleRule{
+			{
+				ID:                       cx.String("id"),
+				Prefix:                   cx.String("prefix"),
+				ExpireDays:               cx.Int64("expire-days"),
+				ExpireNoncurrentDays:     cx.Int64("expire-noncurrent-days"),
+				RetainNoncurrentVersions: cx.Int64("retain-noncurrent-versions"),
+				AbortMultipartDays:       cx.Int64("abort-multipart-days"),
+			},
+		}
+	}
+	if len(rules.Rules) <= 0 {
+		return fmt.Errorf("no lifecycle rules have been specified")
+	}
+
+	var list []*s3.LifecycleRule
+	for _, x := range rules.Rules {
+		rule, err := x.toLifecycleRule()
+		if err != nil {
+			return err
+		}
+		list = append(list, rule)
+	}
+
+	if _, err := cmd.s3Client.PutBucketLifecycleConfigurationWithContext(cmd.ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket: aws.String(name),
+		LifecycleConfiguration: &s3.BucketLifecycleConfiguration{
+			Rules: list,
+		},
+	}); err != nil {
+		return err
+	}
+
+	o.fields(map[string]interface{}{
+		"operation": "lifecycle",
+		"bucket":    name,
+		"rules":     len(list),
+	}).log("successfully set %d lifecycle rule/s on bucket: %s\n", len(list), name)
+
+	return nil
+}
+
+//
+// toLifecycleRule converts the rule into the s3 representation
+//
+func (r *lifecycleRule) toLifecycleRule() (*s3.LifecycleRule, error) {
+	if r.ID == "" {
+		return nil, fmt.Errorf("the lifecycle rule must have an id")
+	}
+	if r.ExpireDays <= 0 && r.ExpireNoncurrentDays <= 0 && r.AbortMultipartDays <= 0 {
+		return nil, fmt.Errorf("the lifecycle rule: %s does not have any expiration", r.ID)
+	}
+
+	rule := &s3.LifecycleRule{
+		ID:     aws.String(r.ID),
+		Filter: &s3.LifecycleRuleFilter{Prefix: aws.String(r.Prefix)},
+		Status: aws.String(s3.ExpirationStatusEnabled),
+	}
+	if r.Disabled {
+		rule.Status = aws.String(s3.ExpirationStatusDisabled)
+	}
+	if r.ExpireDays > 0 {
+		rule.Expiration = &s3.LifecycleExpiration{Days: aws.Int64(r.ExpireDays)}
+	}
+	if r.ExpireNoncurrentDays > 0 {
+		rule.NoncurrentVersionExpiration = &s3.NoncurrentVersionExpiration{
+			NoncurrentDays: aws.Int64(r.ExpireNoncurrentDays),
+		}
+		if r.RetainNoncurrentVersions > 0 {
+			rule.NoncurrentVersionExpiration.NewerNoncurrentVersions = aws.Int64(r.RetainNoncurrentVersions)
+		}
+	}
+	if r.AbortMultipartDays > 0 {
+		rule.AbortIncompleteMultipartUpload = &s3.AbortIncompleteMultipartUpload{
+			DaysAfterInitiation: aws.Int64(r.AbortMultipartDays),
+		}
+	}
+
+	return rule, nil
+}
+
+//
+// fromLifecycleRule converts the s3 representation into a simplified rule
+//
+func fromLifecycleRule(x *s3.LifecycleRule) *lifecycleRule {
+	rule := &lifecycleRule{
+		ID:       aws.StringValue(x.ID),
+		Prefix:   aws.StringValue(x.Prefix),
+		Disabled: aws.StringValue(x.Status) != s3.ExpirationStatusEnabled,
+	}
+	if x.Filter != nil && x.Filter.Prefix != nil {
+		rule.Prefix = aws.StringValue(x.Filter.Prefix)
+	}
+	if x.Expiration != nil {
+		rule.ExpireDays = aws.Int64Value(x.Expiration.Days)
+	}
+	if x.NoncurrentVersionExpiration != nil {
+		rule.ExpireNoncurrentDays = aws.Int64Value(x.NoncurrentVersionExpiration.NoncurrentDays)
+		rule.RetainNoncurrentVersions = aws.Int64Value(x.NoncurrentVersionExpiration.NewerNoncurrentVersions)
+	}
+	if x.AbortIncompleteMultipartUpload != nil {
+		rule.AbortMultipartDays = aws.Int64Value(x.AbortIncompleteMultipartUpload.DaysAfterInitiation)
+	}
+
+	return rule
+}
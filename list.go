@@ -16,9 +16,13 @@ limitations under the License.
 package main
 
 import (
+	"fmt"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/urfave/cli"
 )
 
@@ -30,7 +34,7 @@ func newListCommand(cmd *cliCommand) cli.Command {
 		Name:    "list",
 		Aliases: []string{"ls"},
 		Usage:   "providing a file listing of the files currently in there",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			cli.BoolFlag{
 				Name:  "l, long",
 				Usage: "provide a detailed / long listing of the files in the bucket",
@@ -44,9 +48,45 @@ func newListCommand(cmd *cliCommand) cli.Command {
 				Name:  "r, recursive",
 				Usage: "enable recursive option and transverse all subdirectories",
 			},
-		},
+			cli.BoolFlag{
+				Name:  "unencrypted-only",
+				Usage: "only list the files which are not encrypted with a kms key",
+			},
+			cli.StringSliceFlag{
+				Name:  "t, tag",
+				Usage: "only list the files with the tag, can be specified multiple times `KEY=VALUE`",
+			},
+			cli.StringFlag{
+				Name:  "kms-key",
+				Usage: "only list the files encrypted with the kms key, an alias, id or arn `KEY`",
+			},
+			cli.BoolFlag{
+				Name:  "by-kms-key",
+				Usage: "group the files by the kms key they are encrypted with",
+			},
+			cli.BoolFlag{
+				Name:  "dirs",
+				Usage: "list the directories under the path with the number and size of their files, rather than all the files beneath it",
+			},
+			cli.BoolFlag{
+				Name:  "summarize",
+				Usage: "display the total number and size of the files listed",
+			},
+			cli.IntFlag{
+				Name:  "max",
+				Usage: "list at most the number of keys, displaying the key to continue from with --start-after `N`",
+			},
+			cli.StringFlag{
+				Name:  "start-after",
+				Usage: "continue the listing from after the key, as displayed by a previous listing with --max `KEY`",
+			},
+			cli.IntFlag{
+				Name:  "depth",
+				Usage: "roll up the number and size of the files by the prefixes to the depth, rather than listing them `N`",
+			},
+		}, regionFlags()...),
 		Action: func(cx *cli.Context) error {
-			return handleCommand(cx, []string{"l:bucket:s"}, cmd, listFiles)
+			return handleCommand(cx, []string{}, cmd, listFiles)
 		},
 	}
 }
@@ -57,41 +97,395 @@ func newListCommand(cmd *cliCommand) cli.Command {
 func listFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 	// step: get the bucket name
 	bucket := cx.String("bucket")
+	regions, err := cmd.selectedRegions(cx, "s3")
+	if err != nil {
+		return err
+	}
+	if isAccessPoint(bucket) {
+		return listBucketFiles(o, cx, cmd, bucket, "")
+	}
+	if len(regions) <= 0 {
+		if bucket == "" {
+			return newExitError(exitUsage, "the command option: 'bucket' is required, unless listing across regions")
+		}
+
+		return listBucketFiles(o, cx, cmd, bucket, "")
+	}
+
+	// step: fan out across the buckets in the regions, or only the bucket if specified
+	buckets, locations, err := cmd.bucketsInRegions(regions)
+	if err != nil {
+		return err
+	}
+	for _, x := range buckets {
+		if bucket != "" && *x.Name != bucket {
+			continue
+		}
+		region := locations[*x.Name]
+		if err := listBucketFiles(o, cx, cmd.forRegion(region), *x.Name, region); err != nil {
+			logger.warningf("skipping the bucket: %s, error: %s", *x.Name, err)
+		}
+	}
+
+	return nil
+}
+
+//
+// listBucketFiles lists the files in the bucket, prefixing the region and bucket when the region is set
+//
+func listBucketFiles(o *formatter, cx *cli.Context, cmd *cliCommand, bucket, region string) error {
 	detailed := cx.Bool("long")
 	recursive := cx.Bool("recursive")
+	unencryptedOnly := cx.Bool("unencrypted-only")
+	byKey := cx.Bool("by-kms-key")
+	summarize := cx.Bool("summarize")
+	depth := cx.Int("depth")
+	maxKeys := cx.Int("max")
+	startAfter := cx.String("start-after")
+	paged := maxKeys > 0 || startAfter != ""
+	dirs := cx.Bool("dirs")
+	switch {
+	case dirs && (depth > 0 || byKey || paged):
+		return newExitError(exitUsage, "invalid option, --dirs cannot be used with --depth, --by-kms-key, --max or --start-after")
+	case depth < 0:
+		return newExitError(exitUsage, "invalid option, --depth must be positive")
+	case maxKeys < 0:
+		return newExitError(exitUsage, "invalid option, --max must be positive")
+	case paged && len(cx.Args()) > 1:
+		return newExitError(exitUsage, "invalid option, --max and --start-after apply to a single path")
+	case depth > 0 && byKey:
+		return newExitError(exitUsage, "invalid option, --depth and --by-kms-key are mutually exclusive")
+	case depth > 0:
+		// note: the rollups include all the files beneath the prefixes
+		recursive = true
+	}
+	filters, err := parseKeyValues(cx.StringSlice("tag"))
+	if err != nil {
+		return err
+	}
+	// step: resolve the kms key filter to the arn, as recorded on the files
+	var kmsFilter string
+	if name := cx.String("kms-key"); name != "" {
+		metadata, err := cmd.describeKey(name)
+		if err != nil {
+			return fmt.Errorf("unable to resolve the kms key: %s, error: %s", name, err)
+		}
+		kmsFilter = aws.StringValue(metadata.Arn)
+	}
+	groups := make(map[string][]string, 0)
+	rollups := make(map[string]*listRollup, 0)
+	total := &listRollup{}
 
 	// step: get the paths to iterate
+	var marker string
 	for _, p := range getPaths(cx) {
 		// step: get a list of paths down that path
-		files, err := cmd.listBucketKeys(bucket, p)
+		var files []*s3.Object
+		var directories []string
+		switch {
+		case dirs:
+			files, directories, err = cmd.listBucketDirs(bucket, p)
+		case paged:
+			files, marker, err = cmd.listBucketPage(bucket, p, startAfter, maxKeys)
+		default:
+			files, err = cmd.listBucketKeys(bucket, p)
+		}
 		if err != nil {
 			return err
 		}
+		if err := listDirectories(o, cmd, bucket, region, directories, total); err != nil {
+			return err
+		}
 
-		// step: iterate the files
+		// step: filter out anything not under the path, i.e. extract post prefix and ignore any keys which have a / in them
+		var list []*s3.Object
 		for _, k := range files {
-			// step: are we recursive? i.e. extract post prefix and ignore any keys which have a / in them
-			if strings.Contains(strings.TrimPrefix(*k.Key, p), "/") && !recursive {
+			if strings.Contains(strings.TrimPrefix(*k.Key, p), "/") && !recursive && !dirs {
+				continue
+			}
+			list = append(list, k)
+		}
+
+		var keys []string
+		for _, k := range list {
+			keys = append(keys, *k.Key)
+		}
+
+		// step: retrieve the encryption details of the files if required
+		var heads map[string]*s3.HeadObjectOutput
+		if detailed || unencryptedOnly || byKey || kmsFilter != "" {
+			if heads, err = cmd.headObjects(bucket, keys); err != nil {
+				return err
+			}
+		}
+
+		// step: retrieve the tags of the files if filtering on them
+		var tags map[string]map[string]string
+		if len(filters) > 0 {
+			if tags, err = cmd.objectTags(bucket, keys); err != nil {
+				return err
+			}
+		}
+
+		// step: iterate the files
+		var prefix string
+		if region != "" {
+			prefix = fmt.Sprintf("%-14s %-42s ", region, bucket)
+		}
+		for _, k := range list {
+			var encryption, kmsKey string
+			if head, found := heads[*k.Key]; found {
+				encryption = aws.StringValue(head.ServerSideEncryption)
+				kmsKey = aws.StringValue(head.SSEKMSKeyId)
+			}
+			if unencryptedOnly && isKMSEncrypted(encryption) {
+				continue
+			}
+			if kmsFilter != "" && kmsKey != kmsFilter {
+				continue
+			}
+			if len(filters) > 0 && !matchesTags(tags[*k.Key], filters) {
+				o.verbose(map[string]interface{}{
+					"action": "filter",
+					"bucket": bucket,
+					"key":    *k.Key,
+				}).log("skipping the file: %s, the tags do not match\n", *k.Key)
+				continue
+			}
+			total.add(k)
+			if depth > 0 {
+				name := rollupPrefix(*k.Key, depth)
+				if _, found := rollups[name]; !found {
+					rollups[name] = &listRollup{}
+				}
+				rollups[name].add(k)
+				continue
+			}
+			if byKey {
+				group := kmsKey
+				if group == "" {
+					group = defaultValue(encryption, "none")
+				}
+				groups[group] = append(groups[group], *k.Key)
 				continue
 			}
 			// step: are we performing a detailed listing?
 			switch detailed {
 			case true:
-				o.fields(map[string]interface{}{
+				o.fields(regionFields(region, bucket, map[string]interface{}{
 					"key":           *k.Key,
 					"size":          *k.Size,
 					"class":         *k.StorageClass,
 					"etag":          *k.ETag,
-					"owner":         *k.Owner,
+					"owner":         k.Owner,
 					"last-modified": k.LastModified,
-				}).log("%s %-10d %-20s %s\n", *k.Owner.DisplayName, *k.Size, (*k.LastModified).Format(time.RFC822), *k.Key)
+					"encryption":    encryption,
+					"kms-key":       kmsKey,
+				})).log("%s%s %s %-20s %s %-40s %s\n", prefix, ownerName(k.Owner), colorize(colorCyan, fmt.Sprintf("%-10d", *k.Size)),
+					(*k.LastModified).Format(time.RFC822), encryptionColumn(encryption), defaultValue(kmsKey, "-"), colorKey(*k.Key))
 			default:
-				o.fields(map[string]interface{}{
+				o.fields(regionFields(region, bucket, map[string]interface{}{
 					"key": *k.Key,
-				}).log("%s\n", *k.Key)
+				})).log("%s%s\n", prefix, colorKey(*k.Key))
 			}
 		}
 	}
+	if byKey {
+		listKeyGroups(o, cmd, bucket, region, groups)
+	}
+	if depth > 0 {
+		listRollups(o, bucket, region, rollups)
+	}
+	if summarize {
+		o.fields(regionFields(region, bucket, map[string]interface{}{
+			"action":  "summary",
+			"objects": total.count,
+			"size":    total.size,
+		})).log("\ntotal objects: %d\ntotal size: %s (%d bytes)\n", total.count, formatBytes(float64(total.size)), total.size)
+	}
+	if marker != "" {
+		o.fields(regionFields(region, bucket, map[string]interface{}{
+			"action":      "continuation",
+			"start-after": marker,
+		})).log("\nmore keys remain, continue with: --start-after %s\n", marker)
+	}
 
 	return nil
 }
+
+//
+// listDirectories lists the directories along with the number and size of the files beneath them, which
+// are included in the totals
+//
+func listDirectories(o *formatter, cmd *cliCommand, bucket, region string, directories []string, total *listRollup) error {
+	var prefix string
+	if region != "" {
+		prefix = fmt.Sprintf("%-14s %-42s ", region, bucket)
+	}
+	for _, name := range directories {
+		files, err := cmd.listBucketKeys(bucket, name)
+		if err != nil {
+			return err
+		}
+		x := &listRollup{}
+		for _, k := range files {
+			x.add(k)
+			total.add(k)
+		}
+		o.fields(regionFields(region, bucket, map[string]interface{}{
+			"directory": name,
+			"count":     x.count,
+			"size":      x.size,
+		})).log("%s%s %s (%d files, %s)\n", prefix, colorize(colorBlue, "DIR"), colorKey(name), x.count, formatBytes(float64(x.size)))
+	}
+
+	return nil
+}
+
+// listRollup is the number and size of the files under a prefix
+type listRollup struct {
+	// the number of files
+	count int
+	// the total size of the files
+	size int64
+}
+
+// add includes the file in the rollup
+func (r *listRollup) add(x *s3.Object) {
+	r.count++
+	r.size += aws.Int64Value(x.Size)
+}
+
+//
+// listRollups lists the number and size of the files by prefix, the largest first
+//
+func listRollups(o *formatter, bucket, region string, rollups map[string]*listRollup) {
+	var names []string
+	for x := range rollups {
+		names = append(names, x)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if rollups[names[i]].size != rollups[names[j]].size {
+			return rollups[names[i]].size > rollups[names[j]].size
+		}
+		return names[i] < names[j]
+	})
+
+	var prefix string
+	if region != "" {
+		prefix = fmt.Sprintf("%-14s %-42s ", region, bucket)
+	}
+	for _, name := range names {
+		x := rollups[name]
+		o.fields(regionFields(region, bucket, map[string]interface{}{
+			"prefix": name,
+			"count":  x.count,
+			"size":   x.size,
+		})).log("%s%s %10s %s\n", prefix, colorize(colorCyan, fmt.Sprintf("%8d", x.count)), formatBytes(float64(x.size)), colorKey(name))
+	}
+}
+
+// rollupPrefix returns the prefix of the key to the depth, i.e. app/prod/ of app/prod/db/password at a
+// depth of 2, the files above the depth rolling up into their directory
+func rollupPrefix(key string, depth int) string {
+	elements := strings.Split(key, "/")
+	elements = elements[:len(elements)-1]
+	if len(elements) > depth {
+		elements = elements[:depth]
+	}
+	if len(elements) <= 0 {
+		return "/"
+	}
+
+	return strings.Join(elements, "/") + "/"
+}
+
+//
+// listKeyGroups lists the files grouped by the kms key they are encrypted with, along with the aliases of
+// the keys
+//
+func listKeyGroups(o *formatter, cmd *cliCommand, bucket, region string, groups map[string][]string) {
+	aliases := make(map[string][]string, 0)
+	list, err := cmd.kmsKeys()
+	if err != nil {
+		logger.warningf("unable to retrieve the kms aliases, error: %s", err)
+	}
+	for _, x := range list {
+		if x.TargetKeyId != nil {
+			aliases[*x.TargetKeyId] = append(aliases[*x.TargetKeyId], aws.StringValue(x.AliasName))
+		}
+	}
+
+	var names []string
+	for x := range groups {
+		names = append(names, x)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		// note: the files record the arn of the key, the aliases target the id
+		names := aliases[name[strings.LastIndex(name, "/")+1:]]
+		o.fields(regionFields(region, bucket, map[string]interface{}{
+			"kms-key": name,
+			"aliases": names,
+			"count":   len(groups[name]),
+			"files":   groups[name],
+		})).log("%s (%s) %d files\n", encryptionGroup(name), defaultValue(strings.Join(names, ", "), "-"), len(groups[name]))
+		for _, x := range groups[name] {
+			o.log("  %s\n", colorKey(x))
+		}
+	}
+}
+
+// encryptionGroup returns the name of the group, highlighting the files not encrypted with kms
+func encryptionGroup(name string) string {
+	switch name {
+	case "none":
+		return colorize(colorRed, name)
+	case s3.ServerSideEncryptionAes256:
+		return colorize(colorYellow, name)
+	}
+
+	return name
+}
+
+//
+// regionFields adds the region and bucket to the fields when listing across regions
+//
+func regionFields(region, bucket string, fields map[string]interface{}) map[string]interface{} {
+	if region != "" {
+		fields["region"] = region
+		fields["bucket"] = bucket
+	}
+
+	return fields
+}
+
+// encryptionColumn returns the encryption of the file, highlighting the files not encrypted with kms
+func encryptionColumn(encryption string) string {
+	column := fmt.Sprintf("%-8s", defaultValue(encryption, "none"))
+	switch {
+	case strings.HasPrefix(encryption, s3.ServerSideEncryptionAwsKms):
+		return column
+	case encryption == "":
+		return colorize(colorRed, column)
+	}
+
+	return colorize(colorYellow, column)
+}
+
+// isKMSEncrypted checks if the file is encrypted with a kms key, including dsse; s3 managed keys are not
+func isKMSEncrypted(encryption string) bool {
+	return encryption == s3.ServerSideEncryptionAwsKms || encryption == s3.ServerSideEncryptionAwsKmsDsse
+}
+
+//
+// ownerName returns the display name of the object owner if known
+//
+func ownerName(owner *s3.Owner) string {
+	if owner == nil || owner.DisplayName == nil {
+		return "-"
+	}
+
+	return *owner.DisplayName
+}
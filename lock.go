@@ -0,0 +1,131 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// the tag marking the files which cannot be modified without --unlock
+const lockedTag = "kmsctl-locked"
+
+// unlockFlag is the option permitting the locked files to be modified
+var unlockFlag = cli.BoolFlag{
+	Name:  "unlock",
+	Usage: "permit the files locked with the lock command to be modified",
+}
+
+//
+// newLockCommands creates the lock and unlock commands
+//
+func newLockCommands(cmd *cliCommand) []cli.Command {
+	bucketFlag := cli.StringFlag{
+		Name:   "b, bucket",
+		Usage:  "the name of the s3 bucket containing the encrypted files `NAME`",
+		EnvVar: "AWS_S3_BUCKET",
+	}
+
+	return []cli.Command{
+		{
+			Name:      "lock",
+			Usage:     "protect one or more files from being overwritten or deleted without --unlock",
+			ArgsUsage: "PATH...",
+			Flags:     []cli.Flag{bucketFlag},
+			Action: func(cx *cli.Context) error {
+				return handleCommand(cx, []string{"l:bucket:s"}, cmd, lockFiles)
+			},
+		},
+		{
+			Name:      "unlock",
+			Usage:     "remove the protection from one or more files",
+			ArgsUsage: "PATH...",
+			Flags:     []cli.Flag{bucketFlag},
+			Action: func(cx *cli.Context) error {
+				return handleCommand(cx, []string{"l:bucket:s"}, cmd, lockFiles)
+			},
+		},
+	}
+}
+
+//
+// lockFiles places or removes the lock tag on the files
+//
+func lockFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	lock := cx.Command.Name == "lock"
+	if len(cx.Args()) <= 0 {
+		return newExitError(exitUsage, "you have not specified the files to %s", cx.Command.Name)
+	}
+
+	for _, x := range cx.Args() {
+		key := strings.TrimPrefix(x, "/")
+		tags, err := cmd.getObjectTags(bucket, key)
+		if err != nil {
+			return err
+		}
+		if lock {
+			tags[lockedTag] = "true"
+		} else {
+			delete(tags, lockedTag)
+		}
+		if err := cmd.putObjectTags(bucket, key, tags); err != nil {
+			return err
+		}
+		o.fields(map[string]interface{}{
+			"action": cx.Command.Name,
+			"bucket": bucket,
+			"key":    key,
+		}).log("successfully %sed the file: s3://%s/%s\n", cx.Command.Name, bucket, key)
+	}
+
+	return nil
+}
+
+//
+// checkUnlocked ensures the file is not locked before it is modified, unless --unlock was given; files
+// which do not exist are not locked, while those whose tags cannot be read are
+//
+func (r *cliCommand) checkUnlocked(bucket, key string) error {
+	// note: the trash keeps the tags of the files, but must be purged regardless
+	if r.unlocked || isTrashKey(key) {
+		return nil
+	}
+	tags, err := r.getObjectTags(bucket, key)
+	if err != nil {
+		switch exitCode(err) {
+		case exitNotFound:
+			return nil
+		case exitAccessDenied:
+			// note: the lock cannot be verified, so the file is treated as locked
+			return newExitError(exitAccessDenied, "unable to read the tags of the file: %s to check it is not locked, "+
+				"use --unlock to modify it regardless, error: %s", key, err)
+		}
+		return err
+	}
+
+	return r.checkLockTag(key, tags)
+}
+
+// checkLockTag ensures the tags of the file do not lock it, unless --unlock was given
+func (r *cliCommand) checkLockTag(key string, tags map[string]string) error {
+	if r.unlocked || isTrashKey(key) || tags[lockedTag] != "true" {
+		return nil
+	}
+
+	return newExitError(exitAccessDenied, "the file: %s is locked, use --unlock to modify it", key)
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logDebug = iota
+	logInfo
+	logWarning
+	logError
+)
+
+var (
+	// the names of the log levels, indexed by level
+	logLevels = []string{"debug", "info", "warning", "error"}
+	// the signed query parameters of the presigned urls, i.e. the signature and session token
+	signedParameters = regexp.MustCompile(`(?i)(X-Amz-(?:Signature|Security-Token|Credential)=)[^&\s"]+`)
+	// the formats of the logs
+	logFormats = []string{"text", "json"}
+	// the colors of the log levels, indexed by level
+	logColors = []string{colorGray, "", colorYellow, colorRed}
+)
+
+//
+// logWriter writes the operational logs, keeping them apart from the output of the commands
+//
+type logWriter struct {
+	sync.Mutex
+	// the writer the logs are written to
+	writer io.Writer
+	// the minimum level logged
+	level int
+	// the format of the logs, text or json
+	format string
+	// the sensitive values masked in the logs
+	redactions []string
+	// the levels are colorized
+	color bool
+}
+
+// logger is the logger of the operational messages, written to the stderr
+var logger = &logWriter{writer: os.Stderr, level: logInfo, format: "text"}
+
+//
+// configure sets the level and format of the logs
+//
+func (l *logWriter) configure(level, format string) error {
+	if !isValidOption(level, logLevels) {
+		return fmt.Errorf("invalid log level: %s, must be one of %s", level, strings.Join(logLevels, ", "))
+	}
+	if !isValidOption(format, logFormats) {
+		return fmt.Errorf("invalid log format: %s, must be one of %s", format, strings.Join(logFormats, ", "))
+	}
+	l.Lock()
+	defer l.Unlock()
+	for i, x := range logLevels {
+		if x == level {
+			l.level = i
+		}
+	}
+	l.format = format
+
+	return nil
+}
+
+//
+// redact registers the sensitive values, i.e. credentials and tokens, masked wherever they appear in the
+// logs; values too short to be meaningful are ignored
+//
+func (l *logWriter) redact(values ...string) {
+	l.Lock()
+	defer l.Unlock()
+	for _, x := range values {
+		if len(x) >= 8 && !isValidOption(x, l.redactions) {
+			l.redactions = append(l.redactions, x)
+		}
+	}
+}
+
+// debugf logs a debug message
+func (l *logWriter) debugf(message string, args ...interface{}) {
+	l.log(logDebug, message, args...)
+}
+
+// infof logs an informational message
+func (l *logWriter) infof(message string, args ...interface{}) {
+	l.log(logInfo, message, args...)
+}
+
+// warningf logs a warning
+func (l *logWriter) warningf(message string, args ...interface{}) {
+	l.log(logWarning, message, args...)
+}
+
+// errorf logs an error
+func (l *logWriter) errorf(message string, args ...interface{}) {
+	l.log(logError, message, args...)
+}
+
+//
+// log writes the message if the level is enabled, as a timestamped line or json document
+//
+func (l *logWriter) log(level int, message string, args ...interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	if level < l.level {
+		return
+	}
+	stamp := time.Now().UTC().Format(time.RFC3339)
+	text := strings.TrimRight(fmt.Sprintf(message, args...), "\n")
+
+	// step: mask the sensitive values
+	for _, x := range l.redactions {
+		text = strings.Replace(text, x, "[redacted]", -1)
+	}
+	text = signedParameters.ReplaceAllString(text, "${1}[redacted]")
+
+	switch l.format {
+	case "json":
+		encoded, err := json.Marshal(map[string]string{
+			"time":    stamp,
+			"level":   logLevels[level],
+			"message": text,
+		})
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(l.writer, "%s\n", encoded)
+	default:
+		label := "[" + logLevels[level] + "]"
+		if l.color && logColors[level] != "" {
+			label = logColors[level] + label + colorReset
+		}
+		fmt.Fprintf(l.writer, "%s %s %s\n", stamp, label, text)
+	}
+}
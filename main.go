@@ -16,10 +16,31 @@ limitations under the License.
 package main
 
 import (
+	"errors"
 	"os"
 )
 
 func main() {
 	app := newCliApplication()
-	app.Run(os.Args)
+	args, err := expandShortcuts(app, os.Args)
+	if err != nil {
+		exitWithError(exitUsage, "%s", err)
+	}
+	err = app.Run(args)
+	// step: remove any temporary files, the commands having returned
+	cleanup.run()
+	if err != nil {
+		os.Exit(runExitCode(err))
+	}
+}
+
+// runExitCode returns the exit code for the error of the application, those without a code being from
+// parsing the options
+func runExitCode(err error) int {
+	var e *exitError
+	if code := exitCode(err); code != exitFailure || errors.As(err, &e) {
+		return code
+	}
+
+	return exitUsage
 }
@@ -0,0 +1,282 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// the upper bounds of the aws latency histogram in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// the descriptions of the metrics
+var metricsHelp = map[string]string{
+	"kmsctl_aws_request_duration_seconds":           "histogram of the latency of the aws api requests",
+	"kmsctl_http_requests_total":                    "the number of http requests served, by status code",
+	"kmsctl_last_successful_sync_timestamp_seconds": "the unix time of the last successful synchronization",
+	"kmsctl_sync_errors_total":                      "the number of synchronizations which failed",
+	"kmsctl_sync_files_total":                       "the number of files processed by the synchronization, by result",
+	"kmsctl_sync_total":                             "the number of synchronizations performed",
+}
+
+//
+// histogram is a prometheus style histogram
+//
+type histogram struct {
+	// the cumulative count of each bucket
+	counts []uint64
+	// the total count of observations
+	count uint64
+	// the sum of the observations
+	sum float64
+}
+
+//
+// metricsRegistry collects the metrics exposed by the long running modes
+//
+type metricsRegistry struct {
+	sync.Mutex
+	// the counters keyed by name and labels
+	counters map[string]map[string]float64
+	// the histograms keyed by name and labels
+	histograms map[string]map[string]*histogram
+	// the time of the last successful synchronization
+	lastSuccess time.Time
+	// the error of the last synchronization if any
+	lastError error
+}
+
+// metrics is the registry of the process
+var metrics = &metricsRegistry{
+	counters:   make(map[string]map[string]float64, 0),
+	histograms: make(map[string]map[string]*histogram, 0),
+}
+
+// add increments the counter
+func (r *metricsRegistry) add(name, labels string, value float64) {
+	r.Lock()
+	defer r.Unlock()
+	if _, found := r.counters[name]; !found {
+		r.counters[name] = make(map[string]float64, 0)
+	}
+	r.counters[name][labels] += value
+}
+
+// observe records the value in the histogram
+func (r *metricsRegistry) observe(name, labels string, value float64) {
+	r.Lock()
+	defer r.Unlock()
+	if _, found := r.histograms[name]; !found {
+		r.histograms[name] = make(map[string]*histogram, 0)
+	}
+	h, found := r.histograms[name][labels]
+	if !found {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		r.histograms[name][labels] = h
+	}
+	for i, x := range latencyBuckets {
+		if value <= x {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+//
+// syncCompleted records the outcome of a synchronization
+//
+func (r *metricsRegistry) syncCompleted(err error) {
+	r.add("kmsctl_sync_total", "", 1)
+	if err != nil {
+		r.add("kmsctl_sync_errors_total", "", 1)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.lastError = err
+	if err == nil {
+		r.lastSuccess = time.Now()
+	}
+}
+
+//
+// healthy checks the last synchronization succeeded within the maximum age, zero for no limit
+//
+func (r *metricsRegistry) healthy(maxAge time.Duration) error {
+	r.Lock()
+	defer r.Unlock()
+	if r.lastError != nil {
+		return fmt.Errorf("the last synchronization failed, error: %s", r.lastError)
+	}
+	if maxAge > 0 && !r.lastSuccess.IsZero() && time.Since(r.lastSuccess) > maxAge {
+		return fmt.Errorf("the last successful synchronization was at: %s", r.lastSuccess.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+//
+// render returns the metrics in the prometheus text exposition format
+//
+func (r *metricsRegistry) render() []byte {
+	r.Lock()
+	defer r.Unlock()
+	buffer := new(bytes.Buffer)
+
+	header := func(name, kind string) {
+		fmt.Fprintf(buffer, "# HELP %s %s\n# TYPE %s %s\n", name, metricsHelp[name], name, kind)
+	}
+
+	for _, name := range metricKeys(r.counters) {
+		header(name, "counter")
+		for _, labels := range metricKeys(r.counters[name]) {
+			fmt.Fprintf(buffer, "%s%s %v\n", name, wrapLabels(labels), r.counters[name][labels])
+		}
+	}
+	for _, name := range metricKeys(r.histograms) {
+		header(name, "histogram")
+		for _, labels := range metricKeys(r.histograms[name]) {
+			h := r.histograms[name][labels]
+			for i, x := range latencyBuckets {
+				fmt.Fprintf(buffer, "%s_bucket%s %d\n", name, wrapLabels(joinLabels(labels, fmt.Sprintf(`le="%v"`, x))), h.counts[i])
+			}
+			fmt.Fprintf(buffer, "%s_bucket%s %d\n", name, wrapLabels(joinLabels(labels, `le="+Inf"`)), h.count)
+			fmt.Fprintf(buffer, "%s_sum%s %v\n", name, wrapLabels(labels), h.sum)
+			fmt.Fprintf(buffer, "%s_count%s %d\n", name, wrapLabels(labels), h.count)
+		}
+	}
+	if !r.lastSuccess.IsZero() {
+		name := "kmsctl_last_successful_sync_timestamp_seconds"
+		header(name, "gauge")
+		fmt.Fprintf(buffer, "%s %d\n", name, r.lastSuccess.Unix())
+	}
+
+	return buffer.Bytes()
+}
+
+//
+// handleMetrics serves the metrics
+//
+func (r *metricsRegistry) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(r.render())
+}
+
+//
+// healthHandler returns a handler reporting the health of the synchronization
+//
+func (r *metricsRegistry) healthHandler(maxAge time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if err := r.healthy(maxAge); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	}
+}
+
+//
+// instrumentSession records the latency of the aws requests made by the session, note the clients
+// copy the handlers on creation, so this must be called before they are created
+//
+func instrumentSession(sess *session.Session) {
+	sess.Handlers.Complete.PushBack(func(req *request.Request) {
+		metrics.observe("kmsctl_aws_request_duration_seconds",
+			fmt.Sprintf(`service="%s",operation="%s"`, req.ClientInfo.ServiceName, req.Operation.Name),
+			time.Since(req.Time).Seconds())
+	})
+}
+
+// sortedKeys returns the sorted keys of the map
+func metricKeys(v interface{}) []string {
+	var list []string
+	switch m := v.(type) {
+	case map[string]map[string]float64:
+		for k := range m {
+			list = append(list, k)
+		}
+	case map[string]map[string]*histogram:
+		for k := range m {
+			list = append(list, k)
+		}
+	case map[string]float64:
+		for k := range m {
+			list = append(list, k)
+		}
+	case map[string]*histogram:
+		for k := range m {
+			list = append(list, k)
+		}
+	}
+	sort.Strings(list)
+
+	return list
+}
+
+// joinLabels joins the label sets
+func joinLabels(labels ...string) string {
+	var list []string
+	for _, x := range labels {
+		if x != "" {
+			list = append(list, x)
+		}
+	}
+
+	return strings.Join(list, ",")
+}
+
+// wrapLabels wraps the labels in braces if any
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+
+	return "{" + labels + "}"
+}
+
+//
+// serveMetrics exposes the metrics and health endpoints until the context is cancelled
+//
+func serveMetrics(cmd *cliCommand, listen string, maxAge time.Duration) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metrics.handleMetrics)
+	mux.HandleFunc("/healthz", metrics.healthHandler(maxAge))
+
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-cmd.ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.errorf("the metrics server failed, error: %s", err)
+		}
+	}()
+}
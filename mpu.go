@@ -0,0 +1,208 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+)
+
+//
+// newMultipartCommand creates a new mpu command
+//
+func newMultipartCommand(cmd *cliCommand) cli.Command {
+	bucketFlag := cli.StringFlag{
+		Name:   "b, bucket",
+		Usage:  "the name of the s3 bucket containing the encrypted files `NAME`",
+		EnvVar: "AWS_S3_BUCKET",
+	}
+	resumeDirFlag := cli.StringFlag{
+		Name:   "resume-dir",
+		Usage:  "the directory the state of the resumable transfers is kept in `DIR`",
+		EnvVar: "KMSCTL_RESUME_DIR",
+		Value:  homePath(".kmsctl", "transfers"),
+	}
+
+	return cli.Command{
+		Name:  "mpu",
+		Usage: "list or abort the incomplete multipart uploads, i.e. those interrupted or abandoned",
+		Subcommands: []cli.Command{
+			{
+				Name:      "list",
+				Aliases:   []string{"ls"},
+				Usage:     "list the incomplete multipart uploads, and whether they can be resumed from here",
+				ArgsUsage: "[PATH]",
+				Flags:     []cli.Flag{bucketFlag, resumeDirFlag},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:bucket:s"}, cmd, listMultipartUploads)
+				},
+			},
+			{
+				Name:      "abort",
+				Usage:     "abort the incomplete multipart uploads of the files, removing the parts uploaded",
+				ArgsUsage: "[PATH...]",
+				Flags: []cli.Flag{
+					bucketFlag,
+					resumeDirFlag,
+					cli.StringFlag{
+						Name:  "upload-id",
+						Usage: "only abort the upload with the id `ID`",
+					},
+					cli.StringFlag{
+						Name:  "older-than",
+						Usage: "only abort the uploads started longer ago than the duration, i.e. 7d `DURATION`",
+					},
+					cli.BoolFlag{
+						Name:  "all",
+						Usage: "abort all the incomplete uploads in the bucket",
+					},
+					cli.BoolFlag{
+						Name:  "y, yes",
+						Usage: "do not prompt for confirmation before aborting",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:bucket:s"}, cmd, abortMultipartUploads)
+				},
+			},
+		},
+	}
+}
+
+//
+// listMultipartUploads lists the incomplete multipart uploads under the path
+//
+func listMultipartUploads(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	uploads, err := cmd.multipartUploads(bucket, cx.Args().First())
+	if err != nil {
+		return err
+	}
+	states := uploadStates(cx.String("resume-dir"))
+
+	for _, x := range uploads {
+		key := strings.TrimPrefix(aws.StringValue(x.Key), cmd.prefix)
+		_, resumable := states[aws.StringValue(x.UploadId)]
+		o.fields(map[string]interface{}{
+			"key":       key,
+			"upload-id": aws.StringValue(x.UploadId),
+			"initiated": aws.TimeValue(x.Initiated),
+			"resumable": resumable,
+		}).log("%-20s %s %s %s\n", aws.TimeValue(x.Initiated).Format(time.RFC822), resumableColumn(resumable),
+			colorKey(key), aws.StringValue(x.UploadId))
+	}
+
+	return nil
+}
+
+//
+// abortMultipartUploads aborts the incomplete multipart uploads of the files, or all of them, removing
+// the state kept to resume them
+//
+func abortMultipartUploads(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	if len(cx.Args()) <= 0 && !cx.Bool("all") && cx.String("upload-id") == "" {
+		return newExitError(exitUsage, "you have not specified the files to abort the uploads of, or --all")
+	}
+	var age time.Duration
+	if value := cx.String("older-than"); value != "" {
+		duration, err := parseDuration(value)
+		if err != nil {
+			return newExitError(exitUsage, "invalid older-than: %s, error: %s", value, err)
+		}
+		age = duration
+	}
+
+	// step: find the uploads to abort
+	uploads, err := cmd.multipartUploads(bucket, "")
+	if err != nil {
+		return err
+	}
+	var list []*s3.MultipartUpload
+	for _, x := range uploads {
+		key := strings.TrimPrefix(aws.StringValue(x.Key), cmd.prefix)
+		switch {
+		case len(cx.Args()) > 0 && !isValidOption(key, cx.Args()):
+			continue
+		case cx.String("upload-id") != "" && aws.StringValue(x.UploadId) != cx.String("upload-id"):
+			continue
+		case age > 0 && time.Since(aws.TimeValue(x.Initiated)) < age:
+			continue
+		}
+		list = append(list, x)
+	}
+	if len(list) <= 0 {
+		return newNotFoundError("no incomplete uploads found to abort")
+	}
+	if err := confirm(cx, "this will abort %d incomplete uploads in the bucket: %s", len(list), bucket); err != nil {
+		return err
+	}
+
+	states := uploadStates(cx.String("resume-dir"))
+	for _, x := range list {
+		key := strings.TrimPrefix(aws.StringValue(x.Key), cmd.prefix)
+		if _, err := cmd.s3Client.AbortMultipartUploadWithContext(cmd.ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(bucket),
+			Key:      x.Key,
+			UploadId: x.UploadId,
+		}); err != nil {
+			return fmt.Errorf("unable to abort the upload of: %s, error: %s", key, err)
+		}
+		if path, found := states[aws.StringValue(x.UploadId)]; found {
+			removeTransfer(path)
+		}
+		o.fields(map[string]interface{}{
+			"action":    "abort",
+			"key":       key,
+			"upload-id": aws.StringValue(x.UploadId),
+		}).log("aborted the upload of: %s, id: %s\n", key, aws.StringValue(x.UploadId))
+	}
+
+	return nil
+}
+
+//
+// multipartUploads retrieves the incomplete multipart uploads under the path
+//
+func (r *cliCommand) multipartUploads(bucket, path string) ([]*s3.MultipartUpload, error) {
+	var list []*s3.MultipartUpload
+	err := r.s3Client.ListMultipartUploadsPagesWithContext(r.ctx, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(r.objectKey(path)),
+	}, func(page *s3.ListMultipartUploadsOutput, last bool) bool {
+		list = append(list, page.Uploads...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// resumableColumn returns whether the upload can be resumed for display
+func resumableColumn(resumable bool) string {
+	if resumable {
+		return colorize(colorCyan, fmt.Sprintf("%-10s", "resumable"))
+	}
+
+	return fmt.Sprintf("%-10s", "-")
+}
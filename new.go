@@ -0,0 +1,220 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+//
+// newNewCommand creates a new new command
+//
+func newNewCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:      "new",
+		Aliases:   []string{"touch"},
+		Usage:     "create a new file in the bucket from a template, prompting for the variables, or with generated content",
+		ArgsUsage: "KEY...",
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket to create the files in",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:   "k, kms",
+				Usage:  "the aws kms id to encrypt the files with, defaults to the bucket default encryption",
+				EnvVar: "AWS_KMS_ID",
+			},
+			cli.StringFlag{
+				Name:  "t, template",
+				Usage: "the name of the template, a path or s3://bucket/key, the files are empty if not specified `NAME`",
+			},
+			cli.StringSliceFlag{
+				Name:  "var",
+				Usage: "the value of a variable of the template, can be specified multiple times `KEY=VALUE`",
+			},
+			cli.StringFlag{
+				Name:   "templates-dir",
+				Usage:  "the directory containing the local templates `DIR`",
+				EnvVar: "KMSCTL_TEMPLATES_DIR",
+				Value:  homePath(".kmsctl", "templates"),
+			},
+			cli.StringFlag{
+				Name:   "templates-prefix",
+				Usage:  "the prefix of the templates shared in the bucket, used if not found locally `PREFIX`",
+				EnvVar: "KMSCTL_TEMPLATES_PREFIX",
+				Value:  "templates/",
+			},
+		}, generateFlags()...),
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s"}, cmd, newFiles)
+		},
+	}
+}
+
+//
+// newFiles instantiates the template and uploads it as each of the files, which must not already exist
+//
+func newFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	kms := cx.String("kms")
+	name := cx.String("template")
+
+	if len(cx.Args()) <= 0 {
+		return newExitError(exitUsage, "you have not specified any files to create")
+	}
+	values, err := parseKeyValues(cx.StringSlice("var"))
+	if err != nil {
+		return newExitError(exitUsage, "%s", err)
+	}
+	if name != "" && cx.String("generate") != "" {
+		return newExitError(exitUsage, "invalid option, you cannot use --template and --generate together")
+	}
+	if kms == "" {
+		if err := cmd.hasDefaultKmsEncryption(bucket); err != nil {
+			return err
+		}
+	}
+
+	// step: retrieve and instantiate the template
+	var content []byte
+	if name != "" {
+		template, err := loadTemplate(cx, cmd, bucket, name)
+		if err != nil {
+			return err
+		}
+		if content, err = expandTemplate(template, values); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range cx.Args() {
+		// note: the file must not exist, so an existing file is never overwritten by a template
+		if cx.String("generate") != "" {
+			if err := putGenerated(o, cx, cmd, bucket, key, kms, &uploadOptions{ifNotExists: true}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := cmd.putContent(bucket, key, content, kms, &uploadOptions{ifNotExists: true}); err != nil {
+			return err
+		}
+		o.fields(map[string]interface{}{
+			"action":   "new",
+			"bucket":   bucket,
+			"key":      key,
+			"template": name,
+		}).log("successfully created the file: s3://%s/%s\n", bucket, key)
+	}
+
+	return nil
+}
+
+//
+// loadTemplate retrieves the template from the s3 location, the path or templates directory, or the
+// templates prefix of the bucket
+//
+func loadTemplate(cx *cli.Context, cmd *cliCommand, bucket, name string) ([]byte, error) {
+	if strings.HasPrefix(name, "s3://") {
+		bucket, key := splitS3Location(name)
+		if bucket == "" || key == "" {
+			return nil, newExitError(exitUsage, "invalid template: %s, expected s3://bucket/key", name)
+		}
+		return cmd.getFile(bucket, key)
+	}
+
+	// step: check for a local template
+	for _, x := range []string{name, filepath.Join(cx.String("templates-dir"), name)} {
+		if found, err := isFile(x); err == nil && found {
+			return ioutil.ReadFile(x)
+		}
+	}
+
+	// step: else the template is shared in the bucket
+	content, err := cmd.getFile(bucket, path.Join(cx.String("templates-prefix"), name))
+	if err != nil {
+		if exitCode(err) == exitNotFound {
+			return nil, newNotFoundError("the template: %s does not exist locally or in the bucket", name)
+		}
+		return nil, err
+	}
+
+	return content, nil
+}
+
+//
+// expandTemplate replaces the ${NAME} and ${NAME:-default} variables of the template with the values,
+// prompting for any not specified when attached to a terminal, else using the default
+//
+func expandTemplate(template []byte, values map[string]string) ([]byte, error) {
+	// step: find the variables in the order they appear
+	var variables []string
+	defaults := make(map[string]string, 0)
+	os.Expand(string(template), func(x string) string {
+		name, value := templateVariable(x)
+		if _, found := defaults[name]; !found {
+			variables = append(variables, name)
+			defaults[name] = value
+		}
+		return ""
+	})
+
+	// step: retrieve the values of the variables which were not specified
+	interactive := isTerminal(os.Stdin)
+	reader := bufio.NewReader(os.Stdin)
+	for _, name := range variables {
+		if _, found := values[name]; found {
+			continue
+		}
+		if !interactive {
+			if defaults[name] == "" {
+				return nil, newExitError(exitUsage, "no value for the template variable: %s, use --var %s=VALUE", name, name)
+			}
+			values[name] = defaults[name]
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", name, defaults[name])
+		answer, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		values[name] = defaultValue(strings.TrimSpace(answer), defaults[name])
+	}
+
+	return []byte(os.Expand(string(template), func(x string) string {
+		name, _ := templateVariable(x)
+		return values[name]
+	})), nil
+}
+
+// templateVariable splits the variable into the name and default value
+func templateVariable(variable string) (string, string) {
+	items := strings.SplitN(variable, ":-", 2)
+	if len(items) != 2 {
+		return variable, ""
+	}
+
+	return items[0], items[1]
+}
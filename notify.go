@@ -0,0 +1,306 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/urfave/cli"
+)
+
+// the id of the queue configuration managed by kmsctl
+const notifyConfigurationID = "kmsctl-sync"
+
+// the events notified by default
+var notifyEvents = []string{s3.EventS3ObjectCreated, s3.EventS3ObjectRemoved}
+
+//
+// s3EventNotification is the subset of the s3 event notification used, the notifications delivered
+// via sns are wrapped in the message of a sns notification
+//
+type s3EventNotification struct {
+	// the message of a sns notification
+	Message string `json:"Message"`
+	// the events of the notification
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+//
+// newBucketNotifyCommand creates the bucket notification commands
+//
+func newBucketNotifyCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "notify",
+		Usage: "manage the event notifications of the bucket, used by get --sync --events-queue",
+		Subcommands: []cli.Command{
+			{
+				Name:      "setup",
+				Usage:     "send the events of the files created and removed in the bucket to a sqs queue",
+				ArgsUsage: "[NAME]",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "b, bucket",
+						Usage:  "the name of the bucket, unless given as the argument `NAME`",
+						EnvVar: "AWS_S3_BUCKET",
+					},
+					cli.StringFlag{
+						Name:  "sqs-queue",
+						Usage: "the arn of the sqs queue receiving the events `ARN`",
+					},
+					cli.StringFlag{
+						Name:  "prefix",
+						Usage: "only send the events of the files under this prefix `PREFIX`",
+					},
+					cli.StringSliceFlag{
+						Name:  "event",
+						Usage: "the events sent to the queue, defaults to s3:ObjectCreated:* and s3:ObjectRemoved:* `EVENT`",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:sqs-queue:s"}, cmd, setupBucketNotify)
+				},
+			},
+			{
+				Name:      "get",
+				Usage:     "display the queues receiving the events of the bucket",
+				ArgsUsage: "[NAME]",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "b, bucket",
+						Usage:  "the name of the bucket, unless given as the argument `NAME`",
+						EnvVar: "AWS_S3_BUCKET",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{}, cmd, getBucketNotify)
+				},
+			},
+		},
+	}
+}
+
+//
+// setupBucketNotify adds or replaces the queue configuration managed by kmsctl, retaining any other
+// notifications of the bucket
+//
+func setupBucketNotify(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := defaultValue(cx.Args().First(), cx.String("bucket"))
+	queue := cx.String("sqs-queue")
+	if bucket == "" {
+		return newExitError(exitUsage, "you have not specified the bucket")
+	}
+	if !strings.HasPrefix(queue, "arn:") {
+		return newExitError(exitUsage, "the sqs queue: %s must be an arn, i.e. arn:aws:sqs:eu-west-1:123456789012:secrets", queue)
+	}
+	events := cx.StringSlice("event")
+	if len(events) <= 0 {
+		events = notifyEvents
+	}
+
+	current, err := cmd.s3Client.GetBucketNotificationConfigurationWithContext(cmd.ctx, &s3.GetBucketNotificationConfigurationRequest{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return err
+	}
+	configuration := &s3.QueueConfiguration{
+		Id:       aws.String(notifyConfigurationID),
+		QueueArn: aws.String(queue),
+		Events:   aws.StringSlice(events),
+	}
+	if prefix := cmd.objectKey(strings.TrimPrefix(cx.String("prefix"), "/")); prefix != "" {
+		configuration.Filter = &s3.NotificationConfigurationFilter{
+			Key: &s3.KeyFilter{
+				FilterRules: []*s3.FilterRule{{Name: aws.String(s3.FilterRuleNamePrefix), Value: aws.String(prefix)}},
+			},
+		}
+	}
+	queues := []*s3.QueueConfiguration{configuration}
+	for _, x := range current.QueueConfigurations {
+		if aws.StringValue(x.Id) != notifyConfigurationID {
+			queues = append(queues, x)
+		}
+	}
+
+	_, err = cmd.s3Client.PutBucketNotificationConfigurationWithContext(cmd.ctx, &s3.PutBucketNotificationConfigurationInput{
+		Bucket: aws.String(bucket),
+		NotificationConfiguration: &s3.NotificationConfiguration{
+			EventBridgeConfiguration:     current.EventBridgeConfiguration,
+			LambdaFunctionConfigurations: current.LambdaFunctionConfigurations,
+			QueueConfigurations:          queues,
+			TopicConfigurations:          current.TopicConfigurations,
+		},
+	})
+	if err != nil {
+		if e, ok := err.(awserr.Error); ok && e.Code() == "InvalidArgument" {
+			return fmt.Errorf("unable to validate the queue: %s, the queue policy must permit s3.amazonaws.com to sqs:SendMessage, error: %s", queue, err)
+		}
+		return err
+	}
+
+	o.fields(map[string]interface{}{
+		"operation": "notify",
+		"bucket":    bucket,
+		"queue":     queue,
+		"events":    events,
+	}).log("successfully set the notifications of bucket: %s to the queue: %s\n", bucket, queue)
+
+	return nil
+}
+
+func getBucketNotify(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := defaultValue(cx.Args().First(), cx.String("bucket"))
+	if bucket == "" {
+		return newExitError(exitUsage, "you have not specified the bucket")
+	}
+
+	resp, err := cmd.s3Client.GetBucketNotificationConfigurationWithContext(cmd.ctx, &s3.GetBucketNotificationConfigurationRequest{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return err
+	}
+	for _, x := range resp.QueueConfigurations {
+		var prefix string
+		if x.Filter != nil && x.Filter.Key != nil {
+			for _, rule := range x.Filter.Key.FilterRules {
+				if strings.EqualFold(aws.StringValue(rule.Name), s3.FilterRuleNamePrefix) {
+					prefix = aws.StringValue(rule.Value)
+				}
+			}
+		}
+		events := aws.StringValueSlice(x.Events)
+
+		o.fields(map[string]interface{}{
+			"id":     aws.StringValue(x.Id),
+			"bucket": bucket,
+			"queue":  aws.StringValue(x.QueueArn),
+			"events": events,
+			"prefix": prefix,
+		}).log("%-20s %s %s %s\n", aws.StringValue(x.Id), aws.StringValue(x.QueueArn), strings.Join(events, ","), prefix)
+	}
+
+	return nil
+}
+
+//
+// watchEvents long polls the queue for the events of the bucket, sending the keys of the files created
+// or removed under the paths on the channel; the messages are removed from the queue once read
+//
+func (r *cliCommand) watchEvents(queue, bucket string, paths []string) <-chan []string {
+	client := sqs.New(r.session)
+	eventsCh := make(chan []string)
+
+	go func() {
+		for {
+			resp, err := client.ReceiveMessageWithContext(r.ctx, &sqs.ReceiveMessageInput{
+				QueueUrl:            aws.String(queue),
+				MaxNumberOfMessages: aws.Int64(10),
+				WaitTimeSeconds:     aws.Int64(20),
+			})
+			if err != nil {
+				if r.ctx.Err() != nil {
+					return
+				}
+				logger.warningf("unable to receive the events from the queue: %s, error: %s", queue, err)
+				select {
+				case <-r.ctx.Done():
+					return
+				case <-time.After(10 * time.Second):
+				}
+				continue
+			}
+
+			var keys []string
+			for _, message := range resp.Messages {
+				keys = append(keys, r.eventKeys(aws.StringValue(message.Body), bucket, paths)...)
+
+				if _, err := client.DeleteMessageWithContext(r.ctx, &sqs.DeleteMessageInput{
+					QueueUrl:      aws.String(queue),
+					ReceiptHandle: message.ReceiptHandle,
+				}); err != nil && r.ctx.Err() == nil {
+					logger.warningf("unable to remove the event from the queue: %s, error: %s", queue, err)
+				}
+			}
+			if len(keys) <= 0 {
+				continue
+			}
+			select {
+			case <-r.ctx.Done():
+				return
+			case eventsCh <- keys:
+			}
+		}
+	}()
+
+	return eventsCh
+}
+
+//
+// eventKeys returns the keys of the files created or removed under the paths in the notification, the
+// test events sent when the notifications are configured and anything unrecognized are ignored
+//
+func (r *cliCommand) eventKeys(body, bucket string, paths []string) []string {
+	notification := new(s3EventNotification)
+	if err := json.Unmarshal([]byte(body), notification); err != nil {
+		return nil
+	}
+	if notification.Message != "" {
+		return r.eventKeys(notification.Message, bucket, paths)
+	}
+
+	var keys []string
+	for _, x := range notification.Records {
+		if x.S3.Bucket.Name != bucket {
+			continue
+		}
+		if !strings.HasPrefix(x.EventName, "ObjectCreated:") && !strings.HasPrefix(x.EventName, "ObjectRemoved:") {
+			continue
+		}
+		// note: the keys in the notifications are url encoded
+		key, err := url.QueryUnescape(x.S3.Object.Key)
+		if err != nil || !strings.HasPrefix(key, r.prefix) {
+			continue
+		}
+		key = strings.TrimPrefix(key, r.prefix)
+		for _, p := range paths {
+			if strings.HasPrefix(key, strings.TrimPrefix(p, "/")) {
+				keys = append(keys, key)
+				break
+			}
+		}
+	}
+
+	return keys
+}
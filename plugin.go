@@ -0,0 +1,166 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// the credential options are never passed to the plugins, which resolve their own from the profile
+var pluginSkippedFlags = []string{"access-key", "secret-key", "session-token"}
+
+//
+// newPluginsCommand creates a new plugins command
+//
+func newPluginsCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "plugins",
+		Usage: "list the plugins found on the PATH, i.e. a " + progName + "-foo executable is run as '" + progName + " foo'",
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{}, cmd, listPlugins)
+		},
+	}
+}
+
+//
+// listPlugins displays the plugins found on the path, the first found taking precedence
+//
+func listPlugins(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	plugins := findPlugins()
+	var names []string
+	for x := range plugins {
+		names = append(names, x)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		o.fields(map[string]interface{}{
+			"action": "plugins",
+			"name":   name,
+			"path":   plugins[name],
+		}).log("%-24s %s\n", name, plugins[name])
+	}
+
+	return nil
+}
+
+//
+// runPlugin returns the handler of the unknown commands, running the plugin named after the command
+// with the remaining arguments, the global options, profile and region being passed in the environment
+//
+func (r *cliCommand) runPlugin() func(cx *cli.Context, name string) {
+	return func(cx *cli.Context, name string) {
+		path, err := exec.LookPath(progName + "-" + name)
+		if err != nil {
+			exitWithError(exitUsage, "unknown command: %s, and no plugin %s-%s found on the PATH", name, progName, name)
+		}
+
+		var args []string
+		if len(cx.Args()) > 1 {
+			args = cx.Args()[1:]
+		}
+		logger.debugf("running the plugin: %s, path: %s", name, path)
+
+		command := exec.Command(path, args...)
+		command.Env = append(os.Environ(), r.pluginEnv(cx)...)
+		command.Stdin = os.Stdin
+		command.Stdout = os.Stdout
+		command.Stderr = os.Stderr
+		// note: the plugin receives the signals from the terminal and handles them itself
+		interrupts.ignore()
+		if err := command.Run(); err != nil {
+			if e, ok := err.(*exec.ExitError); ok {
+				cleanup.run()
+				os.Exit(e.ExitCode())
+			}
+			exitWithError(exitFailure, "unable to run the plugin: %s, error: %s", name, err)
+		}
+	}
+}
+
+//
+// pluginEnv returns the global options as KMSCTL_ variables, i.e. --log-level as KMSCTL_LOG_LEVEL, along
+// with the region, prefix and profile; the credentials are never exported, the plugin resolving its own
+// from the profile, so the secrets are not handed to every plugin on the PATH
+//
+func (r *cliCommand) pluginEnv(cx *cli.Context) []string {
+	var env []string
+	for _, flag := range cx.App.Flags {
+		name := longFlagName(flag)
+		if name == "help" || name == "version" || isValidOption(name, pluginSkippedFlags) {
+			continue
+		}
+		if value := cx.GlobalString(name); value != "" {
+			env = append(env, "KMSCTL_"+strings.ToUpper(strings.Replace(name, "-", "_", -1))+"="+value)
+		}
+	}
+	env = append(env,
+		"KMSCTL_PREFIX="+r.prefix,
+		"AWS_REGION="+r.region(),
+		"AWS_DEFAULT_REGION="+r.region(),
+	)
+	if profile := cx.GlobalString("profile"); profile != "" {
+		env = append(env, "AWS_PROFILE="+profile, "AWS_DEFAULT_PROFILE="+profile)
+	}
+
+	return env
+}
+
+// longFlagName returns the longest of the names of the flag, i.e. region of "r, region"
+func longFlagName(flag cli.Flag) string {
+	var name string
+	for _, x := range strings.Split(flag.GetName(), ",") {
+		if x = strings.TrimSpace(x); len(x) > len(name) {
+			name = x
+		}
+	}
+
+	return name
+}
+
+// findPlugins returns the paths of the plugins on the PATH by name, the first found taking precedence
+func findPlugins() map[string]string {
+	plugins := make(map[string]string, 0)
+	for _, directory := range filepath.SplitList(os.Getenv("PATH")) {
+		files, err := ioutil.ReadDir(directory)
+		if err != nil {
+			continue
+		}
+		for _, x := range files {
+			name := x.Name()
+			if runtime.GOOS == "windows" {
+				name = strings.TrimSuffix(name, filepath.Ext(name))
+			}
+			if x.IsDir() || !strings.HasPrefix(name, progName+"-") || (runtime.GOOS != "windows" && x.Mode().Perm()&0111 == 0) {
+				continue
+			}
+			name = strings.TrimPrefix(name, progName+"-")
+			if _, found := plugins[name]; !found && name != "" {
+				plugins[name] = filepath.Join(directory, x.Name())
+			}
+		}
+	}
+
+	return plugins
+}
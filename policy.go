@@ -0,0 +1,319 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+)
+
+const (
+	// the statement id used to deny uploads with the wrong encryption
+	denyIncorrectEncryptionSid = "KmsctlDenyIncorrectEncryption"
+	// the statement id used to deny uploads with a different kms key
+	denyIncorrectKeySid = "KmsctlDenyIncorrectKmsKey"
+	// the statement id used to deny uploads without an encryption header
+	denyMissingEncryptionSid = "KmsctlDenyMissingEncryption"
+)
+
+//
+// newBucketPolicyCommand creates the bucket policy commands
+//
+func newBucketPolicyCommand(cmd *cliCommand) cli.Command {
+	bucketFlag := cli.StringFlag{
+		Name:   "b, bucket",
+		Usage:  "the name of the bucket `NAME`",
+		EnvVar: "AWS_S3_BUCKET",
+	}
+
+	return cli.Command{
+		Name:  "policy",
+		Usage: "manage the policy attached to the bucket",
+		Subcommands: []cli.Command{
+			{
+				Name:  "get",
+				Usage: "retrieve the policy attached to the bucket",
+				Flags: []cli.Flag{bucketFlag},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:bucket:s"}, cmd, getBucketPolicy)
+				},
+			},
+			{
+				Name:  "put",
+				Usage: "attach a policy to the bucket, replacing any existing policy",
+				Flags: []cli.Flag{
+					bucketFlag,
+					cli.StringFlag{
+						Name:  "f, file",
+						Usage: "the path to the file containing the policy document, use - for stdin `PATH`",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:bucket:s", "l:file:s"}, cmd, putBucketPolicy)
+				},
+			},
+			{
+				Name:    "delete",
+				Aliases: []string{"rm"},
+				Usage:   "remove the policy attached to the bucket",
+				Flags:   []cli.Flag{bucketFlag},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:bucket:s"}, cmd, deleteBucketPolicy)
+				},
+			},
+			{
+				Name:  "enforce-encryption",
+				Usage: "add statements to the bucket policy denying uploads not encrypted with the kms key",
+				Flags: []cli.Flag{
+					bucketFlag,
+					cli.StringFlag{
+						Name:   "k, kms",
+						Usage:  "the alias, arn or id of the kms key uploads must be encrypted with `KEY`",
+						EnvVar: "AWS_KMS_ID",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:bucket:s", "l:kms:s"}, cmd, enforceBucketEncryption)
+				},
+			},
+		},
+	}
+}
+
+func getBucketPolicy(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	name := cx.String("bucket")
+
+	policy, err := cmd.getBucketPolicy(name)
+	if err != nil {
+		return err
+	}
+	if policy == "" {
+		return fmt.Errorf("the bucket: %s does not have a policy", name)
+	}
+
+	// step: indent the document for readability
+	var document bytes.Buffer
+	if err := json.Indent(&document, []byte(policy), "", "  "); err != nil {
+		return err
+	}
+
+	o.fields(map[string]interface{}{
+		"bucket": name,
+		"policy": policy,
+	}).log("%s\n", document.String())
+
+	return nil
+}
+
+func putBucketPolicy(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	name := cx.String("bucket")
+	path := cx.String("file")
+
+	content, err := readFileOrStdin(path)
+	if err != nil {
+		return err
+	}
+	if !json.Valid(content) {
+		return fmt.Errorf("the policy document: %s is not valid json", path)
+	}
+
+	if _, err := cmd.s3Client.PutBucketPolicyWithContext(cmd.ctx, &s3.PutBucketPolicyInput{
+		Bucket: aws.String(name),
+		Policy: aws.String(string(content)),
+	}); err != nil {
+		return err
+	}
+
+	o.fields(map[string]interface{}{
+		"operation": "policy",
+		"bucket":    name,
+	}).log("successfully attached the policy to bucket: %s\n", name)
+
+	return nil
+}
+
+func deleteBucketPolicy(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	name := cx.String("bucket")
+
+	if _, err := cmd.s3Client.DeleteBucketPolicyWithContext(cmd.ctx, &s3.DeleteBucketPolicyInput{
+		Bucket: aws.String(name),
+	}); err != nil {
+		return err
+	}
+
+	o.fields(map[string]interface{}{
+		"operation": "delete-policy",
+		"bucket":    name,
+	}).log("successfully removed the policy from bucket: %s\n", name)
+
+	return nil
+}
+
+func enforceBucketEncryption(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	name := cx.String("bucket")
+
+	// step: resolve the kms key to the arn
+	metadata, err := cmd.describeKey(cx.String("kms"))
+	if err != nil {
+		return fmt.Errorf("unable to resolve the kms key: %s, error: %s", cx.String("kms"), err)
+	}
+	keyArn := aws.StringValue(metadata.Arn)
+
+	// step: if the bucket does not default to kms encryption, uploads must carry the header
+	rule, err := cmd.getBucketEncryption(name)
+	if err != nil {
+		return err
+	}
+	requireHeader := rule == nil || aws.StringValue(rule.ApplyServerSideEncryptionByDefault.SSEAlgorithm) != s3.ServerSideEncryptionAwsKms
+
+	// step: retrieve any existing policy and merge in our statements
+	policy := map[string]interface{}{
+		"Version":   "2012-10-17",
+		"Statement": []interface{}{},
+	}
+	existing, err := cmd.getBucketPolicy(name)
+	if err != nil {
+		return err
+	}
+	if existing != "" {
+		if err := json.Unmarshal([]byte(existing), &policy); err != nil {
+			return fmt.Errorf("unable to parse the existing bucket policy, error: %s", err)
+		}
+	}
+	statements := encryptionPolicyStatements(cmd.region(), name, []string{keyArn, aws.StringValue(metadata.KeyId)}, requireHeader)
+	policy["Statement"] = mergePolicyStatements(policy["Statement"], statements)
+
+	encoded, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	if _, err := cmd.s3Client.PutBucketPolicyWithContext(cmd.ctx, &s3.PutBucketPolicyInput{
+		Bucket: aws.String(name),
+		Policy: aws.String(string(encoded)),
+	}); err != nil {
+		return err
+	}
+
+	o.fields(map[string]interface{}{
+		"operation":      "enforce-encryption",
+		"bucket":         name,
+		"kms-key":        keyArn,
+		"require-header": requireHeader,
+	}).log("successfully enforced kms encryption with key: %s on bucket: %s\n", keyArn, name)
+
+	return nil
+}
+
+//
+// encryptionPolicyStatements generates the statements denying uploads not encrypted by the key, the
+// key may be referenced by arn or id in the upload
+//
+func encryptionPolicyStatements(region, bucket string, keys []string, requireHeader bool) []interface{} {
+	resource := s3ARN(region, bucket+"/*")
+	statements := []interface{}{
+		map[string]interface{}{
+			"Sid":       denyIncorrectEncryptionSid,
+			"Effect":    "Deny",
+			"Principal": "*",
+			"Action":    "s3:PutObject",
+			"Resource":  resource,
+			"Condition": map[string]interface{}{
+				"StringNotEquals": map[string]string{"s3:x-amz-server-side-encryption": s3.ServerSideEncryptionAwsKms},
+				"Null":            map[string]string{"s3:x-amz-server-side-encryption": "false"},
+			},
+		},
+		map[string]interface{}{
+			"Sid":       denyIncorrectKeySid,
+			"Effect":    "Deny",
+			"Principal": "*",
+			"Action":    "s3:PutObject",
+			"Resource":  resource,
+			"Condition": map[string]interface{}{
+				"StringNotEquals": map[string][]string{"s3:x-amz-server-side-encryption-aws-kms-key-id": keys},
+				"Null":            map[string]string{"s3:x-amz-server-side-encryption-aws-kms-key-id": "false"},
+			},
+		},
+	}
+	if requireHeader {
+		statements = append(statements, map[string]interface{}{
+			"Sid":       denyMissingEncryptionSid,
+			"Effect":    "Deny",
+			"Principal": "*",
+			"Action":    "s3:PutObject",
+			"Resource":  resource,
+			"Condition": map[string]interface{}{
+				"Null": map[string]string{"s3:x-amz-server-side-encryption": "true"},
+			},
+		})
+	}
+
+	return statements
+}
+
+//
+// mergePolicyStatements replaces any of our previous statements with the updated ones
+//
+func mergePolicyStatements(current interface{}, statements []interface{}) []interface{} {
+	ours := map[string]bool{
+		denyIncorrectEncryptionSid: true,
+		denyIncorrectKeySid:        true,
+		denyMissingEncryptionSid:   true,
+	}
+
+	// step: a policy with a single statement may not be a list
+	var list []interface{}
+	switch v := current.(type) {
+	case []interface{}:
+		list = v
+	case map[string]interface{}:
+		list = []interface{}{v}
+	}
+
+	var merged []interface{}
+	for _, x := range list {
+		if statement, ok := x.(map[string]interface{}); ok {
+			if sid, ok := statement["Sid"].(string); ok && ours[sid] {
+				continue
+			}
+		}
+		merged = append(merged, x)
+	}
+
+	return append(merged, statements...)
+}
+
+//
+// getBucketPolicy retrieves the bucket policy, or empty if the bucket has none
+//
+func (r *cliCommand) getBucketPolicy(bucket string) (string, error) {
+	resp, err := r.s3Client.GetBucketPolicyWithContext(r.ctx, &s3.GetBucketPolicyInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		if e, ok := err.(awserr.Error); ok && e.Code() == "NoSuchBucketPolicy" {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return aws.StringValue(resp.Policy), nil
+}
@@ -0,0 +1,317 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/urfave/cli"
+)
+
+//
+// newPromoteCommand creates a new promote command
+//
+func newPromoteCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:      "promote",
+		Usage:     "copy one or more files from one environment (or s3://bucket/prefix) to another, re-encrypting them with the kms key of the destination",
+		ArgsUsage: "KEY...",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "from",
+				Usage: "the name of the environment, or s3://bucket/prefix, to copy the files from `NAME`",
+			},
+			cli.StringFlag{
+				Name:  "to",
+				Usage: "the name of the environment, or s3://bucket/prefix, to copy the files to `NAME`",
+			},
+			cli.StringFlag{
+				Name:   "audit-log",
+				Usage:  "the path to the file the promotions are recorded in `PATH`",
+				EnvVar: "KMSCTL_AUDIT_LOG",
+				Value:  homePath(".kmsctl", "audit.log"),
+			},
+			cli.StringFlag{
+				Name:  "k, kms",
+				Usage: "the kms key used to encrypt the files at the destination, overrides the environment `KEY`",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "display the changes which would be promoted without copying them",
+			},
+			cli.BoolFlag{
+				Name:  "show-values",
+				Usage: "display the values in the diff of the changes, by default they are redacted",
+			},
+			cli.BoolFlag{
+				Name:  "y, yes",
+				Usage: "do not prompt for confirmation before promoting the changes",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:from:s", "l:to:s"}, cmd, promoteFiles)
+		},
+	}
+}
+
+//
+// promotion is the audit record of a promoted file
+//
+type promotion struct {
+	Time     time.Time `json:"time"`
+	Identity string    `json:"identity"`
+	Key      string    `json:"key"`
+	From     string    `json:"from"`
+	To       string    `json:"to"`
+	Source   string    `json:"source"`
+	Target   string    `json:"target"`
+	KMS      string    `json:"kms,omitempty"`
+	Approval string    `json:"approval"`
+	// the content being promoted
+	content []byte
+	// the etag of the destination file the changes were diffed against, empty if it did not exist
+	etag string
+}
+
+//
+// promoteFiles copies the files between the environments, displaying the changes to the destination and
+// requiring confirmation before writing them; a destination changed since it was diffed is not overwritten
+//
+func promoteFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	if len(cx.Args()) <= 0 {
+		return newExitError(exitUsage, "you have not specified any files to promote")
+	}
+	if cx.String("from") == cx.String("to") {
+		return newExitError(exitUsage, "the source and destination environments are the same")
+	}
+	dryRun := cx.Bool("dry-run")
+	showValues := cx.Bool("show-values")
+
+	// step: create the clients for the environments
+	from, err := resolveEnvironment(cx, cx.String("from"))
+	if err != nil {
+		return err
+	}
+	to, err := resolveEnvironment(cx, cx.String("to"))
+	if err != nil {
+		return err
+	}
+	if kmsKey := cx.String("kms"); kmsKey != "" {
+		to.KMS = kmsKey
+	}
+	source, err := cmd.forEnvironment(cx, from)
+	if err != nil {
+		return err
+	}
+	target, err := cmd.forEnvironment(cx, to)
+	if err != nil {
+		return err
+	}
+
+	// step: expand the paths into the files
+	var keys []string
+	for _, x := range cx.Args() {
+		path := strings.TrimPrefix(x, "/")
+		if path != "" && !strings.HasSuffix(path, "/") {
+			keys = append(keys, path)
+			continue
+		}
+		files, err := source.listBucketKeys(from.Bucket, path)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			keys = append(keys, aws.StringValue(f.Key))
+		}
+	}
+
+	// step: retrieve the files and compare them against the destination
+	summary := newTransferSummary("promoted")
+	var changes []*promotion
+	for _, key := range keys {
+		record := &promotion{
+			Key:    key,
+			From:   from.Name,
+			To:     to.Name,
+			Source: fmt.Sprintf("s3://%s/%s", from.Bucket, source.objectKey(key)),
+			Target: fmt.Sprintf("s3://%s/%s", to.Bucket, target.objectKey(key)),
+			KMS:    to.KMS,
+		}
+		content, err := source.getFile(from.Bucket, key)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve the file: %s, error: %s", record.Source, err)
+		}
+		// note: the destination is retrieved directly, the etag being required to write it conditionally
+		exists := true
+		current, resp, err := target.getFileIfChanged(to.Bucket, key, "")
+		switch {
+		case err == nil:
+			record.etag = aws.StringValue(resp.ETag)
+		case exitCode(err) == exitNotFound:
+			exists = false
+		default:
+			return fmt.Errorf("failed to retrieve the file: %s, error: %s", record.Target, err)
+		}
+		diff, err := unifiedDiff(current, content, record.Target, record.Source)
+		switch {
+		case err != nil:
+			diff = fmt.Sprintf("the content of %s differs, %s\n", record.Target, err)
+		case !showValues:
+			diff = redactDiff(diff)
+		}
+		if diff == "" && exists {
+			summary.skip()
+			o.fields(map[string]interface{}{
+				"action": "promote",
+				"key":    key,
+				"source": record.Source,
+				"target": record.Target,
+				"change": "unchanged",
+			}).log("%s is unchanged\n", record.Target)
+			continue
+		}
+		change := "modified"
+		if !exists {
+			change = "created"
+		}
+		o.fields(map[string]interface{}{
+			"action": "promote",
+			"key":    key,
+			"source": record.Source,
+			"target": record.Target,
+			"change": change,
+			"diff":   diff,
+		}).log("%s", diff)
+
+		record.content = content
+		changes = append(changes, record)
+	}
+	if len(changes) <= 0 {
+		summary.print(o)
+		return nil
+	}
+	if dryRun {
+		o.fields(map[string]interface{}{
+			"action":  "promote",
+			"changes": len(changes),
+			"dry-run": true,
+		}).log("[dry-run] would promote %d files from %s to %s\n", len(changes), from.Name, to.Name)
+		return nil
+	}
+
+	// step: the changes must be approved before writing them
+	approval := "yes"
+	if !cx.Bool("yes") {
+		approval = "confirmed"
+	}
+	if err := confirm(cx, "this will promote %d files from %s to %s", len(changes), from.Name, to.Name); err != nil {
+		return err
+	}
+
+	// step: without a kms key the destination must encrypt by default
+	if to.KMS == "" {
+		if err := target.hasDefaultKmsEncryption(to.Bucket); err != nil {
+			return err
+		}
+	}
+
+	// step: the identity performing the promotion for the audit log
+	identity := "unknown"
+	if resp, err := sts.New(target.session).GetCallerIdentityWithContext(cmd.ctx, &sts.GetCallerIdentityInput{}); err == nil {
+		identity = aws.StringValue(resp.Arn)
+	}
+
+	// step: copy the files, the destination is encrypted with the kms key of the environment and only
+	// written if it is unchanged since the diff was approved
+	for _, record := range changes {
+		options := &uploadOptions{ifMatch: record.etag, ifNotExists: record.etag == ""}
+		if err := target.putContent(to.Bucket, record.Key, record.content, to.KMS, options); err != nil {
+			if exitCode(err) == exitFailure {
+				return fmt.Errorf("failed to promote the file: %s, it may have been changed since it was diffed, error: %s", record.Key, err)
+			}
+			return fmt.Errorf("failed to promote the file: %s, error: %s", record.Key, err)
+		}
+		record.Time = time.Now().UTC()
+		record.Identity = identity
+		record.Approval = approval
+		if err := writeAuditRecord(cx.String("audit-log"), record); err != nil {
+			return fmt.Errorf("failed to record the promotion in the audit log, error: %s", err)
+		}
+		summary.success()
+
+		o.fields(map[string]interface{}{
+			"action": "promote",
+			"key":    record.Key,
+			"source": record.Source,
+			"target": record.Target,
+		}).log("promoted %s to %s\n", record.Source, record.Target)
+	}
+	summary.print(o)
+
+	return summary.err()
+}
+
+//
+// resolveEnvironment retrieves the environment by name from the configuration file, or from a
+// s3://bucket/prefix location
+//
+func resolveEnvironment(cx *cli.Context, name string) (*environment, error) {
+	if !strings.HasPrefix(name, "s3://") {
+		return getEnvironment(cx, name)
+	}
+	bucket, prefix := splitS3Location(name)
+	if bucket == "" {
+		return nil, newExitError(exitUsage, "invalid location: %s, expected s3://bucket/prefix", name)
+	}
+	env := &environment{Name: name, Bucket: bucket}
+	if env.Prefix = strings.Trim(prefix, "/"); env.Prefix != "" {
+		env.Prefix += "/"
+	}
+
+	return env, nil
+}
+
+//
+// writeAuditRecord appends the record to the audit log
+//
+func writeAuditRecord(path string, record interface{}) error {
+	if path == "" {
+		return nil
+	}
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(encoded, '\n'))
+
+	return err
+}
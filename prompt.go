@@ -0,0 +1,97 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/urfave/cli"
+)
+
+// canPrompt checks if the missing options can be asked for, i.e. we are attached to a terminal
+func canPrompt() bool {
+	return isTerminal(os.Stdin) && isTerminal(os.Stderr)
+}
+
+//
+// promptOption asks the user for the value of a missing option, offering a selection of the buckets
+// or kms aliases where the option is one, and sets the option on the context
+//
+func promptOption(cx *cli.Context, cmd *cliCommand, name string) error {
+	choices, err := optionChoices(cmd, name)
+	if err != nil {
+		logger.warningf("unable to list the choices for the option: %s, error: %s", name, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "the option: '%s' is required\n", name)
+	for i, x := range choices {
+		fmt.Fprintf(os.Stderr, "  %3d) %s\n", i+1, x)
+	}
+	if len(choices) > 0 {
+		fmt.Fprintf(os.Stderr, "select a %s by number or enter a value: ", name)
+	} else {
+		fmt.Fprintf(os.Stderr, "enter a value for %s: ", name)
+	}
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	value := strings.TrimSpace(answer)
+	if n, err := strconv.Atoi(value); err == nil && n >= 1 && n <= len(choices) {
+		value = choices[n-1]
+	}
+	if value == "" {
+		return newExitError(exitUsage, "the command option: '%s' is required", name)
+	}
+
+	return cx.Set(name, value)
+}
+
+// optionChoices returns the values which can be selected for the option, if any
+func optionChoices(cmd *cliCommand, name string) ([]string, error) {
+	var list []string
+	switch name {
+	case "bucket", "dest-bucket":
+		buckets, err := cmd.listS3Buckets()
+		if err != nil {
+			return nil, err
+		}
+		for _, x := range buckets {
+			list = append(list, aws.StringValue(x.Name))
+		}
+	case "kms", "dest-kms":
+		aliases, err := cmd.kmsKeys()
+		if err != nil {
+			return nil, err
+		}
+		for _, x := range aliases {
+			// note: the aws managed keys are not offered
+			if alias := aws.StringValue(x.AliasName); !strings.HasPrefix(alias, "alias/aws/") {
+				list = append(list, strings.TrimPrefix(alias, "alias/"))
+			}
+		}
+	}
+	sort.Strings(list)
+
+	return list, nil
+}
@@ -17,8 +17,10 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/urfave/cli"
 )
@@ -27,7 +29,7 @@ func newPutCommand(cmd *cliCommand) cli.Command {
 	return cli.Command{
 		Name:  "put",
 		Usage: "upload one of more files, encrypt and place into the bucket",
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			cli.StringFlag{
 				Name:   "b, bucket",
 				Usage:  "the name of the s3 bucket containing the encrypted files",
@@ -35,7 +37,7 @@ func newPutCommand(cmd *cliCommand) cli.Command {
 			},
 			cli.StringFlag{
 				Name:   "k, kms",
-				Usage:  "the aws kms id to use when performing operations",
+				Usage:  "the aws kms id to use when performing operations, defaults to the bucket default encryption",
 				EnvVar: "AWS_KMS_ID",
 			},
 			cli.StringFlag{
@@ -46,9 +48,67 @@ func newPutCommand(cmd *cliCommand) cli.Command {
 				Name:  "flatten",
 				Usage: "do not maintain the directory structure, flatten all files into a single directory",
 			},
-		},
+			cli.BoolFlag{
+				Name:  "continue-on-error",
+				Usage: "continue processing the remaining files on a failure, exiting non-zero once complete",
+			},
+			cli.StringSliceFlag{
+				Name:  "t, tag",
+				Usage: "a tag to place on the uploaded files, can be specified multiple times `KEY=VALUE`",
+			},
+			cli.StringFlag{
+				Name:  "expires",
+				Usage: "record when the files expire, a duration (i.e. 90d) or a date (i.e. 2006-01-02) `EXPIRY`",
+			},
+			cli.BoolFlag{
+				Name:  "follow-symlinks",
+				Usage: "follow all the symlinks, by default only a symlink given as the path is followed",
+			},
+			cli.BoolFlag{
+				Name:  "skip-symlinks",
+				Usage: "skip all the symlinks, including those given as the path",
+			},
+			cli.BoolFlag{
+				Name:  "skip-unchanged",
+				Usage: "skip the upload of the files whose content and kms key match the file in the bucket",
+			},
+			cli.BoolFlag{
+				Name:  "if-not-exists",
+				Usage: "fail rather than overwrite the file if it already exists in the bucket",
+			},
+			cli.StringFlag{
+				Name:  "if-match",
+				Usage: "only overwrite the file if its etag still matches, i.e. the etag from a json listing `ETAG`",
+			},
+			cli.BoolFlag{
+				Name:  "sops",
+				Usage: "decrypt the sops encoded files, verifying the mac, and upload the plaintext document",
+			},
+			cli.StringFlag{
+				Name:  "compress",
+				Usage: "compress the files before uploading them, they are decompressed on retrieval, i.e. gzip `ALGORITHM`",
+			},
+			cli.BoolFlag{
+				Name:  "watch",
+				Usage: "keep running, uploading the files under the paths as they are created or modified",
+			},
+			cli.DurationFlag{
+				Name:  "debounce",
+				Usage: "the time the files must be unchanged before they are uploaded when watching `DURATION`",
+				Value: 2 * time.Second,
+			},
+			cli.StringFlag{
+				Name:  "archive",
+				Usage: "bundle the files into a tar.gz uploaded as a single file, moving them as a unit `NAME`",
+			},
+			cli.BoolFlag{
+				Name:  "strict",
+				Usage: "fail rather than warn when the bucket does not block public access or has a public acl",
+			},
+			unlockFlag,
+		}, append(append(generateFlags(), hookFlags()...), transferFlags(true)...)...),
 		Action: func(cx *cli.Context) error {
-			return handleCommand(cx, []string{"l:bucket:s", "l:kms:s"}, cmd, putFiles)
+			return handleCommand(cx, []string{"l:bucket:s"}, cmd, putFiles)
 		},
 	}
 }
@@ -58,19 +118,72 @@ func newPutCommand(cmd *cliCommand) cli.Command {
 //
 func putFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 	bucket := cx.String("bucket")
+	cmd.unlocked = cx.Bool("unlock")
 	kms := cx.String("kms")
 	flatten := cx.Bool("flatten")
 	path := cx.String("path")
+	continueOnError := cx.Bool("continue-on-error")
+	sops := cx.Bool("sops")
 
 	if flatten && path != "" {
 		return fmt.Errorf("invalid option, you cannot flatten *and* specify a path")
 	}
+	if err := cmd.applyTransferOptions(cx); err != nil {
+		return err
+	}
+	hooks, err := getTransferHooks(cx, "put")
+	if err != nil {
+		return err
+	}
+	tags, err := parseKeyValues(cx.StringSlice("tag"))
+	if err != nil {
+		return newExitError(exitUsage, "invalid tag, error: %s", err)
+	}
+	if cx.String("expires") != "" {
+		expires, err := parseExpiry(cx.String("expires"), time.Now())
+		if err != nil {
+			return newExitError(exitUsage, "invalid expires: %s, error: %s", cx.String("expires"), err)
+		}
+		tags[expiresTag] = expires.UTC().Format(time.RFC3339)
+	}
+	if err := validCompression(cx.String("compress")); err != nil {
+		return err
+	}
+	options := &uploadOptions{
+		tags:          tags,
+		ifNotExists:   cx.Bool("if-not-exists"),
+		ifMatch:       cx.String("if-match"),
+		compress:      cx.String("compress"),
+		skipUnchanged: cx.Bool("skip-unchanged"),
+	}
+	if options.ifNotExists && options.ifMatch != "" {
+		return newExitError(exitUsage, "invalid option, you cannot use --if-not-exists and --if-match together")
+	}
+	var symlinks string
+	switch {
+	case cx.Bool("follow-symlinks") && cx.Bool("skip-symlinks"):
+		return newExitError(exitUsage, "invalid option, you cannot use --follow-symlinks and --skip-symlinks together")
+	case cx.Bool("follow-symlinks"):
+		symlinks = symlinksFollow
+	case cx.Bool("skip-symlinks"):
+		symlinks = symlinksSkip
+	}
 
 	// step: ensure the bucket exists
 	if found, err := cmd.hasBucket(bucket); err != nil {
 		return err
 	} else if !found {
-		return fmt.Errorf("the bucket: %s does not exist", bucket)
+		return newNotFoundError("the bucket: %s does not exist", bucket)
+	}
+	if err := checkPublicExposure(cx, cmd, bucket); err != nil {
+		return err
+	}
+
+	// step: fall back to the default encryption of the bucket if no key was given
+	if kms == "" {
+		if err := cmd.hasDefaultKmsEncryption(bucket); err != nil {
+			return err
+		}
 	}
 
 	// check: we need any least one argument
@@ -78,37 +191,191 @@ func putFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 		return fmt.Errorf("you have not specified any files to upload")
 	}
 
+	// step: are we generating the content of the files, the arguments being the keys
+	if cx.String("generate") != "" {
+		if sops || flatten || path != "" || cx.Bool("watch") || cx.String("archive") != "" {
+			return newExitError(exitUsage, "invalid option, you cannot use --generate with --sops, --flatten, --path, --watch or --archive")
+		}
+		for _, key := range cx.Args() {
+			if err := putGenerated(o, cx, cmd, bucket, key, kms, options); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	// note: the failures of the files are reported rather than stopping the watch
+	if cx.Bool("watch") {
+		continueOnError = true
+	}
+
+	// step: are we uploading the files as a bundle
+	if archive := cx.String("archive"); archive != "" {
+		if sops || flatten || cx.Bool("watch") {
+			return fmt.Errorf("invalid option, you cannot use --archive with --sops, --flatten or --watch")
+		}
+		if !isBundle(archive) {
+			return newExitError(exitUsage, "the archive: %s must have a .tar.gz or .tgz extension", archive)
+		}
+		keyName := archive
+		if path != "" {
+			keyName = fmt.Sprintf("%s/%s", strings.TrimRight(path, "/"), archive)
+		}
+		content, err := createBundle(cx.Args(), symlinks)
+		if err != nil {
+			return err
+		}
+		if err := cmd.putContent(bucket, keyName, content, kms, options); err == errUnchanged {
+			o.log("skipping the archive: %s, s3://%s/%s is unchanged\n", archive, bucket, keyName)
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to put the archive: %s, error: %s", keyName, err)
+		}
+		o.fields(map[string]interface{}{
+			"action": "put",
+			"bucket": bucket,
+			"key":    keyName,
+			"size":   len(content),
+		}).log("successfully pushed the archive: %s to s3://%s/%s\n", archive, bucket, keyName)
+
+		return nil
+	}
+
+	// step: upload the file, an error is only returned when we cannot continue
+	summary := newTransferSummary("uploaded")
+	pushFile := func(filename string) error {
+		// step: construct the key for this file, the keys are always separated by a slash
+		keyName := filepath.ToSlash(filename)
+		if flatten {
+			keyName = filepath.Base(keyName)
+		}
+		if path != "" {
+			keyName = fmt.Sprintf("%s/%s", strings.TrimRight(path, "/"), filepath.Base(keyName))
+		}
+
+		// step: upload the file to the bucket, decrypting any sops files
+		upload := func() error {
+			// note: the permissions and modification time are recorded for get --preserve
+			info, err := os.Stat(filename)
+			if err != nil {
+				return err
+			}
+			fileOptions := *options
+			fileOptions.metadata = localFileMetadata(info)
+
+			if !sops {
+				return cmd.putFile(bucket, keyName, filename, kms, &fileOptions)
+			}
+			content, err := cmd.readSopsFile(filename)
+			if err != nil {
+				return err
+			}
+			return cmd.putContent(bucket, keyName, content, kms, &fileOptions)
+		}
+		if err := runHooks(hooks, "put", bucket, keyName, filename, upload); err == errUnchanged {
+			summary.skip()
+			o.fields(map[string]interface{}{
+				"action": "put",
+				"path":   filename,
+				"bucket": bucket,
+				"key":    keyName,
+				"skip":   true,
+			}).log("skipping the file: %s, s3://%s/%s is unchanged\n", filename, bucket, keyName)
+			return nil
+		} else if err != nil {
+			if continueOnError {
+				summary.fail(filename, err)
+				return nil
+			}
+			return fmt.Errorf("failed to put the file: %s, error: %s", filename, err)
+		}
+		summary.success()
+
+		// step: add the log
+		o.fields(map[string]interface{}{
+			"action": "put",
+			"path":   filename,
+			"bucket": bucket,
+			"key":    keyName,
+		}).log("successfully pushed the file: %s to s3://%s/%s\n", filename, bucket, keyName)
+
+		return nil
+	}
+
 	// step: iterate the paths and upload the files
 	for _, p := range getPaths(cx) {
 		// step: get a list of files under this path
-		files, err := expandFiles(p)
+		files, err := expandFiles(p, symlinks)
 		if err != nil {
+			if continueOnError {
+				summary.fail(p, err)
+				continue
+			}
 			return fmt.Errorf("failed to process path: %s, error: %s", p, err)
 		}
 		// step: iterate the files in the path
 		for _, filename := range files {
-			// step: construct the key for this file
-			keyName := filename
-			if flatten {
-				keyName = filepath.Base(keyName)
-			}
-			if path != "" {
-				keyName = fmt.Sprintf("%s/%s", strings.TrimRight(path, "/"), filepath.Base(keyName))
+			if err := pushFile(filename); err != nil {
+				return err
 			}
+		}
+	}
+	summary.print(o)
 
-			// step: upload the file to the bucket
-			if err := cmd.putFile(bucket, keyName, filename, kms); err != nil {
-				return fmt.Errorf("failed to put the file: %s, error: %s", filename, err)
+	// step: mirror the changes to the files until we are stopped
+	if cx.Bool("watch") {
+		changesCh, err := watchFiles(cmd.ctx, cx.Args(), symlinks, cx.Duration("debounce"))
+		if err != nil {
+			return err
+		}
+		for files := range changesCh {
+			summary = newTransferSummary("uploaded")
+			for _, filename := range files {
+				pushFile(filename)
+			}
+			for filename, err := range summary.errors {
+				o.fields(map[string]interface{}{
+					"action": "put",
+					"path":   filename,
+					"bucket": bucket,
+					"error":  err,
+				}).log("failed to put the file: %s, error: %s\n", filename, err)
 			}
+		}
+		logger.infof("exiting the watch of the files")
 
-			// step: add the log
-			o.fields(map[string]interface{}{
-				"action": "put",
-				"path":   filename,
-				"bucket": bucket,
-				"key":    keyName,
-			}).log("successfully pushed the file: %s to s3://%s/%s\n", filename, bucket, keyName)
+		return nil
+	}
+
+	return summary.err()
+}
+
+//
+// checkPublicExposure warns, or with --strict fails, when the bucket could expose the files publicly
+//
+func checkPublicExposure(cx *cli.Context, cmd *cliCommand, bucket string) error {
+	// note: the bucket behind an access point cannot be inspected through it
+	if isAccessPoint(bucket) {
+		logger.debugf("skipping the public access check of the access point: %s", bucket)
+		return nil
+	}
+	issues, err := cmd.publicExposure(bucket)
+	if err != nil {
+		if cx.Bool("strict") {
+			return fmt.Errorf("unable to verify the bucket: %s blocks public access, %s", bucket, err)
 		}
+		logger.warningf("unable to verify the bucket: %s blocks public access, error: %s", bucket, err)
+		return nil
+	}
+	if len(issues) <= 0 {
+		return nil
+	}
+	if cx.Bool("strict") {
+		return newExitError(exitAccessDenied, "refusing to upload into the bucket: %s, %s", bucket, strings.Join(issues, ", "))
+	}
+	for _, x := range issues {
+		logger.warningf("the bucket: %s may expose the files publicly, %s", bucket, x)
 	}
 
 	return nil
@@ -0,0 +1,164 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+)
+
+//
+// regionFlags returns the options used by the read only commands to fan out across regions
+//
+func regionFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "regions",
+			Usage: "perform the operation across these regions, comma separated or specified multiple times `REGION`",
+		},
+		cli.BoolFlag{
+			Name:  "all-regions",
+			Usage: "perform the operation across all the regions the service is available in",
+		},
+	}
+}
+
+//
+// selectedRegions returns the regions the command should fan out across, or nil for only the default region
+//
+func (r *cliCommand) selectedRegions(cx *cli.Context, service string) ([]string, error) {
+	var list []string
+
+	if cx.Bool("all-regions") {
+		partition, found := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), r.region())
+		if !found {
+			return nil, fmt.Errorf("unable to determine the partition of the region: %s", r.region())
+		}
+		for name := range partition.Services()[service].Regions() {
+			list = append(list, name)
+		}
+	}
+	for _, x := range cx.StringSlice("regions") {
+		for _, name := range strings.Split(x, ",") {
+			if name = strings.TrimSpace(name); name != "" && !isValidOption(name, list) {
+				list = append(list, name)
+			}
+		}
+	}
+	sort.Strings(list)
+
+	return list, nil
+}
+
+//
+// region returns the region of the session
+//
+func (r *cliCommand) region() string {
+	return aws.StringValue(r.session.Config.Region)
+}
+
+//
+// regionPartition returns the partition of the region, i.e. aws, aws-cn or aws-us-gov, defaulting to aws
+//
+func regionPartition(region string) endpoints.Partition {
+	if partition, found := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); found {
+		return partition
+	}
+
+	return endpoints.AwsPartition()
+}
+
+// s3ARN returns the arn of the bucket or object in the partition of the region
+func s3ARN(region, resource string) string {
+	return fmt.Sprintf("arn:%s:s3:::%s", regionPartition(region).ID(), resource)
+}
+
+//
+// getBucketRegion returns the region the bucket resides in
+//
+func (r *cliCommand) getBucketRegion(name string) (string, error) {
+	if isAccessPoint(name) {
+		return accessPointRegion(name), nil
+	}
+	resp, err := r.s3Client.GetBucketLocationWithContext(r.ctx, &s3.GetBucketLocationInput{
+		Bucket: aws.String(name),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return s3.NormalizeBucketLocation(aws.StringValue(resp.LocationConstraint)), nil
+}
+
+//
+// regionWarning reports a failure in one of the regions, the fan out continues with the others
+//
+func regionWarning(region string, err error) {
+	logger.warningf("skipping the region: %s, error: %s", region, err)
+}
+
+//
+// bucketsInRegions returns the buckets which reside in the regions, along with the region of each, skipping
+// the buckets whose region cannot be retrieved
+//
+func (r *cliCommand) bucketsInRegions(regions []string) ([]*s3.Bucket, map[string]string, error) {
+	buckets, err := r.listS3Buckets()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// step: retrieve the location of the buckets concurrently
+	locations := make([]string, len(buckets))
+	errs := make([]error, len(buckets))
+	var wg sync.WaitGroup
+	limiter := make(chan struct{}, headConcurrency)
+	for i, x := range buckets {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			limiter <- struct{}{}
+			defer func() { <-limiter }()
+			locations[i], errs[i] = r.getBucketRegion(name)
+		}(i, *x.Name)
+	}
+	wg.Wait()
+
+	var list []*s3.Bucket
+	found := make(map[string]string, 0)
+	for i, x := range buckets {
+		// note: a bucket whose location is denied or which was removed since the listing is skipped
+		if errs[i] != nil {
+			if r.ctx.Err() != nil {
+				return nil, nil, r.ctx.Err()
+			}
+			logger.warningf("skipping the bucket: %s, unable to retrieve its region, error: %s", *x.Name, errs[i])
+			continue
+		}
+		if isValidOption(locations[i], regions) {
+			list = append(list, x)
+			found[*x.Name] = locations[i]
+		}
+	}
+
+	return list, found, nil
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+)
+
+//
+// newBucketReplicationCommand creates the bucket replication commands
+//
+func newBucketReplicationCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "replication",
+		Usage: "manage the replication of the bucket to another region, i.e. for disaster recovery",
+		Subcommands: []cli.Command{
+			{
+				Name:  "set",
+				Usage: "replicate the kms encrypted files of the bucket into a destination bucket, re-encrypting with the destination key",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "b, bucket",
+						Usage:  "the name of the source bucket `NAME`",
+						EnvVar: "AWS_S3_BUCKET",
+					},
+					cli.StringFlag{
+						Name:  "dest-bucket",
+						Usage: "the name of the bucket to replicate the files into `NAME`",
+					},
+					cli.StringFlag{
+						Name:  "dest-region",
+						Usage: "the region the destination bucket and kms key reside in `REGION`",
+					},
+					cli.StringFlag{
+						Name:  "dest-kms",
+						Usage: "the alias, arn or id of the kms key in the destination region to encrypt the replicas `KEY`",
+					},
+					cli.StringFlag{
+						Name:  "role",
+						Usage: "the arn of the iam role s3 assumes to replicate the files `ARN`",
+					},
+					cli.StringFlag{
+						Name:  "prefix",
+						Usage: "only replicate the files under this prefix `PREFIX`",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:bucket:s", "l:dest-bucket:s", "l:dest-region:s", "l:dest-kms:s", "l:role:s"}, cmd, setBucketReplication)
+				},
+			},
+			{
+				Name:      "status",
+				Usage:     "display the replication status of the files in the bucket",
+				ArgsUsage: "[PREFIX...]",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "b, bucket",
+						Usage:  "the name of the source bucket `NAME`",
+						EnvVar: "AWS_S3_BUCKET",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:bucket:s"}, cmd, bucketReplicationStatus)
+				},
+			},
+		},
+	}
+}
+
+func setBucketReplication(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	name := cx.String("bucket")
+	destBucket := cx.String("dest-bucket")
+	destRegion := cx.String("dest-region")
+
+	// step: replication requires versioning on both buckets
+	destS3Client := s3.New(cmd.sessionForRegion(destRegion))
+	for _, x := range []struct {
+		client *s3.S3
+		bucket string
+	}{
+		{client: cmd.s3Client, bucket: name},
+		{client: destS3Client, bucket: destBucket},
+	} {
+		resp, err := x.client.GetBucketVersioningWithContext(cmd.ctx, &s3.GetBucketVersioningInput{
+			Bucket: aws.String(x.bucket),
+		})
+		if err != nil {
+			return err
+		}
+		if aws.StringValue(resp.Status) != s3.BucketVersioningStatusEnabled {
+			return fmt.Errorf("replication requires versioning be enabled on the bucket: %s", x.bucket)
+		}
+	}
+
+	// step: resolve the destination kms key in the destination region
+	resp, err := kms.New(cmd.sessionForRegion(destRegion)).DescribeKeyWithContext(cmd.ctx, &kms.DescribeKeyInput{
+		KeyId: aws.String(kmsKeyID(cx.String("dest-kms"))),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to resolve the destination kms key: %s, error: %s", cx.String("dest-kms"), err)
+	}
+	destKey := aws.StringValue(resp.KeyMetadata.Arn)
+
+	if _, err := cmd.s3Client.PutBucketReplicationWithContext(cmd.ctx, &s3.PutBucketReplicationInput{
+		Bucket: aws.String(name),
+		ReplicationConfiguration: &s3.ReplicationConfiguration{
+			Role: aws.String(cx.String("role")),
+			Rules: []*s3.ReplicationRule{
+				{
+					ID:       aws.String("kmsctl-" + destRegion),
+					Priority: aws.Int64(1),
+					Status:   aws.String(s3.ReplicationRuleStatusEnabled),
+					Filter: &s3.ReplicationRuleFilter{
+						Prefix: aws.String(cx.String("prefix")),
+					},
+					DeleteMarkerReplication: &s3.DeleteMarkerReplication{
+						Status: aws.String(s3.DeleteMarkerReplicationStatusDisabled),
+					},
+					SourceSelectionCriteria: &s3.SourceSelectionCriteria{
+						SseKmsEncryptedObjects: &s3.SseKmsEncryptedObjects{
+							Status: aws.String(s3.SseKmsEncryptedObjectsStatusEnabled),
+						},
+					},
+					Destination: &s3.Destination{
+						Bucket: aws.String(s3ARN(destRegion, destBucket)),
+						EncryptionConfiguration: &s3.EncryptionConfiguration{
+							ReplicaKmsKeyID: aws.String(destKey),
+						},
+					},
+				},
+			},
+		},
+	}); err != nil {
+		return err
+	}
+
+	o.fields(map[string]interface{}{
+		"operation":   "replication",
+		"bucket":      name,
+		"dest-bucket": destBucket,
+		"dest-region": destRegion,
+		"dest-kms":    destKey,
+	}).log("successfully set replication of bucket: %s to: %s (%s)\n", name, destBucket, destRegion)
+
+	return nil
+}
+
+func bucketReplicationStatus(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+
+	for _, p := range getPaths(cx) {
+		files, err := cmd.listBucketKeys(bucket, strings.TrimPrefix(p, "/"))
+		if err != nil {
+			return err
+		}
+		var keys []string
+		for _, x := range files {
+			keys = append(keys, *x.Key)
+		}
+		heads, err := cmd.headObjects(bucket, keys)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			// step: files outside of the replication rules have no status
+			status := defaultValue(aws.StringValue(heads[key].ReplicationStatus), "NONE")
+
+			o.fields(map[string]interface{}{
+				"bucket": bucket,
+				"key":    key,
+				"status": status,
+			}).log("%-10s %s\n", status, key)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,318 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+//
+// partialDownload is the state of an interrupted download, the content retrieved so far being kept
+// alongside it in a .part file
+//
+type partialDownload struct {
+	// the bucket of the file
+	Bucket string `json:"bucket"`
+	// the key of the file, including any prefix
+	Key string `json:"key"`
+	// the etag of the file being retrieved
+	ETag string `json:"etag"`
+	// the size of the file
+	Size int64 `json:"size"`
+}
+
+//
+// partialUpload is the state of an interrupted multipart upload
+//
+type partialUpload struct {
+	// the bucket of the file
+	Bucket string `json:"bucket"`
+	// the key of the file, including any prefix
+	Key string `json:"key"`
+	// the id of the multipart upload
+	UploadID string `json:"upload_id"`
+	// the checksum of the content being uploaded
+	Checksum string `json:"checksum"`
+	// the size of the content
+	Size int64 `json:"size"`
+	// the size of the parts
+	PartSize int64 `json:"part_size"`
+	// when the upload was started
+	Started time.Time `json:"started"`
+}
+
+// transferPath returns the path of the transfer state for the file, with the extension
+func (r *cliCommand) transferPath(bucket, key, extension string) string {
+	sum := sha256.Sum256([]byte(bucket + "/" + key))
+
+	return filepath.Join(r.resumeDir, hex.EncodeToString(sum[:])+extension)
+}
+
+//
+// resumeDownload retrieves the file, continuing from the content retrieved by an interrupted attempt
+// provided the file has not changed since; no content is returned if the etag matches
+//
+func (r *cliCommand) resumeDownload(bucket, key, etag string) ([]byte, *s3.GetObjectOutput, error) {
+	statePath := r.transferPath(bucket, r.objectKey(key), ".download.json")
+	partPath := r.transferPath(bucket, r.objectKey(key), ".part")
+
+	// step: find how much of the file was retrieved, if any
+	var offset int64
+	state := &partialDownload{}
+	if content, err := ioutil.ReadFile(statePath); err == nil && json.Unmarshal(content, state) == nil {
+		if stat, err := os.Stat(partPath); err == nil && stat.Size() < state.Size {
+			offset = stat.Size()
+		}
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(r.objectKey(key)),
+	}
+	if etag != "" {
+		input.IfNoneMatch = aws.String(etag)
+	}
+	if offset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+		input.IfMatch = aws.String(state.ETag)
+	}
+	resp, err := r.s3Client.GetObjectWithContext(r.ctx, input)
+	if err != nil {
+		e, ok := err.(awserr.RequestFailure)
+		switch {
+		case ok && e.StatusCode() == http.StatusNotModified:
+			return nil, nil, nil
+		case ok && e.StatusCode() == http.StatusPreconditionFailed && offset > 0:
+			// note: the file has changed since the interrupted attempt, so we start again
+			logger.warningf("the file: %s has changed since the download was interrupted, restarting it", key)
+			removeTransfer(statePath, partPath)
+			return r.resumeDownload(bucket, key, etag)
+		}
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if offset <= 0 {
+		state = &partialDownload{
+			Bucket: bucket,
+			Key:    r.objectKey(key),
+			ETag:   aws.StringValue(resp.ETag),
+			Size:   aws.Int64Value(resp.ContentLength),
+		}
+		encoded, err := json.Marshal(state)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := writeFileAtomic(statePath, encoded, 0600); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		logger.infof("resuming the download of: %s from %d of %d bytes", key, offset, state.Size)
+	}
+
+	// step: append the content to the partial file, kept should the transfer be interrupted
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if offset <= 0 {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(partPath, flags, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.Copy(file, r.limitReader(resp.Body)); err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("the download of: %s was interrupted, rerun with --resume to continue, error: %s", key, err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	// step: read back the complete file, verifying the checksum and reversing any compression
+	content, err := ioutil.ReadFile(partPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(content))
+	if content, err = r.readObject(key, resp); err != nil {
+		removeTransfer(statePath, partPath)
+		return nil, nil, err
+	}
+	removeTransfer(statePath, partPath)
+
+	return content, resp, nil
+}
+
+//
+// resumeUpload uploads the content in parts, recording the multipart upload so an interrupted upload
+// of the same content continues from the parts already uploaded
+//
+func (r *cliCommand) resumeUpload(input *s3manager.UploadInput, body io.ReadSeeker, size int64, checksum string) error {
+	statePath := r.transferPath(*input.Bucket, *input.Key, ".upload.json")
+
+	// note: s3 permits at most 10000 parts
+	partSize := r.uploader.PartSize
+	if size/partSize >= s3manager.MaxUploadParts {
+		partSize = size/s3manager.MaxUploadParts + 1
+	}
+
+	// step: find the parts already uploaded by an interrupted attempt
+	done := make(map[int64]*s3.CompletedPart, 0)
+	state := &partialUpload{}
+	if content, err := ioutil.ReadFile(statePath); err == nil && json.Unmarshal(content, state) == nil &&
+		state.Checksum == checksum && state.Size == size {
+		partSize = state.PartSize
+		err := r.s3Client.ListPartsPagesWithContext(r.ctx, &s3.ListPartsInput{
+			Bucket:   input.Bucket,
+			Key:      input.Key,
+			UploadId: aws.String(state.UploadID),
+		}, func(page *s3.ListPartsOutput, last bool) bool {
+			for _, x := range page.Parts {
+				done[aws.Int64Value(x.PartNumber)] = &s3.CompletedPart{ETag: x.ETag, PartNumber: x.PartNumber}
+			}
+			return true
+		})
+		if err != nil {
+			logger.warningf("unable to continue the upload of: %s, restarting it, error: %s", *input.Key, err)
+			state.UploadID = ""
+		} else {
+			logger.infof("resuming the upload of: %s, %d parts already uploaded", *input.Key, len(done))
+		}
+	} else if state.UploadID != "" {
+		// note: the content has changed since, so the parts already uploaded are of no use
+		if _, err := r.s3Client.AbortMultipartUploadWithContext(r.ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   input.Bucket,
+			Key:      input.Key,
+			UploadId: aws.String(state.UploadID),
+		}); err != nil {
+			logger.warningf("unable to abort the previous upload of: %s, error: %s", *input.Key, err)
+		}
+		state.UploadID = ""
+	}
+
+	// step: start the multipart upload if not continuing one
+	if state.UploadID == "" {
+		resp, err := r.s3Client.CreateMultipartUploadWithContext(r.ctx, &s3.CreateMultipartUploadInput{
+			Bucket:               input.Bucket,
+			Key:                  input.Key,
+			Metadata:             input.Metadata,
+			ServerSideEncryption: input.ServerSideEncryption,
+			SSEKMSKeyId:          input.SSEKMSKeyId,
+			Tagging:              input.Tagging,
+		})
+		if err != nil {
+			return err
+		}
+		state = &partialUpload{
+			Bucket:   *input.Bucket,
+			Key:      *input.Key,
+			UploadID: aws.StringValue(resp.UploadId),
+			Checksum: checksum,
+			Size:     size,
+			PartSize: partSize,
+			Started:  time.Now().UTC(),
+		}
+		encoded, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(statePath, encoded, 0600); err != nil {
+			return err
+		}
+	}
+
+	// step: upload the parts which are missing
+	var parts []*s3.CompletedPart
+	for number, offset := int64(1), int64(0); offset < size; number, offset = number+1, offset+partSize {
+		if part, found := done[number]; found {
+			parts = append(parts, part)
+			continue
+		}
+		if _, err := body.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		content, err := ioutil.ReadAll(r.limitReader(io.LimitReader(body, partSize)))
+		if err != nil {
+			return err
+		}
+		resp, err := r.s3Client.UploadPartWithContext(r.ctx, &s3.UploadPartInput{
+			Bucket:     input.Bucket,
+			Key:        input.Key,
+			UploadId:   aws.String(state.UploadID),
+			PartNumber: aws.Int64(number),
+			Body:       bytes.NewReader(content),
+		})
+		if err != nil {
+			return fmt.Errorf("the upload of: %s was interrupted, rerun with --resume to continue, error: %s", *input.Key, err)
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: resp.ETag, PartNumber: aws.Int64(number)})
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return *parts[i].PartNumber < *parts[j].PartNumber
+	})
+
+	if _, err := r.s3Client.CompleteMultipartUploadWithContext(r.ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          input.Bucket,
+		Key:             input.Key,
+		UploadId:        aws.String(state.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	}); err != nil {
+		return fmt.Errorf("unable to complete the upload of: %s, rerun with --resume to retry, error: %s", *input.Key, err)
+	}
+	removeTransfer(statePath)
+
+	return nil
+}
+
+// removeTransfer removes the state of a completed transfer
+func removeTransfer(paths ...string) {
+	for _, x := range paths {
+		if err := os.Remove(x); err != nil && !os.IsNotExist(err) {
+			logger.warningf("unable to remove the transfer state: %s, error: %s", x, err)
+		}
+	}
+}
+
+// uploadStates returns the recorded multipart uploads in the directory by upload id
+func uploadStates(directory string) map[string]string {
+	states := make(map[string]string, 0)
+	files, err := filepath.Glob(filepath.Join(directory, "*.upload.json"))
+	if err != nil {
+		return states
+	}
+	for _, x := range files {
+		state := &partialUpload{}
+		if content, err := ioutil.ReadFile(x); err == nil && json.Unmarshal(content, state) == nil {
+			states[state.UploadID] = x
+		}
+	}
+
+	return states
+}
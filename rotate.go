@@ -0,0 +1,234 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/urfave/cli"
+)
+
+//
+// newRotateSecretCommand creates a new rotate-secret command
+//
+func newRotateSecretCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:      "rotate-secret",
+		Usage:     "rotate a file to a newly generated value, applying it to the target system with a hook before uploading it",
+		ArgsUsage: "KEY",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:  "hook",
+				Usage: "the command applying the new value to the target system, including any arguments `COMMAND`",
+			},
+			cli.StringFlag{
+				Name:  "generate",
+				Usage: "the value to generate, one of password:LENGTH, hex:LENGTH, base64:BYTES or rsa:BITS `TYPE`",
+				Value: "password:32",
+			},
+			cli.BoolFlag{
+				Name:  "kms-random",
+				Usage: "generate the random bytes with kms rather than locally, not supported for rsa keys",
+			},
+			cli.BoolFlag{
+				Name:  "rollback",
+				Usage: "restore the version of the file prior to the current one, applying it with the hook",
+			},
+			cli.StringFlag{
+				Name:  "to-version",
+				Usage: "the version of the file restored by --rollback, rather than the previous one `VERSION`",
+			},
+			cli.StringFlag{
+				Name:   "tmpdir",
+				Usage:  "the directory the values are written to for the hook, i.e. a tmpfs such as /dev/shm `DIR`",
+				EnvVar: "KMSCTL_TMPDIR",
+			},
+			cli.BoolFlag{
+				Name:  "y, yes",
+				Usage: "do not prompt for confirmation before rotating the file",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s", "l:hook:s"}, cmd, rotateSecret)
+		},
+	}
+}
+
+//
+// rotateSecret generates a new value for the file, or retrieves a prior version on a rollback, applies it
+// to the target system with the hook and uploads it as the new version of the file; the versioning of the
+// bucket keeps the previous value retrievable
+//
+func rotateSecret(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	rollback := cx.Bool("rollback")
+
+	if len(cx.Args()) != 1 {
+		return newExitError(exitUsage, "you must specify the key of the file to rotate")
+	}
+	if cx.String("to-version") != "" && !rollback {
+		return newExitError(exitUsage, "invalid option, --to-version requires --rollback")
+	}
+	key := strings.TrimPrefix(cx.Args()[0], "/")
+	hook, err := splitArgs(cx.String("hook"))
+	if err != nil || len(hook) <= 0 {
+		return newExitError(exitUsage, "invalid hook: %s", cx.String("hook"))
+	}
+
+	// step: the previous values are only retrievable from a versioned bucket
+	if status, err := cmd.getBucketVersioning(bucket); err != nil {
+		return err
+	} else if status != "Enabled" {
+		return newExitError(exitUsage, "the bucket: %s must have versioning enabled to rotate files, see buckets versioning", bucket)
+	}
+
+	// step: retrieve the current value of the file
+	metadata, err := cmd.getFileMetadata(key, bucket)
+	if err != nil {
+		return err
+	}
+	current, err := cmd.getFile(bucket, key)
+	if err != nil {
+		return err
+	}
+	tags, err := cmd.getObjectTags(bucket, key)
+	if err != nil {
+		return err
+	}
+	compression, _ := metadataValue(metadata.Metadata, compressionMetadata)
+	previous := aws.StringValue(metadata.VersionId)
+
+	// step: generate the new value, or retrieve the version being rolled back to
+	action := "rotate"
+	var value []byte
+	if rollback {
+		action = "rollback"
+		if value, err = cmd.rollbackVersion(bucket, key, previous, cx.String("to-version")); err != nil {
+			return err
+		}
+	} else {
+		secret, err := cmd.generateSecret(cx.String("generate"), cx.Bool("kms-random"))
+		if err != nil {
+			return err
+		}
+		value = secret.content
+	}
+	logger.redact(string(value))
+
+	if err := confirm(cx, "this will %s the file: s3://%s/%s, applying the value with: %s", action, bucket, key, cx.String("hook")); err != nil {
+		return err
+	}
+
+	// step: apply the value to the target system, nothing is uploaded if the hook fails
+	if err := runRotationHook(cx, hook, action, bucket, key, value, current); err != nil {
+		return fmt.Errorf("the hook failed, the file: %s has not been changed, error: %s", key, err)
+	}
+
+	// step: upload the value, provided the file has not been changed in the meantime
+	if err := cmd.putContent(bucket, key, value, aws.StringValue(metadata.SSEKMSKeyId), &uploadOptions{
+		tags:     tags,
+		compress: compression,
+		ifMatch:  aws.StringValue(metadata.ETag),
+	}); err != nil {
+		// note: the target system has the new value, so we attempt to restore the current one
+		logger.errorf("failed to upload the file: %s, reverting the target system with the hook, error: %s", key, err)
+		if e := runRotationHook(cx, hook, "revert", bucket, key, current, value); e != nil {
+			return fmt.Errorf("failed to upload the file: %s and to revert the target system, the target system "+
+				"has the new value, error: %s, hook error: %s", key, err, e)
+		}
+		return fmt.Errorf("failed to upload the file: %s, the target system was reverted, error: %s", key, err)
+	}
+
+	o.fields(map[string]interface{}{
+		"action":           action,
+		"bucket":           bucket,
+		"key":              key,
+		"previous-version": previous,
+	}).log("successfully performed the %s of s3://%s/%s, the previous version: %s\n", action, bucket, key, previous)
+
+	return nil
+}
+
+//
+// rollbackVersion retrieves the content of the version, or of the version prior to the current one
+//
+func (r *cliCommand) rollbackVersion(bucket, key, current, version string) ([]byte, error) {
+	if version == "" {
+		versions, err := r.listFileVersions(bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		for _, x := range versions {
+			if !x.deleted && x.version != current {
+				version = x.version
+				break
+			}
+		}
+		if version == "" {
+			return nil, newNotFoundError("the file: %s has no previous version to rollback to", key)
+		}
+	}
+	if version == current {
+		return nil, newExitError(exitUsage, "the version: %s is the current version of the file", version)
+	}
+
+	return r.getFileVersion(bucket, key, version)
+}
+
+//
+// runRotationHook invokes the hook with the values written to files in a private directory, the paths
+// being passed in the environment rather than the values themselves
+//
+func runRotationHook(cx *cli.Context, hook []string, action, bucket, key string, value, previous []byte) error {
+	directory, err := privateTempDir(cx.String("tmpdir"))
+	if err != nil {
+		return err
+	}
+	defer cleanup.remove(directory)
+
+	valuePath := filepath.Join(directory, "value")
+	previousPath := filepath.Join(directory, "previous")
+	if err := ioutil.WriteFile(valuePath, value, 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(previousPath, previous, 0600); err != nil {
+		return err
+	}
+
+	command := exec.Command(hook[0], hook[1:]...)
+	command.Env = append(os.Environ(),
+		"KMSCTL_ROTATION="+action,
+		"KMSCTL_BUCKET="+bucket,
+		"KMSCTL_KEY="+key,
+		"KMSCTL_SECRET_FILE="+valuePath,
+		"KMSCTL_PREVIOUS_SECRET_FILE="+previousPath,
+	)
+	command.Stdout = os.Stderr
+	command.Stderr = os.Stderr
+
+	return command.Run()
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// the name of the manifest written alongside the secrets
+	secretsManifestName = ".kmsctl-manifest.json"
+	// the permissions of the secrets written for containers
+	secretsFileMode = 0400
+)
+
+//
+// secretEntry is the record of a secret in the manifest
+//
+type secretEntry struct {
+	// the name of the secret, i.e. the filename
+	Name string `json:"name"`
+	// the bucket the secret was retrieved from
+	Bucket string `json:"bucket"`
+	// the key of the secret in the bucket
+	Key string `json:"key"`
+	// the etag of the object
+	ETag string `json:"etag"`
+	// the size of the secret
+	Size int64 `json:"size"`
+	// the time the secret was retrieved
+	Retrieved time.Time `json:"retrieved"`
+}
+
+//
+// secretsManifest records the secrets written into a secrets directory, i.e. /run/secrets
+//
+type secretsManifest struct {
+	// the directory containing the secrets
+	directory string
+	// the secrets keyed by name
+	entries map[string]*secretEntry
+	// the directory is a systemd credstore, owned by root and without a manifest
+	systemd bool
+}
+
+//
+// newSecretsManifest creates a manifest for the directory
+//
+func newSecretsManifest(directory string) *secretsManifest {
+	return &secretsManifest{
+		directory: directory,
+		entries:   make(map[string]*secretEntry, 0),
+	}
+}
+
+//
+// newSystemdCredstore creates a manifest for a systemd credential store, i.e. /etc/credstore, the
+// credentials are then consumed by the units via LoadCredential= or ImportCredential=
+//
+func newSystemdCredstore(directory string) *secretsManifest {
+	return &secretsManifest{
+		directory: directory,
+		entries:   make(map[string]*secretEntry, 0),
+		systemd:   true,
+	}
+}
+
+//
+// path returns the path of the secret in the directory, ensuring it does not conflict with another key
+//
+func (r *secretsManifest) path(bucket, key string) (string, error) {
+	name := filepath.Base(key)
+	if r.systemd && (name == "." || name == ".." || len(name) > 255) {
+		return "", fmt.Errorf("the key: %s is not a valid systemd credential name", key)
+	}
+	if entry, found := r.entries[name]; found && (entry.Bucket != bucket || entry.Key != key) {
+		return "", fmt.Errorf("the secret: %s conflicts with the key: %s", name, entry.Key)
+	}
+
+	return filepath.Join(r.directory, name), nil
+}
+
+//
+// add writes the secret into the directory and records it in the manifest
+//
+func (r *secretsManifest) add(bucket, key, etag string, content []byte) error {
+	path, err := r.path(bucket, key)
+	if err != nil {
+		return err
+	}
+	// step: the credential store must only be accessible to root
+	if r.systemd {
+		if err := os.MkdirAll(r.directory, 0700); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(path, content, secretsFileMode); err != nil {
+		return err
+	}
+	if r.systemd && os.Geteuid() == 0 {
+		if err := os.Chown(path, 0, 0); err != nil {
+			return err
+		}
+	}
+	r.entries[filepath.Base(key)] = &secretEntry{
+		Name:      filepath.Base(key),
+		Bucket:    bucket,
+		Key:       key,
+		ETag:      etag,
+		Size:      int64(len(content)),
+		Retrieved: time.Now().UTC(),
+	}
+
+	return nil
+}
+
+//
+// write saves the manifest into the directory
+//
+func (r *secretsManifest) write() error {
+	var names []string
+	for name := range r.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var list []*secretEntry
+	for _, name := range names {
+		list = append(list, r.entries[name])
+	}
+	content, err := json.MarshalIndent(map[string]interface{}{"secrets": list}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(r.directory, secretsManifestName), append(content, '\n'), 0444)
+}
@@ -0,0 +1,305 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/urfave/cli"
+)
+
+//
+// newExportSecretsManagerCommand creates the command to export files to secrets manager
+//
+func newExportSecretsManagerCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "secretsmanager",
+		Usage: "export the files in the bucket as secrets in secrets manager, json objects become key/value secrets",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files `NAME`",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:  "prefix",
+				Usage: "only export the files under this prefix, which is removed from the secret name `PREFIX`",
+			},
+			cli.StringFlag{
+				Name:  "name-prefix",
+				Usage: "a prefix added to the secret names, i.e. prod/app/ `PREFIX`",
+			},
+			cli.StringFlag{
+				Name:   "k, kms",
+				Usage:  "the kms key used to encrypt the secrets, defaults to the aws managed key (alias/aws/secretsmanager) `KEY`",
+				EnvVar: "AWS_KMS_ID",
+			},
+			cli.BoolFlag{
+				Name:  "overwrite",
+				Usage: "add a new version to any existing secrets, otherwise they are skipped",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "display the secrets which would be written without making any changes",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s"}, cmd, exportSecretsManager)
+		},
+	}
+}
+
+//
+// newImportSecretsManagerCommand creates the command to import secrets from secrets manager
+//
+func newImportSecretsManagerCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "secretsmanager",
+		Usage: "import the secrets from secrets manager into the bucket, key/value secrets are written as json",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "name-prefix",
+				Usage: "only import the secrets whose name starts with this prefix, which is removed from the key `PREFIX`",
+			},
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket to place the files `NAME`",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:  "prefix",
+				Usage: "the prefix in the bucket the secrets are placed under `PREFIX`",
+			},
+			cli.StringFlag{
+				Name:   "k, kms",
+				Usage:  "the kms key to encrypt the files with, defaults to the bucket default encryption `KEY`",
+				EnvVar: "AWS_KMS_ID",
+			},
+			cli.BoolFlag{
+				Name:  "overwrite",
+				Usage: "overwrite any existing files in the bucket, otherwise they are skipped",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "display the files which would be written without making any changes",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s"}, cmd, importSecretsManager)
+		},
+	}
+}
+
+//
+// exportSecretsManager writes the files in the bucket to secrets manager
+//
+func exportSecretsManager(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	prefix := cx.String("prefix")
+	namePrefix := cx.String("name-prefix")
+	dryRun := cx.Bool("dry-run")
+
+	files, err := cmd.listBucketKeys(bucket, prefix)
+	if err != nil {
+		return err
+	}
+	client := secretsmanager.New(cmd.session)
+
+	summary := newTransferSummary("exported")
+	for _, x := range files {
+		name := namePrefix + strings.TrimPrefix(strings.TrimPrefix(*x.Key, prefix), "/")
+		if dryRun {
+			o.fields(map[string]interface{}{
+				"action":  "export",
+				"bucket":  bucket,
+				"key":     *x.Key,
+				"secret":  name,
+				"dry-run": true,
+			}).log("[dry-run] would export s3://%s/%s to the secret: %s\n", bucket, *x.Key, name)
+			summary.skip()
+			continue
+		}
+
+		content, err := cmd.getFile(bucket, *x.Key)
+		if err != nil {
+			summary.fail(*x.Key, err)
+			continue
+		}
+		secretString, secretBinary := secretPayload(content)
+
+		// step: create the secret, or add a new version if it exists and we are overwriting
+		input := &secretsmanager.CreateSecretInput{
+			Name:         aws.String(name),
+			SecretString: secretString,
+			SecretBinary: secretBinary,
+		}
+		if kms := cx.String("kms"); kms != "" {
+			input.KmsKeyId = aws.String(kmsKeyID(kms))
+		}
+		_, err = client.CreateSecretWithContext(cmd.ctx, input)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceExistsException {
+			if !cx.Bool("overwrite") {
+				o.fields(map[string]interface{}{
+					"action":  "export",
+					"key":     *x.Key,
+					"secret":  name,
+					"skipped": true,
+				}).log("skipping the secret: %s, it already exists (use --overwrite)\n", name)
+				summary.skip()
+				continue
+			}
+			_, err = client.PutSecretValueWithContext(cmd.ctx, &secretsmanager.PutSecretValueInput{
+				SecretId:     aws.String(name),
+				SecretString: secretString,
+				SecretBinary: secretBinary,
+			})
+		}
+		if err != nil {
+			summary.fail(*x.Key, err)
+			continue
+		}
+		summary.success()
+
+		o.fields(map[string]interface{}{
+			"action": "export",
+			"bucket": bucket,
+			"key":    *x.Key,
+			"secret": name,
+		}).log("exported s3://%s/%s to the secret: %s\n", bucket, *x.Key, name)
+	}
+	summary.print(o)
+
+	return summary.err()
+}
+
+//
+// importSecretsManager copies the secrets into the bucket
+//
+func importSecretsManager(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	prefix := cx.String("prefix")
+	namePrefix := cx.String("name-prefix")
+	kms := cx.String("kms")
+	dryRun := cx.Bool("dry-run")
+
+	if kms == "" {
+		if err := cmd.hasDefaultKmsEncryption(bucket); err != nil {
+			return err
+		}
+	}
+	client := secretsmanager.New(cmd.session)
+
+	// step: retrieve the secrets, filtered by the name prefix
+	input := &secretsmanager.ListSecretsInput{}
+	if namePrefix != "" {
+		input.Filters = []*secretsmanager.Filter{
+			{Key: aws.String(secretsmanager.FilterNameStringTypeName), Values: aws.StringSlice([]string{namePrefix})},
+		}
+	}
+	var names []string
+	err := client.ListSecretsPagesWithContext(cmd.ctx, input, func(page *secretsmanager.ListSecretsOutput, last bool) bool {
+		for _, x := range page.SecretList {
+			// note: the name filter matches on any word in the name, so we check the prefix
+			if strings.HasPrefix(aws.StringValue(x.Name), namePrefix) {
+				names = append(names, aws.StringValue(x.Name))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	// note: the existing files are skipped below, the condition guarding against those created since
+	options := &uploadOptions{ifNotExists: !cx.Bool("overwrite")}
+	summary := newTransferSummary("imported")
+	for _, name := range names {
+		key := prefix + strings.TrimPrefix(strings.TrimPrefix(name, namePrefix), "/")
+
+		// step: skip any existing files unless overwriting
+		if !cx.Bool("overwrite") {
+			found, err := cmd.hasKey(key, bucket)
+			if err != nil {
+				summary.fail(name, err)
+				continue
+			}
+			if found {
+				o.fields(map[string]interface{}{
+					"action":  "import",
+					"secret":  name,
+					"key":     key,
+					"skipped": true,
+				}).log("skipping the file: s3://%s/%s, it already exists (use --overwrite)\n", bucket, key)
+				summary.skip()
+				continue
+			}
+		}
+		if dryRun {
+			o.fields(map[string]interface{}{
+				"action":  "import",
+				"secret":  name,
+				"bucket":  bucket,
+				"key":     key,
+				"dry-run": true,
+			}).log("[dry-run] would import the secret: %s to s3://%s/%s\n", name, bucket, key)
+			summary.skip()
+			continue
+		}
+
+		resp, err := client.GetSecretValueWithContext(cmd.ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(name),
+		})
+		if err != nil {
+			summary.fail(name, err)
+			continue
+		}
+		content := resp.SecretBinary
+		if resp.SecretString != nil {
+			content = []byte(aws.StringValue(resp.SecretString))
+		}
+		if err := cmd.putContent(bucket, key, content, kms, options); err != nil {
+			summary.fail(name, err)
+			continue
+		}
+		summary.success()
+
+		o.fields(map[string]interface{}{
+			"action": "import",
+			"secret": name,
+			"bucket": bucket,
+			"key":    key,
+		}).log("imported the secret: %s to s3://%s/%s\n", name, bucket, key)
+	}
+	summary.print(o)
+
+	return summary.err()
+}
+
+//
+// secretPayload returns the secret string for text content, which includes json objects, shown as
+// key/value secrets, or the secret binary for anything else
+//
+func secretPayload(content []byte) (*string, []byte) {
+	if utf8.Valid(content) {
+		return aws.String(string(content)), nil
+	}
+
+	return nil, content
+}
@@ -0,0 +1,313 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/urfave/cli"
+)
+
+//
+// newServeCommand creates a new serve command
+//
+func newServeCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "serve",
+		Usage: "serve the files in the bucket over a read only http api, protected by a bearer token",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:   "l, listen",
+				Usage:  "the interface and port the http api should listen on `ADDRESS`",
+				EnvVar: "KMSCTL_LISTEN",
+				Value:  "127.0.0.1:8200",
+			},
+			cli.StringFlag{
+				Name:   "t, token-file",
+				Usage:  "the path to a file containing the bearer token the clients must present `PATH`",
+				EnvVar: "KMSCTL_TOKEN_FILE",
+			},
+			cli.DurationFlag{
+				Name:  "cache-ttl",
+				Usage: "the duration the files are cached in memory, zero to disable the cache",
+				Value: time.Duration(5 * time.Minute),
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s", "l:listen:s", "l:token-file:s"}, cmd, serveBucket)
+		},
+	}
+}
+
+//
+// cachedSecret is a file held in the cache
+//
+type cachedSecret struct {
+	// the content of the file
+	content []byte
+	// the time the entry expires
+	expires time.Time
+}
+
+//
+// secretServer serves the files in the bucket over http
+//
+type secretServer struct {
+	sync.RWMutex
+	// the bucket the files are served from
+	bucket string
+	// the bearer token the clients must present
+	token string
+	// the duration the files are cached for
+	ttl time.Duration
+	// the cache of files, keyed by key
+	cache map[string]*cachedSecret
+	// the command used to access the bucket
+	cmd *cliCommand
+	// the output formatter
+	o *formatter
+}
+
+//
+// serveBucket runs the http api until the process is interrupted
+//
+func serveBucket(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	listen := cx.String("listen")
+
+	// step: read the bearer token
+	content, err := ioutil.ReadFile(cx.String("token-file"))
+	if err != nil {
+		return fmt.Errorf("unable to read the token file, error: %s", err)
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return fmt.Errorf("the token file: %s is empty", cx.String("token-file"))
+	}
+	logger.redact(token)
+
+	// step: ensure the bucket exists
+	if found, err := cmd.hasBucket(bucket); err != nil {
+		return err
+	} else if !found {
+		return newNotFoundError("the bucket: %s does not exist", bucket)
+	}
+
+	server := &secretServer{
+		bucket: bucket,
+		token:  token,
+		ttl:    cx.Duration("cache-ttl"),
+		cache:  make(map[string]*cachedSecret, 0),
+		cmd:    cmd,
+		o:      o,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/secret/", server.authorized(server.handleSecret))
+	mux.HandleFunc("/v1/list", server.authorized(server.handleList))
+	mux.HandleFunc("/v1/list/", server.authorized(server.handleList))
+	mux.HandleFunc("/metrics", metrics.handleMetrics)
+	mux.HandleFunc("/healthz", metrics.healthHandler(0))
+
+	httpServer := &http.Server{
+		Addr:              listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// step: evict the expired files, so they are not held in memory once the ttl has passed
+	if server.ttl > 0 {
+		go func() {
+			ticker := time.NewTicker(server.ttl)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-cmd.ctx.Done():
+					return
+				case <-ticker.C:
+					server.prune()
+				}
+			}
+		}()
+	}
+
+	// step: shutdown the server when the command is cancelled
+	go func() {
+		<-cmd.ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(ctx)
+	}()
+
+	o.fields(map[string]interface{}{
+		"action": "serve",
+		"bucket": bucket,
+		"listen": listen,
+	}).log("serving the bucket: %s on: %s\n", bucket, listen)
+
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
+
+//
+// authorized wraps the handler, rejecting any requests without the bearer token
+//
+func (r *secretServer) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		header := req.Header.Get("Authorization")
+		token := strings.TrimPrefix(header, "Bearer ")
+		if token == header || subtle.ConstantTimeCompare([]byte(token), []byte(r.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			r.logRequest(req, http.StatusUnauthorized)
+			return
+		}
+		next(w, req)
+	}
+}
+
+//
+// handleSecret returns the content of the file
+//
+func (r *secretServer) handleSecret(w http.ResponseWriter, req *http.Request) {
+	key := strings.TrimPrefix(req.URL.Path, "/v1/secret/")
+	if key == "" {
+		http.Error(w, "no key specified", http.StatusBadRequest)
+		return
+	}
+
+	content, err := r.getSecret(key)
+	if err != nil {
+		code := httpStatus(err)
+		http.Error(w, http.StatusText(code), code)
+		r.logRequest(req, code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(content)
+	r.logRequest(req, http.StatusOK)
+}
+
+//
+// handleList returns the keys under the prefix
+//
+func (r *secretServer) handleList(w http.ResponseWriter, req *http.Request) {
+	prefix := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/v1/list"), "/")
+
+	files, err := r.cmd.listBucketKeys(r.bucket, prefix)
+	if err != nil {
+		code := httpStatus(err)
+		http.Error(w, http.StatusText(code), code)
+		r.logRequest(req, code)
+		return
+	}
+	keys := make([]string, 0)
+	for _, x := range files {
+		keys = append(keys, aws.StringValue(x.Key))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	r.logRequest(req, http.StatusOK)
+}
+
+//
+// getSecret retrieves the file from the cache, or the bucket
+//
+func (r *secretServer) getSecret(key string) ([]byte, error) {
+	r.RLock()
+	cached, found := r.cache[key]
+	r.RUnlock()
+	if found && time.Now().Before(cached.expires) {
+		return cached.content, nil
+	}
+
+	content, err := r.cmd.getFile(r.bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	if r.ttl > 0 {
+		r.Lock()
+		r.cache[key] = &cachedSecret{content: content, expires: time.Now().Add(r.ttl)}
+		r.Unlock()
+	}
+
+	return content, nil
+}
+
+//
+// prune removes the expired files from the cache
+//
+func (r *secretServer) prune() {
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	for key, x := range r.cache {
+		if !now.Before(x.expires) {
+			delete(r.cache, key)
+		}
+	}
+}
+
+//
+// logRequest logs the outcome of the request
+//
+func (r *secretServer) logRequest(req *http.Request, code int) {
+	metrics.add("kmsctl_http_requests_total", fmt.Sprintf(`code="%d"`, code), 1)
+	r.o.fields(map[string]interface{}{
+		"action": "request",
+		"method": req.Method,
+		"path":   req.URL.Path,
+		"remote": req.RemoteAddr,
+		"status": code,
+	}).log("%s %s %s %d\n", req.RemoteAddr, req.Method, req.URL.Path, code)
+}
+
+//
+// httpStatus returns the http status code for the error
+//
+func httpStatus(err error) int {
+	switch exitCode(err) {
+	case exitNotFound:
+		return http.StatusNotFound
+	case exitAccessDenied:
+		return http.StatusForbidden
+	}
+
+	return http.StatusBadGateway
+}
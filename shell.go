@@ -0,0 +1,441 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+//
+// shellState is the state held across the commands in an interactive shell
+//
+type shellState struct {
+	// the bucket we are working in
+	bucket string
+	// the prefix in the bucket, i.e. the current directory
+	prefix string
+	// the kms key to encrypt uploads with
+	kms string
+	// the commands entered in the shell
+	history []string
+	// the file the history is persisted to
+	historyFile string
+}
+
+//
+// shellBuiltin is a command available in the shell
+//
+type shellBuiltin struct {
+	// the usage of the command
+	usage string
+	// the method to call
+	method func(*formatter, *cliCommand, *shellState, []string) error
+}
+
+//
+// newShellCommand creates a new shell command
+//
+func newShellCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "shell",
+		Usage: "start an interactive shell, keeping the session, bucket and kms key between commands",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket to start the shell in `NAME`",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:   "k, kms",
+				Usage:  "the aws kms id to use when uploading files `KEY`",
+				EnvVar: "AWS_KMS_ID",
+			},
+			cli.StringFlag{
+				Name:  "history-file",
+				Usage: "the file used to persist the shell history `PATH`",
+				Value: homePath(".kmsctl_history"),
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{}, cmd, runShell)
+		},
+	}
+}
+
+//
+// shellBuiltins returns the commands available in the shell
+//
+func shellBuiltins() map[string]shellBuiltin {
+	return map[string]shellBuiltin{
+		"bucket":  {usage: "bucket [NAME]: display or change the bucket", method: shellBucket},
+		"kms":     {usage: "kms [KEY]: display or change the kms key used for uploads", method: shellKms},
+		"cd":      {usage: "cd [PATH]: change the current directory within the bucket", method: shellCd},
+		"pwd":     {usage: "pwd: display the current bucket and directory", method: shellPwd},
+		"ls":      {usage: "ls [PATH]: list the files and directories", method: shellLs},
+		"cat":     {usage: "cat FILE...: display the content of the files", method: shellCat},
+		"get":     {usage: "get FILE [DEST]: retrieve the file to the local destination", method: shellGet},
+		"put":     {usage: "put PATH [NAME]: upload and encrypt the local file into the current directory", method: shellPut},
+		"rm":      {usage: "rm FILE...: remove the files from the bucket", method: shellRm},
+		"history": {usage: "history: display the commands entered", method: shellHistory},
+	}
+}
+
+//
+// runShell reads and executes commands until exit or the end of the input
+//
+func runShell(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	state := &shellState{
+		bucket:      cx.String("bucket"),
+		kms:         cx.String("kms"),
+		historyFile: cx.String("history-file"),
+	}
+	builtins := shellBuiltins()
+
+	// step: load any previous history
+	if content, err := os.ReadFile(state.historyFile); err == nil {
+		state.history = strings.Split(strings.TrimSpace(string(content)), "\n")
+	}
+
+	// step: an interrupt cancels the command running, rather than leaving the shell
+	defer interrupts.set(func(os.Signal) {
+		fmt.Fprintf(os.Stdout, "\n%s> ", state.location())
+	})()
+
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		fmt.Fprintf(os.Stdout, "%s> ", state.location())
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if err == io.EOF && line == "" {
+			fmt.Fprintln(os.Stdout)
+			return nil
+		}
+
+		args, perr := splitArgs(strings.TrimSpace(line))
+		if perr != nil {
+			logger.errorf("unable to parse the command, error: %s", perr)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		state.addHistory(strings.TrimSpace(line))
+
+		switch name := args[0]; name {
+		case "exit", "quit":
+			return nil
+		case "help":
+			names := make([]string, 0, len(builtins))
+			for k := range builtins {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			for _, k := range names {
+				fmt.Fprintf(os.Stdout, "  %s\n", builtins[k].usage)
+			}
+			fmt.Fprintf(os.Stdout, "  exit: leave the shell\n")
+		default:
+			builtin, found := builtins[name]
+			if !found {
+				logger.errorf("unknown command: %s, type help for a list of commands", name)
+				continue
+			}
+			if err := runShellBuiltin(o, cmd, state, builtin, args[1:]); err != nil {
+				logger.errorf("%s failed, error: %s", name, err)
+			}
+		}
+	}
+}
+
+//
+// runShellBuiltin runs the command with a context of its own, cancelled on an interrupt
+//
+func runShellBuiltin(o *formatter, cmd *cliCommand, state *shellState, builtin shellBuiltin, args []string) error {
+	ctx, cancel := context.WithCancel(cmd.ctx)
+	defer cancel()
+	restore := interrupts.set(func(os.Signal) {
+		cancel()
+	})
+	defer restore()
+
+	command := *cmd
+	command.ctx, command.cancel = ctx, cancel
+
+	return builtin.method(o, &command, state, args)
+}
+
+//
+// location returns the prompt for the current state
+//
+func (r *shellState) location() string {
+	if r.bucket == "" {
+		return progName
+	}
+
+	return fmt.Sprintf("%s:s3://%s/%s", progName, r.bucket, r.prefix)
+}
+
+//
+// resolve returns the key in the bucket relative to the current directory
+//
+func (r *shellState) resolve(name string) string {
+	if strings.HasPrefix(name, "/") {
+		return strings.TrimPrefix(path.Clean(name), "/")
+	}
+	key := path.Clean("/" + r.prefix + name)
+	if key == "/" {
+		return ""
+	}
+
+	return strings.TrimPrefix(key, "/")
+}
+
+//
+// requireBucket checks a bucket has been selected
+//
+func (r *shellState) requireBucket() error {
+	if r.bucket == "" {
+		return fmt.Errorf("no bucket selected, use: bucket NAME")
+	}
+
+	return nil
+}
+
+//
+// addHistory records the command and appends it to the history file
+//
+func (r *shellState) addHistory(line string) {
+	r.history = append(r.history, line)
+	if r.historyFile == "" {
+		return
+	}
+	file, err := os.OpenFile(r.historyFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, line)
+}
+
+func shellBucket(o *formatter, cmd *cliCommand, state *shellState, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stdout, defaultValue(state.bucket, "none"))
+		return nil
+	}
+	found, err := cmd.hasBucket(args[0])
+	if err != nil {
+		return err
+	} else if !found {
+		return fmt.Errorf("the bucket: %s does not exist", args[0])
+	}
+	state.bucket = args[0]
+	state.prefix = ""
+
+	return nil
+}
+
+func shellKms(o *formatter, cmd *cliCommand, state *shellState, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stdout, defaultValue(state.kms, "bucket default"))
+		return nil
+	}
+	if _, err := cmd.describeKey(args[0]); err != nil {
+		return err
+	}
+	state.kms = args[0]
+
+	return nil
+}
+
+func shellCd(o *formatter, cmd *cliCommand, state *shellState, args []string) error {
+	if err := state.requireBucket(); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		state.prefix = ""
+		return nil
+	}
+	prefix := state.resolve(args[0])
+	if prefix != "" {
+		prefix = prefix + "/"
+	}
+	// step: check the directory has some content
+	keys, err := cmd.listBucketKeys(state.bucket, prefix)
+	if err != nil {
+		return err
+	} else if len(keys) == 0 && prefix != "" {
+		return fmt.Errorf("the directory: %s does not exist", args[0])
+	}
+	state.prefix = prefix
+
+	return nil
+}
+
+func shellPwd(o *formatter, cmd *cliCommand, state *shellState, args []string) error {
+	if err := state.requireBucket(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "s3://%s/%s\n", state.bucket, state.prefix)
+
+	return nil
+}
+
+func shellLs(o *formatter, cmd *cliCommand, state *shellState, args []string) error {
+	if err := state.requireBucket(); err != nil {
+		return err
+	}
+	prefix := state.prefix
+	if len(args) > 0 {
+		if prefix = state.resolve(args[0]); prefix != "" {
+			prefix = prefix + "/"
+		}
+	}
+	keys, err := cmd.listBucketKeys(state.bucket, prefix)
+	if err != nil {
+		return err
+	}
+
+	// step: only show the immediate children of the directory
+	seen := make(map[string]bool)
+	for _, x := range keys {
+		name := strings.TrimPrefix(*x.Key, prefix)
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[:i+1]
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		o.fields(map[string]interface{}{
+			"bucket": state.bucket,
+			"key":    prefix + name,
+		}).log("%s\n", name)
+	}
+
+	return nil
+}
+
+func shellCat(o *formatter, cmd *cliCommand, state *shellState, args []string) error {
+	if err := state.requireBucket(); err != nil {
+		return err
+	}
+	for _, x := range args {
+		content, err := cmd.getFile(state.bucket, state.resolve(x))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "%s", content)
+	}
+
+	return nil
+}
+
+func shellGet(o *formatter, cmd *cliCommand, state *shellState, args []string) error {
+	if err := state.requireBucket(); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("you have not specified the file to retrieve")
+	}
+	key := state.resolve(args[0])
+	dest := path.Base(key)
+	if len(args) > 1 {
+		dest = args[1]
+	}
+	// note: the file is writable by the user, so a later get of the same file can replace it
+	if err := processFile(dest, key, state.bucket, "0600", cmd); err != nil {
+		return err
+	}
+	o.fields(map[string]interface{}{
+		"action": "get",
+		"bucket": state.bucket,
+		"key":    key,
+		"path":   dest,
+	}).log("retrieved s3://%s/%s to %s\n", state.bucket, key, dest)
+
+	return nil
+}
+
+func shellPut(o *formatter, cmd *cliCommand, state *shellState, args []string) error {
+	if err := state.requireBucket(); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("you have not specified the file to upload")
+	}
+	name := filepath.Base(args[0])
+	if len(args) > 1 {
+		name = args[1]
+	}
+	key := state.resolve(name)
+
+	// step: without a kms key we rely on the default encryption of the bucket
+	if state.kms == "" {
+		if err := cmd.hasDefaultKmsEncryption(state.bucket); err != nil {
+			return err
+		}
+	}
+	if err := cmd.putFile(state.bucket, key, args[0], state.kms, nil); err != nil {
+		return err
+	}
+	o.fields(map[string]interface{}{
+		"action": "put",
+		"bucket": state.bucket,
+		"key":    key,
+		"path":   args[0],
+	}).log("successfully pushed the file: %s to s3://%s/%s\n", args[0], state.bucket, key)
+
+	return nil
+}
+
+func shellRm(o *formatter, cmd *cliCommand, state *shellState, args []string) error {
+	if err := state.requireBucket(); err != nil {
+		return err
+	}
+	for _, x := range args {
+		key := state.resolve(x)
+		if err := cmd.removeFile(state.bucket, key); err != nil {
+			return err
+		}
+		o.fields(map[string]interface{}{
+			"action": "delete",
+			"bucket": state.bucket,
+			"key":    key,
+		}).log("successfully deleted s3://%s/%s\n", state.bucket, key)
+	}
+
+	return nil
+}
+
+func shellHistory(o *formatter, cmd *cliCommand, state *shellState, args []string) error {
+	for i, x := range state.history {
+		fmt.Fprintf(os.Stdout, "%5d  %s\n", i+1, x)
+	}
+
+	return nil
+}
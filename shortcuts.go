@@ -0,0 +1,106 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+//
+// expandShortcuts replaces a command which is a shortcut from the configuration file with its expansion,
+// i.e. prodget = get --bucket prod-secrets, before the arguments are parsed; the commands always take
+// precedence over the shortcuts, and the expansions are not expanded again
+//
+func expandShortcuts(app *cli.App, args []string) ([]string, error) {
+	index, path := commandIndex(app, args)
+	if index < 0 {
+		return args, nil
+	}
+	name := args[index]
+	if app.Command(name) != nil {
+		return args, nil
+	}
+	if found, err := isFile(path); err != nil || !found {
+		return args, nil
+	}
+	c, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	expansion, found := c.Shortcuts[name]
+	if !found {
+		return args, nil
+	}
+	expanded, err := splitArgs(expansion)
+	if err != nil || len(expanded) <= 0 {
+		return nil, fmt.Errorf("the shortcut: %s has an invalid expansion: %s", name, expansion)
+	}
+	logger.debugf("expanding the shortcut: %s to: %s", name, expansion)
+
+	list := append([]string{}, args[:index]...)
+	list = append(list, expanded...)
+
+	return append(list, args[index+1:]...), nil
+}
+
+//
+// commandIndex returns the index of the command in the arguments, skipping the global options and their
+// values, along with the configuration file given by the options, the environment or the default
+//
+func commandIndex(app *cli.App, args []string) (int, string) {
+	path := defaultValue(os.Getenv("KMSCTL_CONFIG"), homePath(".kmsctl", "config.yaml"))
+
+	// step: the global options which do not take a value
+	switches := map[string]bool{"help": true, "h": true, "version": true, "v": true}
+	for _, flag := range app.Flags {
+		switch flag.(type) {
+		case cli.BoolFlag, cli.BoolTFlag:
+			for _, x := range strings.Split(flag.GetName(), ",") {
+				switches[strings.TrimSpace(x)] = true
+			}
+		}
+	}
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			if arg == "--" {
+				i++
+			}
+			if i < len(args) {
+				return i, path
+			}
+			return -1, path
+		}
+		name := strings.TrimLeft(arg, "-")
+		value := ""
+		if x := strings.Index(name, "="); x >= 0 {
+			name, value = name[:x], name[x+1:]
+		} else if !switches[name] && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		if name == "config" {
+			path = value
+		}
+	}
+
+	return -1, path
+}
@@ -0,0 +1,638 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// the version of sops the files are compatible with
+	sopsVersion = "3.8.1"
+	// the default suffix of keys which are not encrypted
+	sopsUnencryptedSuffix = "_unencrypted"
+)
+
+// sopsValueRegex matches an encrypted sops value
+var sopsValueRegex = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.*),tag:(.*),type:(.*)\]$`)
+
+//
+// sopsFile is a parsed sops yaml or json document
+//
+type sopsFile struct {
+	// the document, excluding the sops metadata
+	tree yaml.MapSlice
+	// the sops metadata block
+	metadata yaml.MapSlice
+	// the format of the file, json or yaml
+	format string
+}
+
+//
+// sopsFormat returns the format of the file from the extension, sops supports json and yaml documents
+//
+func sopsFormat(path string) string {
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		return "json"
+	}
+
+	return "yaml"
+}
+
+//
+// parseSopsFile parses the content into the document and sops metadata
+//
+func parseSopsFile(content []byte, format string) (*sopsFile, error) {
+	var tree yaml.MapSlice
+	// note: json is a subset of yaml, so the yaml decoder preserves the order of both
+	if err := yaml.Unmarshal(content, &tree); err != nil {
+		return nil, fmt.Errorf("unable to parse the %s document, error: %s", format, err)
+	}
+	file := &sopsFile{format: format}
+	for _, x := range tree {
+		if fmt.Sprint(x.Key) == "sops" {
+			if metadata, ok := x.Value.(yaml.MapSlice); ok {
+				file.metadata = metadata
+			}
+			continue
+		}
+		file.tree = append(file.tree, x)
+	}
+
+	return file, nil
+}
+
+//
+// decryptSops decrypts a sops file, verifying the mac, and returns the plaintext document
+//
+func (r *cliCommand) decryptSops(content []byte, format string) ([]byte, error) {
+	file, err := parseSopsFile(content, format)
+	if err != nil {
+		return nil, err
+	}
+	if file.metadata == nil {
+		return nil, fmt.Errorf("the file does not contain a sops metadata block")
+	}
+	dataKey, err := r.sopsDataKey(file.metadata)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := newSopsRules(file.metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// step: decrypt the values and compute the mac
+	hash := sha512.New()
+	tree, err := sopsWalk(file.tree, nil, func(value interface{}, path []string) (interface{}, error) {
+		if !rules.isEncrypted(path) {
+			if !rules.macOnlyEncrypted {
+				hash.Write(sopsBytes(value))
+			}
+			return value, nil
+		}
+		encrypted, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("the value at: %s is not encrypted", strings.Join(path, "."))
+		}
+		plain, err := sopsDecryptValue(encrypted, dataKey, sopsAdditionalData(path))
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt the value at: %s, error: %s", strings.Join(path, "."), err)
+		}
+		hash.Write(sopsBytes(plain))
+
+		return plain, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// step: verify the mac of the document
+	lastModified := sopsMetadataString(file.metadata, "lastmodified")
+	mac, err := sopsDecryptValue(sopsMetadataString(file.metadata, "mac"), dataKey, lastModified)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt the sops mac, error: %s", err)
+	}
+	if fmt.Sprint(mac) != fmt.Sprintf("%X", hash.Sum(nil)) {
+		return nil, fmt.Errorf("the sops mac does not match, the file has been tampered with")
+	}
+
+	return marshalSopsTree(tree.(yaml.MapSlice), format)
+}
+
+//
+// encryptSops encrypts the plaintext document into a sops file with the kms key, any existing
+// sops file is used to preserve the data key and metadata block
+//
+func (r *cliCommand) encryptSops(content, existing []byte, format, kmsKey string) ([]byte, error) {
+	plain, err := parseSopsFile(content, format)
+	if err != nil {
+		return nil, err
+	}
+
+	// step: reuse the data key and metadata of the existing file, or generate a new one
+	var dataKey []byte
+	var metadata yaml.MapSlice
+	if existing != nil {
+		if previous, err := parseSopsFile(existing, format); err == nil && previous.metadata != nil {
+			if key, err := r.sopsDataKey(previous.metadata); err == nil {
+				dataKey, metadata = key, previous.metadata
+			}
+		}
+	}
+	if dataKey == nil {
+		if dataKey, metadata, err = r.newSopsMetadata(kmsKey); err != nil {
+			return nil, err
+		}
+	}
+	rules, err := newSopsRules(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// step: encrypt the values and compute the mac
+	hash := sha512.New()
+	tree, err := sopsWalk(plain.tree, nil, func(value interface{}, path []string) (interface{}, error) {
+		encrypted := rules.isEncrypted(path)
+		if encrypted || !rules.macOnlyEncrypted {
+			hash.Write(sopsBytes(value))
+		}
+		if !encrypted {
+			return value, nil
+		}
+
+		return sopsEncryptValue(value, dataKey, sopsAdditionalData(path))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// step: update the modified time and mac of the metadata
+	lastModified := time.Now().UTC().Format(time.RFC3339)
+	mac, err := sopsEncryptValue(fmt.Sprintf("%X", hash.Sum(nil)), dataKey, lastModified)
+	if err != nil {
+		return nil, err
+	}
+	metadata = setSopsMetadataValue(metadata, "lastmodified", lastModified)
+	metadata = setSopsMetadataValue(metadata, "mac", mac)
+
+	document := append(tree.(yaml.MapSlice), yaml.MapItem{Key: "sops", Value: metadata})
+
+	return marshalSopsTree(document, format)
+}
+
+//
+// newSopsMetadata generates a data key, encrypted with the kms key, and the metadata block
+//
+func (r *cliCommand) newSopsMetadata(kmsKey string) ([]byte, yaml.MapSlice, error) {
+	key, err := r.describeKey(kmsKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	resp, err := r.kmsClient.EncryptWithContext(r.ctx, &kms.EncryptInput{
+		KeyId:     key.Arn,
+		Plaintext: dataKey,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dataKey, yaml.MapSlice{
+		{Key: "kms", Value: []interface{}{
+			yaml.MapSlice{
+				{Key: "arn", Value: aws.StringValue(key.Arn)},
+				{Key: "created_at", Value: time.Now().UTC().Format(time.RFC3339)},
+				{Key: "enc", Value: base64.StdEncoding.EncodeToString(resp.CiphertextBlob)},
+				{Key: "aws_profile", Value: ""},
+			},
+		}},
+		{Key: "lastmodified", Value: ""},
+		{Key: "mac", Value: ""},
+		{Key: "unencrypted_suffix", Value: sopsUnencryptedSuffix},
+		{Key: "version", Value: sopsVersion},
+	}, nil
+}
+
+//
+// sopsDataKey decrypts the data key from any of the kms entries in the metadata
+//
+func (r *cliCommand) sopsDataKey(metadata yaml.MapSlice) ([]byte, error) {
+	entries, _ := sopsMetadataValue(metadata, "kms").([]interface{})
+	if len(entries) <= 0 {
+		return nil, fmt.Errorf("the sops file has no kms key groups, only kms is supported")
+	}
+
+	var errs []string
+	for _, x := range entries {
+		entry, ok := x.(yaml.MapSlice)
+		if !ok {
+			continue
+		}
+		keyARN := sopsMetadataString(entry, "arn")
+		ciphertext, err := base64.StdEncoding.DecodeString(sopsMetadataString(entry, "enc"))
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", keyARN, err))
+			continue
+		}
+		input := &kms.DecryptInput{
+			CiphertextBlob: ciphertext,
+			KeyId:          aws.String(keyARN),
+		}
+		if encryptionContext, ok := sopsMetadataValue(entry, "context").(yaml.MapSlice); ok {
+			input.EncryptionContext = make(map[string]*string, 0)
+			for _, c := range encryptionContext {
+				input.EncryptionContext[fmt.Sprint(c.Key)] = aws.String(fmt.Sprint(c.Value))
+			}
+		}
+
+		// step: the key must be decrypted in the region of the kms key
+		client := r
+		if parsed, err := arn.Parse(keyARN); err == nil && parsed.Region != r.region() {
+			client = r.forRegion(parsed.Region)
+		}
+		resp, err := client.kmsClient.DecryptWithContext(r.ctx, input)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", keyARN, err))
+			continue
+		}
+
+		return resp.Plaintext, nil
+	}
+
+	return nil, fmt.Errorf("unable to decrypt the sops data key, errors: %s", strings.Join(errs, ", "))
+}
+
+//
+// sopsWalk visits the leaf values of the tree, replacing them with the value returned
+//
+func sopsWalk(value interface{}, path []string, fn func(interface{}, []string) (interface{}, error)) (interface{}, error) {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		var tree yaml.MapSlice
+		for _, x := range v {
+			walked, err := sopsWalk(x.Value, append(append([]string{}, path...), fmt.Sprint(x.Key)), fn)
+			if err != nil {
+				return nil, err
+			}
+			tree = append(tree, yaml.MapItem{Key: x.Key, Value: walked})
+		}
+		return tree, nil
+	case []interface{}:
+		// note: the items in a list share the path of the list
+		var list []interface{}
+		for _, x := range v {
+			walked, err := sopsWalk(x, path, fn)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, walked)
+		}
+		return list, nil
+	case nil:
+		return nil, nil
+	default:
+		return fn(v, path)
+	}
+}
+
+//
+// sopsEncryptValue encrypts the value with the data key, in the sops value format
+//
+func sopsEncryptValue(value interface{}, key []byte, additionalData string) (string, error) {
+	// note: sops leaves the empty strings unencrypted
+	if value == "" {
+		return "", nil
+	}
+	var kind string
+	switch value.(type) {
+	case string:
+		kind = "str"
+	case int, int64, uint64:
+		kind = "int"
+	case float64:
+		kind = "float"
+	case bool:
+		kind = "bool"
+	default:
+		return "", fmt.Errorf("unsupported value type: %T", value)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, 32)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, sopsBytes(value), []byte(additionalData))
+	data, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		base64.StdEncoding.EncodeToString(data),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(tag), kind), nil
+}
+
+//
+// sopsDecryptValue decrypts a sops value with the data key
+//
+func sopsDecryptValue(value string, key []byte, additionalData string) (interface{}, error) {
+	if value == "" {
+		return "", nil
+	}
+	matches := sopsValueRegex.FindStringSubmatch(value)
+	if matches == nil {
+		return nil, fmt.Errorf("the value is not in the sops format")
+	}
+	var decoded [3][]byte
+	for i := range decoded {
+		content, err := base64.StdEncoding.DecodeString(matches[i+1])
+		if err != nil {
+			return nil, err
+		}
+		decoded[i] = content
+	}
+	data, iv, tag := decoded[0], decoded[1], decoded[2]
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, iv, append(data, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind := matches[4]; kind {
+	case "str":
+		return string(plain), nil
+	case "int":
+		return strconv.Atoi(string(plain))
+	case "float":
+		return strconv.ParseFloat(string(plain), 64)
+	case "bool":
+		return strconv.ParseBool(string(plain))
+	case "bytes":
+		return string(plain), nil
+	default:
+		return nil, fmt.Errorf("unsupported value type: %s", kind)
+	}
+}
+
+//
+// sopsBytes returns the byte representation of the value, booleans use the python style as sops does
+//
+func sopsBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case string:
+		return []byte(v)
+	case int:
+		return []byte(strconv.Itoa(v))
+	case int64:
+		return []byte(strconv.FormatInt(v, 10))
+	case uint64:
+		return []byte(strconv.FormatUint(v, 10))
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		return []byte(strings.Title(strconv.FormatBool(v)))
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
+
+// sopsAdditionalData returns the authenticated data for the value at the path
+func sopsAdditionalData(path []string) string {
+	return strings.Join(path, ":") + ":"
+}
+
+//
+// sopsRules are the rules of the metadata selecting which values are encrypted, sops permits
+// only one of the suffixes or regexes to be set
+//
+type sopsRules struct {
+	// the keys with the suffix, and their children, are not encrypted
+	unencryptedSuffix string
+	// only the keys with the suffix, and their children, are encrypted
+	encryptedSuffix string
+	// the keys matching the regex, and their children, are not encrypted
+	unencryptedRegex *regexp.Regexp
+	// only the keys matching the regex, and their children, are encrypted
+	encryptedRegex *regexp.Regexp
+	// the mac only covers the encrypted values
+	macOnlyEncrypted bool
+}
+
+//
+// newSopsRules parses the rules from the metadata, defaulting to the unencrypted suffix when none are set
+//
+func newSopsRules(metadata yaml.MapSlice) (*sopsRules, error) {
+	rules := &sopsRules{
+		unencryptedSuffix: sopsMetadataString(metadata, "unencrypted_suffix"),
+		encryptedSuffix:   sopsMetadataString(metadata, "encrypted_suffix"),
+		macOnlyEncrypted:  sopsMetadataString(metadata, "mac_only_encrypted") == "true",
+	}
+	for key, rx := range map[string]**regexp.Regexp{
+		"unencrypted_regex": &rules.unencryptedRegex,
+		"encrypted_regex":   &rules.encryptedRegex,
+	} {
+		if expression := sopsMetadataString(metadata, key); expression != "" {
+			compiled, err := regexp.Compile(expression)
+			if err != nil {
+				return nil, fmt.Errorf("invalid sops %s: %s, error: %s", key, expression, err)
+			}
+			*rx = compiled
+		}
+	}
+	if rules.unencryptedSuffix == "" && rules.encryptedSuffix == "" && rules.unencryptedRegex == nil && rules.encryptedRegex == nil {
+		rules.unencryptedSuffix = sopsUnencryptedSuffix
+	}
+
+	return rules, nil
+}
+
+// isEncrypted checks if the value at the path is encrypted, following the order sops applies the rules
+func (r *sopsRules) isEncrypted(path []string) bool {
+	encrypted := true
+	if r.unencryptedSuffix != "" && sopsPathMatches(path, func(x string) bool { return strings.HasSuffix(x, r.unencryptedSuffix) }) {
+		encrypted = false
+	}
+	if r.encryptedSuffix != "" {
+		encrypted = sopsPathMatches(path, func(x string) bool { return strings.HasSuffix(x, r.encryptedSuffix) })
+	}
+	if r.unencryptedRegex != nil && sopsPathMatches(path, r.unencryptedRegex.MatchString) {
+		encrypted = false
+	}
+	if r.encryptedRegex != nil {
+		encrypted = sopsPathMatches(path, r.encryptedRegex.MatchString)
+	}
+
+	return encrypted
+}
+
+// sopsPathMatches checks if any of the keys in the path match
+func sopsPathMatches(path []string, fn func(string) bool) bool {
+	for _, x := range path {
+		if fn(x) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sopsMetadataValue returns the value of the key in the metadata
+func sopsMetadataValue(metadata yaml.MapSlice, key string) interface{} {
+	for _, x := range metadata {
+		if fmt.Sprint(x.Key) == key {
+			return x.Value
+		}
+	}
+
+	return nil
+}
+
+// sopsMetadataString returns the value of the key in the metadata as a string, empty if not found
+func sopsMetadataString(metadata yaml.MapSlice, key string) string {
+	value := sopsMetadataValue(metadata, key)
+	if value == nil {
+		return ""
+	}
+
+	return fmt.Sprint(value)
+}
+
+// setSopsMetadataValue sets the value of the key in the metadata, preserving the order
+func setSopsMetadataValue(metadata yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i, x := range metadata {
+		if fmt.Sprint(x.Key) == key {
+			metadata[i].Value = value
+			return metadata
+		}
+	}
+
+	return append(metadata, yaml.MapItem{Key: key, Value: value})
+}
+
+//
+// marshalSopsTree encodes the tree in the format, preserving the order of the keys
+//
+func marshalSopsTree(tree yaml.MapSlice, format string) ([]byte, error) {
+	if format != "json" {
+		return yaml.Marshal(tree)
+	}
+	buffer := new(bytes.Buffer)
+	if err := writeOrderedJSON(buffer, tree, ""); err != nil {
+		return nil, err
+	}
+	buffer.WriteString("\n")
+
+	return buffer.Bytes(), nil
+}
+
+//
+// writeOrderedJSON writes the value as indented json, preserving the order of the maps
+//
+func writeOrderedJSON(buffer *bytes.Buffer, value interface{}, indent string) error {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		if len(v) == 0 {
+			buffer.WriteString("{}")
+			return nil
+		}
+		buffer.WriteString("{\n")
+		for i, x := range v {
+			key, _ := json.Marshal(fmt.Sprint(x.Key))
+			buffer.WriteString(indent + "\t" + string(key) + ": ")
+			if err := writeOrderedJSON(buffer, x.Value, indent+"\t"); err != nil {
+				return err
+			}
+			if i < len(v)-1 {
+				buffer.WriteString(",")
+			}
+			buffer.WriteString("\n")
+		}
+		buffer.WriteString(indent + "}")
+	case []interface{}:
+		if len(v) == 0 {
+			buffer.WriteString("[]")
+			return nil
+		}
+		buffer.WriteString("[\n")
+		for i, x := range v {
+			buffer.WriteString(indent + "\t")
+			if err := writeOrderedJSON(buffer, x, indent+"\t"); err != nil {
+				return err
+			}
+			if i < len(v)-1 {
+				buffer.WriteString(",")
+			}
+			buffer.WriteString("\n")
+		}
+		buffer.WriteString(indent + "]")
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buffer.Write(encoded)
+	}
+
+	return nil
+}
+
+//
+// readSopsFile reads a local sops file and returns the decrypted document
+//
+func (r *cliCommand) readSopsFile(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.decryptSops(content, sopsFormat(path))
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/urfave/cli"
+)
+
+//
+// newExportSSMCommand creates the command to export files to the parameter store
+//
+func newExportSSMCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "ssm",
+		Usage: "export the files in the bucket as secure string parameters in the ssm parameter store",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files `NAME`",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:  "prefix",
+				Usage: "only export the files under this prefix, which is removed from the parameter name `PREFIX`",
+			},
+			cli.StringFlag{
+				Name:  "path",
+				Usage: "the parameter path the files are placed under, i.e. /prod/app/ `PATH`",
+			},
+			cli.StringFlag{
+				Name:   "k, kms",
+				Usage:  "the kms key used to encrypt the parameters, defaults to the aws managed key (alias/aws/ssm) `KEY`",
+				EnvVar: "AWS_KMS_ID",
+			},
+			cli.BoolFlag{
+				Name:  "overwrite",
+				Usage: "overwrite any existing parameters, otherwise they are skipped",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "display the parameters which would be written without making any changes",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s", "l:path:s"}, cmd, exportSSM)
+		},
+	}
+}
+
+//
+// exportSSM writes the files in the bucket to the parameter store
+//
+func exportSSM(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	prefix := cx.String("prefix")
+	path := cx.String("path")
+	dryRun := cx.Bool("dry-run")
+
+	if !strings.HasPrefix(path, "/") {
+		return newExitError(exitUsage, "the parameter path: %s must be absolute, i.e. /prod/app/", path)
+	}
+	path = strings.TrimSuffix(path, "/") + "/"
+
+	// step: retrieve the files to export
+	files, err := cmd.listBucketKeys(bucket, prefix)
+	if err != nil {
+		return err
+	}
+	client := ssm.New(cmd.session)
+
+	summary := newTransferSummary("exported")
+	for _, x := range files {
+		name := path + strings.TrimPrefix(strings.TrimPrefix(*x.Key, prefix), "/")
+		if dryRun {
+			o.fields(map[string]interface{}{
+				"action":    "export",
+				"bucket":    bucket,
+				"key":       *x.Key,
+				"parameter": name,
+				"dry-run":   true,
+			}).log("[dry-run] would export s3://%s/%s to the parameter: %s\n", bucket, *x.Key, name)
+			summary.skip()
+			continue
+		}
+
+		content, err := cmd.getFile(bucket, *x.Key)
+		if err != nil {
+			summary.fail(*x.Key, err)
+			continue
+		}
+		input := &ssm.PutParameterInput{
+			Name:      aws.String(name),
+			Type:      aws.String(ssm.ParameterTypeSecureString),
+			Value:     aws.String(string(content)),
+			Overwrite: aws.Bool(cx.Bool("overwrite")),
+			Tier:      aws.String(ssm.ParameterTierIntelligentTiering),
+		}
+		if kms := cx.String("kms"); kms != "" {
+			input.KeyId = aws.String(kmsKeyID(kms))
+		}
+		if _, err := client.PutParameterWithContext(cmd.ctx, input); err != nil {
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeParameterAlreadyExists {
+				o.fields(map[string]interface{}{
+					"action":    "export",
+					"key":       *x.Key,
+					"parameter": name,
+					"skipped":   true,
+				}).log("skipping the parameter: %s, it already exists (use --overwrite)\n", name)
+				summary.skip()
+				continue
+			}
+			summary.fail(*x.Key, err)
+			continue
+		}
+		summary.success()
+
+		o.fields(map[string]interface{}{
+			"action":    "export",
+			"bucket":    bucket,
+			"key":       *x.Key,
+			"parameter": name,
+		}).log("exported s3://%s/%s to the parameter: %s\n", bucket, *x.Key, name)
+	}
+	summary.print(o)
+
+	return summary.err()
+}
+
+//
+// newImportSSMCommand creates the command to import parameters from the parameter store
+//
+func newImportSSMCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "ssm",
+		Usage: "import the parameters under a path in the ssm parameter store into the bucket",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "path",
+				Usage: "the parameter path to import, recursing the hierarchy beneath, i.e. /prod/app/ `PATH`",
+			},
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket to place the files `NAME`",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:  "prefix",
+				Usage: "the prefix in the bucket the parameters are placed under `PREFIX`",
+			},
+			cli.StringFlag{
+				Name:   "k, kms",
+				Usage:  "the kms key to encrypt the files with, defaults to the bucket default encryption `KEY`",
+				EnvVar: "AWS_KMS_ID",
+			},
+			cli.BoolFlag{
+				Name:  "overwrite",
+				Usage: "overwrite any existing files in the bucket, otherwise they are skipped",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "display the files which would be written without making any changes",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:path:s", "l:bucket:s"}, cmd, importSSM)
+		},
+	}
+}
+
+//
+// importSSM copies the parameters under the path into the bucket
+//
+func importSSM(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	prefix := cx.String("prefix")
+	path := cx.String("path")
+	kms := cx.String("kms")
+	dryRun := cx.Bool("dry-run")
+
+	if !strings.HasPrefix(path, "/") {
+		return newExitError(exitUsage, "the parameter path: %s must be absolute, i.e. /prod/app/", path)
+	}
+	if kms == "" {
+		if err := cmd.hasDefaultKmsEncryption(bucket); err != nil {
+			return err
+		}
+	}
+
+	// step: retrieve the parameters under the path
+	var parameters []*ssm.Parameter
+	err := ssm.New(cmd.session).GetParametersByPathPagesWithContext(cmd.ctx, &ssm.GetParametersByPathInput{
+		Path:           aws.String(path),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(true),
+	}, func(page *ssm.GetParametersByPathOutput, last bool) bool {
+		parameters = append(parameters, page.Parameters...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	// note: the existing files are skipped below, the condition guarding against those created since
+	options := &uploadOptions{ifNotExists: !cx.Bool("overwrite")}
+	summary := newTransferSummary("imported")
+	for _, x := range parameters {
+		name := aws.StringValue(x.Name)
+		key := prefix + strings.TrimPrefix(strings.TrimPrefix(name, path), "/")
+
+		// step: skip any existing files unless overwriting
+		if !cx.Bool("overwrite") {
+			found, err := cmd.hasKey(key, bucket)
+			if err != nil {
+				summary.fail(name, err)
+				continue
+			}
+			if found {
+				o.fields(map[string]interface{}{
+					"action":    "import",
+					"parameter": name,
+					"key":       key,
+					"skipped":   true,
+				}).log("skipping the file: s3://%s/%s, it already exists (use --overwrite)\n", bucket, key)
+				summary.skip()
+				continue
+			}
+		}
+		if dryRun {
+			o.fields(map[string]interface{}{
+				"action":    "import",
+				"parameter": name,
+				"bucket":    bucket,
+				"key":       key,
+				"dry-run":   true,
+			}).log("[dry-run] would import the parameter: %s to s3://%s/%s\n", name, bucket, key)
+			summary.skip()
+			continue
+		}
+
+		if err := cmd.putContent(bucket, key, []byte(aws.StringValue(x.Value)), kms, options); err != nil {
+			summary.fail(name, err)
+			continue
+		}
+		summary.success()
+
+		o.fields(map[string]interface{}{
+			"action":    "import",
+			"parameter": name,
+			"bucket":    bucket,
+			"key":       key,
+		}).log("imported the parameter: %s to s3://%s/%s\n", name, bucket, key)
+	}
+	summary.print(o)
+
+	return summary.err()
+}
@@ -0,0 +1,370 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssooidc"
+)
+
+//
+// ssoProfile is the sso configuration of a profile in the shared config
+//
+type ssoProfile struct {
+	// the name of the sso-session, empty for legacy profiles
+	session string
+	// the start url of the sso portal
+	startURL string
+	// the region of the sso service
+	region string
+	// the scopes requested for the sso-session
+	scopes []string
+}
+
+//
+// ssoToken is the cached sso token, in the same format as the aws cli
+//
+type ssoToken struct {
+	StartURL              string `json:"startUrl"`
+	Region                string `json:"region"`
+	AccessToken           string `json:"accessToken"`
+	ExpiresAt             string `json:"expiresAt"`
+	ClientID              string `json:"clientId,omitempty"`
+	ClientSecret          string `json:"clientSecret,omitempty"`
+	RegistrationExpiresAt string `json:"registrationExpiresAt,omitempty"`
+	RefreshToken          string `json:"refreshToken,omitempty"`
+}
+
+//
+// ssoLoginProvider performs the sso login, if required, before the credentials are first retrieved
+//
+type ssoLoginProvider struct {
+	sync.Mutex
+	// the credentials of the session
+	creds *credentials.Credentials
+	// the login performed on the first retrieval
+	login func() error
+	// indicates the login has been performed
+	done bool
+}
+
+//
+// newSSOLoginCredentials wraps the credentials, logging in on the first retrieval rather than up front
+//
+func newSSOLoginCredentials(creds *credentials.Credentials, login func() error) *credentials.Credentials {
+	return credentials.NewCredentials(&ssoLoginProvider{creds: creds, login: login})
+}
+
+// Retrieve performs the login on first use and returns the credentials of the session
+func (r *ssoLoginProvider) Retrieve() (credentials.Value, error) {
+	r.Lock()
+	if !r.done {
+		if err := r.login(); err != nil {
+			r.Unlock()
+			return credentials.Value{}, err
+		}
+		r.done = true
+	}
+	r.Unlock()
+
+	return r.creds.Get()
+}
+
+// IsExpired checks if the credentials of the session have expired
+func (r *ssoLoginProvider) IsExpired() bool {
+	return r.creds.IsExpired()
+}
+
+//
+// ensureSSOLogin checks if the profile uses sso and performs the device login when the cached token
+// is missing or expired, the sdk then uses the cached token to retrieve the role credentials
+//
+func (r *cliCommand) ensureSSOLogin(profile, configFile string, httpClient *http.Client) error {
+	sso, err := getSSOProfile(profile, configFile)
+	if err != nil || sso == nil {
+		return err
+	}
+	cacheFile, err := sso.cacheFile()
+	if err != nil {
+		return err
+	}
+
+	// step: check for a valid cached token
+	if token, err := readSSOToken(cacheFile); err == nil {
+		if expires, err := time.Parse(time.RFC3339, token.ExpiresAt); err == nil && time.Now().Add(time.Minute).Before(expires) {
+			return nil
+		}
+		// note: the sdk will refresh the token itself for sso-session profiles with a refresh token
+		if sso.session != "" && token.RefreshToken != "" {
+			return nil
+		}
+	}
+
+	token, err := r.ssoDeviceLogin(sso, httpClient)
+	if err != nil {
+		return fmt.Errorf("sso login for the profile: %s failed, error: %s", profile, err)
+	}
+
+	return writeSSOToken(cacheFile, token)
+}
+
+//
+// ssoDeviceLogin performs the device authorization flow, opening the browser for the user to approve
+//
+func (r *cliCommand) ssoDeviceLogin(sso *ssoProfile, httpClient *http.Client) (*ssoToken, error) {
+	client := ssooidc.New(session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String(sso.region),
+		Credentials: credentials.AnonymousCredentials,
+		HTTPClient:  httpClient,
+	})))
+
+	// step: register the client
+	registration, err := client.RegisterClientWithContext(r.ctx, &ssooidc.RegisterClientInput{
+		ClientName: aws.String(progName),
+		ClientType: aws.String("public"),
+		Scopes:     aws.StringSlice(sso.scopes),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// step: start the device authorization and ask the user to approve
+	auth, err := client.StartDeviceAuthorizationWithContext(r.ctx, &ssooidc.StartDeviceAuthorizationInput{
+		ClientId:     registration.ClientId,
+		ClientSecret: registration.ClientSecret,
+		StartUrl:     aws.String(sso.startURL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	url := aws.StringValue(auth.VerificationUriComplete)
+	fmt.Fprintf(os.Stderr, "the sso token has expired, attempting to open the browser to login, if it does not open, visit:\n\n  %s\n\nand confirm the code: %s\n",
+		url, aws.StringValue(auth.UserCode))
+	openBrowser(url)
+
+	// step: poll for the token until approved or the code expires
+	interval := time.Duration(aws.Int64Value(auth.Interval)) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	expires := time.Now().Add(time.Duration(aws.Int64Value(auth.ExpiresIn)) * time.Second)
+	for time.Now().Before(expires) {
+		select {
+		case <-r.ctx.Done():
+			return nil, r.ctx.Err()
+		case <-time.After(interval):
+		}
+
+		resp, err := client.CreateTokenWithContext(r.ctx, &ssooidc.CreateTokenInput{
+			ClientId:     registration.ClientId,
+			ClientSecret: registration.ClientSecret,
+			DeviceCode:   auth.DeviceCode,
+			GrantType:    aws.String("urn:ietf:params:oauth:grant-type:device_code"),
+		})
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok {
+				switch aerr.Code() {
+				case ssooidc.ErrCodeAuthorizationPendingException:
+					continue
+				case ssooidc.ErrCodeSlowDownException:
+					interval += 5 * time.Second
+					continue
+				}
+			}
+			return nil, err
+		}
+
+		token := &ssoToken{
+			StartURL:    sso.startURL,
+			Region:      sso.region,
+			AccessToken: aws.StringValue(resp.AccessToken),
+			ExpiresAt:   time.Now().Add(time.Duration(aws.Int64Value(resp.ExpiresIn)) * time.Second).UTC().Format(time.RFC3339),
+		}
+		// note: the client registration and refresh token are only used by sso-session profiles
+		if sso.session != "" {
+			token.ClientID = aws.StringValue(registration.ClientId)
+			token.ClientSecret = aws.StringValue(registration.ClientSecret)
+			token.RegistrationExpiresAt = time.Unix(aws.Int64Value(registration.ClientSecretExpiresAt), 0).UTC().Format(time.RFC3339)
+			token.RefreshToken = aws.StringValue(resp.RefreshToken)
+		}
+
+		return token, nil
+	}
+
+	return nil, fmt.Errorf("the device authorization expired before being approved")
+}
+
+//
+// getSSOProfile retrieves the sso configuration of the profile, nil if the profile does not use sso
+//
+func getSSOProfile(profile, configFile string) (*ssoProfile, error) {
+	config, err := parseIniFile(configFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	section := "profile " + profile
+	if profile == "default" {
+		section = "default"
+	}
+	values, found := config[section]
+	if !found {
+		return nil, nil
+	}
+
+	// step: is the profile using a sso-session?
+	if name := values["sso_session"]; name != "" {
+		sess, found := config["sso-session "+name]
+		if !found {
+			return nil, fmt.Errorf("the sso-session: %s referenced by the profile: %s does not exist", name, profile)
+		}
+		sso := &ssoProfile{
+			session:  name,
+			startURL: sess["sso_start_url"],
+			region:   sess["sso_region"],
+			scopes:   []string{"sso:account:access"},
+		}
+		if scopes := sess["sso_registration_scopes"]; scopes != "" {
+			sso.scopes = strings.Split(strings.ReplaceAll(scopes, " ", ""), ",")
+		}
+
+		return sso, nil
+	}
+	if values["sso_start_url"] == "" {
+		return nil, nil
+	}
+
+	return &ssoProfile{
+		startURL: values["sso_start_url"],
+		region:   values["sso_region"],
+	}, nil
+}
+
+//
+// cacheFile returns the path of the cached token, the sha1 of the session name or the start url
+//
+func (r *ssoProfile) cacheFile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	key := r.startURL
+	if r.session != "" {
+		key = r.session
+	}
+	hash := sha1.Sum([]byte(key))
+
+	return filepath.Join(home, ".aws", "sso", "cache", hex.EncodeToString(hash[:])+".json"), nil
+}
+
+// readSSOToken reads the cached token from disk
+func readSSOToken(path string) (*ssoToken, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	token := new(ssoToken)
+	if err := json.Unmarshal(content, token); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// writeSSOToken writes the token to the cache
+func writeSSOToken(path string, token *ssoToken) error {
+	content, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, content, 0600)
+}
+
+// openBrowser attempts to open the url in the browser, ignoring any failure
+func openBrowser(url string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err == nil {
+		go cmd.Wait()
+	}
+}
+
+//
+// parseIniFile parses the sections and key/values of a ini file, i.e. the aws shared config
+//
+func parseIniFile(path string) (map[string]map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sections := make(map[string]map[string]string, 0)
+	var current map[string]string
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "", strings.HasPrefix(line, "#"), strings.HasPrefix(line, ";"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			name := strings.Join(strings.Fields(strings.Trim(line, "[]")), " ")
+			if _, found := sections[name]; !found {
+				sections[name] = make(map[string]string, 0)
+			}
+			current = sections[name]
+		default:
+			items := strings.SplitN(line, "=", 2)
+			if current == nil || len(items) != 2 {
+				continue
+			}
+			current[strings.TrimSpace(items[0])] = strings.TrimSpace(items[1])
+		}
+	}
+
+	return sections, scanner.Err()
+}
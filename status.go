@@ -0,0 +1,177 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/urfave/cli"
+)
+
+//
+// newStatusCommand creates a new status command
+//
+func newStatusCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "status",
+		Usage: "report the files retrieved into the output directory which have been modified, deleted or changed in the bucket",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "d, output-dir",
+				Usage:  "the path to the directory the files were retrieved into",
+				EnvVar: "KMSCTL_OUTPUT_DIR",
+				Value:  "./secrets",
+			},
+			cli.StringFlag{
+				Name:   "state-file",
+				Usage:  "the file recording the files retrieved, by default in the output directory `PATH`",
+				EnvVar: "KMSCTL_STATE_FILE",
+			},
+			cli.BoolFlag{
+				Name:  "local",
+				Usage: "only check the files in the output directory, without comparing them to the bucket",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{}, cmd, showStatus)
+		},
+	}
+}
+
+//
+// showStatus compares the files recorded in the state with the output directory and the bucket, exiting
+// non-zero if any have drifted
+//
+func showStatus(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	path := defaultValue(cx.String("state-file"), filepath.Join(cx.String("output-dir"), syncStateName))
+	if found, err := isFile(path); err != nil || !found {
+		return newNotFoundError("no state file found: %s, the files are recorded by get", path)
+	}
+	state, err := loadSyncState(path)
+	if err != nil {
+		return err
+	}
+
+	// step: retrieve the etags of the files in the buckets
+	var remote map[string]map[string]string
+	if !cx.Bool("local") {
+		if remote, err = remoteETags(cmd, state); err != nil {
+			return err
+		}
+	}
+
+	var drifted int
+	for _, key := range state.keys() {
+		file := state.Files[key]
+		changes := localChanges(file)
+		if remote != nil {
+			etag, found := remote[file.Bucket][key]
+			switch {
+			case !found:
+				changes = append(changes, "removed-remotely")
+			case etag != file.ETag:
+				changes = append(changes, "changed-remotely")
+			}
+		}
+		fields := map[string]interface{}{
+			"action":      "status",
+			"bucket":      file.Bucket,
+			"key":         key,
+			"destination": file.Destination,
+			"changes":     changes,
+		}
+		if len(changes) <= 0 {
+			o.verbose(fields).log("%-32s %s -> %s\n", "unchanged", key, file.Destination)
+			continue
+		}
+		drifted++
+		o.fields(fields).log("%-32s %s -> %s\n", colorize(colorYellow, strings.Join(changes, ",")), key, file.Destination)
+	}
+
+	o.fields(map[string]interface{}{
+		"action":  "summary",
+		"files":   len(state.Files),
+		"drifted": drifted,
+	}).log("%d of %d files have drifted\n", drifted, len(state.Files))
+
+	if drifted > 0 {
+		return newExitError(exitFailure, "%d of %d files have drifted", drifted, len(state.Files))
+	}
+
+	return nil
+}
+
+// localChanges returns the changes made to the file in the output directory since it was written
+func localChanges(file *syncStateFile) []string {
+	info, err := os.Stat(file.Destination)
+	if err != nil {
+		return []string{"deleted"}
+	}
+	var changes []string
+	if file.Hash != "" {
+		if content, err := ioutil.ReadFile(file.Destination); err != nil || contentHash(content) != file.Hash {
+			changes = append(changes, "modified")
+		}
+	}
+	if file.Perms != "" && fileMode(info) != file.Perms {
+		changes = append(changes, "perms")
+	}
+
+	return changes
+}
+
+//
+// remoteETags lists the files of each bucket in the state, under the longest prefix common to the keys,
+// returning the etags by bucket and key
+//
+func remoteETags(cmd *cliCommand, state *syncState) (map[string]map[string]string, error) {
+	prefixes := make(map[string]string, 0)
+	for _, key := range state.keys() {
+		bucket := state.Files[key].Bucket
+		if prefix, found := prefixes[bucket]; found {
+			prefixes[bucket] = commonPrefix(prefix, key)
+			continue
+		}
+		prefixes[bucket] = key
+	}
+
+	etags := make(map[string]map[string]string, 0)
+	for bucket, prefix := range prefixes {
+		files, err := cmd.listBucketKeys(bucket, prefix)
+		if err != nil {
+			return nil, err
+		}
+		etags[bucket] = make(map[string]string, len(files))
+		for _, x := range files {
+			etags[bucket][strings.TrimPrefix(aws.StringValue(x.Key), "/")] = aws.StringValue(x.ETag)
+		}
+	}
+
+	return etags, nil
+}
+
+// commonPrefix returns the longest prefix shared by the two strings
+func commonPrefix(a, b string) string {
+	i := 0
+	for ; i < len(a) && i < len(b) && a[i] == b[i]; i++ {
+	}
+
+	return a[:i]
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"sort"
+)
+
+//
+// transferSummary records the outcome of a bulk transfer
+//
+type transferSummary struct {
+	// the action being performed i.e. uploaded or retrieved
+	action string
+	// the number of files transferred
+	transferred int
+	// the number of files skipped
+	skipped int
+	// the errors keyed by file
+	errors map[string]string
+	// the number of failures due to the access being denied
+	denied int
+}
+
+//
+// newTransferSummary creates a summary for the action
+//
+func newTransferSummary(action string) *transferSummary {
+	return &transferSummary{
+		action: action,
+		errors: make(map[string]string, 0),
+	}
+}
+
+// success records a successful transfer
+func (r *transferSummary) success() {
+	r.transferred++
+}
+
+// skip records a file which did not need transferring
+func (r *transferSummary) skip() {
+	r.skipped++
+}
+
+// fail records a failed transfer
+func (r *transferSummary) fail(name string, err error) {
+	r.errors[name] = err.Error()
+	if exitCode(err) == exitAccessDenied {
+		r.denied++
+	}
+}
+
+//
+// print outputs the summary of the transfer
+//
+func (r *transferSummary) print(o *formatter) {
+	// note: when quiet only the failures are reported
+	if o.quiet() {
+		for k, v := range r.errors {
+			logger.errorf("failed: %s, error: %s", k, v)
+		}
+		return
+	}
+	o.fields(map[string]interface{}{
+		"action":  "summary",
+		r.action:  r.transferred,
+		"skipped": r.skipped,
+		"failed":  len(r.errors),
+		"errors":  r.errors,
+	}).log("%s: %d, skipped: %d, failed: %d\n", r.action, r.transferred, r.skipped, len(r.errors))
+
+	var names []string
+	for k := range r.errors {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		o.log("  failed: %s, error: %s\n", k, r.errors[k])
+	}
+}
+
+//
+// err returns an error if any of the transfers failed, the access being denied if it was for all the files
+//
+func (r *transferSummary) err() error {
+	if len(r.errors) > 0 {
+		code := exitPartialFailure
+		if r.denied == len(r.errors) && r.transferred+r.skipped == 0 {
+			code = exitAccessDenied
+		}
+		return newExitError(code, "%d of %d files failed to be %s", len(r.errors), len(r.errors)+r.transferred+r.skipped, r.action)
+	}
+
+	return nil
+}
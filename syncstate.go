@@ -0,0 +1,164 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// the name of the state file kept in the output directory
+const syncStateName = ".kmsctl-state.json"
+
+//
+// syncStateFile is the version of a file last written to the output directory
+//
+type syncStateFile struct {
+	// the bucket the file was retrieved from
+	Bucket string `json:"bucket"`
+	// the etag of the object written
+	ETag string `json:"etag"`
+	// the version of the object written, if the bucket is versioned
+	Version string `json:"version,omitempty"`
+	// the path the file was written to
+	Destination string `json:"destination"`
+	// the sha256 of the content written
+	Hash string `json:"sha256"`
+	// the permissions of the file written
+	Perms string `json:"perms"`
+}
+
+//
+// syncState records the files retrieved by get, so unchanged files are not retrieved again across runs
+//
+type syncState struct {
+	// the path of the state file
+	path string
+	// the files keyed by the key in the bucket
+	Files map[string]*syncStateFile `json:"files"`
+	// the state has changed since it was read
+	changed bool
+}
+
+//
+// loadSyncState reads the state file, an empty state being returned if it does not exist
+//
+func loadSyncState(path string) (*syncState, error) {
+	state := &syncState{
+		path:  path,
+		Files: make(map[string]*syncStateFile, 0),
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(content, state); err != nil {
+		// note: a corrupt state only means the files are retrieved again
+		logger.warningf("ignoring the state file: %s, error: %s", path, err)
+		state.Files = make(map[string]*syncStateFile, 0)
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]*syncStateFile, 0)
+	}
+
+	return state, nil
+}
+
+//
+// etag returns the etag of the file last written, provided the destination still holds the content
+// written; a destination modified or corrupted since is dropped from the state, so it is retrieved again
+//
+func (r *syncState) etag(key, destination string) string {
+	x, found := r.Files[key]
+	if !found || x.Destination != destination {
+		return ""
+	}
+	content, err := ioutil.ReadFile(destination)
+	if err != nil || contentHash(content) != x.Hash {
+		if err == nil {
+			logger.debugf("the file: %s has changed since it was retrieved, retrieving it again", destination)
+		}
+		r.remove(key)
+		return ""
+	}
+
+	return x.ETag
+}
+
+// record updates the version of the file written
+func (r *syncState) record(key string, file *syncStateFile) {
+	if x, found := r.Files[key]; found && *x == *file {
+		return
+	}
+	r.Files[key] = file
+	r.changed = true
+}
+
+// remove deletes the file from the state
+func (r *syncState) remove(key string) {
+	if _, found := r.Files[key]; found {
+		delete(r.Files, key)
+		r.changed = true
+	}
+}
+
+// keys returns the keys of the files in the state, sorted
+func (r *syncState) keys() []string {
+	var list []string
+	for x := range r.Files {
+		list = append(list, x)
+	}
+	sort.Strings(list)
+
+	return list
+}
+
+// contentHash returns the hex encoded sha256 of the content
+func contentHash(content []byte) string {
+	hash := sha256.Sum256(content)
+
+	return hex.EncodeToString(hash[:])
+}
+
+// fileMode returns the permissions of the file in octal
+func fileMode(info os.FileInfo) string {
+	return fmt.Sprintf("%#o", info.Mode().Perm())
+}
+
+// write saves the state file if it has changed
+func (r *syncState) write() error {
+	if !r.changed {
+		return nil
+	}
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(r.path, content, 0600); err != nil {
+		return fmt.Errorf("unable to write the state file: %s, error: %s", r.path, err)
+	}
+	r.changed = false
+
+	return nil
+}
@@ -0,0 +1,480 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+)
+
+//
+// newBucketTaggingCommands creates the bucket tagging commands
+//
+func newBucketTaggingCommands(cmd *cliCommand) []cli.Command {
+	bucketFlag := cli.StringFlag{
+		Name:   "b, bucket",
+		Usage:  "the name of the bucket `NAME`",
+		EnvVar: "AWS_S3_BUCKET",
+	}
+
+	return []cli.Command{
+		{
+			Name:      "tag",
+			Usage:     "add or update one or more tags on the bucket",
+			ArgsUsage: "KEY=VALUE...",
+			Flags:     []cli.Flag{bucketFlag},
+			Action: func(cx *cli.Context) error {
+				return handleCommand(cx, []string{"l:bucket:s"}, cmd, tagBucket)
+			},
+		},
+		{
+			Name:      "untag",
+			Usage:     "remove one or more tags from the bucket",
+			ArgsUsage: "KEY...",
+			Flags:     []cli.Flag{bucketFlag},
+			Action: func(cx *cli.Context) error {
+				return handleCommand(cx, []string{"l:bucket:s"}, cmd, untagBucket)
+			},
+		},
+		{
+			Name:  "tags",
+			Usage: "display the tags on the bucket",
+			Flags: []cli.Flag{bucketFlag},
+			Action: func(cx *cli.Context) error {
+				return handleCommand(cx, []string{"l:bucket:s"}, cmd, listBucketTags)
+			},
+		},
+	}
+}
+
+func tagBucket(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	name := cx.String("bucket")
+
+	values, err := parseKeyValues(cx.Args())
+	if err != nil {
+		return err
+	}
+	if len(values) <= 0 {
+		return fmt.Errorf("you have not specified any tags to add")
+	}
+
+	// step: merge the tags into the existing ones
+	tags, err := cmd.getBucketTags(name)
+	if err != nil {
+		return err
+	}
+	for k, v := range values {
+		tags[k] = v
+	}
+	if err := cmd.putBucketTags(name, tags); err != nil {
+		return err
+	}
+
+	o.fields(map[string]interface{}{
+		"operation": "tag",
+		"bucket":    name,
+		"tags":      tags,
+	}).log("successfully tagged the bucket: %s\n", name)
+
+	return nil
+}
+
+func untagBucket(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	name := cx.String("bucket")
+
+	if len(cx.Args()) <= 0 {
+		return fmt.Errorf("you have not specified any tags to remove")
+	}
+
+	tags, err := cmd.getBucketTags(name)
+	if err != nil {
+		return err
+	}
+	for _, k := range cx.Args() {
+		delete(tags, k)
+	}
+	if err := cmd.putBucketTags(name, tags); err != nil {
+		return err
+	}
+
+	o.fields(map[string]interface{}{
+		"operation": "untag",
+		"bucket":    name,
+		"tags":      tags,
+	}).log("successfully removed the tags from the bucket: %s\n", name)
+
+	return nil
+}
+
+func listBucketTags(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	name := cx.String("bucket")
+
+	tags, err := cmd.getBucketTags(name)
+	if err != nil {
+		return err
+	}
+	for _, k := range sortedKeys(tags) {
+		o.fields(map[string]interface{}{
+			"bucket": name,
+			"key":    k,
+			"value":  tags[k],
+		}).log("%-30s %s\n", k, tags[k])
+	}
+
+	return nil
+}
+
+//
+// newObjectTaggingCommands creates the file tagging commands
+//
+func newObjectTaggingCommands(cmd *cliCommand) []cli.Command {
+	bucketFlag := cli.StringFlag{
+		Name:   "b, bucket",
+		Usage:  "the name of the s3 bucket containing the encrypted files `NAME`",
+		EnvVar: "AWS_S3_BUCKET",
+	}
+
+	return []cli.Command{
+		{
+			Name:      "tag",
+			Usage:     "add or update one or more tags on a file in the bucket",
+			ArgsUsage: "PATH KEY=VALUE...",
+			Flags:     []cli.Flag{bucketFlag},
+			Action: func(cx *cli.Context) error {
+				return handleCommand(cx, []string{"l:bucket:s"}, cmd, tagFile)
+			},
+		},
+		{
+			Name:      "untag",
+			Usage:     "remove one or more tags from a file in the bucket",
+			ArgsUsage: "PATH KEY...",
+			Flags:     []cli.Flag{bucketFlag},
+			Action: func(cx *cli.Context) error {
+				return handleCommand(cx, []string{"l:bucket:s"}, cmd, untagFile)
+			},
+		},
+		{
+			Name:      "tags",
+			Usage:     "display the tags on one or more files in the bucket",
+			ArgsUsage: "PATH...",
+			Flags:     []cli.Flag{bucketFlag},
+			Action: func(cx *cli.Context) error {
+				return handleCommand(cx, []string{"l:bucket:s"}, cmd, listFileTags)
+			},
+		},
+	}
+}
+
+func tagFile(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+
+	if len(cx.Args()) <= 0 {
+		return newExitError(exitUsage, "you have not specified the file to tag")
+	}
+	key := strings.TrimPrefix(cx.Args().First(), "/")
+	values, err := parseKeyValues(cx.Args().Tail())
+	if err != nil {
+		return err
+	}
+	if len(values) <= 0 {
+		return fmt.Errorf("you have not specified any tags to add")
+	}
+
+	// step: merge the tags into the existing ones
+	tags, err := cmd.getObjectTags(bucket, key)
+	if err != nil {
+		return err
+	}
+	for k, v := range values {
+		tags[k] = v
+	}
+	if err := cmd.putObjectTags(bucket, key, tags); err != nil {
+		return err
+	}
+
+	o.fields(map[string]interface{}{
+		"operation": "tag",
+		"bucket":    bucket,
+		"key":       key,
+		"tags":      tags,
+	}).log("successfully tagged the file: s3://%s/%s\n", bucket, key)
+
+	return nil
+}
+
+func untagFile(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+
+	if len(cx.Args()) <= 0 {
+		return newExitError(exitUsage, "you have not specified the file to untag")
+	}
+	key := strings.TrimPrefix(cx.Args().First(), "/")
+	if len(cx.Args().Tail()) <= 0 {
+		return fmt.Errorf("you have not specified any tags to remove")
+	}
+
+	tags, err := cmd.getObjectTags(bucket, key)
+	if err != nil {
+		return err
+	}
+	for _, k := range cx.Args().Tail() {
+		delete(tags, k)
+	}
+	if err := cmd.putObjectTags(bucket, key, tags); err != nil {
+		return err
+	}
+
+	o.fields(map[string]interface{}{
+		"operation": "untag",
+		"bucket":    bucket,
+		"key":       key,
+		"tags":      tags,
+	}).log("successfully removed the tags from the file: s3://%s/%s\n", bucket, key)
+
+	return nil
+}
+
+func listFileTags(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+
+	if len(cx.Args()) <= 0 {
+		return newExitError(exitUsage, "you have not specified any files")
+	}
+	for _, x := range cx.Args() {
+		key := strings.TrimPrefix(x, "/")
+		tags, err := cmd.getObjectTags(bucket, key)
+		if err != nil {
+			return err
+		}
+		o.fields(map[string]interface{}{
+			"bucket": bucket,
+			"key":    key,
+			"tags":   tags,
+		}).log("%-40s %s\n", key, formatTags(tags))
+	}
+
+	return nil
+}
+
+//
+// getObjectTags retrieves the tags on a file in the bucket
+//
+func (r *cliCommand) getObjectTags(bucket, key string) (map[string]string, error) {
+	resp, err := r.s3Client.GetObjectTaggingWithContext(r.ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(r.objectKey(key)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string, 0)
+	for _, x := range resp.TagSet {
+		tags[aws.StringValue(x.Key)] = aws.StringValue(x.Value)
+	}
+
+	return tags, nil
+}
+
+//
+// existingTags returns the tags of the file about to be replaced, or none if the file does not exist
+//
+func (r *cliCommand) existingTags(bucket, key string) (map[string]string, error) {
+	tags, err := r.getObjectTags(bucket, key)
+	if err != nil {
+		if exitCode(err) == exitNotFound {
+			return make(map[string]string, 0), nil
+		}
+		return nil, fmt.Errorf("unable to retrieve the tags of the file: %s, error: %s", key, err)
+	}
+
+	return tags, nil
+}
+
+//
+// putObjectTags replaces the tags on a file in the bucket
+//
+func (r *cliCommand) putObjectTags(bucket, key string, tags map[string]string) error {
+	if len(tags) <= 0 {
+		_, err := r.s3Client.DeleteObjectTaggingWithContext(r.ctx, &s3.DeleteObjectTaggingInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(r.objectKey(key)),
+		})
+
+		return err
+	}
+
+	_, err := r.s3Client.PutObjectTaggingWithContext(r.ctx, &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(bucket),
+		Key:     aws.String(r.objectKey(key)),
+		Tagging: &s3.Tagging{TagSet: toTagSet(tags)},
+	})
+
+	return err
+}
+
+//
+// objectTags retrieves the tags for a collection of keys in parallel
+//
+func (r *cliCommand) objectTags(bucket string, keys []string) (map[string]map[string]string, error) {
+	type result struct {
+		key  string
+		tags map[string]string
+		err  error
+	}
+	keysCh := make(chan string)
+	resultCh := make(chan result, len(keys))
+
+	// step: start the workers
+	var wg sync.WaitGroup
+	for i := 0; i < headConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keysCh {
+				tags, err := r.getObjectTags(bucket, key)
+				resultCh <- result{key: key, tags: tags, err: err}
+			}
+		}()
+	}
+	for _, key := range keys {
+		keysCh <- key
+	}
+	close(keysCh)
+	wg.Wait()
+	close(resultCh)
+
+	list := make(map[string]map[string]string, len(keys))
+	for x := range resultCh {
+		if x.err != nil {
+			return nil, fmt.Errorf("unable to retrieve the tags for: %s, error: %s", x.key, x.err)
+		}
+		list[x.key] = x.tags
+	}
+
+	return list, nil
+}
+
+//
+// getBucketTags retrieves the tags on the bucket
+//
+func (r *cliCommand) getBucketTags(bucket string) (map[string]string, error) {
+	tags := make(map[string]string, 0)
+
+	resp, err := r.s3Client.GetBucketTaggingWithContext(r.ctx, &s3.GetBucketTaggingInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		if e, ok := err.(awserr.Error); ok && e.Code() == "NoSuchTagSet" {
+			return tags, nil
+		}
+		return nil, err
+	}
+	for _, x := range resp.TagSet {
+		tags[aws.StringValue(x.Key)] = aws.StringValue(x.Value)
+	}
+
+	return tags, nil
+}
+
+//
+// putBucketTags replaces the tags on the bucket
+//
+func (r *cliCommand) putBucketTags(bucket string, tags map[string]string) error {
+	if len(tags) <= 0 {
+		_, err := r.s3Client.DeleteBucketTaggingWithContext(r.ctx, &s3.DeleteBucketTaggingInput{
+			Bucket: aws.String(bucket),
+		})
+
+		return err
+	}
+
+	_, err := r.s3Client.PutBucketTaggingWithContext(r.ctx, &s3.PutBucketTaggingInput{
+		Bucket:  aws.String(bucket),
+		Tagging: &s3.Tagging{TagSet: toTagSet(tags)},
+	})
+
+	return err
+}
+
+//
+// toTagSet converts the tags into a s3 tag set
+//
+func toTagSet(tags map[string]string) []*s3.Tag {
+	var list []*s3.Tag
+	for _, k := range sortedKeys(tags) {
+		list = append(list, &s3.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+
+	return list
+}
+
+//
+// encodeTags produces the url encoded representation of the tags used on upload
+//
+func encodeTags(tags map[string]string) string {
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+
+	return values.Encode()
+}
+
+//
+// matchesTags checks the tags contain all the filters
+//
+func matchesTags(tags, filters map[string]string) bool {
+	for k, v := range filters {
+		if value, found := tags[k]; !found || value != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+//
+// formatTags produces a k=v,k=v representation of the tags
+//
+func formatTags(tags map[string]string) string {
+	var list []string
+	for _, k := range sortedKeys(tags) {
+		list = append(list, fmt.Sprintf("%s=%s", k, tags[k]))
+	}
+
+	return strings.Join(list, ",")
+}
+
+//
+// sortedKeys returns the keys of the map in order
+//
+func sortedKeys(values map[string]string) []string {
+	var list []string
+	for k := range values {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+
+	return list
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+)
+
+//
+// tailState is the position reached in the file being followed
+//
+type tailState struct {
+	// the etag of the object last retrieved
+	etag string
+	// the length of the content already displayed
+	offset int64
+	// the object is compressed and cannot be retrieved by range
+	compressed bool
+}
+
+//
+// newTailCommand creates a new tail command
+//
+func newTailCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:    "tail",
+		Aliases: []string{"follow"},
+		Usage:   "follow a file in the bucket, polling for and displaying any content appended to it",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.DurationFlag{
+				Name:  "interval",
+				Usage: "the interval between checking the file for changes `DURATION`",
+				Value: 10 * time.Second,
+			},
+			cli.IntFlag{
+				Name:  "n, lines",
+				Usage: "the number of lines of the existing content to display, zero displays only the new content",
+				Value: 10,
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s"}, cmd, tailFile)
+		},
+	}
+}
+
+//
+// tailFile displays the end of the file and polls for anything appended to it
+//
+func tailFile(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	interval := cx.Duration("interval")
+	lines := cx.Int("lines")
+
+	if len(cx.Args()) != 1 {
+		return newExitError(exitUsage, "you must specify the single file to follow")
+	}
+	if interval <= 0 {
+		return newExitError(exitUsage, "the interval must be positive")
+	}
+	if lines < 0 {
+		return newExitError(exitUsage, "the lines cannot be negative")
+	}
+	key := strings.TrimPrefix(cx.Args().First(), "/")
+
+	// step: display the last lines of the existing content
+	state := new(tailState)
+	content, err := cmd.tailObject(bucket, key, state)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "%s", lastLines(content, lines))
+
+	// step: poll for the content appended to the file
+	for {
+		select {
+		case <-cmd.ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+		content, err := cmd.tailObject(bucket, key, state)
+		if err != nil {
+			if cmd.ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "%s", content)
+	}
+}
+
+//
+// tailObject retrieves the content of the file beyond the offset, nothing is retrieved if the etag is
+// unchanged; if the file has been truncated or replaced by a smaller one it is displayed from the start
+//
+func (r *cliCommand) tailObject(bucket, key string, state *tailState) ([]byte, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(r.objectKey(key)),
+	}
+	if state.etag != "" {
+		input.IfNoneMatch = aws.String(state.etag)
+	}
+	// note: the range is of the stored bytes, so is only usable on uncompressed files
+	ranged := state.offset > 0 && !state.compressed
+	if ranged {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", state.offset))
+	}
+
+	resp, err := r.s3Client.GetObjectWithContext(r.ctx, input)
+	if err != nil {
+		if e, ok := err.(awserr.RequestFailure); ok {
+			switch e.StatusCode() {
+			case http.StatusNotModified:
+				return nil, nil
+			case http.StatusRequestedRangeNotSatisfiable:
+				logger.warningf("the file: %s has been truncated", key)
+				state.etag, state.offset = "", 0
+				return r.tailObject(bucket, key, state)
+			}
+		}
+		if exitCode(err) == exitNotFound {
+			return nil, newNotFoundError("the file: %s does not exist in the bucket: %s", key, bucket)
+		}
+		return nil, fmt.Errorf("failed to retrieve the file: %s, error: %s", key, err)
+	}
+	etag := aws.StringValue(resp.ETag)
+
+	// step: the appended content is retrieved directly, anything else is read and verified in full
+	if ranged {
+		defer resp.Body.Close()
+		content, err := ioutil.ReadAll(r.limitReader(resp.Body))
+		if err != nil {
+			return nil, err
+		}
+		state.etag = etag
+		state.offset += int64(len(content))
+		return content, nil
+	}
+	_, state.compressed = metadataValue(resp.Metadata, compressionMetadata)
+	content, err := r.readObject(key, resp)
+	if err != nil {
+		return nil, err
+	}
+	state.etag = etag
+	if int64(len(content)) < state.offset {
+		logger.warningf("the file: %s has been truncated", key)
+		state.offset = 0
+	}
+	content = content[state.offset:]
+	state.offset += int64(len(content))
+
+	return content, nil
+}
+
+// lastLines returns the last number of lines of the content
+func lastLines(content []byte, lines int) []byte {
+	if lines <= 0 {
+		return nil
+	}
+	end := bytes.TrimSuffix(content, []byte("\n"))
+	for i := 0; i < lines; i++ {
+		index := bytes.LastIndexByte(end, '\n')
+		if index < 0 {
+			return content
+		}
+		end = end[:index]
+	}
+
+	return content[len(end)+1:]
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/urfave/cli"
+)
+
+// the units accepted by the sizes, all binary multiples
+var sizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kb":  1 << 10,
+	"kib": 1 << 10,
+	"m":   1 << 20,
+	"mb":  1 << 20,
+	"mib": 1 << 20,
+	"g":   1 << 30,
+	"gb":  1 << 30,
+	"gib": 1 << 30,
+}
+
+//
+// transferFlags returns the options used to tune the transfers, the part size and concurrency
+// only apply to uploads
+//
+func transferFlags(upload bool) []cli.Flag {
+	flags := []cli.Flag{
+		cli.StringFlag{
+			Name:   "bwlimit",
+			Usage:  "limit the bandwidth used by the transfers, i.e. 10MB/s or 512KB/s `RATE`",
+			EnvVar: "KMSCTL_BWLIMIT",
+		},
+		cli.BoolFlag{
+			Name:  "resume",
+			Usage: "keep the state of the transfers, continuing those interrupted rather than starting them again",
+		},
+		cli.StringFlag{
+			Name:   "resume-dir",
+			Usage:  "the directory the state of the resumable transfers is kept in `DIR`",
+			EnvVar: "KMSCTL_RESUME_DIR",
+			Value:  homePath(".kmsctl", "transfers"),
+		},
+	}
+	if upload {
+		flags = append(flags,
+			cli.StringFlag{
+				Name:  "part-size",
+				Usage: "the size of the parts of a multipart upload, at least 5MB `SIZE`",
+			},
+			cli.IntFlag{
+				Name:  "upload-concurrency",
+				Usage: "the number of parts of a file uploaded in parallel",
+				Value: s3manager.DefaultUploadConcurrency,
+			},
+		)
+	}
+
+	return flags
+}
+
+//
+// applyTransferOptions configures the bandwidth limit and the uploader from the options
+//
+func (r *cliCommand) applyTransferOptions(cx *cli.Context) error {
+	if value := cx.String("bwlimit"); value != "" {
+		rate, err := parseSize(strings.TrimSuffix(value, "/s"))
+		if err != nil || rate <= 0 {
+			return newExitError(exitUsage, "invalid bwlimit: %s, expected a rate i.e. 10MB/s", value)
+		}
+		r.limiter = newRateLimiter(rate)
+	}
+	if value := cx.String("part-size"); value != "" {
+		size, err := parseSize(value)
+		if err != nil || size < s3manager.MinUploadPartSize {
+			return newExitError(exitUsage, "invalid part-size: %s, expected a size of at least 5MB", value)
+		}
+		r.uploader.PartSize = size
+	}
+	if cx.IsSet("upload-concurrency") {
+		if cx.Int("upload-concurrency") <= 0 {
+			return newExitError(exitUsage, "the upload-concurrency must be positive")
+		}
+		r.uploader.Concurrency = cx.Int("upload-concurrency")
+	}
+	if cx.Bool("resume") {
+		// note: the partial downloads are decrypted, so are only accessible to the user
+		if err := os.MkdirAll(cx.String("resume-dir"), 0700); err != nil {
+			return fmt.Errorf("unable to create the resume directory: %s, error: %s", cx.String("resume-dir"), err)
+		}
+		r.resumeDir = cx.String("resume-dir")
+	}
+
+	return nil
+}
+
+// limitReader wraps the reader in the bandwidth limit, if any
+func (r *cliCommand) limitReader(reader io.Reader) io.Reader {
+	if r.limiter == nil {
+		return reader
+	}
+
+	return &rateLimitedReader{ctx: r.ctx, reader: reader, limiter: r.limiter}
+}
+
+// parseSize parses a size with an optional unit, i.e. 10MB
+func parseSize(value string) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	i := strings.IndexFunc(value, func(c rune) bool { return (c < '0' || c > '9') && c != '.' })
+	if i < 0 {
+		i = len(value)
+	}
+	unit, found := sizeUnits[strings.TrimSpace(value[i:])]
+	if !found {
+		return 0, fmt.Errorf("unknown unit: %s", value[i:])
+	}
+	number, err := strconv.ParseFloat(value[:i], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(number * float64(unit)), nil
+}
+
+//
+// rateLimiter is a token bucket shared by the transfers, refilled at the rate per second
+//
+type rateLimiter struct {
+	sync.Mutex
+	// the bytes per second
+	rate int64
+	// the bytes available
+	tokens float64
+	// when the bucket was last refilled
+	refilled time.Time
+}
+
+// newRateLimiter creates a limiter permitting the bytes per second
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate, tokens: float64(rate), refilled: time.Now()}
+}
+
+//
+// wait blocks until the bytes are permitted, or the context is cancelled
+//
+func (l *rateLimiter) wait(ctx context.Context, size int) error {
+	l.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.refilled).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.refilled = now
+	// note: the bytes are taken up front, the deficit determines how long we must wait
+	l.tokens -= float64(size)
+	deficit := -l.tokens
+	l.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(time.Duration(deficit / float64(l.rate) * float64(time.Second))):
+	}
+
+	return nil
+}
+
+//
+// rateLimitedReader is a reader throttled by the limiter
+//
+type rateLimitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *rateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	// note: keep the reads within a second of the rate so the transfer is smooth
+	if int64(len(p)) > r.limiter.rate {
+		p = p[:r.limiter.rate]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if werr := r.limiter.wait(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+const (
+	// the prefix the deleted files are moved under
+	trashPrefix = ".trash/"
+	// the format of the timestamp the files are deleted under
+	trashTimeFormat = "20060102T150405Z"
+)
+
+//
+// trashedFile is a file which has been moved into the trash
+//
+type trashedFile struct {
+	// the key of the file in the trash
+	key string
+	// the original key of the file
+	original string
+	// when the file was deleted
+	deleted time.Time
+}
+
+//
+// newTrashCommand creates a new trash command
+//
+func newTrashCommand(cmd *cliCommand) cli.Command {
+	bucketFlag := cli.StringFlag{
+		Name:   "b, bucket",
+		Usage:  "the name of the s3 bucket containing the encrypted files `NAME`",
+		EnvVar: "AWS_S3_BUCKET",
+	}
+
+	return cli.Command{
+		Name:  "trash",
+		Usage: "list or purge the files deleted with --trash",
+		Subcommands: []cli.Command{
+			{
+				Name:    "list",
+				Aliases: []string{"ls"},
+				Usage:   "list the files in the trash",
+				Flags:   []cli.Flag{bucketFlag},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:bucket:s"}, cmd, listTrash)
+				},
+			},
+			{
+				Name:  "purge",
+				Usage: "permanently remove the files which have been in the trash longer than the retention",
+				Flags: []cli.Flag{
+					bucketFlag,
+					cli.StringFlag{
+						Name:  "older-than",
+						Usage: "remove the files deleted longer ago than the retention, i.e. 30d `DURATION`",
+						Value: "30d",
+					},
+					cli.BoolFlag{
+						Name:  "y, yes",
+						Usage: "do not prompt for confirmation before purging",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:bucket:s"}, cmd, purgeTrash)
+				},
+			},
+		},
+	}
+}
+
+//
+// newRestoreCommand creates a new restore command
+//
+func newRestoreCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:      "restore",
+		Usage:     "restore one or more files deleted with --trash, by default the most recently deleted copy",
+		ArgsUsage: "PATH...",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files `NAME`",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:  "t, timestamp",
+				Usage: "restore the copy deleted at this time, as shown by trash list `TIMESTAMP`",
+			},
+			cli.BoolFlag{
+				Name:  "force",
+				Usage: "overwrite the file if it has since been recreated",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s"}, cmd, restoreFiles)
+		},
+	}
+}
+
+func listTrash(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+
+	files, err := cmd.trashedFiles(bucket)
+	if err != nil {
+		return err
+	}
+	for _, x := range files {
+		o.fields(map[string]interface{}{
+			"bucket":  bucket,
+			"key":     x.original,
+			"deleted": x.deleted,
+			"trash":   x.key,
+		}).log("%-18s %s\n", x.deleted.Format(trashTimeFormat), x.original)
+	}
+
+	return nil
+}
+
+func purgeTrash(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	retention, err := parseDuration(cx.String("older-than"))
+	if err != nil {
+		return newExitError(exitUsage, "invalid older-than: %s, error: %s", cx.String("older-than"), err)
+	}
+
+	files, err := cmd.trashedFiles(bucket)
+	if err != nil {
+		return err
+	}
+	var expired []*trashedFile
+	for _, x := range files {
+		if time.Since(x.deleted) > retention {
+			expired = append(expired, x)
+		}
+	}
+	if len(expired) <= 0 {
+		return nil
+	}
+	if err := confirm(cx, "this will permanently remove %d files from the trash of the bucket: %s", len(expired), bucket); err != nil {
+		return err
+	}
+
+	summary := newTransferSummary("purged")
+	for _, x := range expired {
+		if err := cmd.removeFile(bucket, x.key); err != nil {
+			summary.fail(x.key, err)
+			continue
+		}
+		summary.success()
+
+		o.fields(map[string]interface{}{
+			"action":  "purge",
+			"bucket":  bucket,
+			"key":     x.original,
+			"deleted": x.deleted,
+		}).log("purged the file: %s deleted at %s\n", x.original, x.deleted.Format(trashTimeFormat))
+	}
+	summary.print(o)
+
+	return summary.err()
+}
+
+//
+// restoreFiles moves the files from the trash back to their original location
+//
+func restoreFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	timestamp := cx.String("timestamp")
+
+	if len(cx.Args()) <= 0 {
+		return newExitError(exitUsage, "you have not specified any files to restore")
+	}
+	files, err := cmd.trashedFiles(bucket)
+	if err != nil {
+		return err
+	}
+
+	for _, x := range cx.Args() {
+		key := strings.TrimPrefix(x, "/")
+
+		// step: find the most recent copy, or the copy deleted at the timestamp
+		var trashed *trashedFile
+		for _, f := range files {
+			if f.original != key {
+				continue
+			}
+			if timestamp != "" && f.deleted.Format(trashTimeFormat) != timestamp {
+				continue
+			}
+			if trashed == nil || f.deleted.After(trashed.deleted) {
+				trashed = f
+			}
+		}
+		if trashed == nil {
+			return newNotFoundError("the file: %s is not in the trash", key)
+		}
+
+		// step: ensure we are not overwriting a recreated file
+		if !cx.Bool("force") {
+			if _, err := cmd.getFileMetadata(key, bucket); err == nil {
+				return fmt.Errorf("the file: %s exists, use --force to overwrite it", key)
+			} else if exitCode(err) != exitNotFound {
+				return err
+			}
+		}
+		if err := cmd.copyFile(bucket, trashed.key, key); err != nil {
+			return fmt.Errorf("failed to restore the file: %s, error: %s", key, err)
+		}
+		if err := cmd.removeFile(bucket, trashed.key); err != nil {
+			return fmt.Errorf("failed to remove the file: %s from the trash, error: %s", trashed.key, err)
+		}
+
+		o.fields(map[string]interface{}{
+			"action":  "restore",
+			"bucket":  bucket,
+			"key":     key,
+			"deleted": trashed.deleted,
+		}).log("restored the file: s3://%s/%s deleted at %s\n", bucket, key, trashed.deleted.Format(trashTimeFormat))
+	}
+
+	return nil
+}
+
+//
+// trashFile moves the file into the trash, returning the key in the trash
+//
+func (r *cliCommand) trashFile(bucket, key string) (string, error) {
+	if err := r.checkUnlocked(bucket, key); err != nil {
+		return "", err
+	}
+	trashed := trashPrefix + time.Now().UTC().Format(trashTimeFormat) + "/" + key
+	if err := r.copyFile(bucket, key, trashed); err != nil {
+		return "", err
+	}
+
+	return trashed, r.removeFile(bucket, key)
+}
+
+//
+// trashedFiles retrieves the files in the trash, oldest first
+//
+func (r *cliCommand) trashedFiles(bucket string) ([]*trashedFile, error) {
+	keys, err := r.listBucketKeys(bucket, trashPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var list []*trashedFile
+	for _, x := range keys {
+		items := strings.SplitN(strings.TrimPrefix(*x.Key, trashPrefix), "/", 2)
+		if len(items) != 2 {
+			continue
+		}
+		deleted, err := time.Parse(trashTimeFormat, items[0])
+		if err != nil {
+			continue
+		}
+		list = append(list, &trashedFile{key: *x.Key, original: items[1], deleted: deleted})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].deleted.Before(list[j].deleted) })
+
+	return list, nil
+}
+
+// isTrashKey checks if the key is within the trash
+func isTrashKey(key string) bool {
+	return strings.HasPrefix(key, trashPrefix)
+}
@@ -16,8 +16,14 @@ limitations under the License.
 package main
 
 import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/urfave/cli"
 )
@@ -31,6 +37,50 @@ func getPaths(cx *cli.Context) []string {
 	return cx.Args()
 }
 
+// keysFromFlag is the option used to read the keys from a file or stdin
+var keysFromFlag = cli.StringFlag{
+	Name:  "keys-from",
+	Usage: "read the keys, one per line, from the file or - for stdin, in addition to the arguments `PATH`",
+}
+
+// getKeys returns the keys from the arguments and the keys-from file, an argument of - reads the keys from stdin
+func getKeys(cx *cli.Context) ([]string, error) {
+	var list []string
+	sources := []string{cx.String("keys-from")}
+	for _, x := range cx.Args() {
+		if x == "-" {
+			sources = append(sources, x)
+			continue
+		}
+		list = append(list, x)
+	}
+	for _, source := range sources {
+		if source == "" {
+			continue
+		}
+		file := os.Stdin
+		if source != "-" {
+			f, err := os.Open(source)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			file = f
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				list = append(list, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("unable to read the keys from: %s, error: %s", source, err)
+		}
+	}
+
+	return list, nil
+}
+
 // checks if the path is a directory
 func isDirectory(path string) (bool, error) {
 	info, err := os.Stat(path)
@@ -48,22 +98,157 @@ func isFile(path string) (bool, error) {
 	return !dir, err
 }
 
-// either returns the file of expands the file in the directories
-func expandFiles(path string) ([]string, error) {
-	var list []string
-	// step: check if it's a file
-	if found, err := isFile(path); err != nil {
-		return list, err
-	} else if found {
-		return []string{path}, nil
-	}
-	// step: walk the files in the directories
-	err := filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
-		if info.Mode().IsRegular() {
-			list = append(list, path)
+// isValidOption checks the value is one of the permitted options
+func isValidOption(value string, options []string) bool {
+	for _, x := range options {
+		if x == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseDuration is a wrapper to time.ParseDuration which also accepts days, i.e. 7d
+func parseDuration(value string) (time.Duration, error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil {
+			return 0, err
+		}
+
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// defaultValue returns the value or the default if empty
+func defaultValue(value, def string) string {
+	if value == "" {
+		return def
+	}
+
+	return value
+}
+
+// parseKeyValues parses a list of KEY=VALUE pairs into a map
+func parseKeyValues(list []string) (map[string]string, error) {
+	values := make(map[string]string, 0)
+	for _, x := range list {
+		items := strings.SplitN(x, "=", 2)
+		if len(items) != 2 || items[0] == "" {
+			return nil, fmt.Errorf("invalid value: %s, must be in the format KEY=VALUE", x)
+		}
+		values[items[0]] = items[1]
+	}
+
+	return values, nil
+}
+
+// splitArgs splits a command line into arguments, honouring single and double quotes
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	var quote rune
+	var inArg, escaped bool
+
+	for _, c := range line {
+		switch {
+		case escaped:
+			current.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				current.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(c)
+			inArg = true
 		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in: %s", line)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args, nil
+}
+
+// readFileOrStdin reads the content of the file, or the stdin if the path is -
+func readFileOrStdin(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+
+	return ioutil.ReadFile(path)
+}
+
+// confirm asks the user to confirm the operation, unless --yes was specified
+func confirm(cx *cli.Context, message string, args ...interface{}) error {
+	if cx.Bool("yes") {
+		return nil
+	}
+	// step: we cannot prompt if we are not attached to a terminal
+	if stat, err := os.Stdin.Stat(); err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("refusing to continue without confirmation, use --yes to skip the prompt")
+	}
+	fmt.Fprintf(os.Stderr, message+"\nare you sure you wish to continue? [y/N]: ", args...)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
 		return nil
-	})
+	}
+
+	return fmt.Errorf("operation aborted")
+}
+
+// writeFileAtomic writes the content to a temporary file and renames it over the path, so readers
+// never see a partial file and read only files can be replaced
+func writeFileAtomic(path string, content []byte, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	temp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := os.WriteFile(temp, content, mode); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp, mode); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	if err := os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+		return err
+	}
+
+	return nil
+}
+
+// homePath returns the path under the home directory of the user, i.e. $HOME or %USERPROFILE% on windows
+func homePath(elem ...string) string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = os.TempDir()
+	}
 
-	return list, err
+	return filepath.Join(append([]string{home}, elem...)...)
 }
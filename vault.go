@@ -0,0 +1,481 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// errVaultNotFound indicates the secret does not exist in vault
+var errVaultNotFound = errors.New("the secret does not exist in vault")
+
+//
+// vaultClient is a minimal client for the vault kv version 2 secrets engine
+//
+type vaultClient struct {
+	// the address of vault
+	address string
+	// the token used to authenticate
+	token string
+	// the vault enterprise namespace if any
+	namespace string
+	// the mount path of the kv engine
+	mount string
+	// the http client
+	client *http.Client
+}
+
+//
+// vaultFlags returns the options used to connect to vault
+//
+func vaultFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:   "vault-addr",
+			Usage:  "the address of the vault service `URL`",
+			EnvVar: "VAULT_ADDR",
+		},
+		cli.StringFlag{
+			Name:   "vault-token",
+			Usage:  "the token used to authenticate to vault `TOKEN`",
+			EnvVar: "VAULT_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "vault-namespace",
+			Usage:  "the vault enterprise namespace `NAMESPACE`",
+			EnvVar: "VAULT_NAMESPACE",
+		},
+		cli.StringFlag{
+			Name:   "vault-cacert",
+			Usage:  "the path to a pem bundle used to verify the vault certificate `PATH`",
+			EnvVar: "VAULT_CACERT",
+		},
+		cli.StringFlag{
+			Name:  "mount",
+			Usage: "the mount path of the kv version 2 secrets engine `PATH`",
+			Value: "secret",
+		},
+		cli.StringFlag{
+			Name:  "path",
+			Usage: "the path within the secrets engine the secrets are placed or read from `PATH`",
+		},
+	}
+}
+
+//
+// newVaultClient creates a vault client from the options
+//
+func newVaultClient(cx *cli.Context) (*vaultClient, error) {
+	if cx.String("vault-addr") == "" {
+		return nil, newExitError(exitUsage, "the vault address (--vault-addr or VAULT_ADDR) is required")
+	}
+	if cx.String("vault-token") == "" {
+		return nil, newExitError(exitUsage, "the vault token (--vault-token or VAULT_TOKEN) is required")
+	}
+	client, err := newHTTPClient(cx.String("vault-cacert"))
+	if err != nil {
+		return nil, err
+	}
+	logger.redact(cx.String("vault-token"))
+
+	return &vaultClient{
+		address:   strings.TrimSuffix(cx.String("vault-addr"), "/"),
+		token:     cx.String("vault-token"),
+		namespace: cx.String("vault-namespace"),
+		mount:     strings.Trim(cx.String("mount"), "/"),
+		client:    client,
+	}, nil
+}
+
+//
+// write stores the data at the path, creating a new version
+//
+func (r *vaultClient) write(ctx context.Context, path string, data map[string]interface{}) error {
+	_, err := r.request(ctx, "POST", "data", path, map[string]interface{}{"data": data})
+
+	return err
+}
+
+//
+// read retrieves the data of the latest version at the path
+//
+func (r *vaultClient) read(ctx context.Context, path string) (map[string]interface{}, error) {
+	resp, err := r.request(ctx, "GET", "data", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	var secret struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp, &secret); err != nil {
+		return nil, err
+	}
+	if secret.Data.Data == nil {
+		return nil, errVaultNotFound
+	}
+
+	return secret.Data.Data, nil
+}
+
+//
+// list recursively retrieves the paths of all the secrets under the path
+//
+func (r *vaultClient) list(ctx context.Context, path string) ([]string, error) {
+	resp, err := r.request(ctx, "LIST", "metadata", path, nil)
+	if err == errVaultNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var listing struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(resp, &listing); err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, x := range listing.Data.Keys {
+		name := strings.TrimSuffix(path, "/") + "/" + x
+		name = strings.TrimPrefix(name, "/")
+		if strings.HasSuffix(x, "/") {
+			children, err := r.list(ctx, name)
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, children...)
+			continue
+		}
+		paths = append(paths, name)
+	}
+
+	return paths, nil
+}
+
+//
+// request performs a request against the kv engine, returning the body of the response
+//
+func (r *vaultClient) request(ctx context.Context, method, kind, path string, body interface{}) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	url := fmt.Sprintf("%s/v1/%s/%s/%s", r.address, r.mount, kind, strings.TrimPrefix(path, "/"))
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", r.token)
+	if r.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", r.namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, errVaultNotFound
+	case resp.StatusCode == http.StatusForbidden:
+		return nil, newExitError(exitAccessDenied, "access to the vault path: %s was denied", path)
+	case resp.StatusCode >= 300:
+		return nil, fmt.Errorf("vault returned status: %d, response: %s", resp.StatusCode, strings.TrimSpace(string(content)))
+	}
+
+	return content, nil
+}
+
+//
+// vaultData converts the content of a file into the secret data, json objects are stored as the
+// key/values of the secret, anything else under a single key 'value'
+//
+func vaultData(content []byte) map[string]interface{} {
+	var values map[string]interface{}
+	if err := json.Unmarshal(content, &values); err == nil && values != nil {
+		return values
+	}
+
+	return map[string]interface{}{"value": string(content)}
+}
+
+//
+// vaultContent converts the secret data back into the content of a file
+//
+func vaultContent(data map[string]interface{}) ([]byte, error) {
+	if value, found := data["value"].(string); found && len(data) == 1 {
+		return []byte(value), nil
+	}
+
+	return json.MarshalIndent(data, "", "  ")
+}
+
+//
+// newExportVaultCommand creates the command to export files to vault
+//
+func newExportVaultCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "vault",
+		Usage: "export the files in the bucket into a vault kv version 2 secrets engine",
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files `NAME`",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:  "prefix",
+				Usage: "only export the files under this prefix, which is removed from the vault path `PREFIX`",
+			},
+			cli.BoolFlag{
+				Name:  "overwrite",
+				Usage: "add a new version to any existing secrets, otherwise they are skipped",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "display the secrets which would be written without making any changes",
+			},
+		}, vaultFlags()...),
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s"}, cmd, exportVault)
+		},
+	}
+}
+
+//
+// newImportVaultCommand creates the command to import secrets from vault
+//
+func newImportVaultCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "vault",
+		Usage: "import the secrets from a vault kv version 2 secrets engine into the bucket",
+		Flags: append([]cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket to place the files `NAME`",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:  "prefix",
+				Usage: "the prefix in the bucket the secrets are placed under `PREFIX`",
+			},
+			cli.StringFlag{
+				Name:   "k, kms",
+				Usage:  "the kms key to encrypt the files with, defaults to the bucket default encryption `KEY`",
+				EnvVar: "AWS_KMS_ID",
+			},
+			cli.BoolFlag{
+				Name:  "overwrite",
+				Usage: "overwrite any existing files in the bucket, otherwise they are skipped",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "display the files which would be written without making any changes",
+			},
+		}, vaultFlags()...),
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s"}, cmd, importVault)
+		},
+	}
+}
+
+//
+// exportVault writes the files in the bucket to vault
+//
+func exportVault(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	prefix := cx.String("prefix")
+	path := strings.Trim(cx.String("path"), "/")
+	dryRun := cx.Bool("dry-run")
+
+	vault, err := newVaultClient(cx)
+	if err != nil {
+		return err
+	}
+	files, err := cmd.listBucketKeys(bucket, prefix)
+	if err != nil {
+		return err
+	}
+
+	summary := newTransferSummary("exported")
+	for _, x := range files {
+		name := strings.TrimPrefix(path+"/"+strings.TrimPrefix(strings.TrimPrefix(*x.Key, prefix), "/"), "/")
+
+		// step: skip any existing secrets unless overwriting
+		if !cx.Bool("overwrite") {
+			if _, err := vault.read(cmd.ctx, name); err == nil {
+				o.fields(map[string]interface{}{
+					"action":  "export",
+					"key":     *x.Key,
+					"path":    name,
+					"skipped": true,
+				}).log("skipping the vault path: %s/%s, it already exists (use --overwrite)\n", vault.mount, name)
+				summary.skip()
+				continue
+			} else if err != errVaultNotFound {
+				summary.fail(*x.Key, err)
+				continue
+			}
+		}
+		if dryRun {
+			o.fields(map[string]interface{}{
+				"action":  "export",
+				"bucket":  bucket,
+				"key":     *x.Key,
+				"path":    name,
+				"dry-run": true,
+			}).log("[dry-run] would export s3://%s/%s to the vault path: %s/%s\n", bucket, *x.Key, vault.mount, name)
+			summary.skip()
+			continue
+		}
+
+		content, err := cmd.getFile(bucket, *x.Key)
+		if err != nil {
+			summary.fail(*x.Key, err)
+			continue
+		}
+		if err := vault.write(cmd.ctx, name, vaultData(content)); err != nil {
+			summary.fail(*x.Key, err)
+			continue
+		}
+		summary.success()
+
+		o.fields(map[string]interface{}{
+			"action": "export",
+			"bucket": bucket,
+			"key":    *x.Key,
+			"path":   name,
+		}).log("exported s3://%s/%s to the vault path: %s/%s\n", bucket, *x.Key, vault.mount, name)
+	}
+	summary.print(o)
+
+	return summary.err()
+}
+
+//
+// importVault copies the secrets under the vault path into the bucket
+//
+func importVault(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	prefix := cx.String("prefix")
+	path := strings.Trim(cx.String("path"), "/")
+	kms := cx.String("kms")
+	dryRun := cx.Bool("dry-run")
+
+	vault, err := newVaultClient(cx)
+	if err != nil {
+		return err
+	}
+	if kms == "" {
+		if err := cmd.hasDefaultKmsEncryption(bucket); err != nil {
+			return err
+		}
+	}
+	paths, err := vault.list(cmd.ctx, path)
+	if err != nil {
+		return err
+	}
+
+	// note: the existing files are skipped below, the condition guarding against those created since
+	options := &uploadOptions{ifNotExists: !cx.Bool("overwrite")}
+	summary := newTransferSummary("imported")
+	for _, name := range paths {
+		key := prefix + strings.TrimPrefix(strings.TrimPrefix(name, path), "/")
+
+		// step: skip any existing files unless overwriting
+		if !cx.Bool("overwrite") {
+			found, err := cmd.hasKey(key, bucket)
+			if err != nil {
+				summary.fail(name, err)
+				continue
+			}
+			if found {
+				o.fields(map[string]interface{}{
+					"action":  "import",
+					"path":    name,
+					"key":     key,
+					"skipped": true,
+				}).log("skipping the file: s3://%s/%s, it already exists (use --overwrite)\n", bucket, key)
+				summary.skip()
+				continue
+			}
+		}
+		if dryRun {
+			o.fields(map[string]interface{}{
+				"action":  "import",
+				"path":    name,
+				"bucket":  bucket,
+				"key":     key,
+				"dry-run": true,
+			}).log("[dry-run] would import the vault path: %s/%s to s3://%s/%s\n", vault.mount, name, bucket, key)
+			summary.skip()
+			continue
+		}
+
+		data, err := vault.read(cmd.ctx, name)
+		if err != nil {
+			summary.fail(name, err)
+			continue
+		}
+		content, err := vaultContent(data)
+		if err != nil {
+			summary.fail(name, err)
+			continue
+		}
+		if err := cmd.putContent(bucket, key, content, kms, options); err != nil {
+			summary.fail(name, err)
+			continue
+		}
+		summary.success()
+
+		o.fields(map[string]interface{}{
+			"action": "import",
+			"path":   name,
+			"bucket": bucket,
+			"key":    key,
+		}).log("imported the vault path: %s/%s to s3://%s/%s\n", vault.mount, name, bucket, key)
+	}
+	summary.print(o)
+
+	return summary.err()
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+)
+
+//
+// newVerifyEncryptionCommand creates a new verify-encryption command
+//
+func newVerifyEncryptionCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:      "verify-encryption",
+		Usage:     "verify all the files in the bucket are encrypted with kms, optionally with a permitted key",
+		ArgsUsage: "[PREFIX...]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files `NAME`",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringSliceFlag{
+				Name:  "allowed-keys",
+				Usage: "the alias, arn or id of a kms key the files are permitted to be encrypted with `KEY`",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s"}, cmd, verifyEncryption)
+		},
+	}
+}
+
+//
+// verifyEncryption checks the files in the bucket are encrypted with a permitted kms key
+//
+func verifyEncryption(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+
+	// step: resolve the permitted keys to their arn
+	allowed := make(map[string]bool, 0)
+	for _, x := range cx.StringSlice("allowed-keys") {
+		metadata, err := cmd.describeKey(x)
+		if err != nil {
+			return fmt.Errorf("unable to resolve the kms key: %s, error: %s", x, err)
+		}
+		allowed[aws.StringValue(metadata.Arn)] = true
+	}
+
+	var scanned, failed int
+	for _, p := range getPaths(cx) {
+		files, err := cmd.listBucketKeys(bucket, strings.TrimPrefix(p, "/"))
+		if err != nil {
+			return err
+		}
+		var keys []string
+		for _, x := range files {
+			keys = append(keys, *x.Key)
+		}
+		heads, err := cmd.headObjects(bucket, keys)
+		if err != nil {
+			return err
+		}
+
+		// step: check each of the files
+		for _, key := range keys {
+			scanned++
+			head := heads[key]
+			if reason := checkObjectEncryption(head, allowed); reason != "" {
+				failed++
+				o.fields(map[string]interface{}{
+					"action":     "verify",
+					"bucket":     bucket,
+					"key":        key,
+					"encryption": aws.StringValue(head.ServerSideEncryption),
+					"kms-key":    aws.StringValue(head.SSEKMSKeyId),
+					"reason":     reason,
+				}).log("FAIL s3://%s/%s: %s\n", bucket, key, reason)
+			}
+		}
+	}
+
+	o.fields(map[string]interface{}{
+		"action":  "summary",
+		"bucket":  bucket,
+		"scanned": scanned,
+		"failed":  failed,
+	}).log("scanned %d files, %d failed verification\n", scanned, failed)
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed the encryption verification", failed, scanned)
+	}
+
+	return nil
+}
+
+//
+// checkObjectEncryption returns the reason the object fails verification, or empty if it passes
+//
+func checkObjectEncryption(head *s3.HeadObjectOutput, allowed map[string]bool) string {
+	switch aws.StringValue(head.ServerSideEncryption) {
+	case s3.ServerSideEncryptionAwsKms, s3.ServerSideEncryptionAwsKmsDsse:
+	case "":
+		return "the file is not encrypted"
+	default:
+		return fmt.Sprintf("the file is encrypted with %s rather than kms", aws.StringValue(head.ServerSideEncryption))
+	}
+	if len(allowed) > 0 && !allowed[aws.StringValue(head.SSEKMSKeyId)] {
+		return fmt.Sprintf("the file is encrypted with a key not permitted: %s", aws.StringValue(head.SSEKMSKeyId))
+	}
+
+	return ""
+}
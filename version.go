@@ -0,0 +1,346 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/urfave/cli"
+)
+
+// the name of the checksums published with the releases
+const releaseChecksums = "SHA256SUMS"
+
+// the time permitted to retrieve each file from github
+const downloadTimeout = 2 * time.Minute
+
+//
+// githubRelease is a release of the repository on github
+//
+type githubRelease struct {
+	// the tag of the release, i.e. v1.0.4
+	Tag string `json:"tag_name"`
+	// the page of the release
+	URL string `json:"html_url"`
+	// the files published with the release
+	Assets []struct {
+		// the name of the file
+		Name string `json:"name"`
+		// the url the file is downloaded from
+		URL string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+//
+// newVersionCommand creates a new version command
+//
+func newVersionCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "version",
+		Usage: "display the version, git commit, build date, go and aws sdk versions of the binary",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "check",
+				Usage: "check github for a newer release",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{}, cmd, showVersion)
+		},
+	}
+}
+
+//
+// newSelfUpdateCommand creates a new self-update command
+//
+func newSelfUpdateCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "self-update",
+		Usage: "replace the binary with the latest release from github, verifying the checksum of the download",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "release",
+				Usage: "the tag of the release to install, defaults to the latest `TAG`",
+			},
+			cli.BoolFlag{
+				Name:  "y, yes",
+				Usage: "do not prompt for confirmation",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{}, cmd, selfUpdate)
+		},
+	}
+}
+
+//
+// showVersion displays the build metadata, and the latest release if checking
+//
+func showVersion(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	fields := map[string]interface{}{
+		"version":    version,
+		"git-commit": gitCommit,
+		"build-date": buildDate,
+		"go-version": runtime.Version(),
+		"aws-sdk":    aws.SDKVersion,
+		"platform":   runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	message := fmt.Sprintf("%s %s\n  git commit: %s\n  build date: %s\n  go version: %s\n  aws sdk:    %s\n  platform:   %s/%s\n",
+		progName, version, gitCommit, buildDate, runtime.Version(), aws.SDKVersion, runtime.GOOS, runtime.GOARCH)
+
+	if cx.Bool("check") {
+		release, err := latestRelease(cx, cmd, "")
+		if err != nil {
+			return err
+		}
+		available := newerVersion(release.Tag, version)
+		fields["latest"] = release.Tag
+		fields["update-available"] = available
+		if available {
+			message += fmt.Sprintf("a newer release: %s is available, see %s or run %s self-update\n", release.Tag, release.URL, progName)
+		} else {
+			message += "this is the latest release\n"
+		}
+	}
+	o.fields(fields).log("%s", message)
+
+	return nil
+}
+
+//
+// selfUpdate downloads the release for the platform, verifies it against the published checksums and
+// replaces the running binary
+//
+func selfUpdate(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	release, err := latestRelease(cx, cmd, cx.String("release"))
+	if err != nil {
+		return err
+	}
+	if cx.String("release") == "" && !newerVersion(release.Tag, version) {
+		o.log("the release: %s is the latest\n", version)
+		return nil
+	}
+
+	// step: find the binary for the platform and the checksums
+	name := fmt.Sprintf("%s_%s_%s_%s.gz", progName, release.Tag, runtime.GOOS, releaseArch())
+	var binaryURL, checksumsURL string
+	for _, x := range release.Assets {
+		switch x.Name {
+		case name:
+			binaryURL = x.URL
+		case releaseChecksums:
+			checksumsURL = x.URL
+		}
+	}
+	if binaryURL == "" {
+		return newNotFoundError("the release: %s has no binary for the platform, expected: %s", release.Tag, name)
+	}
+	if checksumsURL == "" {
+		return fmt.Errorf("the release: %s has no %s, the download cannot be verified", release.Tag, releaseChecksums)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if executable, err = filepath.EvalSymlinks(executable); err != nil {
+		return err
+	}
+	if err := confirm(cx, "this will replace: %s (%s) with the release: %s", executable, version, release.Tag); err != nil {
+		return err
+	}
+
+	client, err := newHTTPClient(cx.GlobalString("ca-bundle"))
+	if err != nil {
+		return err
+	}
+	checksums, err := download(cmd.ctx, client, checksumsURL)
+	if err != nil {
+		return err
+	}
+	archive, err := download(cmd.ctx, client, binaryURL)
+	if err != nil {
+		return err
+	}
+
+	// step: verify the checksum of the download
+	expected := releaseChecksum(checksums, name)
+	if expected == "" {
+		return fmt.Errorf("the %s of the release does not include: %s", releaseChecksums, name)
+	}
+	sum := sha256.Sum256(archive)
+	if hex.EncodeToString(sum[:]) != expected {
+		return fmt.Errorf("the checksum of the download: %s does not match the release, refusing to install", name)
+	}
+	reader, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return fmt.Errorf("unable to decompress the release, error: %s", err)
+	}
+	binary, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("unable to decompress the release, error: %s", err)
+	}
+
+	// step: replace the binary, the running binary cannot be overwritten on windows so is moved aside
+	var previous string
+	if runtime.GOOS == "windows" {
+		previous = executable + ".old"
+		os.Remove(previous)
+		if err := os.Rename(executable, previous); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(executable, binary, 0755); err != nil {
+		// note: put the binary moved aside back, else there is no executable left
+		if previous != "" {
+			if e := os.Rename(previous, executable); e != nil {
+				logger.errorf("unable to restore the binary: %s from: %s, error: %s", executable, previous, e)
+			}
+		}
+		return fmt.Errorf("unable to replace the binary: %s, error: %s", executable, err)
+	}
+
+	o.fields(map[string]interface{}{
+		"action":   "self-update",
+		"path":     executable,
+		"previous": version,
+		"release":  release.Tag,
+	}).log("updated %s from %s to %s\n", executable, version, release.Tag)
+
+	return nil
+}
+
+//
+// latestRelease retrieves the release of the repository from github, the latest if no tag is given
+//
+func latestRelease(cx *cli.Context, cmd *cliCommand, tag string) (*githubRelease, error) {
+	client, err := newHTTPClient(cx.GlobalString("ca-bundle"))
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", repository)
+	if tag != "" {
+		url = fmt.Sprintf("https://api.github.com/repos/%s/releases/tags/%s", repository, tag)
+	}
+	content, err := download(cmd.ctx, client, url)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve the release from github, error: %s", err)
+	}
+	release := new(githubRelease)
+	if err := json.Unmarshal(content, release); err != nil {
+		return nil, fmt.Errorf("unable to parse the release from github, error: %s", err)
+	}
+
+	return release, nil
+}
+
+// download retrieves the content of the url, bounded by the timeout
+func download(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, downloadTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, newNotFoundError("%s was not found", url)
+	default:
+		return nil, fmt.Errorf("%s returned: %s", url, resp.Status)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// releaseChecksum returns the checksum of the file from the sha256sum formatted checksums
+func releaseChecksum(checksums []byte, name string) string {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0])
+		}
+	}
+
+	return ""
+}
+
+// releaseArch returns the architecture as named in the releases, i.e. uname -m
+func releaseArch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	case "386":
+		return "i386"
+	}
+
+	return runtime.GOARCH
+}
+
+// newerVersion checks if the release is newer than the current version, i.e. v1.1.0 against v1.0.4
+func newerVersion(release, current string) bool {
+	a, b := versionParts(release), versionParts(current)
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] > b[i]
+		}
+	}
+
+	return len(a) > len(b)
+}
+
+// versionParts returns the numeric parts of the version, ignoring any pre-release suffix
+func versionParts(value string) []int {
+	value = strings.TrimPrefix(value, "v")
+	if i := strings.IndexAny(value, "-+"); i >= 0 {
+		value = value[:i]
+	}
+	var parts []int
+	for _, x := range strings.Split(value, ".") {
+		n, err := strconv.Atoi(x)
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+	}
+
+	return parts
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+)
+
+//
+// newBucketVersioningCommand creates the bucket versioning commands
+//
+func newBucketVersioningCommand(cmd *cliCommand) cli.Command {
+	flags := []cli.Flag{
+		cli.StringFlag{
+			Name:   "b, bucket",
+			Usage:  "the name of the bucket `NAME`",
+			EnvVar: "AWS_S3_BUCKET",
+		},
+	}
+
+	return cli.Command{
+		Name:  "versioning",
+		Usage: "manage the versioning of files within the bucket",
+		Subcommands: []cli.Command{
+			{
+				Name:  "enable",
+				Usage: "enable versioning on the bucket, retaining the history of all files",
+				Flags: flags,
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:bucket:s"}, cmd, enableBucketVersioning)
+				},
+			},
+			{
+				Name:  "suspend",
+				Usage: "suspend versioning on the bucket, existing versions are retained",
+				Flags: flags,
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:bucket:s"}, cmd, suspendBucketVersioning)
+				},
+			},
+			{
+				Name:  "status",
+				Usage: "display the versioning status of the bucket",
+				Flags: flags,
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{"l:bucket:s"}, cmd, bucketVersioningStatus)
+				},
+			},
+		},
+	}
+}
+
+func enableBucketVersioning(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	return updateBucketVersioning(o, cx.String("bucket"), s3.BucketVersioningStatusEnabled, cmd)
+}
+
+func suspendBucketVersioning(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	return updateBucketVersioning(o, cx.String("bucket"), s3.BucketVersioningStatusSuspended, cmd)
+}
+
+func bucketVersioningStatus(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	name := cx.String("bucket")
+
+	status, err := cmd.getBucketVersioning(name)
+	if err != nil {
+		return err
+	}
+
+	o.fields(map[string]interface{}{
+		"bucket":     name,
+		"versioning": status,
+	}).log("%-42s %s\n", name, status)
+
+	return nil
+}
+
+//
+// updateBucketVersioning changes the versioning status of the bucket
+//
+func updateBucketVersioning(o *formatter, name, status string, cmd *cliCommand) error {
+	if err := cmd.setBucketVersioning(name, status); err != nil {
+		return err
+	}
+
+	o.fields(map[string]interface{}{
+		"operation":  "versioning",
+		"bucket":     name,
+		"versioning": status,
+	}).log("successfully set the versioning of bucket: %s to: %s\n", name, status)
+
+	return nil
+}
+
+//
+// setBucketVersioning sets the versioning status on the bucket
+//
+func (r *cliCommand) setBucketVersioning(bucket, status string) error {
+	_, err := r.s3Client.PutBucketVersioningWithContext(r.ctx, &s3.PutBucketVersioningInput{
+		Bucket: aws.String(bucket),
+		VersioningConfiguration: &s3.VersioningConfiguration{
+			Status: aws.String(status),
+		},
+	})
+
+	return err
+}
+
+//
+// getBucketVersioning retrieves the versioning status of the bucket
+//
+func (r *cliCommand) getBucketVersioning(bucket string) (string, error) {
+	resp, err := r.s3Client.GetBucketVersioningWithContext(r.ctx, &s3.GetBucketVersioningInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// step: a bucket which has never had versioning has no status
+	return defaultValue(aws.StringValue(resp.Status), "Disabled"), nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//
+// fileWatcher notifies the paths created or modified under the watched paths
+//
+type fileWatcher interface {
+	// events returns the paths as they change, closed when the watcher stops
+	events() <-chan string
+	// close stops the watcher
+	close() error
+}
+
+//
+// watchFiles watches the paths, sending the files created or modified once they have been unchanged
+// for the debounce period; the channel is closed when the context is cancelled
+//
+func watchFiles(ctx context.Context, paths []string, symlinks string, debounce time.Duration) (<-chan []string, error) {
+	if debounce <= 0 {
+		return nil, newExitError(exitUsage, "the debounce must be positive")
+	}
+	watcher, err := newFileWatcher(paths)
+	if err != nil {
+		return nil, err
+	}
+	changesCh := make(chan []string)
+
+	go func() {
+		defer close(changesCh)
+		defer watcher.close()
+
+		pending := make(map[string]bool, 0)
+		timer := time.NewTimer(debounce)
+		timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case path, ok := <-watcher.events():
+				if !ok {
+					return
+				}
+				if isWatchedFile(paths, path, symlinks) {
+					pending[path] = true
+					timer.Reset(debounce)
+				}
+			case <-timer.C:
+				var files []string
+				for x := range pending {
+					files = append(files, x)
+				}
+				sort.Strings(files)
+				pending = make(map[string]bool, 0)
+
+				select {
+				case <-ctx.Done():
+					return
+				case changesCh <- files:
+				}
+			}
+		}
+	}()
+
+	return changesCh, nil
+}
+
+//
+// isWatchedFile checks the path is a regular file under one of the watched paths, the symlinks are
+// only followed if requested
+//
+func isWatchedFile(paths []string, path, symlinks string) bool {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return false
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if symlinks != symlinksFollow {
+			return false
+		}
+		if info, err = os.Stat(path); err != nil {
+			return false
+		}
+	}
+	if !info.Mode().IsRegular() {
+		return false
+	}
+	for _, x := range paths {
+		relative, err := filepath.Rel(filepath.Clean(x), path)
+		if err != nil {
+			continue
+		}
+		if relative == "." || (relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))) {
+			return true
+		}
+	}
+
+	return false
+}
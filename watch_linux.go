@@ -0,0 +1,186 @@
+//go:build linux
+// +build linux
+
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// the inotify events of the files being written, moved or created in the directories
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE
+
+//
+// inotifyWatcher watches the directories using inotify
+//
+type inotifyWatcher struct {
+	// the inotify file descriptor
+	fd int
+	// the file wrapping the descriptor
+	file *os.File
+	// the directories keyed by the watch descriptor
+	watches map[int]string
+	// the directories whose subdirectories are watched
+	recursive map[string]bool
+	// the channel of the changed paths
+	eventsCh chan string
+	// closed when the watcher is stopped
+	doneCh chan struct{}
+}
+
+//
+// newFileWatcher watches the directories given, including their subdirectories, and the parent
+// directory of the files given, so files replaced by a rename are also noticed
+//
+func newFileWatcher(paths []string) (fileWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the inotify watcher, error: %s", err)
+	}
+	w := &inotifyWatcher{
+		fd:        fd,
+		file:      os.NewFile(uintptr(fd), "inotify"),
+		watches:   make(map[int]string, 0),
+		recursive: make(map[string]bool, 0),
+		eventsCh:  make(chan string, 100),
+		doneCh:    make(chan struct{}),
+	}
+	for _, x := range paths {
+		info, err := os.Stat(x)
+		if err != nil {
+			w.file.Close()
+			return nil, err
+		}
+		if info.IsDir() {
+			err = w.addRecursive(x)
+		} else {
+			err = w.add(filepath.Dir(x))
+		}
+		if err != nil {
+			w.file.Close()
+			return nil, err
+		}
+	}
+	go w.run()
+
+	return w, nil
+}
+
+// events returns the channel of the changed paths
+func (w *inotifyWatcher) events() <-chan string {
+	return w.eventsCh
+}
+
+// close stops the watcher
+func (w *inotifyWatcher) close() error {
+	close(w.doneCh)
+
+	return w.file.Close()
+}
+
+// add watches the directory
+func (w *inotifyWatcher) add(directory string) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, directory, inotifyMask)
+	if err != nil {
+		return fmt.Errorf("unable to watch the directory: %s, error: %s", directory, err)
+	}
+	w.watches[wd] = directory
+
+	return nil
+}
+
+// addRecursive watches the directory and all the subdirectories
+func (w *inotifyWatcher) addRecursive(directory string) error {
+	return filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		w.recursive[path] = true
+		return w.add(path)
+	})
+}
+
+//
+// run reads the inotify events until the watcher is closed, the directories created under the watched
+// directories are watched in turn
+//
+func (w *inotifyWatcher) run() {
+	defer close(w.eventsCh)
+
+	buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buffer)
+		if err != nil {
+			return
+		}
+		var paths []string
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			start := offset + syscall.SizeofInotifyEvent
+			offset = start + int(event.Len)
+			name := strings.TrimRight(string(buffer[start:offset]), "\x00")
+
+			switch {
+			case event.Mask&syscall.IN_Q_OVERFLOW != 0:
+				logger.warningf("the inotify queue overflowed, some changes may not have been noticed")
+				continue
+			case event.Mask&syscall.IN_IGNORED != 0:
+				delete(w.watches, int(event.Wd))
+				continue
+			}
+			directory, found := w.watches[int(event.Wd)]
+			if !found || name == "" {
+				continue
+			}
+			path := filepath.Join(directory, name)
+
+			if event.Mask&syscall.IN_ISDIR == 0 {
+				paths = append(paths, path)
+				continue
+			}
+			if !w.recursive[directory] {
+				continue
+			}
+			// note: the files may have been written before the directory was watched
+			if err := w.addRecursive(path); err != nil {
+				logger.warningf("%s", err)
+			}
+			filepath.Walk(path, func(x string, info os.FileInfo, err error) error {
+				if err == nil && !info.IsDir() {
+					paths = append(paths, x)
+				}
+				return nil
+			})
+		}
+		for _, x := range paths {
+			select {
+			case <-w.doneCh:
+				return
+			case w.eventsCh <- x:
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// the interval between scanning the files for changes
+const pollWatcherInterval = time.Second
+
+//
+// pollWatcher notices the changes by periodically scanning the modification time of the files,
+// used where inotify is unavailable
+//
+type pollWatcher struct {
+	// the paths being watched
+	paths []string
+	// the modification time of the files
+	modified map[string]time.Time
+	// the channel of the changed paths
+	eventsCh chan string
+	// closed when the watcher is stopped
+	doneCh chan struct{}
+}
+
+// newFileWatcher watches the files under the paths
+func newFileWatcher(paths []string) (fileWatcher, error) {
+	w := &pollWatcher{
+		paths:    paths,
+		eventsCh: make(chan string, 100),
+		doneCh:   make(chan struct{}),
+	}
+	w.modified = w.scan()
+	go w.run()
+
+	return w, nil
+}
+
+// events returns the channel of the changed paths
+func (w *pollWatcher) events() <-chan string {
+	return w.eventsCh
+}
+
+// close stops the watcher
+func (w *pollWatcher) close() error {
+	close(w.doneCh)
+
+	return nil
+}
+
+// scan retrieves the modification time of the files under the paths
+func (w *pollWatcher) scan() map[string]time.Time {
+	modified := make(map[string]time.Time, 0)
+	for _, x := range w.paths {
+		files, _ := expandFiles(x, symlinksFollow)
+		for _, filename := range files {
+			if info, err := os.Stat(filename); err == nil {
+				modified[filename] = info.ModTime()
+			}
+		}
+	}
+
+	return modified
+}
+
+// run scans the files until the watcher is closed
+func (w *pollWatcher) run() {
+	defer close(w.eventsCh)
+
+	ticker := time.NewTicker(pollWatcherInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.doneCh:
+			return
+		case <-ticker.C:
+		}
+		modified := w.scan()
+		for filename, mtime := range modified {
+			if previous, found := w.modified[filename]; found && previous.Equal(mtime) {
+				continue
+			}
+			select {
+			case <-w.doneCh:
+				return
+			case w.eventsCh <- filename:
+			}
+		}
+		w.modified = modified
+	}
+}